@@ -4,20 +4,44 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/SlinkyProject/slurm-exporter/internal/client"
 	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+	"github.com/SlinkyProject/slurm-exporter/internal/events"
+	"github.com/SlinkyProject/slurm-exporter/internal/externalmetrics"
+	"github.com/SlinkyProject/slurm-exporter/internal/keda"
+	"github.com/SlinkyProject/slurm-exporter/internal/keda/externalscaler"
+	"github.com/SlinkyProject/slurm-exporter/internal/leader"
+	"github.com/SlinkyProject/slurm-exporter/internal/otlp"
+	"github.com/SlinkyProject/slurm-exporter/internal/pods"
+	"github.com/SlinkyProject/slurm-exporter/internal/remotewrite"
+	"github.com/SlinkyProject/slurm-exporter/internal/summary"
 )
 
 var (
@@ -26,9 +50,88 @@ var (
 
 // Input flags to the command
 type Flags struct {
-	MetricsAddr string
-	Server      string
-	CacheFreq   time.Duration
+	MetricsAddr  string
+	Server       string
+	CacheFreq    time.Duration
+	FullSyncFreq time.Duration
+	// Per object type, overriding CacheFreq when set
+	JobsCacheFreq       time.Duration
+	NodesCacheFreq      time.Duration
+	PartitionsCacheFreq time.Duration
+	StatsCacheFreq      time.Duration
+
+	SchedulerCounters bool
+	MetricsSchema     string
+	Precompute        bool
+	CacheTTL          time.Duration
+	StaleGracePeriod  time.Duration
+	// Circuit breaker of the requests to slurmrestd
+	BreakerFailures   int
+	BreakerBackoff    time.Duration
+	BreakerMaxBackoff time.Duration
+	// HTTP server
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	MaxConcurrentScrapes int
+	ShutdownTimeout      time.Duration
+	// Leader election among the replicas
+	LeaderElection              bool
+	LeaderElectionNamespace     string
+	LeaderElectionLeaseName     string
+	LeaderElectionLeaseDuration time.Duration
+	LeaderElectionRenewDeadline time.Duration
+	LeaderElectionRetryPeriod   time.Duration
+	LeaderElectionPod           string
+	// External metrics API for autoscaling
+	ExternalMetricsAddr     string
+	ExternalMetricsCertFile string
+	ExternalMetricsKeyFile  string
+	// KEDA external scaler
+	KedaAddr string
+	// Kubernetes pod labels of the node metrics
+	PodLabels          bool
+	PodLabelsNamespace string
+	PodLabelsSelector  string
+	// Kubernetes Events of the node state transitions
+	Events bool
+	// Push of the metrics by Prometheus remote write
+	RemoteWriteURL             string
+	RemoteWriteInterval        time.Duration
+	RemoteWriteTimeout         time.Duration
+	RemoteWriteHeaders         map[string]string
+	RemoteWriteBearerTokenFile string
+	RemoteWriteExternalLabels  map[string]string
+	RemoteWriteQueueSize       int
+	RemoteWriteMinBackoff      time.Duration
+	RemoteWriteMaxBackoff      time.Duration
+	// Export of the metrics by OTLP
+	OTLPEndpoint           string
+	OTLPProtocol           string
+	OTLPInsecure           bool
+	OTLPInterval           time.Duration
+	OTLPTimeout            time.Duration
+	OTLPHeaders            map[string]string
+	OTLPResourceAttributes map[string]string
+}
+
+// MarshalLog masks the values of the headers when the flags are logged, as
+// they may carry credentials.
+func (f Flags) MarshalLog() any {
+	type loggedFlags Flags
+	logged := loggedFlags(f)
+	logged.RemoteWriteHeaders = redactValues(f.RemoteWriteHeaders)
+	logged.OTLPHeaders = redactValues(f.OTLPHeaders)
+	return logged
+}
+
+// redactValues returns the keys of the map, with their values masked.
+func redactValues(m map[string]string) map[string]string {
+	redacted := make(map[string]string, len(m))
+	for key := range m {
+		redacted[key] = "REDACTED"
+	}
+	return redacted
 }
 
 func parseFlags(flags *Flags) {
@@ -50,6 +153,300 @@ func parseFlags(flags *Flags) {
 		5*time.Second,
 		"The amount of time to wait between updating the slurm restapi cache. Must be greater than 1s and must be parsable by time.ParseDuration.",
 	)
+	flag.DurationVar(
+		&flags.JobsCacheFreq,
+		"cache-freq.jobs",
+		0,
+		"The amount of time to wait between updating the jobs in the slurm restapi cache. Defaults to --cache-freq.",
+	)
+	flag.DurationVar(
+		&flags.NodesCacheFreq,
+		"cache-freq.nodes",
+		0,
+		"The amount of time to wait between updating the nodes in the slurm restapi cache. Defaults to --cache-freq.",
+	)
+	flag.DurationVar(
+		&flags.PartitionsCacheFreq,
+		"cache-freq.partitions",
+		0,
+		"The amount of time to wait between updating the partitions in the slurm restapi cache. Defaults to --cache-freq.",
+	)
+	flag.DurationVar(
+		&flags.StatsCacheFreq,
+		"cache-freq.stats",
+		0,
+		"The amount of time to wait between updating the scheduler statistics in the slurm restapi cache. Defaults to --cache-freq.",
+	)
+	flag.DurationVar(
+		&flags.FullSyncFreq,
+		"full-sync-freq",
+		0,
+		"The amount of time between full syncs of the jobs and nodes in the slurm restapi cache. In between, the jobs and nodes are only fetched if they changed. If zero (the default), every sync is a full sync, i.e. the incremental sync is disabled.",
+	)
+	flag.BoolVar(
+		&flags.SchedulerCounters,
+		"scheduler-counters",
+		false,
+		"Export the scheduler statistics which accumulate since the last reset (sdiag -r, slurmctld restart) as monotonic counters.",
+	)
+	flag.StringVar(
+		&flags.MetricsSchema,
+		"metrics.schema",
+		string(collector.MetricsSchemaV1),
+		"The metric schema to export, one of: v1, v2, both. The v2 schema uses base units, correct metric types and OpenMetrics compliant names.",
+	)
+	flag.BoolVar(
+		&flags.Precompute,
+		"precompute",
+		false,
+		"Compute the Slurm metrics in the background whenever the slurm restapi cache changes, instead of at scrape time.",
+	)
+	flag.DurationVar(
+		&flags.CacheTTL,
+		"metrics.cache-ttl",
+		0,
+		"The amount of time to serve a rendered scrape response from a cache, such that concurrent and repeated scrapes (e.g. of HA Prometheus pairs) cost one computation. If zero, responses are not cached.",
+	)
+	flag.DurationVar(
+		&flags.StaleGracePeriod,
+		"stale-grace-period",
+		0,
+		"The amount of time to keep serving the last Slurm objects which were listed successfully, while the slurm restapi is unavailable. If zero, nothing is served once listing fails.",
+	)
+	flag.IntVar(
+		&flags.BreakerFailures,
+		"breaker.failures",
+		0,
+		"The number of consecutive failed requests to the slurm restapi (errors, HTTP 5xx and 429) after which requests are backed off. If zero, requests are never backed off.",
+	)
+	flag.DurationVar(
+		&flags.BreakerBackoff,
+		"breaker.backoff",
+		10*time.Second,
+		"The amount of time to back off the slurm restapi once --breaker.failures is reached, doubling after every failed probe.",
+	)
+	flag.DurationVar(
+		&flags.BreakerMaxBackoff,
+		"breaker.max-backoff",
+		5*time.Minute,
+		"The maximum amount of time to back off the slurm restapi.",
+	)
+	flag.DurationVar(
+		&flags.ReadTimeout,
+		"http.read-timeout",
+		30*time.Second,
+		"The maximum duration for reading an entire request.",
+	)
+	flag.DurationVar(
+		&flags.WriteTimeout,
+		"http.write-timeout",
+		2*time.Minute,
+		"The maximum duration before timing out the write of a response. Must be greater than the scrape timeout.",
+	)
+	flag.DurationVar(
+		&flags.IdleTimeout,
+		"http.idle-timeout",
+		2*time.Minute,
+		"The maximum amount of time to wait for the next request on a keep-alive connection.",
+	)
+	flag.IntVar(
+		&flags.MaxConcurrentScrapes,
+		"http.max-concurrent-scrapes",
+		10,
+		"The maximum number of concurrent scrapes of the metrics, beyond which scrapes are rejected (HTTP 503). If zero, there is no limit.",
+	)
+	flag.DurationVar(
+		&flags.ShutdownTimeout,
+		"shutdown-timeout",
+		25*time.Second,
+		"The maximum amount of time to wait for the ongoing scrapes to finish on shutdown (SIGTERM, SIGINT).",
+	)
+	flag.BoolVar(
+		&flags.LeaderElection,
+		"leader-election",
+		false,
+		"Elect a leader among the exporter replicas by a Kubernetes Lease, such that only the leader polls the slurm restapi and exports the Slurm metrics.",
+	)
+	flag.StringVar(
+		&flags.LeaderElectionNamespace,
+		"leader-election.namespace",
+		os.Getenv("POD_NAMESPACE"),
+		"The namespace of the leader election Lease. Defaults to the env POD_NAMESPACE.",
+	)
+	flag.StringVar(
+		&flags.LeaderElectionLeaseName,
+		"leader-election.lease-name",
+		"slurm-exporter",
+		"The name of the leader election Lease.",
+	)
+	flag.DurationVar(
+		&flags.LeaderElectionLeaseDuration,
+		"leader-election.lease-duration",
+		15*time.Second,
+		"The amount of time that followers wait before taking over the leadership of an unresponsive leader.",
+	)
+	flag.DurationVar(
+		&flags.LeaderElectionRenewDeadline,
+		"leader-election.renew-deadline",
+		10*time.Second,
+		"The amount of time that the leader retries renewing the Lease before giving up the leadership.",
+	)
+	flag.DurationVar(
+		&flags.LeaderElectionRetryPeriod,
+		"leader-election.retry-period",
+		2*time.Second,
+		"The amount of time to wait between attempts to acquire or renew the Lease.",
+	)
+	flag.StringVar(
+		&flags.LeaderElectionPod,
+		"leader-election.pod",
+		os.Getenv("POD_NAME"),
+		"The name of the pod of this replica, in the namespace of the Lease, which is labeled by whether it leads, for a Service of the leader. Defaults to the env POD_NAME.",
+	)
+	flag.StringVar(
+		&flags.ExternalMetricsAddr,
+		"external-metrics.bind-address",
+		"",
+		"The address the external metrics API (external.metrics.k8s.io) binds to, serving TLS. If empty, the external metrics API is not served.",
+	)
+	flag.StringVar(
+		&flags.ExternalMetricsCertFile,
+		"external-metrics.tls-cert-file",
+		"",
+		"The TLS certificate file of the external metrics API. If empty, a self-signed certificate is generated.",
+	)
+	flag.StringVar(
+		&flags.ExternalMetricsKeyFile,
+		"external-metrics.tls-key-file",
+		"",
+		"The TLS key file of the external metrics API.",
+	)
+	flag.StringVar(
+		&flags.KedaAddr,
+		"keda.bind-address",
+		"",
+		"The address the KEDA external scaler (gRPC) binds to. If empty, the KEDA external scaler is not served.",
+	)
+	flag.BoolVar(
+		&flags.PodLabels,
+		"pod-labels",
+		false,
+		"Map the Slurm nodes to the Kubernetes pods that they run in by slurm_node_info (k8s_namespace, k8s_pod, k8s_node, nodeset), by watching the pods.",
+	)
+	flag.StringVar(
+		&flags.PodLabelsNamespace,
+		"pod-labels.namespace",
+		os.Getenv("POD_NAMESPACE"),
+		"The namespace of the pods of the Slurm nodes. If empty, the pods of all namespaces are watched. Defaults to the env POD_NAMESPACE.",
+	)
+	flag.StringVar(
+		&flags.PodLabelsSelector,
+		"pod-labels.selector",
+		"",
+		"The label selector of the pods of the Slurm nodes. If empty, all pods of the namespace are watched.",
+	)
+	flag.BoolVar(
+		&flags.Events,
+		"events",
+		false,
+		"Record Kubernetes Events on the pods of the Slurm nodes (selected by --pod-labels.namespace and --pod-labels.selector) when the nodes go down, drain or fail.",
+	)
+	flag.StringVar(
+		&flags.RemoteWriteURL,
+		"remote-write.url",
+		"",
+		"The Prometheus remote write endpoint to push the metrics to. If empty, the metrics are not pushed.",
+	)
+	flag.DurationVar(
+		&flags.RemoteWriteInterval,
+		"remote-write.interval",
+		30*time.Second,
+		"The amount of time to wait between pushes of the metrics.",
+	)
+	flag.DurationVar(
+		&flags.RemoteWriteTimeout,
+		"remote-write.timeout",
+		30*time.Second,
+		"The maximum duration of a remote write request.",
+	)
+	flags.RemoteWriteHeaders = map[string]string{}
+	flag.Func(
+		"remote-write.header",
+		"A header of the remote write requests, as 'Name: value'. May be repeated.",
+		keyValueFlag(flags.RemoteWriteHeaders, ":"),
+	)
+	flag.StringVar(
+		&flags.RemoteWriteBearerTokenFile,
+		"remote-write.bearer-token-file",
+		"",
+		"The file of the bearer token of the remote write requests, which is read on every request.",
+	)
+	flags.RemoteWriteExternalLabels = map[string]string{}
+	flag.Func(
+		"remote-write.external-label",
+		"A label added to every pushed series, as 'name=value' (e.g. cluster=prod). May be repeated.",
+		keyValueFlag(flags.RemoteWriteExternalLabels, "="),
+	)
+	flag.IntVar(
+		&flags.RemoteWriteQueueSize,
+		"remote-write.queue-size",
+		10,
+		"The number of pushes kept in memory while the remote write endpoint is unavailable, beyond which the oldest are dropped.",
+	)
+	flag.DurationVar(
+		&flags.RemoteWriteMinBackoff,
+		"remote-write.min-backoff",
+		time.Second,
+		"The amount of time to wait before retrying a failed remote write request, doubling after every retry.",
+	)
+	flag.DurationVar(
+		&flags.RemoteWriteMaxBackoff,
+		"remote-write.max-backoff",
+		time.Minute,
+		"The maximum amount of time to wait before retrying a failed remote write request.",
+	)
+	flag.StringVar(
+		&flags.OTLPEndpoint,
+		"otlp.endpoint",
+		"",
+		"The OTLP endpoint to export the metrics to, as host:port for grpc, or else the URL of the metrics (e.g. http://collector:4318/v1/metrics). If empty, the metrics are not exported by OTLP.",
+	)
+	flag.StringVar(
+		&flags.OTLPProtocol,
+		"otlp.protocol",
+		otlp.ProtocolGRPC,
+		"The protocol of the OTLP endpoint, one of: grpc, http/protobuf.",
+	)
+	flag.BoolVar(
+		&flags.OTLPInsecure,
+		"otlp.insecure",
+		false,
+		"Connect to the OTLP grpc endpoint without TLS.",
+	)
+	flag.DurationVar(
+		&flags.OTLPInterval,
+		"otlp.interval",
+		30*time.Second,
+		"The amount of time to wait between exports of the metrics by OTLP.",
+	)
+	flag.DurationVar(
+		&flags.OTLPTimeout,
+		"otlp.timeout",
+		30*time.Second,
+		"The maximum duration of an OTLP export.",
+	)
+	flags.OTLPHeaders = map[string]string{}
+	flag.Func(
+		"otlp.header",
+		"A header of the OTLP exports, as 'Name: value'. May be repeated.",
+		keyValueFlag(flags.OTLPHeaders, ":"),
+	)
+	flags.OTLPResourceAttributes = map[string]string{}
+	flag.Func(
+		"otlp.resource-attribute",
+		"An attribute of the resource of the OTLP metrics, as 'name=value' (e.g. deployment.environment=prod), overriding the detected slurm.cluster.name and slurm.version. May be repeated.",
+		keyValueFlag(flags.OTLPResourceAttributes, "="),
+	)
 	flag.Parse()
 }
 
@@ -63,28 +460,488 @@ func main() {
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 	setupLog.Info("With", "Flags", flags)
 
-	slurmClient, err := client.NewSlurmClient(flags.Server, flags.CacheFreq)
+	metricsSchema, err := collector.ParseMetricsSchema(flags.MetricsSchema)
+	if err != nil {
+		setupLog.Error(err, "invalid metrics schema")
+		os.Exit(1)
+	}
+
+	cacheIntervals := client.CacheIntervals{
+		Jobs:       flags.JobsCacheFreq,
+		Nodes:      flags.NodesCacheFreq,
+		Partitions: flags.PartitionsCacheFreq,
+		Stats:      flags.StatsCacheFreq,
+	}.WithDefault(flags.CacheFreq)
+	var breaker *client.Breaker
+	if flags.BreakerFailures > 0 {
+		breaker, err = client.NewBreaker(client.BreakerOptions{
+			Failures:   flags.BreakerFailures,
+			Backoff:    flags.BreakerBackoff,
+			MaxBackoff: flags.BreakerMaxBackoff,
+		})
+		if err != nil {
+			setupLog.Error(err, "invalid circuit breaker")
+			os.Exit(1)
+		}
+	}
+	slurmClient, err := client.NewSlurmClient(flags.Server, cacheIntervals, flags.FullSyncFreq, breaker)
 	if err != nil {
 		setupLog.Error(err, "could not create slurm client")
 		os.Exit(1)
 	}
 
-	collectors := []prometheus.Collector{
-		collector.NewSchedulerCollector(slurmClient),
-		collector.NewNodeCollector(slurmClient),
-		collector.NewJobCollector(slurmClient),
-		collector.NewPartitionCollector(slurmClient),
-		collector.NewAccountCollector(slurmClient),
-		collector.NewUserCollector(slurmClient),
+	snapshots := collector.NewSnapshotterWithGracePeriod(slurmClient, flags.StaleGracePeriod)
+	nodeCollector := collector.NewNodeCollector(snapshots)
+	var podWatcher *pods.Watcher
+	if flags.PodLabels || flags.Events {
+		podWatcher, err = newPodWatcher(flags)
+		if err != nil {
+			setupLog.Error(err, "could not create pod watcher")
+			os.Exit(1)
+		}
 	}
-	for _, collector := range collectors {
-		prometheus.MustRegister(collector)
+	if flags.PodLabels {
+		nodeCollector = collector.NewNodeCollectorWithPods(snapshots, podWatcher)
+	}
+	var eventBroadcaster record.EventBroadcaster
+	var eventPublisher *events.Publisher
+	if flags.Events {
+		eventBroadcaster, err = newEventBroadcaster()
+		if err != nil {
+			setupLog.Error(err, "could not create event broadcaster")
+			os.Exit(1)
+		}
+		recorder := eventBroadcaster.NewRecorder(clientgoscheme.Scheme, corev1.EventSource{Component: "slurm-exporter"})
+		eventPublisher = events.NewPublisher(slurmClient, podWatcher, recorder, cacheIntervals.Nodes)
+	}
+	collectors := map[string]prometheus.Collector{
+		"scheduler":       collector.NewSchedulerCollector(snapshots, flags.SchedulerCounters),
+		"node":            nodeCollector,
+		"node_transition": collector.NewNodeTransitionCollector(snapshots),
+		"node_power":      collector.NewNodePowerCollector(snapshots),
+		"job":             collector.NewJobCollector(snapshots),
+		"job_lifecycle":   collector.NewJobLifecycleCollector(snapshots),
+		"partition":       collector.NewPartitionCollector(snapshots),
+		"account":         collector.NewAccountCollector(snapshots),
+		"user":            collector.NewUserCollector(snapshots),
+	}
+	status := collector.NewStatus()
+	var slurmCollectors []prometheus.Collector
+	for name, c := range collectors {
+		slurmCollectors = append(slurmCollectors, collector.NewTimeoutCollector(name, collector.NewSchemaCollector(status.Track(name, c, snapshots), metricsSchema), snapshots))
+	}
+	slurmCollectors = append(slurmCollectors, collector.NewCacheCollector(cacheIntervals.ByType()))
+	slurmCollectors = append(slurmCollectors, collector.NewStalenessCollector(snapshots))
+	if breaker != nil {
+		slurmCollectors = append(slurmCollectors, collector.NewBreakerCollector(breaker))
+	}
+
+	var elector *leader.Elector
+	if flags.LeaderElection {
+		elector, err = newElector(flags)
+		if err != nil {
+			setupLog.Error(err, "could not create leader elector")
+			os.Exit(1)
+		}
+		prometheus.MustRegister(elector.NewCollector())
 	}
 
 	setupLog.Info("starting exporter")
-	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(flags.MetricsAddr, nil); err != nil {
+	// Same as promhttp.Handler(), along with the Slurm metrics, whose
+	// collectors share one snapshot of the Slurm objects per scrape or push.
+	slurmGatherer := func(ctx context.Context) prometheus.Gatherer {
+		return snapshots.Gatherer(ctx, slurmCollectors...)
+	}
+	var precomputer *collector.Precomputer
+	if flags.Precompute {
+		period := min(cacheIntervals.Jobs, cacheIntervals.Nodes, cacheIntervals.Partitions, cacheIntervals.Stats)
+		precomputer = collector.NewPrecomputer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), period)
+			defer cancel()
+			return snapshots.Gatherer(ctx, slurmCollectors...).Gather()
+		}), period)
+		client.NewCacheNotifier(slurmClient).Subscribe(precomputer.Trigger)
+		slurmGatherer = func(context.Context) prometheus.Gatherer {
+			return precomputer
+		}
+	}
+	if elector != nil {
+		// Followers export only the metrics of the exporter itself.
+		leaderGatherer := slurmGatherer
+		slurmGatherer = func(ctx context.Context) prometheus.Gatherer {
+			return elector.Gatherer(leaderGatherer(ctx))
+		}
+	}
+	gatherers := func(ctx context.Context) prometheus.Gatherer {
+		return prometheus.Gatherers{prometheus.DefaultGatherer, slurmGatherer(ctx)}
+	}
+	var remoteWriter *remotewrite.Writer
+	if flags.RemoteWriteURL != "" {
+		pushGatherer := gatherers
+		if elector != nil {
+			// Only the leader pushes, such that the replicas do not push the
+			// same series.
+			pushGatherer = func(ctx context.Context) prometheus.Gatherer {
+				return elector.Gatherer(gatherers(ctx))
+			}
+		}
+		remoteWriter, err = newRemoteWriter(flags, pushGatherer)
+		if err != nil {
+			setupLog.Error(err, "could not create remote writer")
+			os.Exit(1)
+		}
+		prometheus.MustRegister(remoteWriter.NewCollector())
+	}
+	var otlpExporter *otlp.Exporter
+	if flags.OTLPEndpoint != "" {
+		slurmResource := otlp.NewSlurmResource(slurmClient)
+		detect := func(ctx context.Context) (map[string]string, error) {
+			// Only the leader polls slurmrestd.
+			if elector != nil && !elector.IsLeader() {
+				return nil, errors.New("not the leader")
+			}
+			return slurmResource(ctx)
+		}
+		otlpExporter, err = newOTLPExporter(flags, gatherers, detect)
+		if err != nil {
+			setupLog.Error(err, "could not create OTLP exporter")
+			os.Exit(1)
+		}
+		prometheus.MustRegister(otlpExporter.NewCollector())
+	}
+	handlerOpts := promhttp.HandlerOpts{
+		MaxRequestsInFlight: flags.MaxConcurrentScrapes,
+	}
+	handler := collector.Handler(gatherers, handlerOpts)
+	if flags.CacheTTL > 0 {
+		handler = collector.NewResponseCache(handler, flags.CacheTTL)
+	}
+	handler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+	// The autoscaling and summary endpoints serve only on the leader, which polls slurmrestd.
+	leaderReady := func() error {
+		if elector != nil && !elector.IsLeader() {
+			return errors.New("not the leader")
+		}
+		return client.Ready(slurmClient)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz(func() error {
+		// Followers are ready, such that their own metrics are scraped. The
+		// endpoints which only the leader serves are routed to it by a
+		// Service which selects the leader.LeaderLabel of the pods.
+		if elector != nil && !elector.IsLeader() {
+			return nil
+		}
+		return client.Ready(slurmClient)
+	}))
+	mux.Handle("/status", status)
+	mux.Handle("/api/"+summary.APIVersion+"/", summary.NewServer(snapshots, leaderReady))
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  flags.ReadTimeout,
+		WriteTimeout: flags.WriteTimeout,
+		IdleTimeout:  flags.IdleTimeout,
+	}
+	listener, err := net.Listen("tcp", flags.MetricsAddr)
+	if err != nil {
+		setupLog.Error(err, "could not listen", "address", flags.MetricsAddr)
+		os.Exit(1)
+	}
+	var externalServer *http.Server
+	var externalListener net.Listener
+	if flags.ExternalMetricsAddr != "" {
+		externalHandler, tlsConfig, err := newExternalMetricsServer(flags, snapshots, leaderReady)
+		if err != nil {
+			setupLog.Error(err, "invalid external metrics configuration")
+			os.Exit(1)
+		}
+		externalServer = &http.Server{
+			Handler:      externalHandler,
+			ReadTimeout:  flags.ReadTimeout,
+			WriteTimeout: flags.WriteTimeout,
+			IdleTimeout:  flags.IdleTimeout,
+		}
+		externalListener, err = tls.Listen("tcp", flags.ExternalMetricsAddr, tlsConfig)
+		if err != nil {
+			setupLog.Error(err, "could not listen", "address", flags.ExternalMetricsAddr)
+			os.Exit(1)
+		}
+	}
+	var kedaServer *grpc.Server
+	var kedaListener net.Listener
+	if flags.KedaAddr != "" {
+		period := min(cacheIntervals.Jobs, cacheIntervals.Nodes, cacheIntervals.Partitions)
+		kedaServer = grpc.NewServer()
+		externalscaler.RegisterExternalScalerServer(kedaServer, keda.NewScaler(snapshots, leaderReady, period))
+		kedaListener, err = net.Listen("tcp", flags.KedaAddr)
+		if err != nil {
+			setupLog.Error(err, "could not listen", "address", flags.KedaAddr)
+			os.Exit(1)
+		}
+	}
+
+	// The root context is cancelled on SIGTERM or SIGINT, upon which the
+	// ongoing scrapes are drained before the client cache is stopped. The
+	// client cache runs until then, or only while leading.
+	ctx := ctrl.SetupSignalHandler()
+	serveCtx, stopServing := context.WithCancel(ctx)
+	clientCtx, stopClient := context.WithCancel(context.Background())
+	clientDone := make(chan struct{})
+	startClient := func(ctx context.Context) {
+		if precomputer != nil {
+			go precomputer.Start(ctx)
+		}
+		if eventPublisher != nil {
+			go eventPublisher.Start(ctx)
+		}
+		slurmClient.Start(ctx)
+	}
+	if remoteWriter != nil {
+		go remoteWriter.Start(ctx)
+	}
+	if otlpExporter != nil {
+		go otlpExporter.Start(ctx)
+	}
+	if podWatcher != nil {
+		go func() {
+			if err := podWatcher.Start(ctx); err != nil {
+				setupLog.Error(err, "problem watching pods")
+			}
+		}()
+	}
+	go func() {
+		defer close(clientDone)
+		if elector == nil {
+			startClient(clientCtx)
+			return
+		}
+		// The slurm client cannot be restarted, hence the exporter stops once
+		// the leadership is lost, to campaign again after its restart.
+		defer stopServing()
+		if err := elector.Run(clientCtx, startClient); err != nil {
+			setupLog.Error(err, "problem running leader election")
+		}
+	}()
+
+	// The servers stop together, once any of them stops.
+	var servers errgroup.Group
+	servers.Go(func() error {
+		defer stopServing()
+		return serve(serveCtx, server, listener, flags.ShutdownTimeout)
+	})
+	if externalServer != nil {
+		servers.Go(func() error {
+			defer stopServing()
+			return serve(serveCtx, externalServer, externalListener, flags.ShutdownTimeout)
+		})
+	}
+	if kedaServer != nil {
+		servers.Go(func() error {
+			defer stopServing()
+			return serveGRPC(serveCtx, kedaServer, kedaListener, flags.ShutdownTimeout)
+		})
+	}
+	err = servers.Wait()
+	setupLog.Info("stopping exporter")
+	stopClient()
+	<-clientDone
+	if eventBroadcaster != nil {
+		eventBroadcaster.Shutdown()
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running exporter")
 		os.Exit(1)
 	}
+	if elector != nil && ctx.Err() == nil {
+		setupLog.Info("lost leadership")
+		os.Exit(1)
+	}
+}
+
+// keyValueFlag returns a flag function which parses a repeatable flag value of
+// a key and a value, split by the separator, into the map.
+func keyValueFlag(m map[string]string, sep string) func(string) error {
+	return func(value string) error {
+		key, val, ok := strings.Cut(value, sep)
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return fmt.Errorf("expected 'key%svalue', got %q", sep, value)
+		}
+		m[key] = val
+		return nil
+	}
+}
+
+// newElector returns the leader elector of the exporter replicas.
+func newElector(flags Flags) (*leader.Elector, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	// The replicas may share a hostname, hence it is made unique.
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	identity += "_" + string(uuid.NewUUID())
+	return leader.NewElector(kubeClient, leader.Options{
+		Namespace:     flags.LeaderElectionNamespace,
+		Name:          flags.LeaderElectionLeaseName,
+		Identity:      identity,
+		LeaseDuration: flags.LeaderElectionLeaseDuration,
+		RenewDeadline: flags.LeaderElectionRenewDeadline,
+		RetryPeriod:   flags.LeaderElectionRetryPeriod,
+		Pod:           flags.LeaderElectionPod,
+	})
+}
+
+// newExternalMetricsServer returns the server of the external metrics API and
+// its TLS configuration, which authenticate the requests proxied by the
+// Kubernetes API aggregation layer, and authorize them by SubjectAccessReviews.
+func newExternalMetricsServer(flags Flags, snapshots *collector.Snapshotter, ready func() error) (*externalmetrics.Server, *tls.Config, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	requestHeader, err := externalmetrics.LoadRequestHeader(ctx, kubeClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := externalmetrics.TLSConfig(flags.ExternalMetricsCertFile, flags.ExternalMetricsKeyFile, requestHeader.ClientCA)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := externalmetrics.NewServer(snapshots, ready, requestHeader, kubeClient.AuthorizationV1().SubjectAccessReviews())
+	return server, tlsConfig, nil
+}
+
+// newRemoteWriter returns the writer which pushes the gathered metrics by
+// Prometheus remote write.
+func newRemoteWriter(flags Flags, gatherer func(ctx context.Context) prometheus.Gatherer) (*remotewrite.Writer, error) {
+	return remotewrite.NewWriter(gatherer, remotewrite.Options{
+		URL:             flags.RemoteWriteURL,
+		Interval:        flags.RemoteWriteInterval,
+		Timeout:         flags.RemoteWriteTimeout,
+		Headers:         flags.RemoteWriteHeaders,
+		BearerTokenFile: flags.RemoteWriteBearerTokenFile,
+		ExternalLabels:  flags.RemoteWriteExternalLabels,
+		QueueSize:       flags.RemoteWriteQueueSize,
+		MinBackoff:      flags.RemoteWriteMinBackoff,
+		MaxBackoff:      flags.RemoteWriteMaxBackoff,
+	})
+}
+
+// newOTLPExporter returns the exporter of the gathered metrics by OTLP.
+func newOTLPExporter(flags Flags, gatherer func(ctx context.Context) prometheus.Gatherer, detect otlp.ResourceFunc) (*otlp.Exporter, error) {
+	return otlp.NewExporter(gatherer, detect, otlp.Options{
+		Endpoint:           flags.OTLPEndpoint,
+		Protocol:           flags.OTLPProtocol,
+		Insecure:           flags.OTLPInsecure,
+		Interval:           flags.OTLPInterval,
+		Timeout:            flags.OTLPTimeout,
+		Headers:            flags.OTLPHeaders,
+		ResourceAttributes: flags.OTLPResourceAttributes,
+	})
+}
+
+// newEventBroadcaster returns the broadcaster of the Kubernetes Events.
+func newEventBroadcaster() (record.EventBroadcaster, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return events.NewBroadcaster(kubeClient), nil
+}
+
+// newPodWatcher returns the watcher of the pods of the Slurm nodes.
+func newPodWatcher(flags Flags) (*pods.Watcher, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	selector, err := labels.Parse(flags.PodLabelsSelector)
+	if err != nil {
+		return nil, err
+	}
+	return pods.NewWatcher(restConfig, pods.Options{
+		Namespace: flags.PodLabelsNamespace,
+		Selector:  selector,
+	})
+}
+
+// serve serves on the listener until the context is done, then shuts the
+// server down, waiting up to the timeout for the ongoing requests to finish.
+func serve(ctx context.Context, server *http.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
+
+// serveGRPC serves on the listener until the context is done, then stops the
+// server gracefully, waiting up to the timeout for the ongoing calls (e.g.
+// streams) to finish before closing them.
+func serveGRPC(ctx context.Context, server *grpc.Server, listener net.Listener, shutdownTimeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		server.GracefulStop()
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		server.Stop()
+	}
+	return <-errCh
+}
+
+// healthz responds that the exporter is alive.
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+}
+
+// readyz responds whether the exporter is ready to serve the Slurm metrics.
+func readyz(ready func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		if err := ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}
 }
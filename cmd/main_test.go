@@ -4,16 +4,30 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 func Test_parseFlags(t *testing.T) {
 	flags := Flags{}
-	os.Args = []string{"test", "--metrics-bind-address", "8081", "--server", "foo", "--cache-freq", "10s"}
+	os.Args = []string{"test", "--metrics-bind-address", "8081", "--server", "foo", "--cache-freq", "10s", "--cache-freq.jobs", "5s", "--cache-freq.stats", "30s", "--full-sync-freq", "5m", "--scheduler-counters", "--metrics.schema", "v2", "--precompute", "--metrics.cache-ttl", "5s", "--stale-grace-period", "2m", "--breaker.failures", "3", "--breaker.backoff", "20s", "--breaker.max-backoff", "10m", "--http.read-timeout", "5s", "--http.write-timeout", "1m", "--http.idle-timeout", "3m", "--http.max-concurrent-scrapes", "4", "--shutdown-timeout", "15s", "--leader-election", "--leader-election.namespace", "slurm", "--leader-election.lease-name", "exporter", "--leader-election.lease-duration", "30s", "--leader-election.renew-deadline", "20s", "--leader-election.retry-period", "5s", "--leader-election.pod", "slurm-exporter-0", "--keda.bind-address", ":9090", "--external-metrics.bind-address", ":6443", "--external-metrics.tls-cert-file", "tls.crt", "--external-metrics.tls-key-file", "tls.key", "--remote-write.url", "http://prometheus:9090/api/v1/write", "--remote-write.interval", "1m", "--remote-write.header", "X-Scope-OrgID: slurm", "--remote-write.external-label", "cluster=prod", "--remote-write.external-label", "job = slurm-exporter", "--remote-write.queue-size", "5", "--otlp.endpoint", "collector:4317", "--otlp.protocol", "http/protobuf", "--otlp.insecure", "--otlp.header", "Authorization: Bearer secret", "--otlp.resource-attribute", "deployment.environment=prod"}
 	parseFlags(&flags)
 	if flags.MetricsAddr != "8081" {
 		t.Errorf("Test_parseFlags() MetricsAddr = %v, want %v", flags.MetricsAddr, "8081")
@@ -24,4 +38,280 @@ func Test_parseFlags(t *testing.T) {
 	if flags.CacheFreq != time.Second*10 {
 		t.Errorf("Test_parseFlags() CacheFreq = %v, want %v", flags.CacheFreq, time.Second*10)
 	}
+	if flags.JobsCacheFreq != time.Second*5 {
+		t.Errorf("Test_parseFlags() JobsCacheFreq = %v, want %v", flags.JobsCacheFreq, time.Second*5)
+	}
+	if flags.NodesCacheFreq != 0 {
+		t.Errorf("Test_parseFlags() NodesCacheFreq = %v, want %v", flags.NodesCacheFreq, 0)
+	}
+	if flags.StatsCacheFreq != time.Second*30 {
+		t.Errorf("Test_parseFlags() StatsCacheFreq = %v, want %v", flags.StatsCacheFreq, time.Second*30)
+	}
+	if flags.FullSyncFreq != time.Minute*5 {
+		t.Errorf("Test_parseFlags() FullSyncFreq = %v, want %v", flags.FullSyncFreq, time.Minute*5)
+	}
+	if !flags.SchedulerCounters {
+		t.Errorf("Test_parseFlags() SchedulerCounters = %v, want %v", flags.SchedulerCounters, true)
+	}
+	if flags.MetricsSchema != "v2" {
+		t.Errorf("Test_parseFlags() MetricsSchema = %v, want %v", flags.MetricsSchema, "v2")
+	}
+	if !flags.Precompute {
+		t.Errorf("Test_parseFlags() Precompute = %v, want %v", flags.Precompute, true)
+	}
+	if flags.CacheTTL != time.Second*5 {
+		t.Errorf("Test_parseFlags() CacheTTL = %v, want %v", flags.CacheTTL, time.Second*5)
+	}
+	if flags.StaleGracePeriod != time.Minute*2 {
+		t.Errorf("Test_parseFlags() StaleGracePeriod = %v, want %v", flags.StaleGracePeriod, time.Minute*2)
+	}
+	if flags.BreakerFailures != 3 {
+		t.Errorf("Test_parseFlags() BreakerFailures = %v, want %v", flags.BreakerFailures, 3)
+	}
+	if flags.BreakerBackoff != time.Second*20 {
+		t.Errorf("Test_parseFlags() BreakerBackoff = %v, want %v", flags.BreakerBackoff, time.Second*20)
+	}
+	if flags.BreakerMaxBackoff != time.Minute*10 {
+		t.Errorf("Test_parseFlags() BreakerMaxBackoff = %v, want %v", flags.BreakerMaxBackoff, time.Minute*10)
+	}
+	if flags.ReadTimeout != time.Second*5 {
+		t.Errorf("Test_parseFlags() ReadTimeout = %v, want %v", flags.ReadTimeout, time.Second*5)
+	}
+	if flags.WriteTimeout != time.Minute {
+		t.Errorf("Test_parseFlags() WriteTimeout = %v, want %v", flags.WriteTimeout, time.Minute)
+	}
+	if flags.IdleTimeout != time.Minute*3 {
+		t.Errorf("Test_parseFlags() IdleTimeout = %v, want %v", flags.IdleTimeout, time.Minute*3)
+	}
+	if flags.MaxConcurrentScrapes != 4 {
+		t.Errorf("Test_parseFlags() MaxConcurrentScrapes = %v, want %v", flags.MaxConcurrentScrapes, 4)
+	}
+	if flags.ShutdownTimeout != time.Second*15 {
+		t.Errorf("Test_parseFlags() ShutdownTimeout = %v, want %v", flags.ShutdownTimeout, time.Second*15)
+	}
+	if !flags.LeaderElection {
+		t.Errorf("Test_parseFlags() LeaderElection = %v, want %v", flags.LeaderElection, true)
+	}
+	if flags.LeaderElectionNamespace != "slurm" {
+		t.Errorf("Test_parseFlags() LeaderElectionNamespace = %v, want %v", flags.LeaderElectionNamespace, "slurm")
+	}
+	if flags.LeaderElectionLeaseName != "exporter" {
+		t.Errorf("Test_parseFlags() LeaderElectionLeaseName = %v, want %v", flags.LeaderElectionLeaseName, "exporter")
+	}
+	if flags.LeaderElectionLeaseDuration != time.Second*30 {
+		t.Errorf("Test_parseFlags() LeaderElectionLeaseDuration = %v, want %v", flags.LeaderElectionLeaseDuration, time.Second*30)
+	}
+	if flags.LeaderElectionRenewDeadline != time.Second*20 {
+		t.Errorf("Test_parseFlags() LeaderElectionRenewDeadline = %v, want %v", flags.LeaderElectionRenewDeadline, time.Second*20)
+	}
+	if flags.LeaderElectionRetryPeriod != time.Second*5 {
+		t.Errorf("Test_parseFlags() LeaderElectionRetryPeriod = %v, want %v", flags.LeaderElectionRetryPeriod, time.Second*5)
+	}
+	if flags.LeaderElectionPod != "slurm-exporter-0" {
+		t.Errorf("Test_parseFlags() LeaderElectionPod = %v, want %v", flags.LeaderElectionPod, "slurm-exporter-0")
+	}
+	if flags.ExternalMetricsAddr != ":6443" {
+		t.Errorf("Test_parseFlags() ExternalMetricsAddr = %v, want %v", flags.ExternalMetricsAddr, ":6443")
+	}
+	if flags.ExternalMetricsCertFile != "tls.crt" {
+		t.Errorf("Test_parseFlags() ExternalMetricsCertFile = %v, want %v", flags.ExternalMetricsCertFile, "tls.crt")
+	}
+	if flags.ExternalMetricsKeyFile != "tls.key" {
+		t.Errorf("Test_parseFlags() ExternalMetricsKeyFile = %v, want %v", flags.ExternalMetricsKeyFile, "tls.key")
+	}
+	if flags.KedaAddr != ":9090" {
+		t.Errorf("Test_parseFlags() KedaAddr = %v, want %v", flags.KedaAddr, ":9090")
+	}
+	if flags.RemoteWriteURL != "http://prometheus:9090/api/v1/write" {
+		t.Errorf("Test_parseFlags() RemoteWriteURL = %v, want %v", flags.RemoteWriteURL, "http://prometheus:9090/api/v1/write")
+	}
+	if flags.RemoteWriteInterval != time.Minute {
+		t.Errorf("Test_parseFlags() RemoteWriteInterval = %v, want %v", flags.RemoteWriteInterval, time.Minute)
+	}
+	wantHeaders := map[string]string{"X-Scope-OrgID": "slurm"}
+	if !maps.Equal(flags.RemoteWriteHeaders, wantHeaders) {
+		t.Errorf("Test_parseFlags() RemoteWriteHeaders = %v, want %v", flags.RemoteWriteHeaders, wantHeaders)
+	}
+	wantExternalLabels := map[string]string{"cluster": "prod", "job": "slurm-exporter"}
+	if !maps.Equal(flags.RemoteWriteExternalLabels, wantExternalLabels) {
+		t.Errorf("Test_parseFlags() RemoteWriteExternalLabels = %v, want %v", flags.RemoteWriteExternalLabels, wantExternalLabels)
+	}
+	if flags.RemoteWriteQueueSize != 5 {
+		t.Errorf("Test_parseFlags() RemoteWriteQueueSize = %v, want %v", flags.RemoteWriteQueueSize, 5)
+	}
+	if flags.OTLPEndpoint != "collector:4317" {
+		t.Errorf("Test_parseFlags() OTLPEndpoint = %v, want %v", flags.OTLPEndpoint, "collector:4317")
+	}
+	if flags.OTLPProtocol != "http/protobuf" {
+		t.Errorf("Test_parseFlags() OTLPProtocol = %v, want %v", flags.OTLPProtocol, "http/protobuf")
+	}
+	if !flags.OTLPInsecure {
+		t.Errorf("Test_parseFlags() OTLPInsecure = %v, want %v", flags.OTLPInsecure, true)
+	}
+	wantOTLPHeaders := map[string]string{"Authorization": "Bearer secret"}
+	if !maps.Equal(flags.OTLPHeaders, wantOTLPHeaders) {
+		t.Errorf("Test_parseFlags() OTLPHeaders = %v, want %v", flags.OTLPHeaders, wantOTLPHeaders)
+	}
+	wantResourceAttributes := map[string]string{"deployment.environment": "prod"}
+	if !maps.Equal(flags.OTLPResourceAttributes, wantResourceAttributes) {
+		t.Errorf("Test_parseFlags() OTLPResourceAttributes = %v, want %v", flags.OTLPResourceAttributes, wantResourceAttributes)
+	}
+}
+
+func TestFlags_MarshalLog(t *testing.T) {
+	flags := Flags{
+		Server:             "http://slurmrestd:6820",
+		RemoteWriteHeaders: map[string]string{"X-Scope-OrgID": "tenant-secret"},
+		OTLPHeaders:        map[string]string{"Authorization": "Bearer secret"},
+	}
+	var out bytes.Buffer
+	zap.New(zap.WriteTo(&out)).Info("With", "Flags", flags)
+	logged := out.String()
+	if !strings.Contains(logged, "http://slurmrestd:6820") || !strings.Contains(logged, "X-Scope-OrgID") || !strings.Contains(logged, "Authorization") {
+		t.Errorf("TestFlags_MarshalLog() logged = %v, want the flags and header names", logged)
+	}
+	if strings.Contains(logged, "secret") {
+		t.Errorf("TestFlags_MarshalLog() logged = %v, want no header values", logged)
+	}
+}
+
+func Test_keyValueFlag(t *testing.T) {
+	m := map[string]string{}
+	set := keyValueFlag(m, "=")
+	if err := set("cluster=prod"); err != nil {
+		t.Errorf("Test_keyValueFlag() error = %v", err)
+	}
+	if m["cluster"] != "prod" {
+		t.Errorf("Test_keyValueFlag() cluster = %v, want %v", m["cluster"], "prod")
+	}
+	for _, value := range []string{"cluster", "=prod"} {
+		if err := set(value); err == nil {
+			t.Errorf("Test_keyValueFlag(%q) error = nil, want an error", value)
+		}
+	}
+}
+
+func Test_healthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("healthz() code = %v, want %v", w.Code, http.StatusOK)
+	}
+}
+
+func Test_readyz(t *testing.T) {
+	tests := []struct {
+		name     string
+		ready    func() error
+		wantCode int
+	}{
+		{
+			name:     "ready",
+			ready:    func() error { return nil },
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "not ready",
+			ready:    func() error { return errors.New("cache has not synced yet") },
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			readyz(tt.ready)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.wantCode {
+				t.Errorf("readyz() code = %v, want %v", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_serve(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			_, _ = w.Write([]byte("ok"))
+		}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, server, listener, time.Minute)
+	}()
+
+	// An ongoing scrape is drained on shutdown.
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serve() returned before the scrape finished, error = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if got := <-body; got != "ok" {
+		t.Errorf("scrape = %v, want %v", got, "ok")
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+}
+
+func Test_serveGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serveGRPC(ctx, server, listener, 50*time.Millisecond)
+	}()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+
+	// An ongoing stream is closed once the shutdown timeout is reached.
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serveGRPC() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("serveGRPC() did not return after the shutdown timeout")
+	}
+	if _, err := stream.Recv(); err == nil {
+		t.Errorf("Recv() error = nil, want the stream to be closed")
+	}
 }
@@ -60,6 +60,28 @@ app.kubernetes.io/name: slurm-exporter
 app.kubernetes.io/instance: {{ include "slurm-exporter.name" . }}
 {{- end }}
 
+{{/*
+Define exporter leaderSelectorLabels, which select the leader pod when leader
+election is enabled, otherwise every pod.
+*/}}
+{{- define "slurm-exporter.leaderSelectorLabels" -}}
+{{ include "slurm-exporter.selectorLabels" . }}
+{{- if .Values.exporter.leaderElection.enabled }}
+slinky.slurm.net/slurm-exporter-leader: "true"
+{{- end }}
+{{- end }}
+
+{{/*
+Define the secret of the TLS certificate of the external metrics API
+*/}}
+{{- define "slurm-exporter.externalMetrics.tlsSecretName" -}}
+{{- if .Values.exporter.externalMetrics.certManager.enabled }}
+{{- printf "%s-external-metrics-tls" (include "slurm-exporter.name" .) }}
+{{- else }}{{- /* if .Values.exporter.externalMetrics.certManager.enabled */}}
+{{- required "exporter.externalMetrics.tlsSecretName is required unless exporter.externalMetrics.certManager.enabled" .Values.exporter.externalMetrics.tlsSecretName }}
+{{- end }}{{- /* if .Values.exporter.externalMetrics.certManager.enabled */}}
+{{- end }}
+
 {{/*
 Common imagePullPolicy
 */}}
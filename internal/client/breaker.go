@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// The states of a Breaker.
+const (
+	// Requests are sent to slurmrestd.
+	BreakerStateClosed = "closed"
+	// Requests are rejected, until the backoff has passed.
+	BreakerStateOpen = "open"
+	// A single request probes whether slurmrestd recovered.
+	BreakerStateHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned for requests which the Breaker rejects.
+var ErrCircuitOpen = errors.New("circuit breaker is open, slurmrestd is backed off")
+
+// BreakerOptions configure a Breaker.
+type BreakerOptions struct {
+	// Failures is the number of consecutive failures which open the circuit.
+	Failures int
+	// Backoff is how long the circuit stays open after it opened, doubling on
+	// every failed probe.
+	Backoff time.Duration
+	// MaxBackoff caps the Backoff.
+	MaxBackoff time.Duration
+}
+
+// Breaker is a circuit breaker for the requests to slurmrestd, such that an
+// overloaded slurmctld is not polled at the full cache frequency.
+//
+// Errors and HTTP 5xx and 429 responses are failures. After Failures
+// consecutive failures the circuit opens, and every request is rejected until
+// the backoff has passed. Then a single request probes slurmrestd: if it
+// succeeds the circuit closes, otherwise it opens again with twice the backoff
+// (or as long as slurmrestd asks for by Retry-After).
+type Breaker struct {
+	options BreakerOptions
+	clock   clock.PassiveClock
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	retryAt  time.Time
+}
+
+func NewBreaker(options BreakerOptions) (*Breaker, error) {
+	return newBreaker(options, clock.RealClock{})
+}
+
+func newBreaker(options BreakerOptions, clk clock.PassiveClock) (*Breaker, error) {
+	if options.Failures <= 0 {
+		return nil, errors.New("breaker failures > 0")
+	}
+	if options.Backoff <= 0 {
+		return nil, errors.New("breaker backoff > 0")
+	}
+	if options.MaxBackoff < options.Backoff {
+		return nil, errors.New("breaker max-backoff >= backoff")
+	}
+	return &Breaker{
+		options: options,
+		clock:   clk,
+		state:   BreakerStateClosed,
+	}, nil
+}
+
+// State returns the state of the circuit.
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// ConsecutiveFailures returns the number of failures since the last success.
+func (b *Breaker) ConsecutiveFailures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// Transport returns a transport which sends the requests to next, unless the
+// circuit is open.
+func (b *Breaker) Transport(next http.RoundTripper) http.RoundTripper {
+	return &breakerTransport{
+		breaker: b,
+		next:    next,
+	}
+}
+
+// allow returns whether a request may be sent.
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerStateOpen:
+		if b.clock.Now().Before(b.retryAt) {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerStateHalfOpen
+		return nil
+	case BreakerStateHalfOpen:
+		// The probe is ongoing.
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+// record accounts for the outcome of a request.
+func (b *Breaker) record(resp *http.Response, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil && resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+		b.state = BreakerStateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state != BreakerStateHalfOpen && b.failures < b.options.Failures {
+		return
+	}
+	b.state = BreakerStateOpen
+	backoff := b.backoff()
+	if resp != nil {
+		backoff = max(backoff, retryAfter(resp))
+	}
+	b.retryAt = b.clock.Now().Add(min(backoff, b.options.MaxBackoff))
+}
+
+// abort returns the circuit to open after a probe was cancelled.
+func (b *Breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerStateHalfOpen {
+		b.state = BreakerStateOpen
+	}
+}
+
+// backoff doubles the Backoff for every failure beyond the ones which opened
+// the circuit.
+func (b *Breaker) backoff() time.Duration {
+	backoff := b.options.Backoff
+	for range b.failures - b.options.Failures {
+		backoff *= 2
+		if backoff >= b.options.MaxBackoff {
+			return b.options.MaxBackoff
+		}
+	}
+	return backoff
+}
+
+// retryAfter returns the delay which the response asks for, in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+type breakerTransport struct {
+	breaker *Breaker
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		// The request was cancelled, which says nothing about slurmrestd. A
+		// probe which was cancelled is retried by the next request.
+		t.breaker.abort()
+		return resp, err
+	}
+	t.breaker.record(resp, err)
+	return resp, err
+}
@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestNewBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		options BreakerOptions
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			options: BreakerOptions{Failures: 3, Backoff: time.Second, MaxBackoff: time.Minute},
+		},
+		{
+			name:    "no failures",
+			options: BreakerOptions{Failures: 0, Backoff: time.Second, MaxBackoff: time.Minute},
+			wantErr: true,
+		},
+		{
+			name:    "no backoff",
+			options: BreakerOptions{Failures: 3, Backoff: 0, MaxBackoff: time.Minute},
+			wantErr: true,
+		},
+		{
+			name:    "max backoff below backoff",
+			options: BreakerOptions{Failures: 3, Backoff: time.Minute, MaxBackoff: time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewBreaker(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewBreaker() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, BreakerStateClosed, got.State())
+			}
+		})
+	}
+}
+
+// fakeResponder responds with its status, or fails while err is set, and
+// counts the requests.
+type fakeResponder struct {
+	status     int
+	retryAfter string
+	err        error
+	requests   int
+}
+
+func (f *fakeResponder) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.requests++
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp := &http.Response{StatusCode: f.status, Body: http.NoBody, Header: http.Header{}}
+	if f.retryAfter != "" {
+		resp.Header.Set("Retry-After", f.retryAfter)
+	}
+	return resp, nil
+}
+
+func newTestBreaker(t *testing.T) (*Breaker, *clocktesting.FakePassiveClock) {
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	breaker, err := newBreaker(BreakerOptions{Failures: 2, Backoff: 10 * time.Second, MaxBackoff: 30 * time.Second}, clk)
+	if err != nil {
+		t.Fatalf("newBreaker() error = %v", err)
+	}
+	return breaker, clk
+}
+
+func roundTrip(transport http.RoundTripper) error {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/slurm/v0.0.43/diag/", nil)
+	_, err := transport.RoundTrip(req)
+	return err
+}
+
+func TestBreaker(t *testing.T) {
+	breaker, clk := newTestBreaker(t)
+	next := &fakeResponder{status: http.StatusOK}
+	transport := breaker.Transport(next)
+
+	assert.NoError(t, roundTrip(transport))
+
+	// Opens after consecutive failures.
+	next.status = http.StatusServiceUnavailable
+	assert.NoError(t, roundTrip(transport))
+	assert.Equal(t, BreakerStateClosed, breaker.State())
+	next.err = errors.New("connection refused")
+	assert.Error(t, roundTrip(transport))
+	assert.Equal(t, BreakerStateOpen, breaker.State())
+	assert.Equal(t, 2, breaker.ConsecutiveFailures())
+	assert.Equal(t, 3, next.requests)
+
+	// Rejected until the backoff has passed.
+	clk.SetTime(clk.Now().Add(9 * time.Second))
+	assert.ErrorIs(t, roundTrip(transport), ErrCircuitOpen)
+	assert.Equal(t, 3, next.requests)
+
+	// A failed probe doubles the backoff.
+	clk.SetTime(clk.Now().Add(time.Second))
+	assert.Error(t, roundTrip(transport))
+	assert.Equal(t, 4, next.requests)
+	assert.Equal(t, BreakerStateOpen, breaker.State())
+	clk.SetTime(clk.Now().Add(19 * time.Second))
+	assert.ErrorIs(t, roundTrip(transport), ErrCircuitOpen)
+
+	// Up to the max backoff.
+	clk.SetTime(clk.Now().Add(time.Second))
+	assert.Error(t, roundTrip(transport))
+	clk.SetTime(clk.Now().Add(29 * time.Second))
+	assert.ErrorIs(t, roundTrip(transport), ErrCircuitOpen)
+	clk.SetTime(clk.Now().Add(time.Second))
+	assert.Error(t, roundTrip(transport))
+	assert.Equal(t, 6, next.requests)
+
+	// A successful probe closes the circuit.
+	clk.SetTime(clk.Now().Add(30 * time.Second))
+	next.err = nil
+	next.status = http.StatusOK
+	assert.NoError(t, roundTrip(transport))
+	assert.Equal(t, BreakerStateClosed, breaker.State())
+	assert.Equal(t, 0, breaker.ConsecutiveFailures())
+}
+
+func TestBreaker_tooManyRequests(t *testing.T) {
+	breaker, clk := newTestBreaker(t)
+	next := &fakeResponder{status: http.StatusTooManyRequests, retryAfter: "20"}
+	transport := breaker.Transport(next)
+
+	assert.NoError(t, roundTrip(transport))
+	assert.NoError(t, roundTrip(transport))
+	assert.Equal(t, BreakerStateOpen, breaker.State())
+
+	// Backed off as long as Retry-After asks for.
+	clk.SetTime(clk.Now().Add(19 * time.Second))
+	assert.ErrorIs(t, roundTrip(transport), ErrCircuitOpen)
+	clk.SetTime(clk.Now().Add(time.Second))
+	assert.NoError(t, roundTrip(transport))
+	assert.Equal(t, 3, next.requests)
+}
+
+func TestBreaker_halfOpen(t *testing.T) {
+	breaker, clk := newTestBreaker(t)
+	next := &fakeResponder{status: http.StatusInternalServerError}
+	transport := breaker.Transport(next)
+	assert.NoError(t, roundTrip(transport))
+	assert.NoError(t, roundTrip(transport))
+	clk.SetTime(clk.Now().Add(10 * time.Second))
+
+	// Only a single probe is sent at a time.
+	assert.NoError(t, breaker.allow())
+	assert.Equal(t, BreakerStateHalfOpen, breaker.State())
+	assert.ErrorIs(t, roundTrip(transport), ErrCircuitOpen)
+	assert.Equal(t, 2, next.requests)
+}
+
+func TestBreaker_cancelled(t *testing.T) {
+	breaker, clk := newTestBreaker(t)
+	next := &fakeResponder{status: http.StatusInternalServerError}
+	transport := breaker.Transport(next)
+	assert.NoError(t, roundTrip(transport))
+	assert.NoError(t, roundTrip(transport))
+	clk.SetTime(clk.Now().Add(10 * time.Second))
+
+	// A cancelled probe is not a failure, and is retried by the next request.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	next.err = context.Canceled
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/slurm/v0.0.43/diag/", nil).WithContext(ctx)
+	_, err := transport.RoundTrip(req)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, BreakerStateOpen, breaker.State())
+	assert.Equal(t, 2, breaker.ConsecutiveFailures())
+
+	next.err = nil
+	next.status = http.StatusOK
+	assert.NoError(t, roundTrip(transport))
+	assert.Equal(t, BreakerStateClosed, breaker.State())
+}
@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	slurmapi "github.com/SlinkyProject/slurm-client/pkg/client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+)
+
+// CacheIntervals are the refresh intervals of the cached slurm objects.
+type CacheIntervals struct {
+	Jobs       time.Duration
+	Nodes      time.Duration
+	Partitions time.Duration
+	Stats      time.Duration
+}
+
+// WithDefault returns the intervals, where unset intervals are interval.
+func (i CacheIntervals) WithDefault(interval time.Duration) CacheIntervals {
+	for _, d := range []*time.Duration{&i.Jobs, &i.Nodes, &i.Partitions, &i.Stats} {
+		if *d == 0 {
+			*d = interval
+		}
+	}
+	return i
+}
+
+// ByType returns the intervals by the name of their object type.
+func (i CacheIntervals) ByType() map[string]time.Duration {
+	return map[string]time.Duration{
+		"jobs":       i.Jobs,
+		"nodes":      i.Nodes,
+		"partitions": i.Partitions,
+		"stats":      i.Stats,
+	}
+}
+
+// cachedClient routes the requests of each cached object type to the client
+// which caches it, such that each object type is refreshed at its own
+// interval. Object types which the slurm client cannot cache are kept in a
+// listCache instead. Anything else goes to the embedded client.
+type cachedClient struct {
+	client.Client
+
+	clients map[object.ObjectType]client.Client
+	lists   map[object.ObjectType]*listCache
+	// Records whether slurmrestd rejects the token, if set.
+	auth *authTransport
+	// Pings slurmctld for the controller info, if set.
+	ping pingClient
+}
+
+var _ client.Client = &cachedClient{}
+
+func baseType(objectType object.ObjectType) object.ObjectType {
+	return object.ObjectType(strings.TrimSuffix(string(objectType), "List"))
+}
+
+func (c *cachedClient) clientFor(objectType object.ObjectType) client.Client {
+	if slurmClient, ok := c.clients[baseType(objectType)]; ok {
+		return slurmClient
+	}
+	return c.Client
+}
+
+// Get implements client.Client.
+func (c *cachedClient) Get(ctx context.Context, key object.ObjectKey, obj object.Object, opts ...client.GetOption) error {
+	return c.clientFor(obj.GetType()).Get(ctx, key, obj, opts...)
+}
+
+// List implements client.Client.
+func (c *cachedClient) List(ctx context.Context, list object.ObjectList, opts ...client.ListOption) error {
+	if cache, ok := c.lists[baseType(list.GetType())]; ok {
+		options := &client.ListOptions{}
+		options.ApplyOptions(opts)
+		return cache.List(ctx, list, options.SkipCache)
+	}
+	return c.clientFor(list.GetType()).List(ctx, list, opts...)
+}
+
+// Create implements client.Client.
+func (c *cachedClient) Create(ctx context.Context, obj object.Object, req any, opts ...client.CreateOption) error {
+	return c.clientFor(obj.GetType()).Create(ctx, obj, req, opts...)
+}
+
+// Update implements client.Client.
+func (c *cachedClient) Update(ctx context.Context, obj object.Object, req any, opts ...client.UpdateOption) error {
+	return c.clientFor(obj.GetType()).Update(ctx, obj, req, opts...)
+}
+
+// Delete implements client.Client.
+func (c *cachedClient) Delete(ctx context.Context, obj object.Object, opts ...client.DeleteOption) error {
+	return c.clientFor(obj.GetType()).Delete(ctx, obj, opts...)
+}
+
+// GetInformer implements client.Client. Object types kept in a listCache have
+// no informer.
+func (c *cachedClient) GetInformer(objectType object.ObjectType) client.InformerCache {
+	if _, ok := c.lists[baseType(objectType)]; ok {
+		return nil
+	}
+	return c.clientFor(objectType).GetInformer(objectType)
+}
+
+// Start implements client.Client. It starts every cache and blocks until the
+// context is done.
+func (c *cachedClient) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, slurmClient := range c.clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slurmClient.Start(ctx)
+		}()
+	}
+	for _, cache := range c.lists {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Start(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// Stop implements client.Client.
+func (c *cachedClient) Stop() {
+	for _, slurmClient := range c.clients {
+		slurmClient.Stop()
+	}
+	c.Client.Stop()
+}
+
+// listCache keeps the list of an object type, refreshed every period.
+type listCache struct {
+	list   func(ctx context.Context) (object.ObjectList, error)
+	period time.Duration
+
+	mu     sync.RWMutex
+	cached object.ObjectList
+	err    error
+}
+
+func newListCache(period time.Duration, list func(ctx context.Context) (object.ObjectList, error)) *listCache {
+	return &listCache{
+		list:   list,
+		period: period,
+	}
+}
+
+// Start refreshes the list every period until the context is done.
+func (c *listCache) Start(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("listCache")
+
+	ticker := time.NewTicker(c.period)
+	defer ticker.Stop()
+	for {
+		if err := c.refresh(ctx); err != nil {
+			logger.Error(err, "failed to refresh cache")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *listCache) refresh(ctx context.Context) error {
+	list, err := c.list(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.err = err
+	if err == nil {
+		c.cached = list
+	}
+	return err
+}
+
+// hasSynced returns whether the list was ever refreshed successfully.
+func (c *listCache) hasSynced() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cached != nil
+}
+
+// List copies the cached items into list. The list is refreshed first if it
+// was never refreshed, or if skipCache is set. The error of the last refresh
+// is returned while it fails.
+func (c *listCache) List(ctx context.Context, list object.ObjectList, skipCache bool) error {
+	c.mu.RLock()
+	refreshed := c.cached != nil || c.err != nil
+	c.mu.RUnlock()
+	if skipCache || !refreshed {
+		if err := c.refresh(ctx); err != nil {
+			return err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.err != nil {
+		return c.err
+	}
+	if c.cached.GetType() != list.GetType() {
+		return fmt.Errorf("cannot list %s from a cache of %s", list.GetType(), c.cached.GetType())
+	}
+	for _, item := range c.cached.GetItems() {
+		list.AppendItem(item.DeepCopyObject())
+	}
+	return nil
+}
+
+// statsList lists the slurmctld statistics, which the slurm client cannot list
+// by itself.
+func statsList(stats slurmapi.StatsInterface) func(ctx context.Context) (object.ObjectList, error) {
+	return func(ctx context.Context) (object.ObjectList, error) {
+		return stats.ListStats(ctx)
+	}
+}
@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+func TestCacheIntervals_WithDefault(t *testing.T) {
+	got := CacheIntervals{Jobs: 5 * time.Second, Partitions: time.Minute}.WithDefault(15 * time.Second)
+	want := CacheIntervals{
+		Jobs:       5 * time.Second,
+		Nodes:      15 * time.Second,
+		Partitions: time.Minute,
+		Stats:      15 * time.Second,
+	}
+	assert.Equal(t, want, got)
+	assert.Equal(t, map[string]time.Duration{
+		"jobs":       5 * time.Second,
+		"nodes":      15 * time.Second,
+		"partitions": time.Minute,
+		"stats":      15 * time.Second,
+	}, got.ByType())
+}
+
+// newNamedClient returns a client whose lists hold one node, named after the
+// client.
+func newNamedClient(name string) client.Client {
+	return fake.NewClientBuilder().
+		WithLists(&types.V0043NodeList{Items: []types.V0043Node{
+			{V0043Node: api.V0043Node{Name: ptr.To(name)}},
+		}}).
+		Build()
+}
+
+func TestCachedClient(t *testing.T) {
+	stats := &types.V0043StatsList{Items: []types.V0043Stats{
+		{V0043StatsMsg: api.V0043StatsMsg{JobsRunning: ptr.To[int32](3)}},
+	}}
+	c := &cachedClient{
+		Client: newNamedClient("default"),
+		clients: map[object.ObjectType]client.Client{
+			types.ObjectTypeV0043Node: newNamedClient("nodes"),
+		},
+		lists: map[object.ObjectType]*listCache{
+			types.ObjectTypeV0043Stats: newListCache(time.Hour, func(_ context.Context) (object.ObjectList, error) {
+				return stats, nil
+			}),
+		},
+	}
+
+	nodeList := &types.V0043NodeList{}
+	assert.NoError(t, c.List(context.TODO(), nodeList))
+	assert.Equal(t, "nodes", string(nodeList.Items[0].GetKey()))
+
+	node := &types.V0043Node{}
+	assert.NoError(t, c.Get(context.TODO(), "nodes", node))
+	assert.Equal(t, "nodes", string(node.GetKey()))
+
+	statsList := &types.V0043StatsList{}
+	assert.NoError(t, c.List(context.TODO(), statsList))
+	assert.Equal(t, stats, statsList)
+	assert.Nil(t, c.GetInformer(types.ObjectTypeV0043Stats))
+
+	partitionList := &types.V0043PartitionInfoList{}
+	assert.NoError(t, c.List(context.TODO(), partitionList))
+	assert.Empty(t, partitionList.Items)
+}
+
+func TestListCache_List(t *testing.T) {
+	var calls atomic.Int32
+	var fail atomic.Bool
+	cache := newListCache(time.Hour, func(_ context.Context) (object.ObjectList, error) {
+		n := calls.Add(1)
+		if fail.Load() {
+			return nil, errors.New("failure")
+		}
+		return &types.V0043NodeList{Items: []types.V0043Node{
+			{V0043Node: api.V0043Node{Name: ptr.To(fmt.Sprintf("node%d", n))}},
+		}}, nil
+	})
+	list := func(skipCache bool) (string, error) {
+		nodeList := &types.V0043NodeList{}
+		if err := cache.List(context.TODO(), nodeList, skipCache); err != nil {
+			return "", err
+		}
+		return string(nodeList.Items[0].GetKey()), nil
+	}
+
+	// Refreshed on first use only.
+	got, err := list(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", got)
+	got, err = list(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", got)
+
+	got, err = list(true)
+	assert.NoError(t, err)
+	assert.Equal(t, "node2", got)
+
+	// The failure is returned until the next successful refresh.
+	fail.Store(true)
+	_, err = list(true)
+	assert.Error(t, err)
+	_, err = list(false)
+	assert.Error(t, err)
+	fail.Store(false)
+	assert.NoError(t, cache.refresh(context.TODO()))
+	got, err = list(false)
+	assert.NoError(t, err)
+	assert.Equal(t, "node4", got)
+
+	err = cache.List(context.TODO(), &types.V0043JobInfoList{}, false)
+	assert.Error(t, err)
+}
+
+func TestListCache_Start(t *testing.T) {
+	var calls atomic.Int32
+	cache := newListCache(10*time.Millisecond, func(_ context.Context) (object.ObjectList, error) {
+		calls.Add(1)
+		return &types.V0043StatsList{}, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.Start(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return calls.Load() >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestCachedClient_Start(t *testing.T) {
+	var started atomic.Int32
+	c := &cachedClient{
+		Client: fake.NewFakeClient(),
+		clients: map[object.ObjectType]client.Client{
+			types.ObjectTypeV0043Node: fake.NewFakeClient(),
+		},
+		lists: map[object.ObjectType]*listCache{
+			types.ObjectTypeV0043Stats: newListCache(time.Hour, func(_ context.Context) (object.ObjectList, error) {
+				started.Add(1)
+				return &types.V0043StatsList{}, nil
+			}),
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Start(ctx)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return started.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
@@ -6,29 +6,52 @@ package client
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
 	"os"
+	"sync"
 	"time"
 
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/clock"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/SlinkyProject/slurm-client/pkg/client"
+	slurmapi "github.com/SlinkyProject/slurm-client/pkg/client/api/v0043"
 	"github.com/SlinkyProject/slurm-client/pkg/object"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
 )
 
+// The slurm objects which the slurm client keeps a cache of.
+var cachedObjects = []object.Object{
+	&types.V0043JobInfo{},
+	&types.V0043Node{},
+	&types.V0043PartitionInfo{},
+}
+
 // Initialize the slurm client to talk to slurmrestd.
 // Requires that the env SLURM_JWT is set.
-func NewSlurmClient(server string, cacheFreq time.Duration) (client.Client, error) {
-	ctx := context.Background()
-	logger := log.FromContext(ctx)
+// Each object type is cached and refreshed at its own interval.
+// If fullSyncFreq is set, jobs and nodes are synced incrementally, and fully
+// only every fullSyncFreq.
+// If breaker is set, the requests to slurmrestd go through it.
+// The cache is kept once the client is started, until its context is done.
+func NewSlurmClient(server string, intervals CacheIntervals, fullSyncFreq time.Duration, breaker *Breaker) (client.Client, error) {
+	logger := log.FromContext(context.Background())
 
 	token, ok := os.LookupEnv("SLURM_JWT")
 	if !ok || token == "" {
 		return nil, errors.New("SLURM_JWT must be defined and not empty")
 	}
 
-	if cacheFreq <= 1*time.Second {
-		return nil, errors.New("cache-freq >= 1s")
+	for objectType, interval := range intervals.ByType() {
+		if interval <= 1*time.Second {
+			return nil, fmt.Errorf("cache-freq of %s >= 1s", objectType)
+		}
+	}
+
+	if fullSyncFreq < 0 {
+		return nil, errors.New("full-sync-freq >= 0")
 	}
 
 	// Create slurm client
@@ -36,25 +59,104 @@ func NewSlurmClient(server string, cacheFreq time.Duration) (client.Client, erro
 		Server:    server,
 		AuthToken: token,
 	}
-
-	// Instruct the client to keep a cache of slurm objects
-	clientOptions := client.ClientOptions{
-		EnableFor: []object.Object{
-			&types.V0043JobInfo{},
-			&types.V0043Node{},
-			&types.V0043PartitionInfo{},
-		},
-		CacheSyncPeriod: cacheFreq,
+	transport := http.DefaultTransport
+	if fullSyncFreq > 0 {
+		transport = newIncrementalTransport(transport, clock.RealClock{}, fullSyncFreq)
+	}
+	auth := &authTransport{next: transport}
+	transport = auth
+	if breaker != nil {
+		transport = breaker.Transport(transport)
+	}
+	config.HTTPClient = &http.Client{
+		Transport: transport,
 	}
-	slurmClient, err := client.NewClient(config, &clientOptions)
+	slurmClient, err := client.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
 
-	// Start client cache
-	go slurmClient.Start(ctx)
+	// Instruct a client per object type to keep a cache of its slurm objects
+	cached := &cachedClient{
+		Client:  slurmClient,
+		clients: make(map[object.ObjectType]client.Client, len(cachedObjects)),
+		auth:    auth,
+	}
+	cacheIntervals := map[object.ObjectType]time.Duration{
+		types.ObjectTypeV0043JobInfo:       intervals.Jobs,
+		types.ObjectTypeV0043Node:          intervals.Nodes,
+		types.ObjectTypeV0043PartitionInfo: intervals.Partitions,
+	}
+	for _, obj := range cachedObjects {
+		clientOptions := client.ClientOptions{
+			EnableFor:       []object.Object{obj},
+			CacheSyncPeriod: cacheIntervals[obj.GetType()],
+		}
+		cached.clients[obj.GetType()], err = client.NewClient(config, &clientOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The slurm client cannot cache the stats, hence they are kept apart
+	apiClient, err := slurmapi.NewSlurmClient(config.Server, config.AuthToken, config.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	statsClient, ok := apiClient.(slurmapi.StatsInterface)
+	if !ok {
+		return nil, errors.New("slurm client cannot list stats")
+	}
+	cached.lists = map[object.ObjectType]*listCache{
+		types.ObjectTypeV0043Stats: newListCache(intervals.Stats, statsList(statsClient)),
+	}
+	cached.ping = apiClient
 
 	logger.Info("Created slurm client")
 
-	return slurmClient, nil
+	return cached, nil
+}
+
+// CacheNotifier notifies its subscribers whenever a sync of the slurm client
+// cache adds, modifies or deletes objects. An informer of the cache has a
+// single event handler, which the CacheNotifier sets, hence there must be at
+// most one CacheNotifier per slurm client, shared by every subscriber.
+type CacheNotifier struct {
+	mu          sync.RWMutex
+	subscribers []func()
+}
+
+// NewCacheNotifier returns the CacheNotifier of the slurm client, replacing
+// the event handler of its informers.
+func NewCacheNotifier(slurmClient client.Client) *CacheNotifier {
+	n := &CacheNotifier{}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(_ any) { n.notify() },
+		UpdateFunc: func(_, _ any) { n.notify() },
+		DeleteFunc: func(_ any) { n.notify() },
+	}
+	for _, obj := range cachedObjects {
+		informer := slurmClient.GetInformer(obj.GetType())
+		if informer == nil {
+			continue
+		}
+		informer.SetEventHandler(handler)
+	}
+	return n
+}
+
+// Subscribe calls notify on every change of the cache. It may be called once
+// per object change, hence notify should be cheap.
+func (n *CacheNotifier) Subscribe(notify func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.subscribers = append(n.subscribers, notify)
+}
+
+func (n *CacheNotifier) notify() {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, notify := range n.subscribers {
+		notify()
+	}
 }
@@ -8,14 +8,22 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/client/interceptor"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestNewSlurmClient(t *testing.T) {
 	type args struct {
-		slurm_jwt string
-		server    string
-		cacheFreq time.Duration
+		slurm_jwt    string
+		server       string
+		cacheFreq    time.Duration
+		fullSyncFreq time.Duration
+		breaker      *Breaker
 	}
 	tests := []struct {
 		name    string
@@ -48,6 +56,37 @@ func TestNewSlurmClient(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "incremental",
+			args: args{
+				slurm_jwt:    "token",
+				server:       "http://localhost:6820",
+				cacheFreq:    time.Duration(30 * time.Second),
+				fullSyncFreq: time.Duration(5 * time.Minute),
+			},
+		},
+		{
+			name: "breaker",
+			args: args{
+				slurm_jwt: "token",
+				server:    "http://localhost:6820",
+				cacheFreq: time.Duration(30 * time.Second),
+				breaker: func() *Breaker {
+					breaker, _ := NewBreaker(BreakerOptions{Failures: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+					return breaker
+				}(),
+			},
+		},
+		{
+			name: "bad fullSyncFreq",
+			args: args{
+				slurm_jwt:    "token",
+				server:       "http://localhost:6820",
+				cacheFreq:    time.Duration(30 * time.Second),
+				fullSyncFreq: time.Duration(-1 * time.Second),
+			},
+			wantErr: true,
+		},
 		{
 			name: "bad cacheFreq",
 			args: args{
@@ -64,7 +103,8 @@ func TestNewSlurmClient(t *testing.T) {
 			if err != nil {
 				t.Errorf("Environment could not be set. error=%v", err)
 			}
-			got, err := NewSlurmClient(tt.args.server, tt.args.cacheFreq)
+			intervals := CacheIntervals{}.WithDefault(tt.args.cacheFreq)
+			got, err := NewSlurmClient(tt.args.server, intervals, tt.args.fullSyncFreq, tt.args.breaker)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewSlurmClient() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -79,3 +119,48 @@ func TestNewSlurmClient(t *testing.T) {
 		})
 	}
 }
+
+// handlerInformer records the event handler which is set on it.
+type handlerInformer struct {
+	client.InformerCache
+	handler cache.ResourceEventHandler
+}
+
+func (i *handlerInformer) SetEventHandler(handler cache.ResourceEventHandler) {
+	i.handler = handler
+}
+
+func TestCacheNotifier(t *testing.T) {
+	t.Run("no informers", func(t *testing.T) {
+		NewCacheNotifier(fake.NewFakeClient()).Subscribe(func() {
+			t.Error("CacheNotifier unexpected notification")
+		})
+	})
+	t.Run("informers", func(t *testing.T) {
+		informers := make(map[object.ObjectType]*handlerInformer)
+		slurmClient := fake.NewClientBuilder().
+			WithInterceptorFuncs(interceptor.Funcs{
+				GetInformer: func(objectType object.ObjectType) client.InformerCache {
+					informers[objectType] = &handlerInformer{}
+					return informers[objectType]
+				},
+			}).
+			Build()
+		notifier := NewCacheNotifier(slurmClient)
+		var first, second int
+		notifier.Subscribe(func() { first++ })
+		notifier.Subscribe(func() { second++ })
+
+		assert.Len(t, informers, len(cachedObjects))
+		node := &types.V0043Node{}
+		informer := informers[node.GetType()]
+		if !assert.NotNil(t, informer) || !assert.NotNil(t, informer.handler) {
+			return
+		}
+		informer.handler.OnAdd(node, false)
+		informer.handler.OnUpdate(node, node)
+		informer.handler.OnDelete(node)
+		assert.Equal(t, 3, first)
+		assert.Equal(t, 3, second)
+	})
+}
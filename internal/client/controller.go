@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/utils/ptr"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+)
+
+// ControllerInfo describes the Slurm cluster, as reported by slurmctld.
+type ControllerInfo struct {
+	// Cluster is the name of the Slurm cluster.
+	Cluster string
+	// Release is the Slurm release of slurmctld (e.g. 25.05.0).
+	Release string
+}
+
+// pingClient pings slurmctld through slurmrestd.
+type pingClient interface {
+	SlurmV0043GetPingWithResponse(ctx context.Context, reqEditors ...api.RequestEditorFn) (*api.SlurmV0043GetPingResponse, error)
+}
+
+// GetControllerInfo pings slurmctld for the name and release of the Slurm
+// cluster. Clients which were not created by NewSlurmClient have no
+// controller info.
+func GetControllerInfo(ctx context.Context, slurmClient client.Client) (ControllerInfo, error) {
+	c, ok := slurmClient.(*cachedClient)
+	if !ok || c.ping == nil {
+		return ControllerInfo{}, nil
+	}
+	return getControllerInfo(ctx, c.ping)
+}
+
+func getControllerInfo(ctx context.Context, ping pingClient) (ControllerInfo, error) {
+	res, err := ping.SlurmV0043GetPingWithResponse(ctx)
+	if err != nil {
+		return ControllerInfo{}, err
+	}
+	if res.StatusCode() != http.StatusOK || res.JSON200 == nil {
+		return ControllerInfo{}, fmt.Errorf("failed to ping slurmctld: %s", http.StatusText(res.StatusCode()))
+	}
+	meta := res.JSON200.Meta
+	if meta == nil || meta.Slurm == nil {
+		return ControllerInfo{}, errors.New("slurmctld did not report the cluster")
+	}
+	info := ControllerInfo{
+		Cluster: ptr.Deref(meta.Slurm.Cluster, ""),
+		Release: ptr.Deref(meta.Slurm.Release, ""),
+	}
+	if version := meta.Slurm.Version; info.Release == "" && version != nil {
+		info.Release = strings.Join([]string{
+			ptr.Deref(version.Major, "0"),
+			ptr.Deref(version.Minor, "0"),
+			ptr.Deref(version.Micro, "0"),
+		}, ".")
+	}
+	return info, nil
+}
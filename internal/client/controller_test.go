@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	slurmapi "github.com/SlinkyProject/slurm-client/pkg/client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+)
+
+func TestGetControllerInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    ControllerInfo
+		wantErr bool
+	}{
+		{
+			name:   "release",
+			status: http.StatusOK,
+			body:   `{"meta":{"slurm":{"cluster":"linux","release":"25.05.0","version":{"major":"25","minor":"05","micro":"0"}}},"pings":[]}`,
+			want:   ControllerInfo{Cluster: "linux", Release: "25.05.0"},
+		},
+		{
+			name:   "version",
+			status: http.StatusOK,
+			body:   `{"meta":{"slurm":{"cluster":"linux","version":{"major":"25","minor":"05","micro":"1"}}},"pings":[]}`,
+			want:   ControllerInfo{Cluster: "linux", Release: "25.05.1"},
+		},
+		{
+			name:    "no meta",
+			status:  http.StatusOK,
+			body:    `{"pings":[]}`,
+			wantErr: true,
+		},
+		{
+			name:    "error",
+			status:  http.StatusInternalServerError,
+			body:    `{"errors":[{"error":"slurmctld is down"}],"pings":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/slurm/v0.0.43/ping/", r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+			apiClient, err := slurmapi.NewSlurmClient(server.URL, "token", nil)
+			assert.NoError(t, err)
+
+			got, err := GetControllerInfo(context.Background(), &cachedClient{ping: apiClient})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetControllerInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetControllerInfo_notCached(t *testing.T) {
+	got, err := GetControllerInfo(context.Background(), fake.NewFakeClient())
+	assert.NoError(t, err)
+	assert.Equal(t, ControllerInfo{}, got)
+}
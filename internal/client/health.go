@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+)
+
+// errTokenRejected is returned by Ready while slurmrestd rejects the token.
+var errTokenRejected = errors.New("slurmrestd rejected the token")
+
+// authTransport records whether slurmrestd rejected the token on the last
+// request.
+type authTransport struct {
+	next     http.RoundTripper
+	rejected atomic.Bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.rejected.Store(resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden)
+	}
+	return resp, err
+}
+
+// Ready returns an error until the cache of every object type synced
+// successfully at least once, or while slurmrestd rejects the token. Clients
+// which were not created by NewSlurmClient are always ready.
+func Ready(slurmClient client.Client) error {
+	c, ok := slurmClient.(*cachedClient)
+	if !ok {
+		return nil
+	}
+	return c.ready()
+}
+
+func (c *cachedClient) ready() error {
+	if c.auth != nil && c.auth.rejected.Load() {
+		return errTokenRejected
+	}
+	for objectType, slurmClient := range c.clients {
+		informer := slurmClient.GetInformer(objectType)
+		if informer == nil {
+			continue
+		}
+		if synced, _ := informer.HasSynced(); !synced {
+			return fmt.Errorf("cache of %s has not synced yet", objectType)
+		}
+	}
+	for objectType, cache := range c.lists {
+		if !cache.hasSynced() {
+			return fmt.Errorf("cache of %s has not synced yet", objectType)
+		}
+	}
+	return nil
+}
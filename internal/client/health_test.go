@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/client/interceptor"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncedInformer reports whether it has synced.
+type syncedInformer struct {
+	client.InformerCache
+	synced bool
+}
+
+func (i *syncedInformer) HasSynced() (bool, error) {
+	return i.synced, nil
+}
+
+func newInformerClient(informer client.InformerCache) client.Client {
+	return fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			GetInformer: func(_ object.ObjectType) client.InformerCache {
+				return informer
+			},
+		}).
+		Build()
+}
+
+func TestReady(t *testing.T) {
+	nodes := &syncedInformer{}
+	stats := newListCache(time.Hour, func(_ context.Context) (object.ObjectList, error) {
+		return &types.V0043StatsList{}, nil
+	})
+	auth := &authTransport{}
+	c := &cachedClient{
+		Client: fake.NewFakeClient(),
+		clients: map[object.ObjectType]client.Client{
+			types.ObjectTypeV0043Node: newInformerClient(nodes),
+		},
+		lists: map[object.ObjectType]*listCache{
+			types.ObjectTypeV0043Stats: stats,
+		},
+		auth: auth,
+	}
+
+	assert.Error(t, Ready(c))
+	nodes.synced = true
+	assert.Error(t, Ready(c))
+	assert.NoError(t, stats.refresh(context.TODO()))
+	assert.NoError(t, Ready(c))
+
+	auth.rejected.Store(true)
+	assert.ErrorIs(t, Ready(c), errTokenRejected)
+
+	assert.NoError(t, Ready(fake.NewFakeClient()))
+}
+
+func TestAuthTransport(t *testing.T) {
+	next := &fakeResponder{status: http.StatusUnauthorized}
+	transport := &authTransport{next: next}
+
+	assert.NoError(t, roundTrip(transport))
+	assert.True(t, transport.rejected.Load())
+	next.status = http.StatusInternalServerError
+	assert.NoError(t, roundTrip(transport))
+	assert.False(t, transport.rejected.Load())
+
+	// Failed requests tell nothing about the token.
+	transport.rejected.Store(true)
+	next.err = context.DeadlineExceeded
+	assert.Error(t, roundTrip(transport))
+	assert.True(t, transport.rejected.Load())
+}
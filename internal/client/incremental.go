@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/utils/clock"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+)
+
+// The slurmrestd endpoints which are synced incrementally, and the response
+// field holding their objects.
+var incrementalEndpoints = map[string]*incrementalEndpoint{
+	"/slurm/v0.0.43/jobs/":  {itemsField: "jobs"},
+	"/slurm/v0.0.43/nodes/": {itemsField: "nodes"},
+}
+
+type incrementalEndpoint struct {
+	itemsField string
+}
+
+// incrementalTransport syncs the job and node lists incrementally. It asks
+// slurmrestd for the objects only if they changed since the last sync (by
+// `update_time`), and otherwise responds with the stored objects, such that the
+// slurm client is unaware of it.
+//
+// slurmrestd answers an `update_time` query with the whole list if anything
+// changed since then, including objects which slurmctld no longer knows of
+// (e.g. purged jobs, deleted dynamic nodes), or else with an empty list. A
+// non-empty response hence replaces the stored objects. Since an empty list of
+// objects cannot be told from no change, the whole list is also fetched every
+// fullSyncPeriod.
+type incrementalTransport struct {
+	next           http.RoundTripper
+	clock          clock.PassiveClock
+	fullSyncPeriod time.Duration
+
+	mu     sync.Mutex
+	caches map[string]*incrementalCache
+}
+
+// incrementalCache is the stored state of one endpoint.
+type incrementalCache struct {
+	mu         sync.Mutex
+	items      []json.RawMessage
+	lastUpdate int64
+	lastFull   time.Time
+}
+
+func newIncrementalTransport(next http.RoundTripper, clk clock.PassiveClock, fullSyncPeriod time.Duration) *incrementalTransport {
+	return &incrementalTransport{
+		next:           next,
+		clock:          clk,
+		fullSyncPeriod: fullSyncPeriod,
+		caches:         make(map[string]*incrementalCache),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *incrementalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint, path := t.endpointOf(req)
+	if endpoint == nil {
+		return t.next.RoundTrip(req)
+	}
+	c := t.cacheOf(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := t.clock.Now()
+	full := c.items == nil || c.lastUpdate == 0 || now.Sub(c.lastFull) >= t.fullSyncPeriod
+	if !full {
+		req = req.Clone(req.Context())
+		query := req.URL.Query()
+		query.Set("update_time", strconv.FormatInt(c.lastUpdate, 10))
+		req.URL.RawQuery = query.Encode()
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = c.merge(endpoint, body, full)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge %s: %w", path, err)
+	}
+	if full {
+		c.lastFull = now
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Del("Content-Length")
+	return res, nil
+}
+
+// endpointOf returns the endpoint which the request lists, if it is synced
+// incrementally.
+func (t *incrementalTransport) endpointOf(req *http.Request) (*incrementalEndpoint, string) {
+	if req.Method != http.MethodGet || req.URL.Query().Has("update_time") {
+		return nil, ""
+	}
+	for path, endpoint := range incrementalEndpoints {
+		if strings.HasSuffix(req.URL.Path, path) {
+			return endpoint, path
+		}
+	}
+	return nil, ""
+}
+
+func (t *incrementalTransport) cacheOf(path string) *incrementalCache {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.caches[path]; !ok {
+		t.caches[path] = &incrementalCache{}
+	}
+	return t.caches[path]
+}
+
+// merge stores the objects of the response body, if the response is a full
+// list or holds any object, and returns the response body with the stored
+// objects.
+func (c *incrementalCache) merge(endpoint *incrementalEndpoint, body []byte, full bool) ([]byte, error) {
+	resp := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if raw, ok := resp[endpoint.itemsField]; ok {
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, err
+		}
+	}
+	var lastUpdate api.V0043Uint64NoValStruct
+	if raw, ok := resp["last_update"]; ok {
+		if err := json.Unmarshal(raw, &lastUpdate); err != nil {
+			return nil, err
+		}
+	}
+
+	// slurmrestd responds to an update_time with all objects, or none if
+	// nothing changed.
+	if full || len(items) > 0 {
+		c.items = items
+		if c.items == nil {
+			c.items = []json.RawMessage{}
+		}
+	}
+	// Without the time of the last update, the next sync cannot be a delta.
+	c.lastUpdate = 0
+	if lastUpdate.Set != nil && *lastUpdate.Set && lastUpdate.Number != nil {
+		c.lastUpdate = *lastUpdate.Number
+	}
+
+	raw, err := json.Marshal(c.items)
+	if err != nil {
+		return nil, err
+	}
+	resp[endpoint.itemsField] = raw
+	return json.Marshal(resp)
+}
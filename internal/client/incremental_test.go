@@ -0,0 +1,294 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+// fakeSlurmrestd serves the jobs and nodes of a fake cluster, answering
+// `update_time` queries with all objects if any changed since then, or else
+// with none, like slurmrestd does.
+type fakeSlurmrestd struct {
+	mu    sync.Mutex
+	now   int64
+	jobs  map[int32]fakeObject
+	nodes map[string]fakeObject
+	fail  bool
+
+	// The time of the last change of the jobs and of the nodes, including
+	// deletions.
+	jobsUpdated  int64
+	nodesUpdated int64
+
+	// The update_time of each request, empty for full lists.
+	updateTimes []string
+}
+
+type fakeObject struct {
+	state string
+}
+
+func newFakeSlurmrestd() *fakeSlurmrestd {
+	return &fakeSlurmrestd{
+		now:   1000,
+		jobs:  make(map[int32]fakeObject),
+		nodes: make(map[string]fakeObject),
+	}
+}
+
+// tick advances the time of the fake cluster.
+func (s *fakeSlurmrestd) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now++
+}
+
+func (s *fakeSlurmrestd) setJob(id int32, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id] = fakeObject{state: state}
+	s.jobsUpdated = s.now
+}
+
+func (s *fakeSlurmrestd) deleteJob(id int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+	s.jobsUpdated = s.now
+}
+
+func (s *fakeSlurmrestd) setNode(name string, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[name] = fakeObject{state: state}
+	s.nodesUpdated = s.now
+}
+
+func (s *fakeSlurmrestd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updateTime := r.URL.Query().Get("update_time")
+	s.updateTimes = append(s.updateTimes, updateTime)
+	if s.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var since int64
+	if updateTime != "" {
+		since, _ = strconv.ParseInt(updateTime, 10, 64)
+	}
+	lastUpdate := api.V0043Uint64NoValStruct{Number: ptr.To(s.now), Set: ptr.To(true)}
+
+	var resp any
+	switch r.URL.Path {
+	case "/slurm/v0.0.43/jobs/":
+		jobs := api.V0043JobInfoMsg{}
+		for id, job := range s.jobs {
+			if s.jobsUpdated < since {
+				break
+			}
+			jobs = append(jobs, api.V0043JobInfo{
+				JobId:    ptr.To(id),
+				JobState: ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobState(job.state)}),
+			})
+		}
+		resp = api.V0043OpenapiJobInfoResp{Jobs: jobs, LastUpdate: lastUpdate}
+	case "/slurm/v0.0.43/nodes/":
+		nodes := api.V0043Nodes{}
+		for name, node := range s.nodes {
+			if s.nodesUpdated < since {
+				break
+			}
+			nodes = append(nodes, api.V0043Node{
+				Name:  ptr.To(name),
+				State: ptr.To([]api.V0043NodeState{api.V0043NodeState(node.state)}),
+			})
+		}
+		resp = api.V0043OpenapiNodesResp{Nodes: nodes, LastUpdate: lastUpdate}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// requests returns the update_time of the requests since the previous call.
+func (s *fakeSlurmrestd) requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	got := s.updateTimes
+	s.updateTimes = nil
+	return got
+}
+
+func newIncrementalClient(t *testing.T, server *fakeSlurmrestd, clk *clocktesting.FakePassiveClock) client.Client {
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	slurmClient, err := client.NewClient(&client.Config{
+		Server:    srv.URL,
+		AuthToken: "token",
+		HTTPClient: &http.Client{
+			Transport: newIncrementalTransport(http.DefaultTransport, clk, 5*time.Minute),
+		},
+	})
+	if err != nil {
+		t.Fatalf("client.NewClient() error = %v", err)
+	}
+	return slurmClient
+}
+
+func listJobs(t *testing.T, slurmClient client.Client) map[int32]string {
+	list := &types.V0043JobInfoList{}
+	if err := slurmClient.List(context.TODO(), list, &client.ListOptions{SkipCache: true}); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	jobs := make(map[int32]string, len(list.Items))
+	for _, job := range list.Items {
+		jobs[ptr.Deref(job.JobId, 0)] = string(ptr.Deref(job.JobState, nil)[0])
+	}
+	return jobs
+}
+
+func listNodes(t *testing.T, slurmClient client.Client) []string {
+	list := &types.V0043NodeList{}
+	if err := slurmClient.List(context.TODO(), list, &client.ListOptions{SkipCache: true}); err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	nodes := make([]string, 0, len(list.Items))
+	for _, node := range list.Items {
+		nodes = append(nodes, string(node.GetKey()))
+	}
+	slices.Sort(nodes)
+	return nodes
+}
+
+func TestIncrementalTransport_jobs(t *testing.T) {
+	server := newFakeSlurmrestd()
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	slurmClient := newIncrementalClient(t, server, clk)
+
+	server.setJob(1, "RUNNING")
+	server.setJob(2, "PENDING")
+	server.tick()
+	assert.Equal(t, map[int32]string{1: "RUNNING", 2: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{""}, server.requests())
+
+	// Nothing changed.
+	server.tick()
+	assert.Equal(t, map[int32]string{1: "RUNNING", 2: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"1001"}, server.requests())
+
+	// Changed and new jobs replace the stored ones.
+	server.setJob(2, "RUNNING")
+	server.setJob(3, "PENDING")
+	server.tick()
+	assert.Equal(t, map[int32]string{1: "RUNNING", 2: "RUNNING", 3: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"1002"}, server.requests())
+
+	// Deleted jobs are removed by the next incremental sync.
+	server.deleteJob(1)
+	server.tick()
+	assert.Equal(t, map[int32]string{2: "RUNNING", 3: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"1003"}, server.requests())
+	server.tick()
+	assert.Equal(t, map[int32]string{2: "RUNNING", 3: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"1004"}, server.requests())
+
+	// The last job deleted looks like no change until the next full sync.
+	server.deleteJob(2)
+	server.deleteJob(3)
+	server.tick()
+	assert.Equal(t, map[int32]string{2: "RUNNING", 3: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"1005"}, server.requests())
+	clk.SetTime(clk.Now().Add(5 * time.Minute))
+	assert.Equal(t, map[int32]string{}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{""}, server.requests())
+}
+
+func TestIncrementalTransport_nodes(t *testing.T) {
+	server := newFakeSlurmrestd()
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	slurmClient := newIncrementalClient(t, server, clk)
+
+	server.setNode("node0", "IDLE")
+	server.tick()
+	assert.Equal(t, []string{"node0"}, listNodes(t, slurmClient))
+
+	server.setNode("node1", "IDLE")
+	server.tick()
+	assert.Equal(t, []string{"node0", "node1"}, listNodes(t, slurmClient))
+	assert.Equal(t, []string{"", "1001"}, server.requests())
+}
+
+func TestIncrementalTransport_failure(t *testing.T) {
+	server := newFakeSlurmrestd()
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	slurmClient := newIncrementalClient(t, server, clk)
+
+	server.setJob(1, "RUNNING")
+	server.tick()
+	assert.Equal(t, map[int32]string{1: "RUNNING"}, listJobs(t, slurmClient))
+
+	// A failed delta is passed on, and does not lose the stored jobs.
+	server.mu.Lock()
+	server.fail = true
+	server.mu.Unlock()
+	err := slurmClient.List(context.TODO(), &types.V0043JobInfoList{}, &client.ListOptions{SkipCache: true})
+	assert.Error(t, err)
+
+	server.mu.Lock()
+	server.fail = false
+	server.mu.Unlock()
+	server.setJob(2, "PENDING")
+	server.tick()
+	assert.Equal(t, map[int32]string{1: "RUNNING", 2: "PENDING"}, listJobs(t, slurmClient))
+	assert.Equal(t, []string{"", "1001", "1001"}, server.requests())
+}
+
+func TestIncrementalTransport_passthrough(t *testing.T) {
+	var paths []string
+	next := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.URL.RequestURI())
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Header: http.Header{}}, nil
+	})
+	transport := newIncrementalTransport(next, clocktesting.NewFakePassiveClock(time.Now()), time.Minute)
+
+	for _, url := range []string{
+		"http://localhost/slurm/v0.0.43/partitions/",
+		"http://localhost/slurm/v0.0.43/job/1",
+		"http://localhost/slurm/v0.0.43/jobs/?update_time=1",
+	} {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		_, err := transport.RoundTrip(req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{
+		"/slurm/v0.0.43/partitions/",
+		"/slurm/v0.0.43/job/1",
+		"/slurm/v0.0.43/jobs/?update_time=1",
+	}, paths)
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -4,55 +4,53 @@
 package collector
 
 import (
-	"context"
-
 	"github.com/prometheus/client_golang/prometheus"
-	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/log"
-
-	"github.com/SlinkyProject/slurm-client/pkg/client"
-	"github.com/SlinkyProject/slurm-client/pkg/types"
 )
 
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewAccountCollector(slurmClient client.Client) prometheus.Collector {
+func NewAccountCollector(snapshots *Snapshotter) prometheus.Collector {
 	return &accountCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
 
-		JobCount: prometheus.NewDesc("slurm_account_jobs_total", "Total number of account jobs", accountLabels, nil),
+		JobCount: newDesc("slurm_account_jobs_total", "Total number of account jobs", accountLabels, nil),
 		JobStates: jobStatesCollector{
 			// Base States
-			BootFail:    prometheus.NewDesc("slurm_account_jobs_bootfail_total", "Number of account jobs in BootFail state", accountLabels, nil),
-			Cancelled:   prometheus.NewDesc("slurm_account_jobs_cancelled_total", "Number of account jobs in Cancelled state", accountLabels, nil),
-			Completed:   prometheus.NewDesc("slurm_account_jobs_completed_total", "Number of account jobs in Completed state", accountLabels, nil),
-			Deadline:    prometheus.NewDesc("slurm_account_jobs_deadline_total", "Number of account jobs in Deadline state", accountLabels, nil),
-			Failed:      prometheus.NewDesc("slurm_account_jobs_failed_total", "Number of account jobs in Failed state", accountLabels, nil),
-			Pending:     prometheus.NewDesc("slurm_account_jobs_pending_total", "Number of account jobs in Pending state", accountLabels, nil),
-			Preempted:   prometheus.NewDesc("slurm_account_jobs_preempted_total", "Number of account jobs in Preempted state", accountLabels, nil),
-			Running:     prometheus.NewDesc("slurm_account_jobs_running_total", "Number of account jobs in Running state", accountLabels, nil),
-			Suspended:   prometheus.NewDesc("slurm_account_jobs_suspended_total", "Number of account jobs in Suspended state", accountLabels, nil),
-			Timeout:     prometheus.NewDesc("slurm_account_jobs_timeout_total", "Number of account jobs in Timeout state", accountLabels, nil),
-			NodeFail:    prometheus.NewDesc("slurm_account_jobs_nodefail_total", "Number of account jobs in NodeFail state", accountLabels, nil),
-			OutOfMemory: prometheus.NewDesc("slurm_account_jobs_outofmemory_total", "Number of account jobs in OutOfMemory state", accountLabels, nil),
+			BootFail:    newDesc("slurm_account_jobs_bootfail_total", "Number of account jobs in BootFail state", accountLabels, nil),
+			Cancelled:   newDesc("slurm_account_jobs_cancelled_total", "Number of account jobs in Cancelled state", accountLabels, nil),
+			Completed:   newDesc("slurm_account_jobs_completed_total", "Number of account jobs in Completed state", accountLabels, nil),
+			Deadline:    newDesc("slurm_account_jobs_deadline_total", "Number of account jobs in Deadline state", accountLabels, nil),
+			Failed:      newDesc("slurm_account_jobs_failed_total", "Number of account jobs in Failed state", accountLabels, nil),
+			Pending:     newDesc("slurm_account_jobs_pending_total", "Number of account jobs in Pending state", accountLabels, nil),
+			Preempted:   newDesc("slurm_account_jobs_preempted_total", "Number of account jobs in Preempted state", accountLabels, nil),
+			Running:     newDesc("slurm_account_jobs_running_total", "Number of account jobs in Running state", accountLabels, nil),
+			Suspended:   newDesc("slurm_account_jobs_suspended_total", "Number of account jobs in Suspended state", accountLabels, nil),
+			Timeout:     newDesc("slurm_account_jobs_timeout_total", "Number of account jobs in Timeout state", accountLabels, nil),
+			NodeFail:    newDesc("slurm_account_jobs_nodefail_total", "Number of account jobs in NodeFail state", accountLabels, nil),
+			OutOfMemory: newDesc("slurm_account_jobs_outofmemory_total", "Number of account jobs in OutOfMemory state", accountLabels, nil),
 			// Flag States
-			Completing:  prometheus.NewDesc("slurm_account_jobs_completing_total", "Number of account jobs with Completing flag", accountLabels, nil),
-			Configuring: prometheus.NewDesc("slurm_account_jobs_configuring_total", "Number of account jobs with Configuring flag", accountLabels, nil),
-			PowerUpNode: prometheus.NewDesc("slurm_account_jobs_powerupnode_total", "Number of account jobs with PowerUpNode flag", accountLabels, nil),
-			StageOut:    prometheus.NewDesc("slurm_account_jobs_stageout_total", "Number of account jobs with StageOut flag", accountLabels, nil),
+			Completing:  newDesc("slurm_account_jobs_completing_total", "Number of account jobs with Completing flag", accountLabels, nil),
+			Configuring: newDesc("slurm_account_jobs_configuring_total", "Number of account jobs with Configuring flag", accountLabels, nil),
+			PowerUpNode: newDesc("slurm_account_jobs_powerupnode_total", "Number of account jobs with PowerUpNode flag", accountLabels, nil),
+			StageOut:    newDesc("slurm_account_jobs_stageout_total", "Number of account jobs with StageOut flag", accountLabels, nil),
 			// Other States
-			Hold: prometheus.NewDesc("slurm_account_jobs_hold_total", "Number of account jobs with Hold flag", accountLabels, nil),
+			Hold: newDesc("slurm_account_jobs_hold_total", "Number of account jobs with Hold flag", accountLabels, nil),
+			// All States
+			States: newDesc("slurm_account_jobs", "Number of account jobs by base state, and by base state and flag", accountStateLabels, nil),
 		},
 		JobTres: jobTresCollector{
 			// CPUs
-			CpusAlloc: prometheus.NewDesc("slurm_account_jobs_cpus_alloc_total", "Number of Allocated CPUs among account jobs", accountLabels, nil),
+			CpusAlloc: newDesc("slurm_account_jobs_cpus_alloc_total", "Number of Allocated CPUs among account jobs", accountLabels, nil),
 			// Memory
-			MemoryAlloc: prometheus.NewDesc("slurm_account_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among account jobs", accountLabels, nil),
+			MemoryAlloc: newDesc("slurm_account_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among account jobs", accountLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_account_jobs_energy_consumed_joules", "Amount of Energy (J) consumed among the account jobs in the job list, which drops as jobs are purged; see slurm_job_lifecycle_energy_consumed_joules_total for the energy over time", accountLabels, nil),
 		},
 	}
 }
 
 type accountCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
 
 	JobCount  *prometheus.Desc
 	JobStates jobStatesCollector
@@ -64,15 +62,19 @@ func (c *accountCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *accountCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *accountCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("AccountCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getAccountMetrics(ctx)
+	metrics, err := c.getAccountMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect account metrics")
-		return
+		return err
 	}
 
 	for account, data := range metrics.JobMetricsPer {
@@ -94,35 +96,26 @@ func (c *accountCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.JobStates.Configuring, prometheus.GaugeValue, float64(data.JobStates.Configuring), account)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.PowerUpNode, prometheus.GaugeValue, float64(data.JobStates.PowerUpNode), account)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.Hold, prometheus.GaugeValue, float64(data.JobStates.Hold), account)
+		for key, count := range data.JobStates.States {
+			ch <- prometheus.MustNewConstMetric(c.JobStates.States, prometheus.GaugeValue, float64(count), account, key.State, key.Flag)
+		}
 		// Tres
 		ch <- prometheus.MustNewConstMetric(c.JobTres.CpusAlloc, prometheus.GaugeValue, float64(data.JobTres.CpusAlloc), account)
 		ch <- prometheus.MustNewConstMetric(c.JobTres.MemoryAlloc, prometheus.GaugeValue, float64(data.JobTres.MemoryAlloc), account)
+		ch <- prometheus.MustNewConstMetric(c.JobTres.EnergyConsumed, prometheus.GaugeValue, float64(data.JobTres.EnergyConsumed), account)
 	}
+	return nil
 }
 
-func (c *accountCollector) getAccountMetrics(ctx context.Context) (*AccountMetrics, error) {
-	jobList := &types.V0043JobInfoList{}
-	if err := c.slurmClient.List(ctx, jobList); err != nil {
+func (c *accountCollector) getAccountMetrics(snapshot *Snapshot) (*AccountMetrics, error) {
+	aggregates, err := snapshot.JobAggregates()
+	if err != nil {
 		return nil, err
 	}
-	metrics := calculateAccountMetrics(jobList)
-	return metrics, nil
-}
-
-func calculateAccountMetrics(jobList *types.V0043JobInfoList) *AccountMetrics {
 	metrics := &AccountMetrics{
-		JobMetricsPer: make(map[string]*JobMetrics),
+		JobMetricsPer: aggregates.AccountJobMetricsPer,
 	}
-	for _, job := range jobList.Items {
-		key := ptr.Deref(job.Account, "")
-		if _, ok := metrics.JobMetricsPer[key]; !ok {
-			metrics.JobMetricsPer[key] = &JobMetrics{}
-		}
-		metrics.JobMetricsPer[key].JobCount++
-		calculateJobState(&metrics.JobMetricsPer[key].JobStates, job)
-		calculateJobTres(&metrics.JobMetricsPer[key].JobTres, job)
-	}
-	return metrics
+	return metrics, nil
 }
 
 type AccountMetrics struct {
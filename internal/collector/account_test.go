@@ -53,11 +53,11 @@ func TestAccountCollector_getAccountMetrics(t *testing.T) {
 				JobMetricsPer: map[string]*JobMetrics{
 					"": {
 						JobCount:  2,
-						JobStates: JobStates{Pending: 2, Hold: 1},
+						JobStates: JobStates{Pending: 2, Hold: 1, States: StateCounts{{State: "pending"}: 2}},
 					},
 					"root": {
 						JobCount:  2,
-						JobStates: JobStates{Running: 2},
+						JobStates: JobStates{Running: 2, States: StateCounts{{State: "running"}: 2}},
 						JobTres:   JobTres{CpusAlloc: 20, MemoryAlloc: 4096},
 					},
 				},
@@ -78,9 +78,9 @@ func TestAccountCollector_getAccountMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &accountCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getAccountMetrics(tt.args.ctx)
+			got, err := c.getAccountMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("accountCollector.getAccountMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -141,7 +141,7 @@ func TestAccountCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewAccountCollector(tt.fields.slurmClient)
+			c := NewAccountCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -183,7 +183,7 @@ func TestAccountCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewAccountCollector(tt.fields.slurmClient)
+			c := NewAccountCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
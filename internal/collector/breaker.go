@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The states of a CircuitBreaker.
+var breakerStates = []string{"closed", "open", "half_open"}
+
+// CircuitBreaker guards the requests to slurmrestd.
+type CircuitBreaker interface {
+	// State returns one of closed, open or half_open.
+	State() string
+	// ConsecutiveFailures returns the number of failures since the last success.
+	ConsecutiveFailures() int
+}
+
+// NewBreakerCollector exports the state of the circuit breaker of the requests
+// to slurmrestd.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewBreakerCollector(breaker CircuitBreaker) prometheus.Collector {
+	return &breakerCollector{
+		breaker: breaker,
+
+		State:               prometheus.NewDesc("slurm_exporter_circuit_breaker_state", "Whether the circuit breaker of the requests to slurmrestd is in the state", []string{"state"}, nil),
+		ConsecutiveFailures: prometheus.NewDesc("slurm_exporter_slurmrestd_consecutive_failures", "Number of failed requests to slurmrestd since the last success", nil, nil),
+	}
+}
+
+type breakerCollector struct {
+	breaker CircuitBreaker
+
+	State               *prometheus.Desc
+	ConsecutiveFailures *prometheus.Desc
+}
+
+func (c *breakerCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *breakerCollector) Collect(ch chan<- prometheus.Metric) {
+	state := c.breaker.State()
+	for _, s := range breakerStates {
+		value := float64(0)
+		if s == state {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.State, prometheus.GaugeValue, value, s)
+	}
+	ch <- prometheus.MustNewConstMetric(c.ConsecutiveFailures, prometheus.GaugeValue, float64(c.breaker.ConsecutiveFailures()))
+}
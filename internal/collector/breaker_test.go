@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeBreaker struct {
+	state    string
+	failures int
+}
+
+func (b *fakeBreaker) State() string {
+	return b.state
+}
+
+func (b *fakeBreaker) ConsecutiveFailures() int {
+	return b.failures
+}
+
+func TestBreakerCollector_Collect(t *testing.T) {
+	c := NewBreakerCollector(&fakeBreaker{state: "open", failures: 4})
+	want := `
+# HELP slurm_exporter_circuit_breaker_state Whether the circuit breaker of the requests to slurmrestd is in the state
+# TYPE slurm_exporter_circuit_breaker_state gauge
+slurm_exporter_circuit_breaker_state{state="closed"} 0
+slurm_exporter_circuit_breaker_state{state="half_open"} 0
+slurm_exporter_circuit_breaker_state{state="open"} 1
+# HELP slurm_exporter_slurmrestd_consecutive_failures Number of failed requests to slurmrestd since the last success
+# TYPE slurm_exporter_slurmrestd_consecutive_failures gauge
+slurm_exporter_slurmrestd_consecutive_failures 4
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestBreakerCollector_Describe(t *testing.T) {
+	c := NewBreakerCollector(&fakeBreaker{})
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	for desc := range ch {
+		assert.NotNil(t, desc)
+	}
+}
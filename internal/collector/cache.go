@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCacheCollector exports the refresh interval of the cache of each Slurm
+// object type.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewCacheCollector(intervals map[string]time.Duration) prometheus.Collector {
+	return &cacheCollector{
+		intervals: intervals,
+
+		RefreshInterval: prometheus.NewDesc("slurm_exporter_cache_refresh_interval_seconds", "Interval at which the cache of the object type is refreshed", []string{"type"}, nil),
+	}
+}
+
+type cacheCollector struct {
+	intervals map[string]time.Duration
+
+	RefreshInterval *prometheus.Desc
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for objectType, interval := range c.intervals {
+		ch <- prometheus.MustNewConstMetric(c.RefreshInterval, prometheus.GaugeValue, interval.Seconds(), objectType)
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheCollector_Collect(t *testing.T) {
+	c := NewCacheCollector(map[string]time.Duration{
+		"jobs":  5 * time.Second,
+		"stats": 30 * time.Second,
+	})
+	want := `
+# HELP slurm_exporter_cache_refresh_interval_seconds Interval at which the cache of the object type is refreshed
+# TYPE slurm_exporter_cache_refresh_interval_seconds gauge
+slurm_exporter_cache_refresh_interval_seconds{type="jobs"} 5
+slurm_exporter_cache_refresh_interval_seconds{type="stats"} 30
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestCacheCollector_Describe(t *testing.T) {
+	c := NewCacheCollector(nil)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	for desc := range ch {
+		assert.NotNil(t, desc)
+	}
+}
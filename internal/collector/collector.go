@@ -10,5 +10,24 @@ var (
 
 	nodeLabels = []string{"node"}
 
+	// The pod labels do not collide with the namespace and pod target labels.
+	nodePodLabels = []string{"node", "k8s_namespace", "k8s_pod", "k8s_node", "nodeset"}
+
+	nodeStateLabels = []string{"node", "state"}
+
+	nodeTransitionLabels = []string{"node", "from_state", "to_state"}
+
 	partitionLabels = []string{"partition"}
+
+	jobLifecycleLabels = []string{"partition", "account"}
+
+	jobLifecycleUserLabels = []string{"account", "userid", "username"}
+
+	stateLabels = []string{"state", "flag"}
+
+	accountStateLabels = []string{"account", "state", "flag"}
+
+	userStateLabels = []string{"userid", "username", "state", "flag"}
+
+	partitionStateLabels = []string{"partition", "state", "flag"}
 )
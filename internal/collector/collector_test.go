@@ -258,7 +258,8 @@ var (
 			Number: ptr.To[int32](2),
 			Set:    ptr.To(true),
 		},
-		UserId: ptr.To[int32](1000),
+		TresReqStr: ptr.To("cpu=8,mem=2G,node=2,billing=8,gres/gpu=2,gres/gpu:a100=2"),
+		UserId:     ptr.To[int32](1000),
 	}}
 	jobList = &types.V0043JobInfoList{
 		Items: []types.V0043JobInfo{
@@ -341,6 +342,11 @@ var (
 		ServerThreadCount: ptr.To[int32](7),
 
 		DbdAgentQueueSize: ptr.To[int32](8),
+
+		ReqTimeStart: &api.V0043Uint64NoValStruct{
+			Number: ptr.To[int64](9),
+			Set:    ptr.To(true),
+		},
 	}}
 )
 
@@ -4,56 +4,62 @@
 package collector
 
 import (
-	"context"
+	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/utils/ptr"
+	"k8s.io/utils/set"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	api "github.com/SlinkyProject/slurm-client/api/v0043"
-	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/utils"
 )
 
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewJobCollector(slurmClient client.Client) prometheus.Collector {
+func NewJobCollector(snapshots *Snapshotter) prometheus.Collector {
 	return &jobCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
 
-		JobCount: prometheus.NewDesc("slurm_jobs_total", "Total number of jobs", nil, nil),
+		JobCount: newDesc("slurm_jobs_total", "Total number of jobs", nil, nil),
 		JobStates: jobStatesCollector{
 			// Base States
-			BootFail:    prometheus.NewDesc("slurm_jobs_bootfail_total", "Number of jobs in BootFail state", nil, nil),
-			Cancelled:   prometheus.NewDesc("slurm_jobs_cancelled_total", "Number of jobs in Cancelled state", nil, nil),
-			Completed:   prometheus.NewDesc("slurm_jobs_completed_total", "Number of jobs in Completed state", nil, nil),
-			Deadline:    prometheus.NewDesc("slurm_jobs_deadline_total", "Number of jobs in Deadline state", nil, nil),
-			Failed:      prometheus.NewDesc("slurm_jobs_failed_total", "Number of jobs in Failed state", nil, nil),
-			Pending:     prometheus.NewDesc("slurm_jobs_pending_total", "Number of jobs in Pending state", nil, nil),
-			Preempted:   prometheus.NewDesc("slurm_jobs_preempted_total", "Number of jobs in Preempted state", nil, nil),
-			Running:     prometheus.NewDesc("slurm_jobs_running_total", "Number of jobs in Running state", nil, nil),
-			Suspended:   prometheus.NewDesc("slurm_jobs_suspended_total", "Number of jobs in Suspended state", nil, nil),
-			Timeout:     prometheus.NewDesc("slurm_jobs_timeout_total", "Number of jobs in Timeout state", nil, nil),
-			NodeFail:    prometheus.NewDesc("slurm_jobs_nodefail_total", "Number of jobs in NodeFail state", nil, nil),
-			OutOfMemory: prometheus.NewDesc("slurm_jobs_outofmemory_total", "Number of jobs in OutOfMemory state", nil, nil),
+			BootFail:    newDesc("slurm_jobs_bootfail_total", "Number of jobs in BootFail state", nil, nil),
+			Cancelled:   newDesc("slurm_jobs_cancelled_total", "Number of jobs in Cancelled state", nil, nil),
+			Completed:   newDesc("slurm_jobs_completed_total", "Number of jobs in Completed state", nil, nil),
+			Deadline:    newDesc("slurm_jobs_deadline_total", "Number of jobs in Deadline state", nil, nil),
+			Failed:      newDesc("slurm_jobs_failed_total", "Number of jobs in Failed state", nil, nil),
+			Pending:     newDesc("slurm_jobs_pending_total", "Number of jobs in Pending state", nil, nil),
+			Preempted:   newDesc("slurm_jobs_preempted_total", "Number of jobs in Preempted state", nil, nil),
+			Running:     newDesc("slurm_jobs_running_total", "Number of jobs in Running state", nil, nil),
+			Suspended:   newDesc("slurm_jobs_suspended_total", "Number of jobs in Suspended state", nil, nil),
+			Timeout:     newDesc("slurm_jobs_timeout_total", "Number of jobs in Timeout state", nil, nil),
+			NodeFail:    newDesc("slurm_jobs_nodefail_total", "Number of jobs in NodeFail state", nil, nil),
+			OutOfMemory: newDesc("slurm_jobs_outofmemory_total", "Number of jobs in OutOfMemory state", nil, nil),
 			// Flag States
-			Completing:  prometheus.NewDesc("slurm_jobs_completing_total", "Number of jobs with Completing flag", nil, nil),
-			Configuring: prometheus.NewDesc("slurm_jobs_configuring_total", "Number of jobs with Configuring flag", nil, nil),
-			PowerUpNode: prometheus.NewDesc("slurm_jobs_powerupnode_total", "Number of jobs with PowerUpNode flag", nil, nil),
-			StageOut:    prometheus.NewDesc("slurm_jobs_stageout_total", "Number of jobs with StageOut flag", nil, nil),
+			Completing:  newDesc("slurm_jobs_completing_total", "Number of jobs with Completing flag", nil, nil),
+			Configuring: newDesc("slurm_jobs_configuring_total", "Number of jobs with Configuring flag", nil, nil),
+			PowerUpNode: newDesc("slurm_jobs_powerupnode_total", "Number of jobs with PowerUpNode flag", nil, nil),
+			StageOut:    newDesc("slurm_jobs_stageout_total", "Number of jobs with StageOut flag", nil, nil),
 			// Other States
-			Hold: prometheus.NewDesc("slurm_jobs_hold_total", "Number of jobs with Hold flag", nil, nil),
+			Hold: newDesc("slurm_jobs_hold_total", "Number of jobs with Hold flag", nil, nil),
+			// All States
+			States: newDesc("slurm_jobs", "Number of jobs by base state, and by base state and flag", stateLabels, nil),
 		},
 		JobTres: jobTresCollector{
 			// CPUs
-			CpusAlloc: prometheus.NewDesc("slurm_jobs_cpus_alloc_total", "Number of Allocated CPUs among jobs", nil, nil),
+			CpusAlloc: newDesc("slurm_jobs_cpus_alloc_total", "Number of Allocated CPUs among jobs", nil, nil),
 			// Memory
-			MemoryAlloc: prometheus.NewDesc("slurm_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among jobs", nil, nil),
+			MemoryAlloc: newDesc("slurm_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among jobs", nil, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_jobs_energy_consumed_joules", "Amount of Energy (J) consumed among the jobs in the job list, which drops as jobs are purged; see slurm_job_lifecycle_energy_consumed_joules_total for the energy over time", nil, nil),
 		},
 	}
 }
 
 type jobCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
 
 	JobCount  *prometheus.Desc
 	JobStates jobStatesCollector
@@ -81,6 +87,8 @@ type jobStatesCollector struct {
 	StageOut    *prometheus.Desc
 	// Other States
 	Hold *prometheus.Desc
+	// All States
+	States *prometheus.Desc
 }
 
 type jobTresCollector struct {
@@ -88,6 +96,8 @@ type jobTresCollector struct {
 	CpusAlloc *prometheus.Desc
 	// Memory
 	MemoryAlloc *prometheus.Desc
+	// Energy
+	EnergyConsumed *prometheus.Desc
 }
 
 func (c *jobCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -95,15 +105,19 @@ func (c *jobCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *jobCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *jobCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("JobCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getJobMetrics(ctx)
+	metrics, err := c.getJobMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect job metrics")
-		return
+		return err
 	}
 
 	ch <- prometheus.MustNewConstMetric(c.JobCount, prometheus.GaugeValue, float64(metrics.JobCount))
@@ -125,34 +139,56 @@ func (c *jobCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(c.JobStates.PowerUpNode, prometheus.GaugeValue, float64(metrics.JobStates.PowerUpNode))
 	ch <- prometheus.MustNewConstMetric(c.JobStates.StageOut, prometheus.GaugeValue, float64(metrics.JobStates.StageOut))
 	ch <- prometheus.MustNewConstMetric(c.JobStates.Hold, prometheus.GaugeValue, float64(metrics.JobStates.Hold))
+	for key, count := range metrics.JobStates.States {
+		ch <- prometheus.MustNewConstMetric(c.JobStates.States, prometheus.GaugeValue, float64(count), key.State, key.Flag)
+	}
 	// Tres
 	ch <- prometheus.MustNewConstMetric(c.JobTres.CpusAlloc, prometheus.GaugeValue, float64(metrics.JobTres.CpusAlloc))
 	ch <- prometheus.MustNewConstMetric(c.JobTres.MemoryAlloc, prometheus.GaugeValue, float64(metrics.JobTres.MemoryAlloc))
+	ch <- prometheus.MustNewConstMetric(c.JobTres.EnergyConsumed, prometheus.GaugeValue, float64(metrics.JobTres.EnergyConsumed))
+	return nil
 }
 
-func (c *jobCollector) getJobMetrics(ctx context.Context) (*JobMetrics, error) {
-	jobList := &types.V0043JobInfoList{}
-	if err := c.slurmClient.List(ctx, jobList); err != nil {
+func (c *jobCollector) getJobMetrics(snapshot *Snapshot) (*JobMetrics, error) {
+	aggregates, err := snapshot.JobAggregates()
+	if err != nil {
 		return nil, err
 	}
-	metrics := calculateJobMetrics(jobList)
-	return metrics, nil
+	return &aggregates.JobMetrics, nil
 }
 
-func calculateJobMetrics(jobList *types.V0043JobInfoList) *JobMetrics {
-	metrics := &JobMetrics{
-		JobCount: uint(len(jobList.Items)),
-	}
-	for _, job := range jobList.Items {
-		calculateJobState(&metrics.JobStates, job)
-		calculateJobTres(&metrics.JobTres, job)
+// jobSample is what the job metrics need from a job, derived once per job
+// rather than once per aggregation the job is counted in.
+type jobSample struct {
+	states           set.Set[api.V0043JobInfoJobState]
+	hold             bool
+	resources        jobResources
+	pendingNodeCount uint
+	pending          pendingResources
+}
+
+func newJobSample(job types.V0043JobInfo) jobSample {
+	return jobSample{
+		states:           job.GetStateAsSet(),
+		hold:             ptr.Deref(job.Hold, false),
+		resources:        getJobResourceAlloc(job),
+		pendingNodeCount: getJobPendingNodeCount(job),
+		pending:          getJobPendingResources(job),
 	}
-	return metrics
+}
+
+func (s jobSample) addTo(metrics *JobMetrics) {
+	metrics.JobCount++
+	addJobStates(&metrics.JobStates, s.states, s.hold)
+	addJobResources(&metrics.JobTres, s.resources)
 }
 
 func calculateJobState(metrics *JobStates, job types.V0043JobInfo) {
+	addJobStates(metrics, job.GetStateAsSet(), ptr.Deref(job.Hold, false))
+}
+
+func addJobStates(metrics *JobStates, states set.Set[api.V0043JobInfoJobState], isHold bool) {
 	metrics.total++
-	states := job.GetStateAsSet()
 	// Base States
 	switch {
 	case states.Has(api.V0043JobInfoJobStateBOOTFAIL):
@@ -194,25 +230,40 @@ func calculateJobState(metrics *JobStates, job types.V0043JobInfo) {
 		metrics.StageOut++
 	}
 	// Other States
-	if isHold := ptr.Deref(job.Hold, false); isHold {
+	if isHold {
 		metrics.Hold++
 	}
+	// All States
+	if metrics.States == nil {
+		metrics.States = make(StateCounts)
+	}
+	countStates(metrics.States, states, jobBaseStates)
 }
 
 func calculateJobTres(metrics *JobTres, job types.V0043JobInfo) {
+	addJobResources(metrics, getJobResourceAlloc(job))
+}
+
+func addJobResources(metrics *JobTres, res jobResources) {
 	metrics.total++
-	res := getJobResourceAlloc(job)
 	metrics.CpusAlloc += res.Cpus
 	metrics.MemoryAlloc += res.Memory
+	metrics.EnergyConsumed += res.Energy
 }
 
 type jobResources struct {
 	Cpus   uint
 	Memory uint
+	Energy uint
 }
 
 func getJobResourceAlloc(job types.V0043JobInfo) jobResources {
 	var res jobResources
+	// Energy is only accounted in the job TRES, in joules.
+	tresAlloc := utils.ParseTRES(ptr.Deref(job.TresAllocStr, ""))
+	if energy, err := strconv.ParseUint(tresAlloc["energy"], 10, 64); err == nil {
+		res.Energy = uint(energy)
+	}
 	jobRes := ptr.Deref(job.JobResources, api.V0043JobRes{})
 	if jobRes.Nodes == nil {
 		return res
@@ -259,6 +310,8 @@ type JobStates struct {
 	StageOut    uint
 	// Other States
 	Hold uint
+	// All States
+	States StateCounts
 }
 
 type JobTres struct {
@@ -267,4 +320,6 @@ type JobTres struct {
 	CpusAlloc uint
 	// Memory
 	MemoryAlloc uint
+	// Energy
+	EnergyConsumed uint
 }
@@ -0,0 +1,323 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"maps"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/utils"
+)
+
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewJobLifecycleCollector(snapshots *Snapshotter) prometheus.Collector {
+	return &jobLifecycleCollector{
+		snapshots: snapshots,
+		tracker:   newJobTracker(),
+
+		JobEvents: jobEventsCollector{
+			Submitted: newDesc("slurm_job_lifecycle_submitted_total", "Number of jobs observed being submitted", jobLifecycleLabels, nil),
+			Started:   newDesc("slurm_job_lifecycle_started_total", "Number of jobs observed starting", jobLifecycleLabels, nil),
+			Completed: newDesc("slurm_job_lifecycle_completed_total", "Number of jobs observed completing successfully", jobLifecycleLabels, nil),
+			Failed:    newDesc("slurm_job_lifecycle_failed_total", "Number of jobs observed failing, includes BootFail, NodeFail and OutOfMemory", jobLifecycleLabels, nil),
+			Cancelled: newDesc("slurm_job_lifecycle_cancelled_total", "Number of jobs observed being cancelled", jobLifecycleLabels, nil),
+			Timeout:   newDesc("slurm_job_lifecycle_timeout_total", "Number of jobs observed reaching their time limit or deadline", jobLifecycleLabels, nil),
+			Preempted: newDesc("slurm_job_lifecycle_preempted_total", "Number of jobs observed being preempted", jobLifecycleLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_job_lifecycle_energy_consumed_joules_total", "Amount of Energy (J) consumed by the jobs observed finishing, the counter of the energy over time (e.g. kWh per project)", jobLifecycleLabels, nil),
+		},
+		UserEnergyConsumed: newDesc("slurm_job_lifecycle_user_energy_consumed_joules_total", "Amount of Energy (J) consumed by the jobs of the user observed finishing, the counter of the energy over time (e.g. kWh per user)", jobLifecycleUserLabels, nil),
+	}
+}
+
+// jobLifecycleCollector derives monotonic counters from the difference between
+// successive job list snapshots. Jobs which start and finish between two
+// scrapes are still counted as long as they remain in the job list (MinJobAge).
+type jobLifecycleCollector struct {
+	snapshots *Snapshotter
+	tracker   *jobTracker
+
+	JobEvents jobEventsCollector
+	// Energy per Account and User
+	UserEnergyConsumed *prometheus.Desc
+}
+
+type jobEventsCollector struct {
+	Submitted *prometheus.Desc
+	Started   *prometheus.Desc
+	Completed *prometheus.Desc
+	Failed    *prometheus.Desc
+	Cancelled *prometheus.Desc
+	Timeout   *prometheus.Desc
+	Preempted *prometheus.Desc
+	// Energy
+	EnergyConsumed *prometheus.Desc
+}
+
+func (c *jobLifecycleCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *jobLifecycleCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *jobLifecycleCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
+	logger := log.FromContext(ctx).WithName("JobLifecycleCollector")
+
+	logger.V(1).Info("collecting metrics")
+
+	metrics, err := c.getJobLifecycleMetrics(snapshot)
+	if err != nil {
+		logger.Error(err, "failed to collect job lifecycle metrics")
+		return err
+	}
+
+	for key, data := range metrics.JobEventsPer {
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Submitted, prometheus.CounterValue, float64(data.Submitted), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Started, prometheus.CounterValue, float64(data.Started), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Completed, prometheus.CounterValue, float64(data.Completed), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Failed, prometheus.CounterValue, float64(data.Failed), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Cancelled, prometheus.CounterValue, float64(data.Cancelled), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Timeout, prometheus.CounterValue, float64(data.Timeout), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.Preempted, prometheus.CounterValue, float64(data.Preempted), key.Partition, key.Account)
+		ch <- prometheus.MustNewConstMetric(c.JobEvents.EnergyConsumed, prometheus.CounterValue, float64(data.EnergyConsumed), key.Partition, key.Account)
+	}
+	for key, energy := range metrics.EnergyConsumedPerUser {
+		ch <- prometheus.MustNewConstMetric(c.UserEnergyConsumed, prometheus.CounterValue, float64(energy), key.Account, key.UserId, key.UserName)
+	}
+	return nil
+}
+
+func (c *jobLifecycleCollector) getJobLifecycleMetrics(snapshot *Snapshot) (*JobLifecycleMetrics, error) {
+	jobList, err := snapshot.Jobs()
+	if err != nil {
+		return nil, err
+	}
+	metrics := c.tracker.observe(jobList)
+	return metrics, nil
+}
+
+// jobTrackerKey identifies a single job submission. Slurm may reuse a job ID
+// (e.g. after FirstJobId wraps or slurmctld loses its state), but not together
+// with the same submit time.
+type jobTrackerKey struct {
+	JobId      int32
+	SubmitTime uint64
+}
+
+type jobTrackerEntry struct {
+	// key is where the events of the job are counted, for its whole lifecycle.
+	key      JobLifecycleKey
+	started  bool
+	finished bool
+}
+
+// jobTracker remembers the lifecycle progress of every job it has seen, so
+// each lifecycle event is counted exactly once no matter how many times the
+// same snapshot is observed.
+type jobTracker struct {
+	mu sync.Mutex
+
+	// initialized is set once the first snapshot was taken as the baseline.
+	initialized bool
+	jobs        map[jobTrackerKey]*jobTrackerEntry
+	metrics     *JobLifecycleMetrics
+}
+
+func newJobTracker() *jobTracker {
+	return &jobTracker{
+		jobs: make(map[jobTrackerKey]*jobTrackerEntry),
+		metrics: &JobLifecycleMetrics{
+			JobEventsPer:          make(map[JobLifecycleKey]*JobEvents),
+			EnergyConsumedPerUser: make(map[JobLifecycleUserKey]uint),
+		},
+	}
+}
+
+// observe diffs the job list against the previously observed one, counts any
+// lifecycle events and returns a copy of the accumulated counters. Events that
+// happened before the first snapshot are not counted.
+func (t *jobTracker) observe(jobList *types.V0043JobInfoList) *JobLifecycleMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seen := make(map[jobTrackerKey]bool, len(jobList.Items))
+	for _, job := range jobList.Items {
+		key := jobTrackerKey{
+			JobId:      ptr.Deref(job.JobId, 0),
+			SubmitTime: ParseUint64NoVal(job.SubmitTime),
+		}
+		seen[key] = true
+
+		started := isJobStarted(job)
+		finished := isJobFinished(job)
+
+		entry, ok := t.jobs[key]
+		if !ok {
+			entry = &jobTrackerEntry{key: jobLifecycleKey(job)}
+			t.jobs[key] = entry
+			if !t.initialized {
+				entry.started = started
+				entry.finished = finished
+				continue
+			}
+			t.eventsFor(entry).Submitted++
+		}
+
+		// A requeued job returns to pending and will start and finish again,
+		// whether or not it was observed finished in between.
+		switch {
+		case entry.finished && !finished:
+			entry.started = false
+			entry.finished = false
+		case entry.started && !started:
+			entry.started = false
+		}
+		if !entry.started && started {
+			entry.started = true
+			t.eventsFor(entry).Started++
+		}
+		if !entry.finished && finished {
+			entry.finished = true
+			calculateJobEvent(t.eventsFor(entry), job)
+			if energy := getJobResourceAlloc(job).Energy; energy > 0 {
+				userKey := JobLifecycleUserKey{
+					Account:     entry.key.Account,
+					UserContext: newUserContext(job),
+				}
+				t.metrics.EnergyConsumedPerUser[userKey] += energy
+			}
+		}
+	}
+	t.initialized = true
+
+	// Forget jobs that were purged from slurmctld (MinJobAge).
+	for key := range t.jobs {
+		if !seen[key] {
+			delete(t.jobs, key)
+		}
+	}
+
+	out := &JobLifecycleMetrics{
+		JobEventsPer:          make(map[JobLifecycleKey]*JobEvents, len(t.metrics.JobEventsPer)),
+		EnergyConsumedPerUser: maps.Clone(t.metrics.EnergyConsumedPerUser),
+	}
+	for key, data := range t.metrics.JobEventsPer {
+		out.JobEventsPer[key] = ptr.To(*data)
+	}
+	return out
+}
+
+// eventsFor returns the events of the job of the entry.
+func (t *jobTracker) eventsFor(entry *jobTrackerEntry) *JobEvents {
+	if _, ok := t.metrics.JobEventsPer[entry.key]; !ok {
+		t.metrics.JobEventsPer[entry.key] = &JobEvents{}
+	}
+	return t.metrics.JobEventsPer[entry.key]
+}
+
+// jobLifecycleKey returns where the events of the job are counted, by the
+// first of its partitions. A pending job may be submitted to several
+// partitions, of which it runs in one, so each of its events is counted once,
+// in the partition it was first observed in. Jobs without a partition are
+// counted in the empty partition.
+func jobLifecycleKey(job types.V0043JobInfo) JobLifecycleKey {
+	key := JobLifecycleKey{
+		Account: ptr.Deref(job.Account, ""),
+	}
+	if partitions := utils.ParseCSV(ptr.Deref(job.Partition, "")); len(partitions) > 0 {
+		key.Partition = partitions[0]
+	}
+	return key
+}
+
+// isJobStarted reports whether the job was allocated resources and launched.
+// Jobs cancelled while pending get a start time but never an allocation.
+func isJobStarted(job types.V0043JobInfo) bool {
+	states := job.GetStateAsSet()
+	switch {
+	case states.HasAny(api.V0043JobInfoJobStateRUNNING, api.V0043JobInfoJobStateSUSPENDED):
+		return true
+	case states.Has(api.V0043JobInfoJobStatePENDING):
+		return false
+	}
+	return ParseUint64NoVal(job.StartTime) > 0 && ptr.Deref(job.Nodes, "") != ""
+}
+
+// isJobFinished reports whether the job reached a terminal base state.
+func isJobFinished(job types.V0043JobInfo) bool {
+	return job.GetStateAsSet().HasAny(
+		api.V0043JobInfoJobStateBOOTFAIL,
+		api.V0043JobInfoJobStateCANCELLED,
+		api.V0043JobInfoJobStateCOMPLETED,
+		api.V0043JobInfoJobStateDEADLINE,
+		api.V0043JobInfoJobStateFAILED,
+		api.V0043JobInfoJobStateNODEFAIL,
+		api.V0043JobInfoJobStateOUTOFMEMORY,
+		api.V0043JobInfoJobStatePREEMPTED,
+		api.V0043JobInfoJobStateTIMEOUT,
+	)
+}
+
+func calculateJobEvent(metrics *JobEvents, job types.V0043JobInfo) {
+	states := job.GetStateAsSet()
+	switch {
+	case states.Has(api.V0043JobInfoJobStateCOMPLETED):
+		metrics.Completed++
+	case states.Has(api.V0043JobInfoJobStateCANCELLED):
+		metrics.Cancelled++
+	case states.HasAny(api.V0043JobInfoJobStateTIMEOUT, api.V0043JobInfoJobStateDEADLINE):
+		metrics.Timeout++
+	case states.Has(api.V0043JobInfoJobStatePREEMPTED):
+		metrics.Preempted++
+	case states.HasAny(
+		api.V0043JobInfoJobStateFAILED,
+		api.V0043JobInfoJobStateBOOTFAIL,
+		api.V0043JobInfoJobStateNODEFAIL,
+		api.V0043JobInfoJobStateOUTOFMEMORY,
+	):
+		metrics.Failed++
+	}
+	// The energy of a job is final once it finished, unlike the energy among
+	// the jobs in the job list, which drops as the jobs are purged.
+	metrics.EnergyConsumed += getJobResourceAlloc(job).Energy
+}
+
+type JobLifecycleMetrics struct {
+	// Per Partition and Account
+	JobEventsPer map[JobLifecycleKey]*JobEvents
+	// Per Account and User
+	EnergyConsumedPerUser map[JobLifecycleUserKey]uint
+}
+
+type JobLifecycleKey struct {
+	Partition string
+	Account   string
+}
+
+type JobLifecycleUserKey struct {
+	Account string
+	UserContext
+}
+
+type JobEvents struct {
+	Submitted uint
+	Started   uint
+	Completed uint
+	Failed    uint
+	Cancelled uint
+	Timeout   uint
+	Preempted uint
+	// Energy
+	EnergyConsumed uint
+}
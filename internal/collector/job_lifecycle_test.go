@@ -0,0 +1,428 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"testing"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+func newLifecycleJob(jobId int32, submitTime int64, state api.V0043JobInfoJobState) types.V0043JobInfo {
+	job := types.V0043JobInfo{V0043JobInfo: api.V0043JobInfo{
+		JobId:     ptr.To(jobId),
+		JobState:  ptr.To([]api.V0043JobInfoJobState{state}),
+		Partition: ptr.To(partition1Name),
+		Account:   ptr.To("root"),
+		SubmitTime: &api.V0043Uint64NoValStruct{
+			Number: ptr.To(submitTime),
+			Set:    ptr.To(true),
+		},
+	}}
+	if state != api.V0043JobInfoJobStatePENDING {
+		job.Nodes = ptr.To(ptr.Deref(node0.Name, ""))
+		job.StartTime = &api.V0043Uint64NoValStruct{
+			Number: ptr.To(submitTime + 1),
+			Set:    ptr.To(true),
+		}
+	}
+	return job
+}
+
+func withEnergy(job types.V0043JobInfo, energy string) types.V0043JobInfo {
+	job.TresAllocStr = ptr.To("cpu=1,energy=" + energy)
+	return job
+}
+
+func withUser(job types.V0043JobInfo, userId int32, userName string) types.V0043JobInfo {
+	job.UserId = ptr.To(userId)
+	job.UserName = ptr.To(userName)
+	return job
+}
+
+func withPartition(job types.V0043JobInfo, partition string) types.V0043JobInfo {
+	job.Partition = ptr.To(partition)
+	return job
+}
+
+func Test_isJobStarted(t *testing.T) {
+	cancelledPending := newLifecycleJob(1, 100, api.V0043JobInfoJobStateCANCELLED)
+	cancelledPending.Nodes = nil
+	tests := []struct {
+		name string
+		job  types.V0043JobInfo
+		want bool
+	}{
+		{
+			name: "empty",
+			job:  types.V0043JobInfo{},
+			want: false,
+		},
+		{
+			name: "pending",
+			job:  newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING),
+			want: false,
+		},
+		{
+			name: "running",
+			job:  newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING),
+			want: true,
+		},
+		{
+			name: "completed",
+			job:  newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED),
+			want: true,
+		},
+		{
+			name: "cancelled while pending",
+			job:  cancelledPending,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isJobStarted(tt.job); got != tt.want {
+				t.Errorf("isJobStarted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_calculateJobEvent(t *testing.T) {
+	tests := []struct {
+		name  string
+		state api.V0043JobInfoJobState
+		want  *JobEvents
+	}{
+		{
+			name:  "completed",
+			state: api.V0043JobInfoJobStateCOMPLETED,
+			want:  &JobEvents{Completed: 1},
+		},
+		{
+			name:  "cancelled",
+			state: api.V0043JobInfoJobStateCANCELLED,
+			want:  &JobEvents{Cancelled: 1},
+		},
+		{
+			name:  "timeout",
+			state: api.V0043JobInfoJobStateTIMEOUT,
+			want:  &JobEvents{Timeout: 1},
+		},
+		{
+			name:  "deadline",
+			state: api.V0043JobInfoJobStateDEADLINE,
+			want:  &JobEvents{Timeout: 1},
+		},
+		{
+			name:  "preempted",
+			state: api.V0043JobInfoJobStatePREEMPTED,
+			want:  &JobEvents{Preempted: 1},
+		},
+		{
+			name:  "failed",
+			state: api.V0043JobInfoJobStateFAILED,
+			want:  &JobEvents{Failed: 1},
+		},
+		{
+			name:  "node fail",
+			state: api.V0043JobInfoJobStateNODEFAIL,
+			want:  &JobEvents{Failed: 1},
+		},
+		{
+			name:  "out of memory",
+			state: api.V0043JobInfoJobStateOUTOFMEMORY,
+			want:  &JobEvents{Failed: 1},
+		},
+		{
+			name:  "running",
+			state: api.V0043JobInfoJobStateRUNNING,
+			want:  &JobEvents{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &JobEvents{}
+			calculateJobEvent(got, newLifecycleJob(1, 100, tt.state))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("calculateJobEvent() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_jobTracker_observe(t *testing.T) {
+	key := JobLifecycleKey{Partition: partition1Name, Account: "root"}
+	tests := []struct {
+		name      string
+		snapshots [][]types.V0043JobInfo
+		want      *JobLifecycleMetrics
+	}{
+		{
+			name:      "empty",
+			snapshots: [][]types.V0043JobInfo{{}},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{},
+			},
+		},
+		{
+			name: "baseline is not counted",
+			snapshots: [][]types.V0043JobInfo{
+				{
+					newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING),
+					newLifecycleJob(2, 100, api.V0043JobInfoJobStateRUNNING),
+					newLifecycleJob(3, 100, api.V0043JobInfoJobStateCOMPLETED),
+				},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{},
+			},
+		},
+		{
+			name: "full lifecycle",
+			snapshots: [][]types.V0043JobInfo{
+				{},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Completed: 1},
+				},
+			},
+		},
+		{
+			name: "started and finished between snapshots",
+			snapshots: [][]types.V0043JobInfo{
+				{},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateTIMEOUT)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Timeout: 1},
+				},
+			},
+		},
+		{
+			name: "same snapshot observed repeatedly",
+			snapshots: [][]types.V0043JobInfo{
+				{},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateFAILED)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateFAILED)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateFAILED)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Failed: 1},
+				},
+			},
+		},
+		{
+			name: "job id reuse",
+			snapshots: [][]types.V0043JobInfo{
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED)},
+				{newLifecycleJob(1, 200, api.V0043JobInfoJobStateCANCELLED)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Cancelled: 1},
+				},
+			},
+		},
+		{
+			name: "energy of finished jobs",
+			snapshots: [][]types.V0043JobInfo{
+				{withEnergy(newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED), "500")},
+				{withEnergy(newLifecycleJob(2, 100, api.V0043JobInfoJobStateRUNNING), "1000")},
+				{withUser(withEnergy(newLifecycleJob(2, 100, api.V0043JobInfoJobStateCOMPLETED), "3000"), 1000, "alice")},
+				// Purging the job keeps its energy.
+				{},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Completed: 1, EnergyConsumed: 3000},
+				},
+				EnergyConsumedPerUser: map[JobLifecycleUserKey]uint{
+					{Account: "root", UserContext: UserContext{UserId: "1000", UserName: "alice"}}: 3000,
+				},
+			},
+		},
+		{
+			name: "several partitions",
+			snapshots: [][]types.V0043JobInfo{
+				{},
+				{withPartition(newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING), partition1Name+","+partition2Name)},
+				// The job runs in another partition than the first listed.
+				{withPartition(newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING), partition2Name)},
+				{withPartition(newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED), partition2Name)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Submitted: 1, Started: 1, Completed: 1},
+				},
+			},
+		},
+		{
+			name: "no partition",
+			snapshots: [][]types.V0043JobInfo{
+				{},
+				{withPartition(newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING), "")},
+				{withPartition(newLifecycleJob(1, 100, api.V0043JobInfoJobStateFAILED), "")},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					{Partition: "", Account: "root"}: {Submitted: 1, Started: 1, Failed: 1},
+				},
+			},
+		},
+		{
+			name: "requeue without a finished state",
+			snapshots: [][]types.V0043JobInfo{
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Started: 1},
+				},
+			},
+		},
+		{
+			name: "requeue",
+			snapshots: [][]types.V0043JobInfo{
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStatePREEMPTED)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStatePENDING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateRUNNING)},
+				{newLifecycleJob(1, 100, api.V0043JobInfoJobStateCOMPLETED)},
+			},
+			want: &JobLifecycleMetrics{
+				JobEventsPer: map[JobLifecycleKey]*JobEvents{
+					key: {Started: 1, Preempted: 1, Completed: 1},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newJobTracker()
+			var got *JobLifecycleMetrics
+			for _, items := range tt.snapshots {
+				got = tracker.observe(&types.V0043JobInfoList{Items: items})
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("jobTracker.observe() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestJobLifecycleCollector_Collect(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan prometheus.Metric
+	}
+	tests := []struct {
+		name     string
+		fields   fields
+		args     args
+		wantNone bool
+	}{
+		{
+			name: "empty",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "data",
+			fields: fields{
+				slurmClient: testDataClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "failure",
+			fields: fields{
+				slurmClient: testFailClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+			wantNone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewJobLifecycleCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Collect(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var got int
+			for range tt.args.ch {
+				got++
+			}
+			if !tt.wantNone {
+				assert.GreaterOrEqual(t, got, 0)
+			} else {
+				assert.Equal(t, got, 0)
+			}
+		})
+	}
+}
+
+func TestJobLifecycleCollector_Describe(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan *prometheus.Desc
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+	}{
+		{
+			name: "test",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan *prometheus.Desc),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewJobLifecycleCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Describe(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var desc *prometheus.Desc
+			for desc = range tt.args.ch {
+				assert.NotNil(t, desc)
+			}
+		})
+	}
+}
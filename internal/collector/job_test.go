@@ -55,6 +55,17 @@ func Test_getJobResourceAlloc(t *testing.T) {
 				Memory: 3072,
 			},
 		},
+		{
+			name: "energy",
+			args: args{
+				job: types.V0043JobInfo{V0043JobInfo: api.V0043JobInfo{
+					TresAllocStr: ptr.To("cpu=4,mem=8G,node=1,billing=4,energy=7200"),
+				}},
+			},
+			want: jobResources{
+				Energy: 7200,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -76,7 +87,7 @@ func Test_calculateJobState(t *testing.T) {
 	}{
 		{
 			name: "empty",
-			want: &JobStates{},
+			want: &JobStates{States: StateCounts{{State: "unknown"}: 1}},
 		},
 		{
 			name: "boot fail",
@@ -87,7 +98,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{BootFail: 1},
+			want: &JobStates{BootFail: 1, States: StateCounts{{State: "boot_fail"}: 1}},
 		},
 		{
 			name: "cancelled",
@@ -98,7 +109,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Cancelled: 1},
+			want: &JobStates{Cancelled: 1, States: StateCounts{{State: "cancelled"}: 1}},
 		},
 		{
 			name: "completed",
@@ -109,7 +120,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Completed: 1},
+			want: &JobStates{Completed: 1, States: StateCounts{{State: "completed"}: 1}},
 		},
 		{
 			name: "deadline",
@@ -120,7 +131,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Deadline: 1},
+			want: &JobStates{Deadline: 1, States: StateCounts{{State: "deadline"}: 1}},
 		},
 		{
 			name: "failed",
@@ -131,7 +142,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Failed: 1},
+			want: &JobStates{Failed: 1, States: StateCounts{{State: "failed"}: 1}},
 		},
 		{
 			name: "pending",
@@ -142,7 +153,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Pending: 1},
+			want: &JobStates{Pending: 1, States: StateCounts{{State: "pending"}: 1}},
 		},
 		{
 			name: "preempted",
@@ -153,7 +164,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Preempted: 1},
+			want: &JobStates{Preempted: 1, States: StateCounts{{State: "preempted"}: 1}},
 		},
 		{
 			name: "running",
@@ -164,7 +175,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Running: 1},
+			want: &JobStates{Running: 1, States: StateCounts{{State: "running"}: 1}},
 		},
 		{
 			name: "suspended",
@@ -175,7 +186,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Suspended: 1},
+			want: &JobStates{Suspended: 1, States: StateCounts{{State: "suspended"}: 1}},
 		},
 		{
 			name: "timeout",
@@ -186,7 +197,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{Timeout: 1},
+			want: &JobStates{Timeout: 1, States: StateCounts{{State: "timeout"}: 1}},
 		},
 		{
 			name: "node fail",
@@ -197,7 +208,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{NodeFail: 1},
+			want: &JobStates{NodeFail: 1, States: StateCounts{{State: "node_fail"}: 1}},
 		},
 		{
 			name: "out of memory",
@@ -208,7 +219,7 @@ func Test_calculateJobState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &JobStates{OutOfMemory: 1},
+			want: &JobStates{OutOfMemory: 1, States: StateCounts{{State: "out_of_memory"}: 1}},
 		},
 		{
 			name: "all states, all flags",
@@ -253,6 +264,24 @@ func Test_calculateJobState(t *testing.T) {
 				PowerUpNode: 1,
 				StageOut:    1,
 				Hold:        1,
+				States: StateCounts{
+					{State: "boot_fail"}:                        1,
+					{State: "boot_fail", Flag: "completing"}:    1,
+					{State: "boot_fail", Flag: "configuring"}:   1,
+					{State: "boot_fail", Flag: "launch_failed"}: 1,
+					{State: "boot_fail", Flag: "power_up_node"}: 1,
+					{State: "boot_fail", Flag: "reconfig_fail"}: 1,
+					{State: "boot_fail", Flag: "requeued"}:      1,
+					{State: "boot_fail", Flag: "requeue_fed"}:   1,
+					{State: "boot_fail", Flag: "requeue_hold"}:  1,
+					{State: "boot_fail", Flag: "resizing"}:      1,
+					{State: "boot_fail", Flag: "resv_del_hold"}: 1,
+					{State: "boot_fail", Flag: "revoked"}:       1,
+					{State: "boot_fail", Flag: "signaling"}:     1,
+					{State: "boot_fail", Flag: "special_exit"}:  1,
+					{State: "boot_fail", Flag: "stage_out"}:     1,
+					{State: "boot_fail", Flag: "stopped"}:       1,
+				},
 			},
 		},
 	}
@@ -304,7 +333,7 @@ func TestJobCollector_getJobMetrics(t *testing.T) {
 			},
 			want: &JobMetrics{
 				JobCount:  4,
-				JobStates: JobStates{Pending: 2, Running: 2, Hold: 1},
+				JobStates: JobStates{Pending: 2, Running: 2, Hold: 1, States: StateCounts{{State: "pending"}: 2, {State: "running"}: 2}},
 				JobTres:   JobTres{CpusAlloc: 20, MemoryAlloc: 4096},
 			},
 		},
@@ -323,9 +352,9 @@ func TestJobCollector_getJobMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &jobCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getJobMetrics(tt.args.ctx)
+			got, err := c.getJobMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("jobCollector.getJobMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -386,7 +415,7 @@ func TestJobCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewJobCollector(tt.fields.slurmClient)
+			c := NewJobCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -428,7 +457,7 @@ func TestJobCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewJobCollector(tt.fields.slurmClient)
+			c := NewJobCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
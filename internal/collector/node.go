@@ -8,57 +8,97 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/utils/ptr"
+	"k8s.io/utils/set"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	api "github.com/SlinkyProject/slurm-client/api/v0043"
-	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
 )
 
+// NodePod is the Kubernetes pod which a Slurm node runs in.
+type NodePod struct {
+	Namespace string
+	Pod       string
+	// K8sNode is the Kubernetes node of the pod.
+	K8sNode string
+	// NodeSet is the NodeSet which owns the pod, if any.
+	NodeSet string
+}
+
+// NodePods looks up the pods of the Slurm nodes.
+type NodePods interface {
+	// NodePods returns the pods of the Slurm nodes, by node name.
+	NodePods(ctx context.Context) (map[string]NodePod, error)
+}
+
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewNodeCollector(slurmClient client.Client) prometheus.Collector {
+func NewNodeCollector(snapshots *Snapshotter) prometheus.Collector {
+	return NewNodeCollectorWithPods(snapshots, nil)
+}
+
+// NewNodeCollectorWithPods returns a node collector which maps each node to
+// its pod by slurm_node_info, such that the per node metrics join with the
+// metrics of kube-state-metrics and cAdvisor. Without pods, it is the same as
+// NewNodeCollector.
+func NewNodeCollectorWithPods(snapshots *Snapshotter, pods NodePods) prometheus.Collector {
+	var nodeInfo *prometheus.Desc
+	if pods != nil {
+		nodeInfo = newDesc("slurm_node_info", "Information about the node and the Kubernetes pod it runs in", nodePodLabels, nil)
+	}
 	return &nodeCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
+		pods:      pods,
+
+		NodeInfo: nodeInfo,
 
-		NodeCount: prometheus.NewDesc("slurm_nodes_total", "Total number of nodes", nil, nil),
+		NodeCount: newDesc("slurm_nodes_total", "Total number of nodes", nil, nil),
 		NodeStates: nodeStatesCollector{
 			// Base State
-			Allocated: prometheus.NewDesc("slurm_nodes_allocated_total", "Number of nodes in Allocated state", nil, nil),
-			Down:      prometheus.NewDesc("slurm_nodes_down_total", "Number of nodes in Down state", nil, nil),
-			Error:     prometheus.NewDesc("slurm_nodes_error_total", "Number of nodes in Error state", nil, nil),
-			Future:    prometheus.NewDesc("slurm_nodes_future_total", "Number of nodes in Future state", nil, nil),
-			Idle:      prometheus.NewDesc("slurm_nodes_idle_total", "Number of nodes in Idle state", nil, nil),
-			Mixed:     prometheus.NewDesc("slurm_nodes_mixed_total", "Number of nodes in Mixed state", nil, nil),
-			Unknown:   prometheus.NewDesc("slurm_nodes_unknown_total", "Number of nodes in Unknown state", nil, nil),
+			Allocated: newDesc("slurm_nodes_allocated_total", "Number of nodes in Allocated state", nil, nil),
+			Down:      newDesc("slurm_nodes_down_total", "Number of nodes in Down state", nil, nil),
+			Error:     newDesc("slurm_nodes_error_total", "Number of nodes in Error state", nil, nil),
+			Future:    newDesc("slurm_nodes_future_total", "Number of nodes in Future state", nil, nil),
+			Idle:      newDesc("slurm_nodes_idle_total", "Number of nodes in Idle state", nil, nil),
+			Mixed:     newDesc("slurm_nodes_mixed_total", "Number of nodes in Mixed state", nil, nil),
+			Unknown:   newDesc("slurm_nodes_unknown_total", "Number of nodes in Unknown state", nil, nil),
 			// Flag State
-			Completing:      prometheus.NewDesc("slurm_nodes_completing_total", "Number of nodes with Completing flag", nil, nil),
-			Drain:           prometheus.NewDesc("slurm_nodes_drain_total", "Number of nodes with Drain flag", nil, nil),
-			Fail:            prometheus.NewDesc("slurm_nodes_fail_total", "Number of nodes with Fail flag", nil, nil),
-			Maintenance:     prometheus.NewDesc("slurm_nodes_maintenance_total", "Number of nodes with Maintenance flag", nil, nil),
-			NotResponding:   prometheus.NewDesc("slurm_nodes_notresponding_total", "Number of nodes with NotResponding flag", nil, nil),
-			Planned:         prometheus.NewDesc("slurm_nodes_planned_total", "Number of nodes with Planned flag", nil, nil),
-			RebootRequested: prometheus.NewDesc("slurm_nodes_rebootrequested_total", "Number of nodes with RebootRequested flag", nil, nil),
-			Reserved:        prometheus.NewDesc("slurm_nodes_reserved_total", "Number of nodes with Reserved flag", nil, nil),
+			Completing:      newDesc("slurm_nodes_completing_total", "Number of nodes with Completing flag", nil, nil),
+			Drain:           newDesc("slurm_nodes_drain_total", "Number of nodes with Drain flag", nil, nil),
+			Fail:            newDesc("slurm_nodes_fail_total", "Number of nodes with Fail flag", nil, nil),
+			Maintenance:     newDesc("slurm_nodes_maintenance_total", "Number of nodes with Maintenance flag", nil, nil),
+			NotResponding:   newDesc("slurm_nodes_notresponding_total", "Number of nodes with NotResponding flag", nil, nil),
+			Planned:         newDesc("slurm_nodes_planned_total", "Number of nodes with Planned flag", nil, nil),
+			RebootRequested: newDesc("slurm_nodes_rebootrequested_total", "Number of nodes with RebootRequested flag", nil, nil),
+			Reserved:        newDesc("slurm_nodes_reserved_total", "Number of nodes with Reserved flag", nil, nil),
+			// All States
+			States: newDesc("slurm_nodes", "Number of nodes by base state, and by base state and flag", stateLabels, nil),
 		},
 		NodeTres: nodeTresCollector{
 			// CPUs
-			CpusTotal:     prometheus.NewDesc("slurm_node_cpus_total", "Total number of CPUs on the node", nodeLabels, nil),
-			CpusEffective: prometheus.NewDesc("slurm_node_cpus_effective_total", "Total number of effective CPUs on the node, excludes CoreSpec", nodeLabels, nil),
-			CpusAlloc:     prometheus.NewDesc("slurm_node_cpus_alloc_total", "Number of Allocated CPUs on the node", nodeLabels, nil),
-			CpusIdle:      prometheus.NewDesc("slurm_node_cpus_idle_total", "Number of Idle CPUs on the node", nodeLabels, nil),
+			CpusTotal:     newDesc("slurm_node_cpus_total", "Total number of CPUs on the node", nodeLabels, nil),
+			CpusEffective: newDesc("slurm_node_cpus_effective_total", "Total number of effective CPUs on the node, excludes CoreSpec", nodeLabels, nil),
+			CpusAlloc:     newDesc("slurm_node_cpus_alloc_total", "Number of Allocated CPUs on the node", nodeLabels, nil),
+			CpusIdle:      newDesc("slurm_node_cpus_idle_total", "Number of Idle CPUs on the node", nodeLabels, nil),
 			// Memory
-			MemoryTotal:     prometheus.NewDesc("slurm_node_memory_bytes", "Total amount of Memory (MB) on the node", nodeLabels, nil),
-			MemoryEffective: prometheus.NewDesc("slurm_node_memory_effective_bytes", "Total amount of effective Memory (MB) on the node, excludes MemSpec", nodeLabels, nil),
-			MemoryAlloc:     prometheus.NewDesc("slurm_node_memory_alloc_bytes", "Amount of Allocated Memory (MB) on the node", nodeLabels, nil),
-			MemoryFree:      prometheus.NewDesc("slurm_node_memory_free_bytes", "Amount of Free Memory (MB) on the node", nodeLabels, nil),
+			MemoryTotal:     newDesc("slurm_node_memory_bytes", "Total amount of Memory (MB) on the node", nodeLabels, nil),
+			MemoryEffective: newDesc("slurm_node_memory_effective_bytes", "Total amount of effective Memory (MB) on the node, excludes MemSpec", nodeLabels, nil),
+			MemoryAlloc:     newDesc("slurm_node_memory_alloc_bytes", "Amount of Allocated Memory (MB) on the node", nodeLabels, nil),
+			MemoryFree:      newDesc("slurm_node_memory_free_bytes", "Amount of Free Memory (MB) on the node", nodeLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_node_energy_consumed_joules_total", "Amount of Energy (J) consumed by the node since slurmd registered", nodeLabels, nil),
+			PowerCurrent:   newDesc("slurm_node_power_watts", "Current power consumption (W) of the node", nodeLabels, nil),
+			PowerAverage:   newDesc("slurm_node_power_average_watts", "Average power consumption (W) of the node", nodeLabels, nil),
 		},
 	}
 }
 
 // Ref: https://slurm.schedmd.com/sinfo.html#SECTION_NODE-STATE-CODES
 type nodeCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
+	pods      NodePods
 
+	// NodeInfo is only set along with pods.
+	NodeInfo   *prometheus.Desc
 	NodeCount  *prometheus.Desc
 	NodeStates nodeStatesCollector
 	NodeTres   nodeTresCollector
@@ -82,6 +122,8 @@ type nodeStatesCollector struct {
 	Planned         *prometheus.Desc
 	RebootRequested *prometheus.Desc
 	Reserved        *prometheus.Desc
+	// All States
+	States *prometheus.Desc
 }
 
 type nodeTresCollector struct {
@@ -95,6 +137,10 @@ type nodeTresCollector struct {
 	MemoryEffective *prometheus.Desc
 	MemoryAlloc     *prometheus.Desc
 	MemoryFree      *prometheus.Desc
+	// Energy
+	EnergyConsumed *prometheus.Desc
+	PowerCurrent   *prometheus.Desc
+	PowerAverage   *prometheus.Desc
 }
 
 func (c *nodeCollector) Describe(ch chan<- *prometheus.Desc) {
@@ -102,15 +148,19 @@ func (c *nodeCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *nodeCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *nodeCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("NodeCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getNodeMetrics(ctx)
+	metrics, err := c.getNodeMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect node metrics")
-		return
+		return err
 	}
 
 	ch <- prometheus.MustNewConstMetric(c.NodeCount, prometheus.GaugeValue, float64(metrics.NodeCount))
@@ -131,52 +181,60 @@ func (c *nodeCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(c.NodeStates.Planned, prometheus.GaugeValue, float64(metrics.NodeStates.Planned))
 	ch <- prometheus.MustNewConstMetric(c.NodeStates.RebootRequested, prometheus.GaugeValue, float64(metrics.NodeStates.RebootRequested))
 	ch <- prometheus.MustNewConstMetric(c.NodeStates.Reserved, prometheus.GaugeValue, float64(metrics.NodeStates.Reserved))
+	for key, count := range metrics.NodeStates.States {
+		ch <- prometheus.MustNewConstMetric(c.NodeStates.States, prometheus.GaugeValue, float64(count), key.State, key.Flag)
+	}
 
+	nodePods, err := c.getNodePods(ctx)
+	if err != nil {
+		// The node info is left out rather than failing the scrape.
+		logger.Error(err, "failed to get the pods of the nodes")
+	}
 	for node, data := range metrics.NodeTresPer {
+		// Only the nodes whose pod is known have a node info.
+		if pod, ok := nodePods[node]; ok {
+			ch <- prometheus.MustNewConstMetric(c.NodeInfo, prometheus.GaugeValue, 1, node, pod.Namespace, pod.Pod, pod.K8sNode, pod.NodeSet)
+		}
+		labels := []string{node}
 		// CPUs
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusTotal, prometheus.GaugeValue, float64(data.CpusTotal), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusEffective, prometheus.GaugeValue, float64(data.CpusEffective), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusAlloc, prometheus.GaugeValue, float64(data.CpusAlloc), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusIdle, prometheus.GaugeValue, float64(data.CpusIdle), node)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusTotal, prometheus.GaugeValue, float64(data.CpusTotal), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusEffective, prometheus.GaugeValue, float64(data.CpusEffective), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusAlloc, prometheus.GaugeValue, float64(data.CpusAlloc), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusIdle, prometheus.GaugeValue, float64(data.CpusIdle), labels...)
 		// Memory
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryTotal, prometheus.GaugeValue, float64(data.MemoryTotal), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryEffective, prometheus.GaugeValue, float64(data.MemoryEffective), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryAlloc, prometheus.GaugeValue, float64(data.MemoryAlloc), node)
-		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryFree, prometheus.GaugeValue, float64(data.MemoryFree), node)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryTotal, prometheus.GaugeValue, float64(data.MemoryTotal), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryEffective, prometheus.GaugeValue, float64(data.MemoryEffective), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryAlloc, prometheus.GaugeValue, float64(data.MemoryAlloc), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryFree, prometheus.GaugeValue, float64(data.MemoryFree), labels...)
+		// Energy
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.EnergyConsumed, prometheus.CounterValue, float64(data.EnergyConsumed), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.PowerCurrent, prometheus.GaugeValue, float64(data.PowerCurrent), labels...)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.PowerAverage, prometheus.GaugeValue, float64(data.PowerAverage), labels...)
 	}
+	return nil
 }
 
-func (c *nodeCollector) getNodeMetrics(ctx context.Context) (*NodeCollectorMetrics, error) {
-	nodeList := &types.V0043NodeList{}
-	if err := c.slurmClient.List(ctx, nodeList); err != nil {
+func (c *nodeCollector) getNodeMetrics(snapshot *Snapshot) (*NodeCollectorMetrics, error) {
+	aggregates, err := snapshot.NodeAggregates()
+	if err != nil {
 		return nil, err
 	}
-	metrics := calculateNodeMetrics(nodeList)
-	return metrics, nil
+	return &aggregates.NodeCollectorMetrics, nil
 }
 
-func calculateNodeMetrics(nodeList *types.V0043NodeList) *NodeCollectorMetrics {
-	metrics := &NodeCollectorMetrics{
-		NodeMetrics: NodeMetrics{
-			NodeCount: uint(len(nodeList.Items)),
-		},
-		NodeTresPer: make(map[string]*NodeTres, len(nodeList.Items)),
+func (c *nodeCollector) getNodePods(ctx context.Context) (map[string]NodePod, error) {
+	if c.pods == nil {
+		return nil, nil
 	}
-	for _, node := range nodeList.Items {
-		key := string(node.GetKey())
-		calculateNodeState(&metrics.NodeStates, node)
-		calculateNodeTres(&metrics.NodeTres, node)
-		if _, ok := metrics.NodeTresPer[key]; !ok {
-			metrics.NodeTresPer[key] = &NodeTres{}
-		}
-		calculateNodeTres(metrics.NodeTresPer[key], node)
-	}
-	return metrics
+	return c.pods.NodePods(ctx)
 }
 
 func calculateNodeState(metrics *NodeStates, node types.V0043Node) {
+	addNodeStates(metrics, node.GetStateAsSet())
+}
+
+func addNodeStates(metrics *NodeStates, states set.Set[api.V0043NodeState]) {
 	metrics.total++
-	states := node.GetStateAsSet()
 	// Base States
 	switch {
 	case states.Has(api.V0043NodeStateALLOCATED):
@@ -219,6 +277,11 @@ func calculateNodeState(metrics *NodeStates, node types.V0043Node) {
 	if states.Has(api.V0043NodeStateRESERVED) {
 		metrics.Reserved++
 	}
+	// All States
+	if metrics.States == nil {
+		metrics.States = make(StateCounts)
+	}
+	countStates(metrics.States, states, nodeBaseStates)
 }
 
 func calculateNodeTres(metrics *NodeTres, node types.V0043Node) {
@@ -233,6 +296,11 @@ func calculateNodeTres(metrics *NodeTres, node types.V0043Node) {
 	metrics.MemoryEffective += uint(ptr.Deref(node.RealMemory, 0) - ptr.Deref(node.SpecializedMemory, 0))
 	metrics.MemoryAlloc += uint(ptr.Deref(node.AllocMemory, 0))
 	metrics.MemoryFree += uint(ParseUint64NoVal(node.FreeMem))
+	// Energy
+	energy := ptr.Deref(node.Energy, api.V0043AcctGatherEnergy{})
+	metrics.EnergyConsumed += uint(max(ptr.Deref(energy.ConsumedEnergy, 0), 0))
+	metrics.PowerCurrent += uint(ParseUint32NoVal(energy.CurrentWatts))
+	metrics.PowerAverage += uint(max(ptr.Deref(energy.AverageWatts, 0), 0))
 }
 
 type NodeCollectorMetrics struct {
@@ -267,6 +335,8 @@ type NodeStates struct {
 	Planned         uint
 	RebootRequested uint
 	Reserved        uint
+	// All States
+	States StateCounts
 }
 
 type NodeTres struct {
@@ -281,4 +351,8 @@ type NodeTres struct {
 	MemoryEffective uint
 	MemoryAlloc     uint
 	MemoryFree      uint
+	// Energy
+	EnergyConsumed uint
+	PowerCurrent   uint
+	PowerAverage   uint
 }
@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+)
+
+// Power saving phases of a node.
+// Ref: https://slurm.schedmd.com/power_save.html
+const (
+	nodePowerPhasePoweredUp    = "powered_up"
+	nodePowerPhasePowerDown    = "power_down"
+	nodePowerPhasePoweringDown = "powering_down"
+	nodePowerPhasePoweredDown  = "powered_down"
+	nodePowerPhasePoweringUp   = "powering_up"
+)
+
+// Node types with regard to elastic capacity.
+const (
+	nodeTypeStatic  = "static"
+	nodeTypeCloud   = "cloud"
+	nodeTypeDynamic = "dynamic"
+)
+
+var nodePowerUpDurationBuckets = []float64{
+	15 * time.Second.Seconds(),
+	30 * time.Second.Seconds(),
+	1 * time.Minute.Seconds(),
+	2 * time.Minute.Seconds(),
+	5 * time.Minute.Seconds(),
+	10 * time.Minute.Seconds(),
+	15 * time.Minute.Seconds(),
+	30 * time.Minute.Seconds(),
+	1 * time.Hour.Seconds(),
+}
+
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewNodePowerCollector(snapshots *Snapshotter) prometheus.Collector {
+	return newNodePowerCollector(snapshots, clock.RealClock{})
+}
+
+func newNodePowerCollector(snapshots *Snapshotter, clk clock.PassiveClock) *nodePowerCollector {
+	return &nodePowerCollector{
+		snapshots: snapshots,
+		clock:     clk,
+		tracker:   newNodeStateTracker(clk, nodePowerPhase),
+		nodes:     make(map[string]*NodePower),
+
+		PartitionPhases: newDesc("slurm_partition_nodes_power_phase", "Number of nodes in the partition by power saving phase and node type", []string{"partition", "phase", "type"}, nil),
+		ResumedAt:       newDesc("slurm_node_power_resume_timestamp_seconds", "When the node was last observed to start powering up (UNIX timestamp)", nodeLabels, nil),
+		SuspendedAt:     newDesc("slurm_node_power_suspend_timestamp_seconds", "When the node was last observed to start powering down (UNIX timestamp)", nodeLabels, nil),
+		ResumeFailures:  newDesc("slurm_node_power_resume_failures_total", "Number of observed power ups which did not leave the node usable", nodeLabels, nil),
+		PowerUpDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "slurm_node_power_up_duration_seconds",
+			Help:    "Duration of successful node power ups, from power up request until the node is usable",
+			Buckets: nodePowerUpDurationBuckets,
+		}),
+	}
+}
+
+// nodePowerCollector follows nodes through the power saving lifecycle, which
+// shows how long it takes for elastic capacity to arrive.
+type nodePowerCollector struct {
+	snapshots *Snapshotter
+	clock     clock.PassiveClock
+	tracker   *NodeStateTracker
+
+	mu    sync.Mutex
+	nodes map[string]*NodePower
+
+	PartitionPhases *prometheus.Desc
+	ResumedAt       *prometheus.Desc
+	SuspendedAt     *prometheus.Desc
+	ResumeFailures  *prometheus.Desc
+	PowerUpDuration prometheus.Histogram
+}
+
+func (c *nodePowerCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *nodePowerCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *nodePowerCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
+	logger := log.FromContext(ctx).WithName("NodePowerCollector")
+
+	logger.V(1).Info("collecting metrics")
+
+	metrics, err := c.getNodePowerMetrics(snapshot)
+	if err != nil {
+		logger.Error(err, "failed to collect node power metrics")
+		return err
+	}
+
+	for key, count := range metrics.PhasesPer {
+		ch <- prometheus.MustNewConstMetric(c.PartitionPhases, prometheus.GaugeValue, float64(count), key.Partition, key.Phase, key.Type)
+	}
+	for node, data := range metrics.NodePowerPer {
+		if !data.ResumedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.ResumedAt, prometheus.GaugeValue, float64(data.ResumedAt.Unix()), node)
+		}
+		if !data.SuspendedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.SuspendedAt, prometheus.GaugeValue, float64(data.SuspendedAt.Unix()), node)
+		}
+		ch <- prometheus.MustNewConstMetric(c.ResumeFailures, prometheus.CounterValue, float64(data.ResumeFailures), node)
+	}
+	c.PowerUpDuration.Collect(ch)
+	return nil
+}
+
+func (c *nodePowerCollector) getNodePowerMetrics(snapshot *Snapshot) (*NodePowerMetrics, error) {
+	nodeList, err := snapshot.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, current := c.tracker.Observe(nodeList)
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range current {
+		if _, ok := c.nodes[key]; !ok {
+			c.nodes[key] = &NodePower{}
+		}
+	}
+	for key := range c.nodes {
+		if _, ok := current[key]; !ok {
+			delete(c.nodes, key)
+		}
+	}
+
+	usable := make(map[string]bool, len(nodeList.Items))
+	for _, node := range nodeList.Items {
+		usable[string(node.GetKey())] = isNodeUsable(node)
+	}
+	for _, tr := range transitions {
+		data := c.nodes[tr.Node]
+		switch {
+		case tr.To == nodePowerPhasePoweringUp:
+			data.ResumedAt = now
+		case tr.To == nodePowerPhasePoweringDown:
+			data.SuspendedAt = now
+		case tr.To == nodePowerPhasePoweredDown && tr.From != nodePowerPhasePoweringDown:
+			// The powering down phase was shorter than the scrape interval.
+			data.SuspendedAt = now
+		}
+		if tr.From != nodePowerPhasePoweringUp {
+			continue
+		}
+		if tr.To == nodePowerPhasePoweredUp && usable[tr.Node] {
+			c.PowerUpDuration.Observe(tr.Duration.Seconds())
+		} else {
+			data.ResumeFailures++
+		}
+	}
+
+	metrics := &NodePowerMetrics{
+		PhasesPer:    make(map[NodePowerKey]uint),
+		NodePowerPer: make(map[string]*NodePower, len(c.nodes)),
+	}
+	for _, node := range nodeList.Items {
+		phase := nodePowerPhase(node)
+		nodeType := nodePowerType(node)
+		for _, partition := range ptr.Deref(node.Partitions, []string{}) {
+			key := NodePowerKey{Partition: partition, Phase: phase, Type: nodeType}
+			metrics.PhasesPer[key]++
+		}
+	}
+	for key, data := range c.nodes {
+		metrics.NodePowerPer[key] = ptr.To(*data)
+	}
+	return metrics, nil
+}
+
+// nodePowerPhase reduces the node state set into its power saving phase.
+// Requested power ups and downs are counted towards the phase they lead to,
+// unless the power down still waits for the node to drain.
+func nodePowerPhase(node types.V0043Node) string {
+	states := node.GetStateAsSet()
+	switch {
+	case states.HasAny(api.V0043NodeStatePOWERINGUP, api.V0043NodeStatePOWERUP):
+		return nodePowerPhasePoweringUp
+	case states.Has(api.V0043NodeStatePOWERINGDOWN):
+		return nodePowerPhasePoweringDown
+	case states.Has(api.V0043NodeStatePOWEREDDOWN):
+		return nodePowerPhasePoweredDown
+	case states.HasAny(api.V0043NodeStatePOWERDOWN, api.V0043NodeStatePOWERDRAIN):
+		return nodePowerPhasePowerDown
+	}
+	return nodePowerPhasePoweredUp
+}
+
+func nodePowerType(node types.V0043Node) string {
+	states := node.GetStateAsSet()
+	switch {
+	case states.Has(api.V0043NodeStateCLOUD):
+		return nodeTypeCloud
+	case states.HasAny(api.V0043NodeStateDYNAMICNORM, api.V0043NodeStateDYNAMICFUTURE):
+		return nodeTypeDynamic
+	}
+	return nodeTypeStatic
+}
+
+// isNodeUsable reports whether a node which finished powering up can run jobs,
+// otherwise the power up failed (e.g. ResumeTimeout was reached).
+func isNodeUsable(node types.V0043Node) bool {
+	return !node.GetStateAsSet().HasAny(
+		api.V0043NodeStateDOWN,
+		api.V0043NodeStateFAIL,
+		api.V0043NodeStateINVALIDREG,
+	)
+}
+
+type NodePowerMetrics struct {
+	// Per Partition, Phase and Type
+	PhasesPer map[NodePowerKey]uint
+	// Per Node
+	NodePowerPer map[string]*NodePower
+}
+
+type NodePowerKey struct {
+	Partition string
+	Phase     string
+	Type      string
+}
+
+type NodePower struct {
+	ResumedAt      time.Time
+	SuspendedAt    time.Time
+	ResumeFailures uint
+}
@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+func Test_nodePowerPhase(t *testing.T) {
+	tests := []struct {
+		name string
+		node types.V0043Node
+		want string
+	}{
+		{
+			name: "empty",
+			node: types.V0043Node{},
+			want: "powered_up",
+		},
+		{
+			name: "idle",
+			node: newStateNode("node0", api.V0043NodeStateIDLE),
+			want: "powered_up",
+		},
+		{
+			name: "power down requested",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStatePOWERDOWN),
+			want: "power_down",
+		},
+		{
+			name: "power down after drain",
+			node: newStateNode("node0", api.V0043NodeStateALLOCATED, api.V0043NodeStateDRAIN, api.V0043NodeStatePOWERDRAIN),
+			want: "power_down",
+		},
+		{
+			name: "powering down",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStatePOWERINGDOWN),
+			want: "powering_down",
+		},
+		{
+			name: "powered down",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStateCLOUD, api.V0043NodeStatePOWEREDDOWN),
+			want: "powered_down",
+		},
+		{
+			name: "power up requested",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStatePOWEREDDOWN, api.V0043NodeStatePOWERUP),
+			want: "powering_up",
+		},
+		{
+			name: "powering up",
+			node: newStateNode("node0", api.V0043NodeStateALLOCATED, api.V0043NodeStatePOWERINGUP),
+			want: "powering_up",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodePowerPhase(tt.node); got != tt.want {
+				t.Errorf("nodePowerPhase() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nodePowerType(t *testing.T) {
+	tests := []struct {
+		name string
+		node types.V0043Node
+		want string
+	}{
+		{
+			name: "static",
+			node: newStateNode("node0", api.V0043NodeStateIDLE),
+			want: "static",
+		},
+		{
+			name: "cloud",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStateCLOUD),
+			want: "cloud",
+		},
+		{
+			name: "dynamic",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStateDYNAMICNORM),
+			want: "dynamic",
+		},
+		{
+			name: "dynamic future",
+			node: newStateNode("node0", api.V0043NodeStateFUTURE, api.V0043NodeStateDYNAMICFUTURE),
+			want: "dynamic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodePowerType(tt.node); got != tt.want {
+				t.Errorf("nodePowerType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodePowerCollector_getNodePowerMetrics(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	clk := clocktesting.NewFakePassiveClock(start)
+	newCloudNode := func(name string, states ...api.V0043NodeState) types.V0043Node {
+		node := newStateNode(name, append(states, api.V0043NodeStateCLOUD)...)
+		node.Partitions = ptr.To(api.V0043CsvString{"cloud"})
+		return node
+	}
+	c := newNodePowerCollector(NewSnapshotter(fake.NewFakeClient()), clk)
+
+	steps := []struct {
+		step  time.Duration
+		nodes []types.V0043Node
+	}{
+		{
+			nodes: []types.V0043Node{
+				newCloudNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStatePOWEREDDOWN),
+				newCloudNode("node1", api.V0043NodeStateIDLE, api.V0043NodeStatePOWEREDDOWN),
+			},
+		},
+		{
+			step: time.Minute,
+			nodes: []types.V0043Node{
+				newCloudNode("node0", api.V0043NodeStateALLOCATED, api.V0043NodeStatePOWERINGUP),
+				newCloudNode("node1", api.V0043NodeStateALLOCATED, api.V0043NodeStatePOWERINGUP),
+			},
+		},
+		{
+			step: 3 * time.Minute,
+			nodes: []types.V0043Node{
+				newCloudNode("node0", api.V0043NodeStateALLOCATED),
+				newCloudNode("node1", api.V0043NodeStateDOWN, api.V0043NodeStatePOWEREDDOWN),
+			},
+		},
+		{
+			step: time.Hour,
+			nodes: []types.V0043Node{
+				newCloudNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStatePOWERINGDOWN),
+				newCloudNode("node1", api.V0043NodeStateDOWN, api.V0043NodeStatePOWEREDDOWN),
+			},
+		},
+	}
+	var got *NodePowerMetrics
+	for _, s := range steps {
+		clk.SetTime(clk.Now().Add(s.step))
+		c.snapshots = NewSnapshotter(fake.NewClientBuilder().WithLists(&types.V0043NodeList{Items: s.nodes}).Build())
+		var err error
+		got, err = c.getNodePowerMetrics(c.snapshots.Snapshot())
+		if err != nil {
+			t.Fatalf("nodePowerCollector.getNodePowerMetrics() error = %v", err)
+		}
+	}
+
+	resumedAt := start.Add(time.Minute)
+	want := &NodePowerMetrics{
+		PhasesPer: map[NodePowerKey]uint{
+			{Partition: "cloud", Phase: "powering_down", Type: "cloud"}: 1,
+			{Partition: "cloud", Phase: "powered_down", Type: "cloud"}:  1,
+		},
+		NodePowerPer: map[string]*NodePower{
+			"node0": {
+				ResumedAt:   resumedAt,
+				SuspendedAt: resumedAt.Add(3*time.Minute + time.Hour),
+			},
+			"node1": {
+				ResumedAt:      resumedAt,
+				SuspendedAt:    resumedAt.Add(3 * time.Minute),
+				ResumeFailures: 1,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("nodePowerCollector.getNodePowerMetrics() = (-want,+got):\n%s", diff)
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(c.PowerUpDuration))
+	m := &dto.Metric{}
+	assert.NoError(t, c.PowerUpDuration.Write(m))
+	assert.Equal(t, (3 * time.Minute).Seconds(), m.GetHistogram().GetSampleSum())
+}
+
+func TestNodePowerCollector_Collect(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan prometheus.Metric
+	}
+	tests := []struct {
+		name     string
+		fields   fields
+		args     args
+		wantNone bool
+	}{
+		{
+			name: "empty",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "data",
+			fields: fields{
+				slurmClient: testDataClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "failure",
+			fields: fields{
+				slurmClient: testFailClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+			wantNone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNodePowerCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Collect(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var got int
+			for range tt.args.ch {
+				got++
+			}
+			if !tt.wantNone {
+				assert.GreaterOrEqual(t, got, 0)
+			} else {
+				assert.Equal(t, got, 0)
+			}
+		})
+	}
+}
+
+func TestNodePowerCollector_Describe(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan *prometheus.Desc
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+	}{
+		{
+			name: "test",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan *prometheus.Desc),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNodePowerCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Describe(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var desc *prometheus.Desc
+			for desc = range tt.args.ch {
+				assert.NotNil(t, desc)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ package collector
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	api "github.com/SlinkyProject/slurm-client/api/v0043"
@@ -14,6 +16,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"k8s.io/utils/ptr"
 )
@@ -29,7 +32,7 @@ func Test_calculateNodeState(t *testing.T) {
 	}{
 		{
 			name: "empty",
-			want: &NodeStates{},
+			want: &NodeStates{States: StateCounts{{State: "unknown"}: 1}},
 		},
 		{
 			name: "allocated",
@@ -40,7 +43,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Allocated: 1},
+			want: &NodeStates{Allocated: 1, States: StateCounts{{State: "allocated"}: 1}},
 		},
 		{
 			name: "down",
@@ -51,7 +54,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Down: 1},
+			want: &NodeStates{Down: 1, States: StateCounts{{State: "down"}: 1}},
 		},
 		{
 			name: "error",
@@ -62,7 +65,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Error: 1},
+			want: &NodeStates{Error: 1, States: StateCounts{{State: "error"}: 1}},
 		},
 		{
 			name: "future",
@@ -73,7 +76,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Future: 1},
+			want: &NodeStates{Future: 1, States: StateCounts{{State: "future"}: 1}},
 		},
 		{
 			name: "idle",
@@ -84,7 +87,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Idle: 1},
+			want: &NodeStates{Idle: 1, States: StateCounts{{State: "idle"}: 1}},
 		},
 		{
 			name: "mixed",
@@ -95,7 +98,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Mixed: 1},
+			want: &NodeStates{Mixed: 1, States: StateCounts{{State: "mixed"}: 1}},
 		},
 		{
 			name: "unknown",
@@ -106,7 +109,7 @@ func Test_calculateNodeState(t *testing.T) {
 					}),
 				}},
 			},
-			want: &NodeStates{Unknown: 1},
+			want: &NodeStates{Unknown: 1, States: StateCounts{{State: "unknown"}: 1}},
 		},
 		{
 			name: "all states, all flags",
@@ -156,6 +159,32 @@ func Test_calculateNodeState(t *testing.T) {
 				Planned:         1,
 				RebootRequested: 1,
 				Reserved:        1,
+				States: StateCounts{
+					{State: "allocated"}:                           1,
+					{State: "allocated", Flag: "cloud"}:            1,
+					{State: "allocated", Flag: "completing"}:       1,
+					{State: "allocated", Flag: "drain"}:            1,
+					{State: "allocated", Flag: "dynamic_future"}:   1,
+					{State: "allocated", Flag: "dynamic_norm"}:     1,
+					{State: "allocated", Flag: "fail"}:             1,
+					{State: "allocated", Flag: "invalid"}:          1,
+					{State: "allocated", Flag: "invalid_reg"}:      1,
+					{State: "allocated", Flag: "maintenance"}:      1,
+					{State: "allocated", Flag: "not_responding"}:   1,
+					{State: "allocated", Flag: "planned"}:          1,
+					{State: "allocated", Flag: "power_down"}:       1,
+					{State: "allocated", Flag: "power_drain"}:      1,
+					{State: "allocated", Flag: "powered_down"}:     1,
+					{State: "allocated", Flag: "powering_down"}:    1,
+					{State: "allocated", Flag: "powering_up"}:      1,
+					{State: "allocated", Flag: "power_up"}:         1,
+					{State: "allocated", Flag: "reboot_canceled"}:  1,
+					{State: "allocated", Flag: "reboot_issued"}:    1,
+					{State: "allocated", Flag: "reboot_requested"}: 1,
+					{State: "allocated", Flag: "reserved"}:         1,
+					{State: "allocated", Flag: "resume"}:           1,
+					{State: "allocated", Flag: "undrain"}:          1,
+				},
 			},
 		},
 	}
@@ -247,6 +276,26 @@ func Test_calculateNodeTres(t *testing.T) {
 				MemoryFree:      224,
 			},
 		},
+		{
+			name: "energy",
+			args: args{
+				node: types.V0043Node{V0043Node: api.V0043Node{
+					Energy: &api.V0043AcctGatherEnergy{
+						ConsumedEnergy: ptr.To[int64](36000),
+						CurrentWatts: &api.V0043Uint32NoValStruct{
+							Number: ptr.To[int32](250),
+							Set:    ptr.To(true),
+						},
+						AverageWatts: ptr.To[int32](200),
+					},
+				}},
+			},
+			want: &NodeTres{
+				EnergyConsumed: 36000,
+				PowerCurrent:   250,
+				PowerAverage:   200,
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -306,6 +355,13 @@ func TestNodeCollector_getNodeMetrics(t *testing.T) {
 						Mixed:      1,
 						Completing: 1,
 						Drain:      1,
+						States: StateCounts{
+							{State: "allocated"}:                 2,
+							{State: "allocated", Flag: "drain"}:  1,
+							{State: "idle"}:                      1,
+							{State: "mixed"}:                     1,
+							{State: "mixed", Flag: "completing"}: 1,
+						},
 					},
 					NodeTres: NodeTres{
 						CpusTotal:       46,
@@ -373,9 +429,9 @@ func TestNodeCollector_getNodeMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &nodeCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getNodeMetrics(tt.args.ctx)
+			got, err := c.getNodeMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("nodeCollector.getNodeMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -436,7 +492,7 @@ func TestNodeCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewNodeCollector(tt.fields.slurmClient)
+			c := NewNodeCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -478,7 +534,7 @@ func TestNodeCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewNodeCollector(tt.fields.slurmClient)
+			c := NewNodeCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
@@ -490,3 +546,64 @@ func TestNodeCollector_Describe(t *testing.T) {
 		})
 	}
 }
+
+// fakeNodePods returns the given pods, or else the error.
+type fakeNodePods struct {
+	pods map[string]NodePod
+	err  error
+}
+
+func (f *fakeNodePods) NodePods(_ context.Context) (map[string]NodePod, error) {
+	return f.pods, f.err
+}
+
+func TestNodeCollector_pods(t *testing.T) {
+	tests := []struct {
+		name string
+		pods *fakeNodePods
+		want string
+	}{
+		{
+			name: "pods",
+			pods: &fakeNodePods{pods: map[string]NodePod{
+				"node0": {Namespace: "slurm", Pod: "cpu-0", K8sNode: "kube-node-0", NodeSet: "cpu"},
+				"node1": {Namespace: "slurm", Pod: "cpu-1", K8sNode: "kube-node-1", NodeSet: "cpu"},
+			}},
+			want: `
+# HELP slurm_node_cpus_total Total number of CPUs on the node
+# TYPE slurm_node_cpus_total gauge
+slurm_node_cpus_total{node="node0"} 16
+slurm_node_cpus_total{node="node1"} 8
+slurm_node_cpus_total{node="node2"} 16
+slurm_node_cpus_total{node="node3"} 6
+# HELP slurm_node_info Information about the node and the Kubernetes pod it runs in
+# TYPE slurm_node_info gauge
+slurm_node_info{k8s_namespace="slurm",k8s_node="kube-node-0",k8s_pod="cpu-0",node="node0",nodeset="cpu"} 1
+slurm_node_info{k8s_namespace="slurm",k8s_node="kube-node-1",k8s_pod="cpu-1",node="node1",nodeset="cpu"} 1
+`,
+		},
+		{
+			name: "lookup failure",
+			pods: &fakeNodePods{err: errors.New("cache not synced")},
+			want: `
+# HELP slurm_node_cpus_total Total number of CPUs on the node
+# TYPE slurm_node_cpus_total gauge
+slurm_node_cpus_total{node="node0"} 16
+slurm_node_cpus_total{node="node1"} 8
+slurm_node_cpus_total{node="node2"} 16
+slurm_node_cpus_total{node="node3"} 6
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNodeCollectorWithPods(NewSnapshotter(testDataClient), tt.pods)
+			err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "slurm_node_info", "slurm_node_cpus_total")
+			assert.NoError(t, err)
+		})
+	}
+
+	// Without pods, there is no node info.
+	c := NewNodeCollector(NewSnapshotter(testDataClient))
+	assert.Equal(t, 0, testutil.CollectAndCount(c, "slurm_node_info"))
+}
@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/set"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+)
+
+const (
+	nodeStateDown          = "down"
+	nodeStateDrain         = "drain"
+	nodeStateFail          = "fail"
+	nodeStateNotResponding = "not_responding"
+	nodeStateUnknown       = "unknown"
+)
+
+var (
+	// unavailableNodeStates are the states whose durations are recorded in
+	// the repair histogram when a node leaves them.
+	unavailableNodeStates = set.New(nodeStateDown, nodeStateDrain, nodeStateFail)
+
+	unavailableNodeDurationBuckets = []float64{
+		1 * time.Minute.Seconds(),
+		5 * time.Minute.Seconds(),
+		15 * time.Minute.Seconds(),
+		30 * time.Minute.Seconds(),
+		1 * time.Hour.Seconds(),
+		4 * time.Hour.Seconds(),
+		12 * time.Hour.Seconds(),
+		24 * time.Hour.Seconds(),
+		72 * time.Hour.Seconds(),
+		168 * time.Hour.Seconds(),
+	}
+)
+
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewNodeTransitionCollector(snapshots *Snapshotter) prometheus.Collector {
+	return newNodeTransitionCollector(snapshots, clock.RealClock{})
+}
+
+func newNodeTransitionCollector(snapshots *Snapshotter, clk clock.PassiveClock) *nodeTransitionCollector {
+	return &nodeTransitionCollector{
+		snapshots:   snapshots,
+		tracker:     newNodeStateTracker(clk, nodeEffectiveState),
+		transitions: make(map[NodeTransitionKey]uint),
+
+		Transitions: newDesc("slurm_node_state_transitions_total", "Number of observed node state transitions", nodeTransitionLabels, nil),
+		TimeInState: newDesc("slurm_node_state_duration_seconds", "Number of seconds the node has been in its current state", nodeStateLabels, nil),
+		UnavailableDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "slurm_node_unavailable_duration_seconds",
+			Help:    "Duration of node Down, Drain and Fail periods, observed when the node leaves the state",
+			Buckets: unavailableNodeDurationBuckets,
+		}, []string{"state"}),
+	}
+}
+
+// nodeTransitionCollector tracks node state changes across successive node
+// list snapshots, which the point in time node state gauges cannot show.
+type nodeTransitionCollector struct {
+	snapshots *Snapshotter
+	tracker   *NodeStateTracker
+
+	mu          sync.Mutex
+	transitions map[NodeTransitionKey]uint
+
+	Transitions         *prometheus.Desc
+	TimeInState         *prometheus.Desc
+	UnavailableDuration *prometheus.HistogramVec
+}
+
+func (c *nodeTransitionCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *nodeTransitionCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *nodeTransitionCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
+	logger := log.FromContext(ctx).WithName("NodeTransitionCollector")
+
+	logger.V(1).Info("collecting metrics")
+
+	metrics, err := c.getNodeTransitionMetrics(snapshot)
+	if err != nil {
+		logger.Error(err, "failed to collect node transition metrics")
+		return err
+	}
+
+	for key, count := range metrics.TransitionsPer {
+		ch <- prometheus.MustNewConstMetric(c.Transitions, prometheus.CounterValue, float64(count), key.Node, key.From, key.To)
+	}
+	for node, data := range metrics.StatePer {
+		ch <- prometheus.MustNewConstMetric(c.TimeInState, prometheus.GaugeValue, data.Duration.Seconds(), node, data.State)
+	}
+	c.UnavailableDuration.Collect(ch)
+	return nil
+}
+
+func (c *nodeTransitionCollector) getNodeTransitionMetrics(snapshot *Snapshot) (*NodeTransitionMetrics, error) {
+	nodeList, err := snapshot.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	transitions, current := c.tracker.Observe(nodeList)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tr := range transitions {
+		key := NodeTransitionKey{Node: tr.Node, From: tr.From, To: tr.To}
+		c.transitions[key]++
+		if unavailableNodeStates.Has(tr.From) {
+			c.UnavailableDuration.WithLabelValues(tr.From).Observe(tr.Duration.Seconds())
+		}
+	}
+
+	metrics := &NodeTransitionMetrics{
+		TransitionsPer: make(map[NodeTransitionKey]uint, len(c.transitions)),
+		StatePer:       current,
+	}
+	for key, count := range c.transitions {
+		// Drop the series of nodes which were removed from the cluster.
+		if _, ok := current[key.Node]; !ok {
+			delete(c.transitions, key)
+			continue
+		}
+		metrics.TransitionsPer[key] = count
+	}
+	return metrics, nil
+}
+
+// nodeEffectiveState reduces the node state set into a single state, where
+// unavailability takes precedence over the base state.
+func nodeEffectiveState(node types.V0043Node) string {
+	states := node.GetStateAsSet()
+	switch {
+	case states.Has(api.V0043NodeStateDOWN):
+		return nodeStateDown
+	case states.Has(api.V0043NodeStateNOTRESPONDING):
+		return nodeStateNotResponding
+	case states.Has(api.V0043NodeStateFAIL):
+		return nodeStateFail
+	case states.Has(api.V0043NodeStateDRAIN):
+		return nodeStateDrain
+	}
+	for _, state := range []api.V0043NodeState{
+		api.V0043NodeStateALLOCATED,
+		api.V0043NodeStateERROR,
+		api.V0043NodeStateFUTURE,
+		api.V0043NodeStateIDLE,
+		api.V0043NodeStateMIXED,
+	} {
+		if states.Has(state) {
+			return strings.ToLower(string(state))
+		}
+	}
+	return nodeStateUnknown
+}
+
+type nodeStateEntry struct {
+	State string
+	Since time.Time
+}
+
+// NodeTransition is a change of the state of a node, which it was in for
+// Duration.
+type NodeTransition struct {
+	Node     string
+	From     string
+	To       string
+	Duration time.Duration
+}
+
+// NodeStateTracker remembers the last observed state of each node, as
+// reduced by stateOf, and when the node entered it.
+type NodeStateTracker struct {
+	mu sync.Mutex
+
+	clock   clock.PassiveClock
+	stateOf func(node types.V0043Node) string
+	nodes   map[string]*nodeStateEntry
+
+	// added reports the nodes which are first observed after the first
+	// observation, in a non-empty state, as a transition from the empty state.
+	added    bool
+	observed bool
+}
+
+// NewNodeStateTracker returns a NodeStateTracker which reports the nodes that
+// are added after the first observation, in a non-empty state, as a
+// transition from the empty state.
+func NewNodeStateTracker(stateOf func(node types.V0043Node) string) *NodeStateTracker {
+	tracker := newNodeStateTracker(clock.RealClock{}, stateOf)
+	tracker.added = true
+	return tracker
+}
+
+func newNodeStateTracker(clk clock.PassiveClock, stateOf func(node types.V0043Node) string) *NodeStateTracker {
+	return &NodeStateTracker{
+		clock:   clk,
+		stateOf: stateOf,
+		nodes:   make(map[string]*nodeStateEntry),
+	}
+}
+
+// Observe records the node states and returns the transitions since the last
+// observation along with how long each node has been in its current state.
+func (t *NodeStateTracker) Observe(nodeList *types.V0043NodeList) ([]NodeTransition, map[string]NodeStateDuration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.clock.Now()
+	transitions := []NodeTransition{}
+	current := make(map[string]NodeStateDuration, len(nodeList.Items))
+	for _, node := range nodeList.Items {
+		key := string(node.GetKey())
+		state := t.stateOf(node)
+
+		entry, ok := t.nodes[key]
+		switch {
+		case !ok:
+			entry = &nodeStateEntry{
+				State: state,
+				Since: nodeStateSince(node, state, now),
+			}
+			t.nodes[key] = entry
+			if t.added && t.observed && state != "" {
+				transitions = append(transitions, NodeTransition{
+					Node: key,
+					To:   state,
+				})
+			}
+		case entry.State != state:
+			transitions = append(transitions, NodeTransition{
+				Node:     key,
+				From:     entry.State,
+				To:       state,
+				Duration: now.Sub(entry.Since),
+			})
+			entry.State = state
+			entry.Since = now
+		}
+		current[key] = NodeStateDuration{
+			State:    entry.State,
+			Duration: now.Sub(entry.Since),
+		}
+	}
+
+	for key := range t.nodes {
+		if _, ok := current[key]; !ok {
+			delete(t.nodes, key)
+		}
+	}
+	t.observed = true
+
+	return transitions, current
+}
+
+// nodeStateSince estimates when a node which was not observed before entered
+// its current state. Unavailable states are usually set along with a reason,
+// whose timestamp is better than the time of the first observation.
+func nodeStateSince(node types.V0043Node, state string, now time.Time) time.Time {
+	if !unavailableNodeStates.Has(state) {
+		return now
+	}
+	changedAt := ParseUint64NoVal(node.ReasonChangedAt)
+	if changedAt == 0 {
+		return now
+	}
+	since := time.Unix(int64(changedAt), 0)
+	if since.After(now) {
+		return now
+	}
+	return since
+}
+
+type NodeTransitionMetrics struct {
+	// Per Node and Transition
+	TransitionsPer map[NodeTransitionKey]uint
+	// Per Node
+	StatePer map[string]NodeStateDuration
+}
+
+type NodeTransitionKey struct {
+	Node string
+	From string
+	To   string
+}
+
+type NodeStateDuration struct {
+	State    string
+	Duration time.Duration
+}
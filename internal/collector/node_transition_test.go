@@ -0,0 +1,345 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+func newStateNode(name string, states ...api.V0043NodeState) types.V0043Node {
+	return types.V0043Node{V0043Node: api.V0043Node{
+		Name:  ptr.To(name),
+		State: ptr.To(states),
+	}}
+}
+
+func Test_nodeEffectiveState(t *testing.T) {
+	tests := []struct {
+		name string
+		node types.V0043Node
+		want string
+	}{
+		{
+			name: "empty",
+			node: types.V0043Node{},
+			want: "unknown",
+		},
+		{
+			name: "idle",
+			node: newStateNode("node0", api.V0043NodeStateIDLE),
+			want: "idle",
+		},
+		{
+			name: "mixed",
+			node: newStateNode("node0", api.V0043NodeStateMIXED, api.V0043NodeStateCOMPLETING),
+			want: "mixed",
+		},
+		{
+			name: "draining",
+			node: newStateNode("node0", api.V0043NodeStateALLOCATED, api.V0043NodeStateDRAIN),
+			want: "drain",
+		},
+		{
+			name: "not responding",
+			node: newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStateNOTRESPONDING),
+			want: "not_responding",
+		},
+		{
+			name: "down",
+			node: newStateNode("node0", api.V0043NodeStateDOWN, api.V0043NodeStateDRAIN, api.V0043NodeStateNOTRESPONDING),
+			want: "down",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeEffectiveState(tt.node); got != tt.want {
+				t.Errorf("nodeEffectiveState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNodeStateTracker_Observe(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	downNode := newStateNode("node1", api.V0043NodeStateDOWN)
+	downNode.ReasonChangedAt = &api.V0043Uint64NoValStruct{
+		Number: ptr.To(start.Add(-time.Hour).Unix()),
+		Set:    ptr.To(true),
+	}
+	type snapshot struct {
+		step  time.Duration
+		nodes []types.V0043Node
+	}
+	tests := []struct {
+		name            string
+		added           bool
+		snapshots       []snapshot
+		wantTransitions []NodeTransition
+		wantCurrent     map[string]NodeStateDuration
+	}{
+		{
+			name:            "empty",
+			snapshots:       []snapshot{{}},
+			wantTransitions: []NodeTransition{},
+			wantCurrent:     map[string]NodeStateDuration{},
+		},
+		{
+			name: "first observation",
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE), downNode}},
+			},
+			wantTransitions: []NodeTransition{},
+			wantCurrent: map[string]NodeStateDuration{
+				"node0": {State: "idle"},
+				"node1": {State: "down", Duration: time.Hour},
+			},
+		},
+		{
+			name: "flapping",
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+				{step: time.Minute, nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE, api.V0043NodeStateNOTRESPONDING)}},
+			},
+			wantTransitions: []NodeTransition{
+				{Node: "node0", From: "idle", To: "not_responding", Duration: time.Minute},
+			},
+			wantCurrent: map[string]NodeStateDuration{
+				"node0": {State: "not_responding"},
+			},
+		},
+		{
+			name: "no change",
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+				{step: time.Minute, nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+			},
+			wantTransitions: []NodeTransition{},
+			wantCurrent: map[string]NodeStateDuration{
+				"node0": {State: "idle", Duration: time.Minute},
+			},
+		},
+		{
+			name: "node removed",
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+				{step: time.Minute},
+			},
+			wantTransitions: []NodeTransition{},
+			wantCurrent:     map[string]NodeStateDuration{},
+		},
+		{
+			name: "node added",
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+				{step: time.Minute, nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE), downNode}},
+			},
+			wantTransitions: []NodeTransition{},
+			wantCurrent: map[string]NodeStateDuration{
+				"node0": {State: "idle", Duration: time.Minute},
+				"node1": {State: "down", Duration: time.Hour + time.Minute},
+			},
+		},
+		{
+			name:  "node added, reported",
+			added: true,
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE)}},
+				{step: time.Minute, nodes: []types.V0043Node{newStateNode("node0", api.V0043NodeStateIDLE), downNode}},
+			},
+			wantTransitions: []NodeTransition{
+				{Node: "node1", To: "down"},
+			},
+			wantCurrent: map[string]NodeStateDuration{
+				"node0": {State: "idle", Duration: time.Minute},
+				"node1": {State: "down", Duration: time.Hour + time.Minute},
+			},
+		},
+		{
+			name:  "first observation, reported",
+			added: true,
+			snapshots: []snapshot{
+				{nodes: []types.V0043Node{downNode}},
+			},
+			wantTransitions: []NodeTransition{},
+			wantCurrent: map[string]NodeStateDuration{
+				"node1": {State: "down", Duration: time.Hour},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clocktesting.NewFakePassiveClock(start)
+			tracker := newNodeStateTracker(clk, nodeEffectiveState)
+			tracker.added = tt.added
+			var gotTransitions []NodeTransition
+			var gotCurrent map[string]NodeStateDuration
+			for _, s := range tt.snapshots {
+				clk.SetTime(clk.Now().Add(s.step))
+				gotTransitions, gotCurrent = tracker.Observe(&types.V0043NodeList{Items: s.nodes})
+			}
+			if diff := cmp.Diff(tt.wantTransitions, gotTransitions); diff != "" {
+				t.Errorf("NodeStateTracker.Observe() transitions = (-want,+got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantCurrent, gotCurrent); diff != "" {
+				t.Errorf("NodeStateTracker.Observe() current = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNodeTransitionCollector_getNodeTransitionMetrics(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	clk := clocktesting.NewFakePassiveClock(start)
+	nodes := &types.V0043NodeList{Items: []types.V0043Node{
+		newStateNode("node0", api.V0043NodeStateIDLE),
+	}}
+	c := newNodeTransitionCollector(NewSnapshotter(fake.NewFakeClient()), clk)
+
+	steps := []struct {
+		step  time.Duration
+		state []api.V0043NodeState
+	}{
+		{state: []api.V0043NodeState{api.V0043NodeStateIDLE}},
+		{step: time.Minute, state: []api.V0043NodeState{api.V0043NodeStateIDLE, api.V0043NodeStateDRAIN}},
+		{step: 2 * time.Hour, state: []api.V0043NodeState{api.V0043NodeStateIDLE}},
+		{step: time.Minute, state: []api.V0043NodeState{api.V0043NodeStateDOWN}},
+	}
+	var got *NodeTransitionMetrics
+	for _, s := range steps {
+		clk.SetTime(clk.Now().Add(s.step))
+		nodes.Items[0].State = ptr.To(s.state)
+		c.snapshots = NewSnapshotter(fake.NewClientBuilder().WithLists(nodes).Build())
+		var err error
+		got, err = c.getNodeTransitionMetrics(c.snapshots.Snapshot())
+		if err != nil {
+			t.Fatalf("nodeTransitionCollector.getNodeTransitionMetrics() error = %v", err)
+		}
+	}
+
+	want := &NodeTransitionMetrics{
+		TransitionsPer: map[NodeTransitionKey]uint{
+			{Node: "node0", From: "idle", To: "drain"}: 1,
+			{Node: "node0", From: "drain", To: "idle"}: 1,
+			{Node: "node0", From: "idle", To: "down"}:  1,
+		},
+		StatePer: map[string]NodeStateDuration{
+			"node0": {State: "down"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("nodeTransitionCollector.getNodeTransitionMetrics() = (-want,+got):\n%s", diff)
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(c.UnavailableDuration))
+}
+
+func TestNodeTransitionCollector_Collect(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan prometheus.Metric
+	}
+	tests := []struct {
+		name     string
+		fields   fields
+		args     args
+		wantNone bool
+	}{
+		{
+			name: "empty",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "data",
+			fields: fields{
+				slurmClient: testDataClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
+		{
+			name: "failure",
+			fields: fields{
+				slurmClient: testFailClient,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+			wantNone: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNodeTransitionCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Collect(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var got int
+			for range tt.args.ch {
+				got++
+			}
+			if !tt.wantNone {
+				assert.GreaterOrEqual(t, got, 0)
+			} else {
+				assert.Equal(t, got, 0)
+			}
+		})
+	}
+}
+
+func TestNodeTransitionCollector_Describe(t *testing.T) {
+	type fields struct {
+		slurmClient client.Client
+	}
+	type args struct {
+		ch chan *prometheus.Desc
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+	}{
+		{
+			name: "test",
+			fields: fields{
+				slurmClient: fake.NewFakeClient(),
+			},
+			args: args{
+				ch: make(chan *prometheus.Desc),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewNodeTransitionCollector(NewSnapshotter(tt.fields.slurmClient))
+			go func() {
+				c.Describe(tt.args.ch)
+				close(tt.args.ch)
+			}()
+			var desc *prometheus.Desc
+			for desc = range tt.args.ch {
+				assert.NotNil(t, desc)
+			}
+		})
+	}
+}
@@ -4,90 +4,101 @@
 package collector
 
 import (
-	"context"
+	"maps"
+	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	api "github.com/SlinkyProject/slurm-client/api/v0043"
-	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
+
 	"github.com/SlinkyProject/slurm-exporter/internal/utils"
 )
 
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewPartitionCollector(slurmClient client.Client) prometheus.Collector {
+func NewPartitionCollector(snapshots *Snapshotter) prometheus.Collector {
 	return &partitionCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
 
-		JobCount: prometheus.NewDesc("slurm_partition_jobs_total", "Total number of jobs in the partition", partitionLabels, nil),
+		JobCount: newDesc("slurm_partition_jobs_total", "Total number of jobs in the partition", partitionLabels, nil),
 		JobStates: jobStatesCollector{
 			// Base State
-			BootFail:    prometheus.NewDesc("slurm_partition_jobs_bootfail_total", "Number of jobs in BootFail state in the partition", partitionLabels, nil),
-			Cancelled:   prometheus.NewDesc("slurm_partition_jobs_cancelled_total", "Number of jobs in Cancelled state in the partition", partitionLabels, nil),
-			Completed:   prometheus.NewDesc("slurm_partition_jobs_completed_total", "Number of jobs in Completed state in the partition", partitionLabels, nil),
-			Deadline:    prometheus.NewDesc("slurm_partition_jobs_deadline_total", "Number of jobs in Deadline state in the partition", partitionLabels, nil),
-			Failed:      prometheus.NewDesc("slurm_partition_jobs_failed_total", "Number of jobs in Failed state in the partition", partitionLabels, nil),
-			Pending:     prometheus.NewDesc("slurm_partition_jobs_pending_total", "Number of jobs in Pending state in the partition", partitionLabels, nil),
-			Running:     prometheus.NewDesc("slurm_partition_jobs_running_total", "Number of jobs in Running state in the partition", partitionLabels, nil),
-			Preempted:   prometheus.NewDesc("slurm_partition_jobs_preempted_total", "Number of jobs in Preempted state in the partition", partitionLabels, nil),
-			Suspended:   prometheus.NewDesc("slurm_partition_jobs_suspended_total", "Number of jobs in Suspended state in the partition", partitionLabels, nil),
-			Timeout:     prometheus.NewDesc("slurm_partition_jobs_timeout_total", "Number of jobs in Timeout state in the partition", partitionLabels, nil),
-			NodeFail:    prometheus.NewDesc("slurm_partition_jobs_nodefail_total", "Number of jobs in NodeFail state in the partition", partitionLabels, nil),
-			OutOfMemory: prometheus.NewDesc("slurm_partition_jobs_outofmemory_total", "Number of jobs in OutOfMemory state in the partition", partitionLabels, nil),
+			BootFail:    newDesc("slurm_partition_jobs_bootfail_total", "Number of jobs in BootFail state in the partition", partitionLabels, nil),
+			Cancelled:   newDesc("slurm_partition_jobs_cancelled_total", "Number of jobs in Cancelled state in the partition", partitionLabels, nil),
+			Completed:   newDesc("slurm_partition_jobs_completed_total", "Number of jobs in Completed state in the partition", partitionLabels, nil),
+			Deadline:    newDesc("slurm_partition_jobs_deadline_total", "Number of jobs in Deadline state in the partition", partitionLabels, nil),
+			Failed:      newDesc("slurm_partition_jobs_failed_total", "Number of jobs in Failed state in the partition", partitionLabels, nil),
+			Pending:     newDesc("slurm_partition_jobs_pending_total", "Number of jobs in Pending state in the partition", partitionLabels, nil),
+			Running:     newDesc("slurm_partition_jobs_running_total", "Number of jobs in Running state in the partition", partitionLabels, nil),
+			Preempted:   newDesc("slurm_partition_jobs_preempted_total", "Number of jobs in Preempted state in the partition", partitionLabels, nil),
+			Suspended:   newDesc("slurm_partition_jobs_suspended_total", "Number of jobs in Suspended state in the partition", partitionLabels, nil),
+			Timeout:     newDesc("slurm_partition_jobs_timeout_total", "Number of jobs in Timeout state in the partition", partitionLabels, nil),
+			NodeFail:    newDesc("slurm_partition_jobs_nodefail_total", "Number of jobs in NodeFail state in the partition", partitionLabels, nil),
+			OutOfMemory: newDesc("slurm_partition_jobs_outofmemory_total", "Number of jobs in OutOfMemory state in the partition", partitionLabels, nil),
 			// Flag States
-			Completing:  prometheus.NewDesc("slurm_partition_jobs_completing_total", "Number of jobs with Completing flag in the partition", partitionLabels, nil),
-			Configuring: prometheus.NewDesc("slurm_partition_jobs_configuring_total", "Number of jobs with Configuring flag in the partition", partitionLabels, nil),
-			PowerUpNode: prometheus.NewDesc("slurm_partition_jobs_powerupnode_total", "Number of jobs with PowerUpNode flag in the partition", partitionLabels, nil),
-			StageOut:    prometheus.NewDesc("slurm_partition_jobs_stageout_total", "Number of jobs with StageOut flag in the partition", partitionLabels, nil),
+			Completing:  newDesc("slurm_partition_jobs_completing_total", "Number of jobs with Completing flag in the partition", partitionLabels, nil),
+			Configuring: newDesc("slurm_partition_jobs_configuring_total", "Number of jobs with Configuring flag in the partition", partitionLabels, nil),
+			PowerUpNode: newDesc("slurm_partition_jobs_powerupnode_total", "Number of jobs with PowerUpNode flag in the partition", partitionLabels, nil),
+			StageOut:    newDesc("slurm_partition_jobs_stageout_total", "Number of jobs with StageOut flag in the partition", partitionLabels, nil),
 			// Other States
-			Hold: prometheus.NewDesc("slurm_partition_jobs_hold_total", "Number of jobs with Hold flag in the partition", partitionLabels, nil),
+			Hold: newDesc("slurm_partition_jobs_hold_total", "Number of jobs with Hold flag in the partition", partitionLabels, nil),
+			// All States
+			States: newDesc("slurm_partition_jobs", "Number of jobs in the partition by base state, and by base state and flag", partitionStateLabels, nil),
 		},
 		JobTres: jobTresCollector{
 			// CPUs
-			CpusAlloc: prometheus.NewDesc("slurm_partition_jobs_cpus_alloc_total", "Number of Allocated CPUs among jobs in the partition", partitionLabels, nil),
+			CpusAlloc: newDesc("slurm_partition_jobs_cpus_alloc_total", "Number of Allocated CPUs among jobs in the partition", partitionLabels, nil),
 			// Memory
-			MemoryAlloc: prometheus.NewDesc("slurm_partition_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among jobs in the partition", partitionLabels, nil),
+			MemoryAlloc: newDesc("slurm_partition_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among jobs in the partition", partitionLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_partition_jobs_energy_consumed_joules", "Amount of Energy (J) consumed among the jobs in the partition in the job list, which drops as jobs are purged; see slurm_job_lifecycle_energy_consumed_joules_total for the energy over time", partitionLabels, nil),
 		},
-		PendingNodeCount: prometheus.NewDesc("slurm_partition_jobs_pending_maxnodecount_total", "Largest number of nodes required among pending jobs in the partition", partitionLabels, nil),
-		NodeCount:        prometheus.NewDesc("slurm_partition_nodes_total", "Total number of slurm nodes", partitionLabels, nil),
+		PendingNodeCount: newDesc("slurm_partition_jobs_pending_maxnodecount_total", "Largest number of nodes required among pending jobs in the partition", partitionLabels, nil),
+		NodeCount:        newDesc("slurm_partition_nodes_total", "Total number of slurm nodes", partitionLabels, nil),
 		NodeStates: nodeStatesCollector{
 			// Base State
-			Allocated: prometheus.NewDesc("slurm_partition_nodes_allocated_total", "Number of nodes in Allocated state", partitionLabels, nil),
-			Down:      prometheus.NewDesc("slurm_partition_nodes_down_total", "Number of nodes in Down state", partitionLabels, nil),
-			Error:     prometheus.NewDesc("slurm_partition_nodes_error_total", "Number of nodes in Error state", partitionLabels, nil),
-			Future:    prometheus.NewDesc("slurm_partition_nodes_future_total", "Number of nodes in Future state", partitionLabels, nil),
-			Idle:      prometheus.NewDesc("slurm_partition_nodes_idle_total", "Number of nodes in Idle state", partitionLabels, nil),
-			Mixed:     prometheus.NewDesc("slurm_partition_nodes_mixed_total", "Number of nodes in Mixed state", partitionLabels, nil),
-			Unknown:   prometheus.NewDesc("slurm_partition_nodes_unknown_total", "Number of nodes in Unknown state", partitionLabels, nil),
+			Allocated: newDesc("slurm_partition_nodes_allocated_total", "Number of nodes in Allocated state", partitionLabels, nil),
+			Down:      newDesc("slurm_partition_nodes_down_total", "Number of nodes in Down state", partitionLabels, nil),
+			Error:     newDesc("slurm_partition_nodes_error_total", "Number of nodes in Error state", partitionLabels, nil),
+			Future:    newDesc("slurm_partition_nodes_future_total", "Number of nodes in Future state", partitionLabels, nil),
+			Idle:      newDesc("slurm_partition_nodes_idle_total", "Number of nodes in Idle state", partitionLabels, nil),
+			Mixed:     newDesc("slurm_partition_nodes_mixed_total", "Number of nodes in Mixed state", partitionLabels, nil),
+			Unknown:   newDesc("slurm_partition_nodes_unknown_total", "Number of nodes in Unknown state", partitionLabels, nil),
 			// Flag State
-			Completing:      prometheus.NewDesc("slurm_partition_nodes_completing_total", "Number of nodes with Completing flag", partitionLabels, nil),
-			Drain:           prometheus.NewDesc("slurm_partition_nodes_drain_total", "Number of nodes with Drain flag", partitionLabels, nil),
-			Fail:            prometheus.NewDesc("slurm_partition_nodes_fail_total", "Number of nodes with Fail flag", partitionLabels, nil),
-			Maintenance:     prometheus.NewDesc("slurm_partition_nodes_maintenance_total", "Number of nodes with Maintenance flag", partitionLabels, nil),
-			NotResponding:   prometheus.NewDesc("slurm_partition_nodes_notresponding_total", "Number of nodes with NotResponding flag", partitionLabels, nil),
-			Planned:         prometheus.NewDesc("slurm_partition_nodes_planned_total", "Number of nodes with Planned flag", partitionLabels, nil),
-			RebootRequested: prometheus.NewDesc("slurm_partition_nodes_rebootrequested_total", "Number of nodes with RebootRequested flag", partitionLabels, nil),
-			Reserved:        prometheus.NewDesc("slurm_partition_nodes_reserved_total", "Number of nodes with Reserved flag", partitionLabels, nil),
+			Completing:      newDesc("slurm_partition_nodes_completing_total", "Number of nodes with Completing flag", partitionLabels, nil),
+			Drain:           newDesc("slurm_partition_nodes_drain_total", "Number of nodes with Drain flag", partitionLabels, nil),
+			Fail:            newDesc("slurm_partition_nodes_fail_total", "Number of nodes with Fail flag", partitionLabels, nil),
+			Maintenance:     newDesc("slurm_partition_nodes_maintenance_total", "Number of nodes with Maintenance flag", partitionLabels, nil),
+			NotResponding:   newDesc("slurm_partition_nodes_notresponding_total", "Number of nodes with NotResponding flag", partitionLabels, nil),
+			Planned:         newDesc("slurm_partition_nodes_planned_total", "Number of nodes with Planned flag", partitionLabels, nil),
+			RebootRequested: newDesc("slurm_partition_nodes_rebootrequested_total", "Number of nodes with RebootRequested flag", partitionLabels, nil),
+			Reserved:        newDesc("slurm_partition_nodes_reserved_total", "Number of nodes with Reserved flag", partitionLabels, nil),
+			// All States
+			States: newDesc("slurm_partition_nodes", "Number of nodes in the partition by base state, and by base state and flag", partitionStateLabels, nil),
 		},
 		NodeTres: nodeTresCollector{
 			// CPUs
-			CpusTotal:     prometheus.NewDesc("slurm_partition_nodes_cpus_total", "Total number of CPUs on the node", partitionLabels, nil),
-			CpusEffective: prometheus.NewDesc("slurm_partition_nodes_cpus_effective_total", "Total number of effective CPUs on the node, excludes CoreSpec", partitionLabels, nil),
-			CpusAlloc:     prometheus.NewDesc("slurm_partition_nodes_cpus_alloc_total", "Number of Allocated CPUs on the node", partitionLabels, nil),
-			CpusIdle:      prometheus.NewDesc("slurm_partition_nodes_cpus_idle_total", "Number of Idle CPUs on the node", partitionLabels, nil),
+			CpusTotal:     newDesc("slurm_partition_nodes_cpus_total", "Total number of CPUs on the node", partitionLabels, nil),
+			CpusEffective: newDesc("slurm_partition_nodes_cpus_effective_total", "Total number of effective CPUs on the node, excludes CoreSpec", partitionLabels, nil),
+			CpusAlloc:     newDesc("slurm_partition_nodes_cpus_alloc_total", "Number of Allocated CPUs on the node", partitionLabels, nil),
+			CpusIdle:      newDesc("slurm_partition_nodes_cpus_idle_total", "Number of Idle CPUs on the node", partitionLabels, nil),
 			// Memory
-			MemoryTotal:     prometheus.NewDesc("slurm_partition_nodes_memory_bytes", "Total amount of Memory (MB) on the node", partitionLabels, nil),
-			MemoryEffective: prometheus.NewDesc("slurm_partition_nodes_memory_effective_bytes", "Total amount of effective Memory (MB) on the node, excludes MemSpec", partitionLabels, nil),
-			MemoryAlloc:     prometheus.NewDesc("slurm_partition_nodes_memory_alloc_bytes", "Amount of Allocated Memory (MB) on the node", partitionLabels, nil),
-			MemoryFree:      prometheus.NewDesc("slurm_partition_nodes_memory_free_bytes", "Amount of Free Memory (MB) on the node", partitionLabels, nil),
+			MemoryTotal:     newDesc("slurm_partition_nodes_memory_bytes", "Total amount of Memory (MB) on the node", partitionLabels, nil),
+			MemoryEffective: newDesc("slurm_partition_nodes_memory_effective_bytes", "Total amount of effective Memory (MB) on the node, excludes MemSpec", partitionLabels, nil),
+			MemoryAlloc:     newDesc("slurm_partition_nodes_memory_alloc_bytes", "Amount of Allocated Memory (MB) on the node", partitionLabels, nil),
+			MemoryFree:      newDesc("slurm_partition_nodes_memory_free_bytes", "Amount of Free Memory (MB) on the node", partitionLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_partition_nodes_energy_consumed_joules", "Amount of Energy (J) consumed by the nodes of the partition since slurmd registered, which drops as nodes leave the partition; see slurm_node_energy_consumed_joules_total for the energy over time", partitionLabels, nil),
+			PowerCurrent:   newDesc("slurm_partition_nodes_power_watts", "Current power consumption (W) of the nodes", partitionLabels, nil),
+			PowerAverage:   newDesc("slurm_partition_nodes_power_average_watts", "Average power consumption (W) of the nodes", partitionLabels, nil),
 		},
 	}
 }
 
 type partitionCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
 
 	JobCount  *prometheus.Desc
 	JobStates jobStatesCollector
@@ -105,15 +116,19 @@ func (c *partitionCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *partitionCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *partitionCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("PartitionCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getPartitionMetrics(ctx)
+	metrics, err := c.getPartitionMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect partition metrics")
-		return
+		return err
 	}
 
 	for partition, data := range metrics.JobMetricsPer {
@@ -136,9 +151,13 @@ func (c *partitionCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.JobStates.PowerUpNode, prometheus.GaugeValue, float64(data.JobStates.PowerUpNode), partition)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.StageOut, prometheus.GaugeValue, float64(data.JobStates.StageOut), partition)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.Hold, prometheus.GaugeValue, float64(data.JobStates.Hold), partition)
+		for key, count := range data.JobStates.States {
+			ch <- prometheus.MustNewConstMetric(c.JobStates.States, prometheus.GaugeValue, float64(count), partition, key.State, key.Flag)
+		}
 		// Tres
 		ch <- prometheus.MustNewConstMetric(c.JobTres.CpusAlloc, prometheus.GaugeValue, float64(data.JobTres.CpusAlloc), partition)
 		ch <- prometheus.MustNewConstMetric(c.JobTres.MemoryAlloc, prometheus.GaugeValue, float64(data.JobTres.MemoryAlloc), partition)
+		ch <- prometheus.MustNewConstMetric(c.JobTres.EnergyConsumed, prometheus.GaugeValue, float64(data.JobTres.EnergyConsumed), partition)
 		// Other
 		ch <- prometheus.MustNewConstMetric(c.PendingNodeCount, prometheus.GaugeValue, float64(data.PendingNodeCount), partition)
 	}
@@ -161,6 +180,9 @@ func (c *partitionCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.NodeStates.Planned, prometheus.GaugeValue, float64(data.NodeStates.Planned), partition)
 		ch <- prometheus.MustNewConstMetric(c.NodeStates.RebootRequested, prometheus.GaugeValue, float64(data.NodeStates.RebootRequested), partition)
 		ch <- prometheus.MustNewConstMetric(c.NodeStates.Reserved, prometheus.GaugeValue, float64(data.NodeStates.Reserved), partition)
+		for key, count := range data.NodeStates.States {
+			ch <- prometheus.MustNewConstMetric(c.NodeStates.States, prometheus.GaugeValue, float64(count), partition, key.State, key.Flag)
+		}
 		// Tres
 		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusTotal, prometheus.GaugeValue, float64(data.NodeTres.CpusTotal), partition)
 		ch <- prometheus.MustNewConstMetric(c.NodeTres.CpusEffective, prometheus.GaugeValue, float64(data.NodeTres.CpusEffective), partition)
@@ -170,62 +192,36 @@ func (c *partitionCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryEffective, prometheus.GaugeValue, float64(data.NodeTres.MemoryEffective), partition)
 		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryAlloc, prometheus.GaugeValue, float64(data.NodeTres.MemoryAlloc), partition)
 		ch <- prometheus.MustNewConstMetric(c.NodeTres.MemoryFree, prometheus.GaugeValue, float64(data.NodeTres.MemoryFree), partition)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.EnergyConsumed, prometheus.GaugeValue, float64(data.NodeTres.EnergyConsumed), partition)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.PowerCurrent, prometheus.GaugeValue, float64(data.NodeTres.PowerCurrent), partition)
+		ch <- prometheus.MustNewConstMetric(c.NodeTres.PowerAverage, prometheus.GaugeValue, float64(data.NodeTres.PowerAverage), partition)
 	}
+	return nil
 }
 
-func (c *partitionCollector) getPartitionMetrics(ctx context.Context) (*PartitionMetrics, error) {
-	partitionList := &types.V0043PartitionInfoList{}
-	if err := c.slurmClient.List(ctx, partitionList); err != nil {
-		return nil, err
-	}
-	jobList := &types.V0043JobInfoList{}
-	if err := c.slurmClient.List(ctx, jobList); err != nil {
-		return nil, err
-	}
-	nodeList := &types.V0043NodeList{}
-	if err := c.slurmClient.List(ctx, nodeList); err != nil {
-		return nil, err
-	}
-	metrics := calculatePartitionMetrics(partitionList, nodeList, jobList)
-	return metrics, nil
+func (c *partitionCollector) getPartitionMetrics(snapshot *Snapshot) (*PartitionMetrics, error) {
+	return snapshot.PartitionMetrics()
 }
 
+// calculatePartitionMetrics picks the per partition aggregates, such that each
+// partition has metrics even without any nodes or jobs.
 func calculatePartitionMetrics(
 	partitionList *types.V0043PartitionInfoList,
-	nodeList *types.V0043NodeList,
-	jobList *types.V0043JobInfoList,
+	nodeAggregates *NodeAggregates,
+	jobAggregates *JobAggregates,
 ) *PartitionMetrics {
 	metrics := &PartitionMetrics{
-		NodeMetricsPer: make(map[string]*NodeMetrics, len(partitionList.Items)),
-		JobMetricsPer:  make(map[string]*PartitionJobMetrics, len(partitionList.Items)),
+		NodeMetricsPer: maps.Clone(nodeAggregates.PartitionNodeMetricsPer),
+		JobMetricsPer:  maps.Clone(jobAggregates.PartitionJobMetricsPer),
 	}
 
 	for _, partition := range partitionList.Items {
 		key := string(partition.GetKey())
-		metrics.NodeMetricsPer[key] = &NodeMetrics{}
-		metrics.JobMetricsPer[key] = &PartitionJobMetrics{}
-	}
-
-	for _, node := range nodeList.Items {
-		for _, key := range ptr.Deref(node.Partitions, []string{}) {
-			if _, ok := metrics.JobMetricsPer[key]; !ok {
-				metrics.NodeMetricsPer[key] = &NodeMetrics{}
-			}
-			metrics.NodeMetricsPer[key].NodeCount++
-			calculateNodeState(&metrics.NodeMetricsPer[key].NodeStates, node)
-			calculateNodeTres(&metrics.NodeMetricsPer[key].NodeTres, node)
+		if _, ok := metrics.NodeMetricsPer[key]; !ok {
+			metrics.NodeMetricsPer[key] = &NodeMetrics{}
 		}
-	}
-
-	for _, job := range jobList.Items {
-		for _, key := range utils.ParseCSV(ptr.Deref(job.Partition, "")) {
-			if _, ok := metrics.JobMetricsPer[key]; !ok {
-				metrics.JobMetricsPer[key] = &PartitionJobMetrics{}
-			}
-			metrics.JobMetricsPer[key].JobCount++
-			calculateJobState(&metrics.JobMetricsPer[key].JobStates, job)
-			calculateJobTres(&metrics.JobMetricsPer[key].JobTres, job)
-			metrics.JobMetricsPer[key].PendingNodeCount = max(metrics.JobMetricsPer[key].PendingNodeCount, getJobPendingNodeCount(job))
+		if _, ok := metrics.JobMetricsPer[key]; !ok {
+			metrics.JobMetricsPer[key] = &PartitionJobMetrics{}
 		}
 	}
 
@@ -244,6 +240,33 @@ func getJobPendingNodeCount(job types.V0043JobInfo) uint {
 	return uint(nodeCount)
 }
 
+// getJobPendingResources returns the resources requested by the job if the job
+// is pending, otherwise returns zero.
+func getJobPendingResources(job types.V0043JobInfo) pendingResources {
+	isPending := job.GetStateAsSet().Has(api.V0043JobInfoJobStatePENDING)
+	isHold := ptr.Deref(job.Hold, false)
+	if !isPending || isHold {
+		return pendingResources{}
+	}
+	res := pendingResources{Jobs: 1}
+	tresReq := utils.ParseTRES(ptr.Deref(job.TresReqStr, ""))
+	if cpus, err := strconv.ParseUint(tresReq["cpu"], 10, 64); err == nil {
+		res.Cpus = uint(cpus)
+	} else {
+		res.Cpus = uint(ParseUint32NoVal(job.Cpus))
+	}
+	if gpus, err := strconv.ParseUint(tresReq["gres/gpu"], 10, 64); err == nil {
+		res.Gpus = uint(gpus)
+	}
+	return res
+}
+
+type pendingResources struct {
+	Jobs uint
+	Cpus uint
+	Gpus uint
+}
+
 type PartitionMetrics struct {
 	NodeMetricsPer map[string]*NodeMetrics
 	JobMetricsPer  map[string]*PartitionJobMetrics
@@ -252,4 +275,14 @@ type PartitionMetrics struct {
 type PartitionJobMetrics struct {
 	JobMetrics
 	PendingNodeCount uint
+	// Requested by the pending jobs which are not held, e.g. for autoscaling.
+	PendingJobCount uint
+	PendingCpus     uint
+	PendingGpus     uint
+}
+
+func (m *PartitionJobMetrics) addPending(res pendingResources) {
+	m.PendingJobCount += res.Jobs
+	m.PendingCpus += res.Cpus
+	m.PendingGpus += res.Gpus
 }
@@ -7,6 +7,7 @@ import (
 	"context"
 	"testing"
 
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
 	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
@@ -14,6 +15,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
 )
 
 func Test_getJobPendingNodeCount(t *testing.T) {
@@ -56,6 +58,52 @@ func Test_getJobPendingNodeCount(t *testing.T) {
 	}
 }
 
+func Test_getJobPendingResources(t *testing.T) {
+	tests := []struct {
+		name string
+		job  types.V0043JobInfo
+		want pendingResources
+	}{
+		{
+			name: "empty",
+			job:  types.V0043JobInfo{},
+			want: pendingResources{},
+		},
+		{
+			name: "running",
+			job:  *job1,
+			want: pendingResources{},
+		},
+		{
+			name: "pending",
+			job:  *job3,
+			want: pendingResources{Jobs: 1, Cpus: 8, Gpus: 2},
+		},
+		{
+			name: "pending without TRES",
+			job: types.V0043JobInfo{V0043JobInfo: api.V0043JobInfo{
+				JobState: ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+				Cpus:     &api.V0043Uint32NoValStruct{Number: ptr.To[int32](4), Set: ptr.To(true)},
+			}},
+			want: pendingResources{Jobs: 1, Cpus: 4},
+		},
+		{
+			name: "held",
+			job: types.V0043JobInfo{V0043JobInfo: api.V0043JobInfo{
+				JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+				Hold:       ptr.To(true),
+				TresReqStr: ptr.To("cpu=8,gres/gpu=2"),
+			}},
+			want: pendingResources{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, getJobPendingResources(tt.job))
+		})
+	}
+}
+
 func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 	type fields struct {
 		slurmClient client.Client
@@ -99,6 +147,11 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 							Allocated: 2,
 							Idle:      1,
 							Drain:     1,
+							States: StateCounts{
+								{State: "allocated"}:                2,
+								{State: "allocated", Flag: "drain"}: 1,
+								{State: "idle"}:                     1,
+							},
 						},
 						NodeTres: NodeTres{
 							CpusTotal:       40,
@@ -119,6 +172,12 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 							Mixed:      1,
 							Completing: 1,
 							Drain:      1,
+							States: StateCounts{
+								{State: "allocated"}:                 2,
+								{State: "allocated", Flag: "drain"}:  1,
+								{State: "mixed"}:                     1,
+								{State: "mixed", Flag: "completing"}: 1,
+							},
 						},
 						NodeTres: NodeTres{
 							total:           3,
@@ -141,6 +200,10 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 								Pending: 1,
 								Running: 1,
 								Hold:    1,
+								States: StateCounts{
+									{State: "pending"}: 1,
+									{State: "running"}: 1,
+								},
 							},
 							JobTres: JobTres{
 								CpusAlloc:   8,
@@ -156,6 +219,10 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 								Pending: 2,
 								Running: 1,
 								Hold:    1,
+								States: StateCounts{
+									{State: "pending"}: 2,
+									{State: "running"}: 1,
+								},
 							},
 							JobTres: JobTres{
 								CpusAlloc:   12,
@@ -163,6 +230,9 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 							},
 						},
 						PendingNodeCount: 2,
+						PendingJobCount:  1,
+						PendingCpus:      8,
+						PendingGpus:      2,
 					},
 				},
 			},
@@ -182,9 +252,9 @@ func TestPartitionCollector_getPartitionMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &partitionCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getPartitionMetrics(tt.args.ctx)
+			got, err := c.getPartitionMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("partitionCollector.getPartitionMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -248,7 +318,7 @@ func TestPartitionCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewPartitionCollector(tt.fields.slurmClient)
+			c := NewPartitionCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -290,7 +360,7 @@ func TestPartitionCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewPartitionCollector(tt.fields.slurmClient)
+			c := NewPartitionCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// Precomputer gathers the metric families in the background and stores them,
+// such that a Gather only hands out the stored metric families. The cost of a
+// scrape is then constant, no matter how many scrapes there are.
+type Precomputer struct {
+	gatherer prometheus.Gatherer
+	period   time.Duration
+	trigger  chan struct{}
+
+	precomputed atomic.Pointer[precomputed]
+}
+
+type precomputed struct {
+	families []*dto.MetricFamily
+	err      error
+}
+
+// NewPrecomputer returns a Precomputer of the gatherer, which gathers at least
+// once per period, and whenever it is triggered.
+func NewPrecomputer(gatherer prometheus.Gatherer, period time.Duration) *Precomputer {
+	return &Precomputer{
+		gatherer: gatherer,
+		period:   period,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Trigger requests a gather, without waiting for it. Triggers which arrive
+// while a gather is pending are coalesced into it.
+func (p *Precomputer) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start gathers in the background until the context is done.
+func (p *Precomputer) Start(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("Precomputer")
+
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		p.precompute()
+		if err := p.precomputed.Load().err; err != nil {
+			logger.Error(err, "failed to precompute metrics")
+		}
+		select {
+		case <-ticker.C:
+		case <-p.trigger:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Precomputer) precompute() {
+	families, err := p.gatherer.Gather()
+	p.precomputed.Store(&precomputed{families: families, err: err})
+}
+
+// Gather implements prometheus.Gatherer. It returns the last precomputed
+// metric families, or gathers them if nothing was precomputed yet.
+func (p *Precomputer) Gather() ([]*dto.MetricFamily, error) {
+	if p.precomputed.Load() == nil {
+		p.precompute()
+	}
+	last := p.precomputed.Load()
+	return last.families, last.err
+}
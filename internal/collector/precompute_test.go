@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+// newCountingGatherer returns a gatherer whose metric family names the number
+// of calls made to it.
+func newCountingGatherer(calls *atomic.Int32) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		n := calls.Add(1)
+		return []*dto.MetricFamily{{Name: ptr.To(string(rune('a' + n)))}}, nil
+	})
+}
+
+func TestPrecomputer_Gather(t *testing.T) {
+	var calls atomic.Int32
+	p := NewPrecomputer(newCountingGatherer(&calls), time.Hour)
+
+	// Nothing was precomputed yet.
+	got, err := p.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got[0].GetName())
+	for range 3 {
+		got, err = p.Gather()
+		assert.NoError(t, err)
+		assert.Equal(t, "b", got[0].GetName())
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestPrecomputer_Gather_failure(t *testing.T) {
+	p := NewPrecomputer(prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return nil, errors.New("failure")
+	}), time.Hour)
+
+	_, err := p.Gather()
+	assert.Error(t, err)
+}
+
+func TestPrecomputer_Start(t *testing.T) {
+	var calls atomic.Int32
+	p := NewPrecomputer(newCountingGatherer(&calls), time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Start(ctx)
+		close(done)
+	}()
+
+	// Gathers once on start.
+	assert.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	got, err := p.Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", got[0].GetName())
+
+	// Gathers again when triggered.
+	p.Trigger()
+	assert.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		got, _ := p.Gather()
+		return got[0].GetName() == "c"
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestPrecomputer_Trigger(t *testing.T) {
+	var calls atomic.Int32
+	p := NewPrecomputer(newCountingGatherer(&calls), time.Hour)
+
+	// Triggers are coalesced until the next gather.
+	for range 3 {
+		p.Trigger()
+	}
+	assert.Len(t, p.trigger, 1)
+	assert.Equal(t, int32(0), calls.Load())
+}
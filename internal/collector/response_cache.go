@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/utils/clock"
+)
+
+// ResponseCache serves the responses of the handler from a cache for the ttl,
+// keyed by the negotiated content type, the accepted encodings and the query
+// of the request (e.g. a collector filter). Requests which arrive while their
+// response is computed wait for it, such that any number of scrapers cost one
+// computation per ttl.
+type ResponseCache struct {
+	handler http.Handler
+	ttl     time.Duration
+	clock   clock.PassiveClock
+
+	group singleflight.Group
+
+	mu        sync.Mutex
+	responses map[string]*cachedResponse
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func NewResponseCache(handler http.Handler, ttl time.Duration) *ResponseCache {
+	return newResponseCache(handler, ttl, clock.RealClock{})
+}
+
+func newResponseCache(handler http.Handler, ttl time.Duration, clk clock.PassiveClock) *ResponseCache {
+	return &ResponseCache{
+		handler:   handler,
+		ttl:       ttl,
+		clock:     clk,
+		responses: make(map[string]*cachedResponse),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (c *ResponseCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := responseKey(r)
+	if resp := c.get(key); resp != nil {
+		resp.writeTo(w)
+		return
+	}
+	v, _, _ := c.group.Do(key, func() (any, error) {
+		if resp := c.get(key); resp != nil {
+			return resp, nil
+		}
+		// The response is shared, hence it must not fail when the client which
+		// happened to request it first goes away.
+		recorder := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		c.handler.ServeHTTP(recorder, r.WithContext(context.WithoutCancel(r.Context())))
+		resp := &cachedResponse{
+			status:  recorder.status,
+			header:  recorder.header,
+			body:    recorder.body.Bytes(),
+			expires: c.clock.Now().Add(c.ttl),
+		}
+		if resp.status == http.StatusOK {
+			c.put(key, resp)
+		}
+		return resp, nil
+	})
+	v.(*cachedResponse).writeTo(w)
+}
+
+func (c *ResponseCache) get(key string) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, ok := c.responses[key]
+	if !ok || !c.clock.Now().Before(resp.expires) {
+		return nil
+	}
+	return resp
+}
+
+func (c *ResponseCache) put(key string, resp *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	maps.DeleteFunc(c.responses, func(_ string, resp *cachedResponse) bool {
+		return !now.Before(resp.expires)
+	})
+	c.responses[key] = resp
+}
+
+// responseKey returns what the response to the request depends on.
+func responseKey(r *http.Request) string {
+	return string(expfmt.NegotiateIncludingOpenMetrics(r.Header)) + "\n" +
+		r.Header.Get("Accept-Encoding") + "\n" +
+		r.URL.Query().Encode()
+}
+
+func (resp *cachedResponse) writeTo(w http.ResponseWriter) {
+	maps.Copy(w.Header(), resp.header)
+	w.WriteHeader(resp.status)
+	_, _ = w.Write(resp.body)
+}
+
+// responseRecorder records a response to be cached.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
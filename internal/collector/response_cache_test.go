@@ -0,0 +1,145 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// countingHandler responds with the number of requests it has served.
+type countingHandler struct {
+	count  atomic.Int32
+	status int
+	// When set, requests wait for it to be closed.
+	block chan struct{}
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := h.count.Add(1)
+	if h.block != nil {
+		<-h.block
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if h.status != 0 {
+		w.WriteHeader(h.status)
+	}
+	fmt.Fprintf(w, "%d %s", n, r.URL.RawQuery)
+}
+
+func scrape(h http.Handler, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponseCache_ttl(t *testing.T) {
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	handler := &countingHandler{}
+	cache := newResponseCache(handler, 10*time.Second, clk)
+
+	w := scrape(cache, "/metrics", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "1 ", w.Body.String())
+
+	clk.SetTime(time.Unix(1009, 0))
+	assert.Equal(t, "1 ", scrape(cache, "/metrics", nil).Body.String())
+
+	clk.SetTime(time.Unix(1010, 0))
+	assert.Equal(t, "2 ", scrape(cache, "/metrics", nil).Body.String())
+}
+
+func TestResponseCache_key(t *testing.T) {
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	handler := &countingHandler{}
+	cache := newResponseCache(handler, 10*time.Second, clk)
+
+	assert.Equal(t, "1 ", scrape(cache, "/metrics", nil).Body.String())
+	// The content type, the encoding and the query are part of the key.
+	openMetrics := http.Header{"Accept": {"application/openmetrics-text;version=1.0.0"}}
+	assert.Equal(t, "2 ", scrape(cache, "/metrics", openMetrics).Body.String())
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	assert.Equal(t, "3 ", scrape(cache, "/metrics", gzip).Body.String())
+	assert.Equal(t, "4 collect[]=node", scrape(cache, "/metrics?collect[]=node", nil).Body.String())
+
+	// The parameters of the query are compared regardless of their order.
+	assert.Equal(t, "5 a=1&b=2", scrape(cache, "/metrics?a=1&b=2", nil).Body.String())
+	assert.Equal(t, "5 a=1&b=2", scrape(cache, "/metrics?b=2&a=1", nil).Body.String())
+	// Different Accept headers which negotiate the same format share the response.
+	openMetrics = http.Header{"Accept": {"application/openmetrics-text;version=1.0.0;q=0.9,text/plain;q=0.5"}}
+	assert.Equal(t, "2 ", scrape(cache, "/metrics", openMetrics).Body.String())
+	assert.Equal(t, int32(5), handler.count.Load())
+}
+
+func TestResponseCache_error(t *testing.T) {
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	handler := &countingHandler{status: http.StatusServiceUnavailable}
+	cache := newResponseCache(handler, 10*time.Second, clk)
+
+	w := scrape(cache, "/metrics", nil)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1 ", w.Body.String())
+	// Errors are not cached.
+	assert.Equal(t, "2 ", scrape(cache, "/metrics", nil).Body.String())
+}
+
+func TestResponseCache_coalesce(t *testing.T) {
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	handler := &countingHandler{block: make(chan struct{})}
+	cache := newResponseCache(handler, 10*time.Second, clk)
+
+	const scrapers = 5
+	bodies := make([]string, scrapers)
+	var wg sync.WaitGroup
+	for i := range scrapers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = scrape(cache, "/metrics", nil).Body.String()
+		}()
+	}
+	// Let the scrapers wait for the first one before it completes.
+	assert.Eventually(t, func() bool { return handler.count.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(handler.block)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), handler.count.Load())
+	for _, body := range bodies {
+		assert.Equal(t, "1 ", body)
+	}
+}
+
+func TestResponseCache_promhttp(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewNodeCollector(NewSnapshotter(testDataClient)))
+	cache := NewResponseCache(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), time.Minute)
+
+	gzip := http.Header{"Accept-Encoding": {"gzip"}}
+	first := scrape(cache, "/metrics", gzip)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "gzip", first.Header().Get("Content-Encoding"))
+	second := scrape(cache, "/metrics", gzip)
+	assert.Equal(t, first.Header(), second.Header())
+	assert.Equal(t, first.Body.Bytes(), second.Body.Bytes())
+
+	plain := scrape(cache, "/metrics", nil)
+	assert.Empty(t, plain.Header().Get("Content-Encoding"))
+	assert.Contains(t, plain.Body.String(), "slurm_")
+}
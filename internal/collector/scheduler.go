@@ -4,92 +4,102 @@
 package collector
 
 import (
-	"context"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
 )
 
+// NewSchedulerCollector exports the slurmctld statistics (sdiag). When
+// resetAware is set, the statistics which accumulate since the last reset are
+// exported as monotonic counters which survive `sdiag -r` and slurmctld
+// restarts, otherwise their raw values are exported as gauges.
+//
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewSchedulerCollector(slurmClient client.Client) prometheus.Collector {
+func NewSchedulerCollector(snapshots *Snapshotter, resetAware bool) prometheus.Collector {
+	var counters *schedulerCounters
+	if resetAware {
+		counters = newSchedulerCounters()
+	}
 	return &schedulerCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
+		counters:  counters,
 
 		schedulerStats: schedulerStats{
-			ScheduleCycleDepth:     prometheus.NewDesc("slurm_scheduler_cycle_depth_total", "Total number of jobs processed in scheduling cycles", nil, nil),
-			ScheduleCycleLast:      prometheus.NewDesc("slurm_scheduler_cycle_last_seconds", "Time in microseconds for last scheduling cycle", nil, nil),
-			ScheduleCycleMax:       prometheus.NewDesc("slurm_scheduler_cycle_max_seconds", "Max time of any scheduling cycle in microseconds since last reset", nil, nil),
-			ScheduleCycleMean:      prometheus.NewDesc("slurm_scheduler_cycle_mean_seconds", "Mean time in microseconds for all scheduling cycles since last reset", nil, nil),
-			ScheduleCycleMeanDepth: prometheus.NewDesc("slurm_scheduler_cycle_depth_mean_total", "Mean of the number of jobs processed in a scheduling", nil, nil),
-			ScheduleCyclePerMinute: prometheus.NewDesc("slurm_scheduler_cycle_perminute_total", "Number of scheduling executions per minute", nil, nil),
-			ScheduleCycleSum:       prometheus.NewDesc("slurm_scheduler_cycle_sum_seconds_total", "Total run time in microseconds for all scheduling cycles since last reset", nil, nil),
-			ScheduleCycleTotal:     prometheus.NewDesc("slurm_scheduler_cycle_total", "Number of scheduling cycles since last reset", nil, nil),
-			ScheduleQueueLength:    prometheus.NewDesc("slurm_scheduler_queue_total", "Number of jobs pending in queue", nil, nil),
+			ScheduleCycleDepth:     newDesc("slurm_scheduler_cycle_depth_total", "Total number of jobs processed in scheduling cycles", nil, nil),
+			ScheduleCycleLast:      newDesc("slurm_scheduler_cycle_last_seconds", "Time in microseconds for last scheduling cycle", nil, nil),
+			ScheduleCycleMax:       newDesc("slurm_scheduler_cycle_max_seconds", "Max time of any scheduling cycle in microseconds since last reset", nil, nil),
+			ScheduleCycleMean:      newDesc("slurm_scheduler_cycle_mean_seconds", "Mean time in microseconds for all scheduling cycles since last reset", nil, nil),
+			ScheduleCycleMeanDepth: newDesc("slurm_scheduler_cycle_depth_mean_total", "Mean of the number of jobs processed in a scheduling", nil, nil),
+			ScheduleCyclePerMinute: newDesc("slurm_scheduler_cycle_perminute_total", "Number of scheduling executions per minute", nil, nil),
+			ScheduleCycleSum:       newDesc("slurm_scheduler_cycle_sum_seconds_total", "Total run time in microseconds for all scheduling cycles since last reset", nil, nil),
+			ScheduleCycleTotal:     newDesc("slurm_scheduler_cycle_total", "Number of scheduling cycles since last reset", nil, nil),
+			ScheduleQueueLength:    newDesc("slurm_scheduler_queue_total", "Number of jobs pending in queue", nil, nil),
 			// Limits
-			DefaultQueueDepth: prometheus.NewDesc("slurm_scheduler_defaultqueuedepth_total", "Reached number of jobs allowed to be tested", nil, nil),
-			EndJobQueue:       prometheus.NewDesc("slurm_scheduler_endjobqueue_total", "Reached end of queue", nil, nil),
-			Licenses:          prometheus.NewDesc("slurm_scheduler_licenses_total", "Blocked on licenses", nil, nil),
-			MaxJobStart:       prometheus.NewDesc("slurm_scheduler_maxjobstart_total", "Reached number of jobs allowed to start", nil, nil),
-			MaxRpcCnt:         prometheus.NewDesc("slurm_scheduler_maxrpc_total", "Reached RPC limit", nil, nil),
-			MaxSchedTime:      prometheus.NewDesc("slurm_scheduler_maxschedtime_total", "Reached maximum allowed scheduler time", nil, nil),
+			DefaultQueueDepth: newDesc("slurm_scheduler_defaultqueuedepth_total", "Reached number of jobs allowed to be tested", nil, nil),
+			EndJobQueue:       newDesc("slurm_scheduler_endjobqueue_total", "Reached end of queue", nil, nil),
+			Licenses:          newDesc("slurm_scheduler_licenses_total", "Blocked on licenses", nil, nil),
+			MaxJobStart:       newDesc("slurm_scheduler_maxjobstart_total", "Reached number of jobs allowed to start", nil, nil),
+			MaxRpcCnt:         newDesc("slurm_scheduler_maxrpc_total", "Reached RPC limit", nil, nil),
+			MaxSchedTime:      newDesc("slurm_scheduler_maxschedtime_total", "Reached maximum allowed scheduler time", nil, nil),
 		},
 		bfSchedulerStats: bfSchedulerStats{
-			BfActive:             prometheus.NewDesc("slurm_bfscheduler_active_bool", "Backfill scheduler currently running", nil, nil),
-			BfBackfilledHetJobs:  prometheus.NewDesc("slurm_bfscheduler_backfilledhetjobs_total", "Number of heterogeneous job components started through backfilling since last Slurm start", nil, nil),
-			BfBackfilledJobs:     prometheus.NewDesc("slurm_bfscheduler_backfilledjobs_total", "Number of jobs started through backfilling since last slurm start", nil, nil),
-			BfCycleCounter:       prometheus.NewDesc("slurm_bfscheduler_cycle_total", "Number of backfill scheduling cycles since last reset", nil, nil),
-			BfCycleLast:          prometheus.NewDesc("slurm_bfscheduler_cycle_seconds", "Execution time in microseconds of last backfill scheduling cycle", nil, nil),
-			BfCycleMax:           prometheus.NewDesc("slurm_bfscheduler_cycle_max_seconds", "Execution time in microseconds of longest backfill scheduling cycle", nil, nil),
-			BfCycleMean:          prometheus.NewDesc("slurm_bfscheduler_cycle_mean_seconds", "Mean time in microseconds of backfilling scheduling cycles since last reset", nil, nil),
-			BfCycleSum:           prometheus.NewDesc("slurm_bfscheduler_cycle_sum_seconds", "Total time in microseconds of backfilling scheduling cycles since last reset", nil, nil),
-			BfDepthMean:          prometheus.NewDesc("slurm_bfscheduler_depth_mean_total", "Mean number of eligible to run jobs processed during all backfilling scheduling cycles since last reset", nil, nil),
-			BfDepthMeanTry:       prometheus.NewDesc("slurm_bfscheduler_depth_try_total", "The subset of Depth Mean that the backfill scheduler attempted to schedule", nil, nil),
-			BfDepthSum:           prometheus.NewDesc("slurm_bfscheduler_depth_sum_total", "Total number of jobs processed during all backfilling scheduling cycles since last reset", nil, nil),
-			BfDepthTrySum:        prometheus.NewDesc("slurm_bfscheduler_depth_trysum_total", "Subset of bf_depth_sum that the backfill scheduler attempted to schedule", nil, nil),
-			BfLastBackfilledJobs: prometheus.NewDesc("slurm_bfscheduler_lastbackfilledjobs_total", "Number of jobs started through backfilling since last reset", nil, nil),
-			BfLastDepth:          prometheus.NewDesc("slurm_bfscheduler_lastdepth_total", "Number of processed jobs during last backfilling scheduling cycle", nil, nil),
-			BfLastDepthTry:       prometheus.NewDesc("slurm_bfscheduler_lastdepthtry_total", "Number of processed jobs during last backfilling scheduling cycle that had a chance to start using available resources", nil, nil),
-			BfQueueLen:           prometheus.NewDesc("slurm_bfscheduler_queue_total", "Number of jobs pending to be processed by backfilling algorithm", nil, nil),
-			BfQueueLenMean:       prometheus.NewDesc("slurm_bfscheduler_queue_mean_total", "Mean number of jobs pending to be processed by backfilling algorithm", nil, nil),
-			BfQueueLenSum:        prometheus.NewDesc("slurm_bfscheduler_queue_sum_total", "Total number of jobs pending to be processed by backfilling algorithm since last reset", nil, nil),
-			BfTableSize:          prometheus.NewDesc("slurm_bfscheduler_table_total", "Number of different time slots tested by the backfill scheduler in its last iteration", nil, nil),
-			BfTableSizeMean:      prometheus.NewDesc("slurm_bfscheduler_tablemean_total", "Mean number of different time slots tested by the backfill scheduler", nil, nil),
-			BfTableSizeSum:       prometheus.NewDesc("slurm_bfscheduler_tablesum_total", "Total number of different time slots tested by the backfill scheduler", nil, nil),
-			BfWhenLastCycle:      prometheus.NewDesc("slurm_bfscheduler_lastcycle_timestamp", "When the last backfill scheduling cycle happened (UNIX timestamp)", nil, nil),
+			BfActive:             newDesc("slurm_bfscheduler_active_bool", "Backfill scheduler currently running", nil, nil),
+			BfBackfilledHetJobs:  newDesc("slurm_bfscheduler_backfilledhetjobs_total", "Number of heterogeneous job components started through backfilling since last Slurm start", nil, nil),
+			BfBackfilledJobs:     newDesc("slurm_bfscheduler_backfilledjobs_total", "Number of jobs started through backfilling since last slurm start", nil, nil),
+			BfCycleCounter:       newDesc("slurm_bfscheduler_cycle_total", "Number of backfill scheduling cycles since last reset", nil, nil),
+			BfCycleLast:          newDesc("slurm_bfscheduler_cycle_seconds", "Execution time in microseconds of last backfill scheduling cycle", nil, nil),
+			BfCycleMax:           newDesc("slurm_bfscheduler_cycle_max_seconds", "Execution time in microseconds of longest backfill scheduling cycle", nil, nil),
+			BfCycleMean:          newDesc("slurm_bfscheduler_cycle_mean_seconds", "Mean time in microseconds of backfilling scheduling cycles since last reset", nil, nil),
+			BfCycleSum:           newDesc("slurm_bfscheduler_cycle_sum_seconds", "Total time in microseconds of backfilling scheduling cycles since last reset", nil, nil),
+			BfDepthMean:          newDesc("slurm_bfscheduler_depth_mean_total", "Mean number of eligible to run jobs processed during all backfilling scheduling cycles since last reset", nil, nil),
+			BfDepthMeanTry:       newDesc("slurm_bfscheduler_depth_try_total", "The subset of Depth Mean that the backfill scheduler attempted to schedule", nil, nil),
+			BfDepthSum:           newDesc("slurm_bfscheduler_depth_sum_total", "Total number of jobs processed during all backfilling scheduling cycles since last reset", nil, nil),
+			BfDepthTrySum:        newDesc("slurm_bfscheduler_depth_trysum_total", "Subset of bf_depth_sum that the backfill scheduler attempted to schedule", nil, nil),
+			BfLastBackfilledJobs: newDesc("slurm_bfscheduler_lastbackfilledjobs_total", "Number of jobs started through backfilling since last reset", nil, nil),
+			BfLastDepth:          newDesc("slurm_bfscheduler_lastdepth_total", "Number of processed jobs during last backfilling scheduling cycle", nil, nil),
+			BfLastDepthTry:       newDesc("slurm_bfscheduler_lastdepthtry_total", "Number of processed jobs during last backfilling scheduling cycle that had a chance to start using available resources", nil, nil),
+			BfQueueLen:           newDesc("slurm_bfscheduler_queue_total", "Number of jobs pending to be processed by backfilling algorithm", nil, nil),
+			BfQueueLenMean:       newDesc("slurm_bfscheduler_queue_mean_total", "Mean number of jobs pending to be processed by backfilling algorithm", nil, nil),
+			BfQueueLenSum:        newDesc("slurm_bfscheduler_queue_sum_total", "Total number of jobs pending to be processed by backfilling algorithm since last reset", nil, nil),
+			BfTableSize:          newDesc("slurm_bfscheduler_table_total", "Number of different time slots tested by the backfill scheduler in its last iteration", nil, nil),
+			BfTableSizeMean:      newDesc("slurm_bfscheduler_tablemean_total", "Mean number of different time slots tested by the backfill scheduler", nil, nil),
+			BfTableSizeSum:       newDesc("slurm_bfscheduler_tablesum_total", "Total number of different time slots tested by the backfill scheduler", nil, nil),
+			BfWhenLastCycle:      newDesc("slurm_bfscheduler_lastcycle_timestamp", "When the last backfill scheduling cycle happened (UNIX timestamp)", nil, nil),
 			// Limits
-			BfMaxJobStart:   prometheus.NewDesc("slurm_bfscheduler_maxjobstart_total", "Reached number of jobs allowed to be tested", nil, nil),
-			BfMaxJobTest:    prometheus.NewDesc("slurm_bfscheduler_maxjobtest_total", "Reached end of queue", nil, nil),
-			BfMaxTime:       prometheus.NewDesc("slurm_bfscheduler_maxtime_total", "Blocked on licenses", nil, nil),
-			BfNodeSpaceSize: prometheus.NewDesc("slurm_bfscheduler_nodespace_total", "Reached table size limit", nil, nil),
-			BfEndJobQueue:   prometheus.NewDesc("slurm_bfscheduler_endjobqueue_total", "Reached RPC limit", nil, nil),
-			BfStateChanged:  prometheus.NewDesc("slurm_bfscheduler_statechanged_total", "Reached maximum allowed scheduler time", nil, nil),
+			BfMaxJobStart:   newDesc("slurm_bfscheduler_maxjobstart_total", "Reached number of jobs allowed to be tested", nil, nil),
+			BfMaxJobTest:    newDesc("slurm_bfscheduler_maxjobtest_total", "Reached end of queue", nil, nil),
+			BfMaxTime:       newDesc("slurm_bfscheduler_maxtime_total", "Blocked on licenses", nil, nil),
+			BfNodeSpaceSize: newDesc("slurm_bfscheduler_nodespace_total", "Reached table size limit", nil, nil),
+			BfEndJobQueue:   newDesc("slurm_bfscheduler_endjobqueue_total", "Reached RPC limit", nil, nil),
+			BfStateChanged:  newDesc("slurm_bfscheduler_statechanged_total", "Reached maximum allowed scheduler time", nil, nil),
 		},
 		jobStats: jobStats{
-			JobStatesTs:   prometheus.NewDesc("slurm_scheduler_jobs_stats_timestamp", "When the job state counts were gathered (UNIX timestamp)", nil, nil),
-			JobsCanceled:  prometheus.NewDesc("slurm_scheduler_jobs_canceled_total", "Number of jobs canceled since the last reset", nil, nil),
-			JobsCompleted: prometheus.NewDesc("slurm_scheduler_jobs_completed_total", "Number of jobs completed since last reset", nil, nil),
-			JobsFailed:    prometheus.NewDesc("slurm_scheduler_jobs_failed_total", "Number of jobs failed due to slurmd or other internal issues since last reset", nil, nil),
-			JobsPending:   prometheus.NewDesc("slurm_scheduler_jobs_pending_total", "Number of jobs pending at the time of listed in job_state_ts", nil, nil),
-			JobsRunning:   prometheus.NewDesc("slurm_scheduler_jobs_running_total", "Number of jobs running at the time of listed in job_state_ts", nil, nil),
-			JobsStarted:   prometheus.NewDesc("slurm_scheduler_jobs_started_total", "Number of jobs started since last reset", nil, nil),
-			JobsSubmitted: prometheus.NewDesc("slurm_scheduler_jobs_submitted_total", "Number of jobs submitted since last reset", nil, nil),
+			JobStatesTs:   newDesc("slurm_scheduler_jobs_stats_timestamp", "When the job state counts were gathered (UNIX timestamp)", nil, nil),
+			JobsCanceled:  newDesc("slurm_scheduler_jobs_canceled_total", "Number of jobs canceled since the last reset", nil, nil),
+			JobsCompleted: newDesc("slurm_scheduler_jobs_completed_total", "Number of jobs completed since last reset", nil, nil),
+			JobsFailed:    newDesc("slurm_scheduler_jobs_failed_total", "Number of jobs failed due to slurmd or other internal issues since last reset", nil, nil),
+			JobsPending:   newDesc("slurm_scheduler_jobs_pending_total", "Number of jobs pending at the time of listed in job_state_ts", nil, nil),
+			JobsRunning:   newDesc("slurm_scheduler_jobs_running_total", "Number of jobs running at the time of listed in job_state_ts", nil, nil),
+			JobsStarted:   newDesc("slurm_scheduler_jobs_started_total", "Number of jobs started since last reset", nil, nil),
+			JobsSubmitted: newDesc("slurm_scheduler_jobs_submitted_total", "Number of jobs submitted since last reset", nil, nil),
 		},
 		agentStats: agentStats{
-			AgentCount:       prometheus.NewDesc("slurm_scheduler_agent_total", "Number of agent threads", nil, nil),
-			AgentQueueSize:   prometheus.NewDesc("slurm_scheduler_agent_queue_total", "Number of enqueued outgoing RPC requests in an internal retry list", nil, nil),
-			AgentThreadCount: prometheus.NewDesc("slurm_scheduler_agent_thread_total", "Total number of active threads created by all agent threads", nil, nil),
+			AgentCount:       newDesc("slurm_scheduler_agent_total", "Number of agent threads", nil, nil),
+			AgentQueueSize:   newDesc("slurm_scheduler_agent_queue_total", "Number of enqueued outgoing RPC requests in an internal retry list", nil, nil),
+			AgentThreadCount: newDesc("slurm_scheduler_agent_thread_total", "Total number of active threads created by all agent threads", nil, nil),
 		},
-		ServerThreadCount: prometheus.NewDesc("slurm_scheduler_thread_total", "Number of current active slurmctld threads", nil, nil),
-		DbdAgentQueueSize: prometheus.NewDesc("slurm_scheduler_dbdagentqueue_total", "Number of messages for SlurmDBD that are queued", nil, nil),
+		ServerThreadCount: newDesc("slurm_scheduler_thread_total", "Number of current active slurmctld threads", nil, nil),
+		DbdAgentQueueSize: newDesc("slurm_scheduler_dbdagentqueue_total", "Number of messages for SlurmDBD that are queued", nil, nil),
 	}
 }
 
 type schedulerCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
+	counters  *schedulerCounters
 
 	schedulerStats
 	bfSchedulerStats
@@ -172,77 +182,98 @@ func (c *schedulerCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *schedulerCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *schedulerCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("SchedulerCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getSchedulerMetrics(ctx)
+	metrics, err := c.getSchedulerMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect scheduler metrics")
-		return
+		return err
+	}
+	// Statistics without a reset time (e.g. of an empty response) are all
+	// zero, which the counters would take for a reset.
+	if c.counters != nil && metrics.ReqTimeStart == 0 {
+		logger.V(1).Info("no scheduler statistics, skipping the counters")
+		return nil
 	}
 
+	// Hold the counters for the whole collection, such that concurrent scrapes
+	// do not interleave their observations.
+	if c.counters != nil {
+		c.counters.mu.Lock()
+		defer c.counters.mu.Unlock()
+	}
+	reset := c.counters.observeReset(metrics.ReqTimeStart)
+
 	// Scheduler
-	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleDepth, prometheus.GaugeValue, float64(metrics.ScheduleCycleDepth))
+	ch <- c.cumulativeMetric(c.ScheduleCycleDepth, float64(metrics.ScheduleCycleDepth), reset)
 	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleLast, prometheus.GaugeValue, float64(metrics.ScheduleCycleLast))
 	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleMax, prometheus.GaugeValue, float64(metrics.ScheduleCycleMax))
 	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleMean, prometheus.GaugeValue, float64(metrics.ScheduleCycleMean))
 	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleMeanDepth, prometheus.GaugeValue, float64(metrics.ScheduleCycleMeanDepth))
 	ch <- prometheus.MustNewConstMetric(c.ScheduleCyclePerMinute, prometheus.GaugeValue, float64(metrics.ScheduleCyclePerMinute))
-	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleSum, prometheus.GaugeValue, float64(metrics.ScheduleCycleSum))
-	ch <- prometheus.MustNewConstMetric(c.ScheduleCycleTotal, prometheus.GaugeValue, float64(metrics.ScheduleCycleTotal))
+	ch <- c.cumulativeMetric(c.ScheduleCycleSum, float64(metrics.ScheduleCycleSum), reset)
+	ch <- c.cumulativeMetric(c.ScheduleCycleTotal, float64(metrics.ScheduleCycleTotal), reset)
 	ch <- prometheus.MustNewConstMetric(c.ScheduleQueueLength, prometheus.GaugeValue, float64(metrics.ScheduleQueueLength))
 	// Scheduler Limits
-	ch <- prometheus.MustNewConstMetric(c.DefaultQueueDepth, prometheus.GaugeValue, float64(metrics.ScheduleExit.DefaultQueueDepth))
-	ch <- prometheus.MustNewConstMetric(c.EndJobQueue, prometheus.GaugeValue, float64(metrics.ScheduleExit.EndJobQueue))
-	ch <- prometheus.MustNewConstMetric(c.Licenses, prometheus.GaugeValue, float64(metrics.ScheduleExit.Licenses))
-	ch <- prometheus.MustNewConstMetric(c.MaxJobStart, prometheus.GaugeValue, float64(metrics.ScheduleExit.MaxJobStart))
-	ch <- prometheus.MustNewConstMetric(c.MaxRpcCnt, prometheus.GaugeValue, float64(metrics.ScheduleExit.MaxRpcCnt))
-	ch <- prometheus.MustNewConstMetric(c.MaxSchedTime, prometheus.GaugeValue, float64(metrics.ScheduleExit.MaxSchedTime))
+	ch <- c.cumulativeMetric(c.DefaultQueueDepth, float64(metrics.ScheduleExit.DefaultQueueDepth), reset)
+	ch <- c.cumulativeMetric(c.EndJobQueue, float64(metrics.ScheduleExit.EndJobQueue), reset)
+	ch <- c.cumulativeMetric(c.Licenses, float64(metrics.ScheduleExit.Licenses), reset)
+	ch <- c.cumulativeMetric(c.MaxJobStart, float64(metrics.ScheduleExit.MaxJobStart), reset)
+	ch <- c.cumulativeMetric(c.MaxRpcCnt, float64(metrics.ScheduleExit.MaxRpcCnt), reset)
+	ch <- c.cumulativeMetric(c.MaxSchedTime, float64(metrics.ScheduleExit.MaxSchedTime), reset)
 	// Backfill
 	if metrics.BfActive {
 		ch <- prometheus.MustNewConstMetric(c.BfActive, prometheus.GaugeValue, float64(1))
 	} else {
 		ch <- prometheus.MustNewConstMetric(c.BfActive, prometheus.GaugeValue, float64(0))
 	}
-	ch <- prometheus.MustNewConstMetric(c.BfBackfilledHetJobs, prometheus.GaugeValue, float64(metrics.BfBackfilledHetJobs))
-	ch <- prometheus.MustNewConstMetric(c.BfBackfilledJobs, prometheus.GaugeValue, float64(metrics.BfBackfilledJobs))
-	ch <- prometheus.MustNewConstMetric(c.BfCycleCounter, prometheus.GaugeValue, float64(metrics.BfCycleCounter))
+	// The backfilled jobs accumulate since slurmctld started, not since the
+	// statistics were reset, so a reset of the statistics would count them
+	// twice. A restart of slurmctld is detected as their value going down.
+	ch <- c.cumulativeMetric(c.BfBackfilledHetJobs, float64(metrics.BfBackfilledHetJobs), false)
+	ch <- c.cumulativeMetric(c.BfBackfilledJobs, float64(metrics.BfBackfilledJobs), false)
+	ch <- c.cumulativeMetric(c.BfCycleCounter, float64(metrics.BfCycleCounter), reset)
 	ch <- prometheus.MustNewConstMetric(c.BfCycleLast, prometheus.GaugeValue, float64(metrics.BfCycleLast))
 	ch <- prometheus.MustNewConstMetric(c.BfCycleMax, prometheus.GaugeValue, float64(metrics.BfCycleMax))
 	ch <- prometheus.MustNewConstMetric(c.BfCycleMean, prometheus.GaugeValue, float64(metrics.BfCycleMean))
-	ch <- prometheus.MustNewConstMetric(c.BfCycleSum, prometheus.GaugeValue, float64(metrics.BfCycleSum))
+	ch <- c.cumulativeMetric(c.BfCycleSum, float64(metrics.BfCycleSum), reset)
 	ch <- prometheus.MustNewConstMetric(c.BfDepthMean, prometheus.GaugeValue, float64(metrics.BfDepthMean))
 	ch <- prometheus.MustNewConstMetric(c.BfDepthMeanTry, prometheus.GaugeValue, float64(metrics.BfDepthMeanTry))
-	ch <- prometheus.MustNewConstMetric(c.BfDepthSum, prometheus.GaugeValue, float64(metrics.BfDepthSum))
-	ch <- prometheus.MustNewConstMetric(c.BfDepthTrySum, prometheus.GaugeValue, float64(metrics.BfDepthTrySum))
-	ch <- prometheus.MustNewConstMetric(c.BfLastBackfilledJobs, prometheus.GaugeValue, float64(metrics.BfLastBackfilledJobs))
+	ch <- c.cumulativeMetric(c.BfDepthSum, float64(metrics.BfDepthSum), reset)
+	ch <- c.cumulativeMetric(c.BfDepthTrySum, float64(metrics.BfDepthTrySum), reset)
+	ch <- c.cumulativeMetric(c.BfLastBackfilledJobs, float64(metrics.BfLastBackfilledJobs), reset)
 	ch <- prometheus.MustNewConstMetric(c.BfLastDepth, prometheus.GaugeValue, float64(metrics.BfLastDepth))
 	ch <- prometheus.MustNewConstMetric(c.BfLastDepthTry, prometheus.GaugeValue, float64(metrics.BfLastDepthTry))
 	ch <- prometheus.MustNewConstMetric(c.BfQueueLen, prometheus.GaugeValue, float64(metrics.BfQueueLen))
 	ch <- prometheus.MustNewConstMetric(c.BfQueueLenMean, prometheus.GaugeValue, float64(metrics.BfQueueLenMean))
-	ch <- prometheus.MustNewConstMetric(c.BfQueueLenSum, prometheus.GaugeValue, float64(metrics.BfQueueLenSum))
+	ch <- c.cumulativeMetric(c.BfQueueLenSum, float64(metrics.BfQueueLenSum), reset)
 	ch <- prometheus.MustNewConstMetric(c.BfTableSize, prometheus.GaugeValue, float64(metrics.BfTableSize))
 	ch <- prometheus.MustNewConstMetric(c.BfTableSizeMean, prometheus.GaugeValue, float64(metrics.BfTableSizeMean))
-	ch <- prometheus.MustNewConstMetric(c.BfTableSizeSum, prometheus.GaugeValue, float64(metrics.BfTableSizeSum))
+	ch <- c.cumulativeMetric(c.BfTableSizeSum, float64(metrics.BfTableSizeSum), reset)
 	ch <- prometheus.MustNewConstMetric(c.BfWhenLastCycle, prometheus.GaugeValue, float64(metrics.BfWhenLastCycle))
 	// Backfill Limits
-	ch <- prometheus.MustNewConstMetric(c.BfMaxJobStart, prometheus.GaugeValue, float64(metrics.BfExit.BfMaxJobStart))
-	ch <- prometheus.MustNewConstMetric(c.BfMaxJobTest, prometheus.GaugeValue, float64(metrics.BfExit.BfMaxJobTest))
-	ch <- prometheus.MustNewConstMetric(c.BfMaxTime, prometheus.GaugeValue, float64(metrics.BfExit.BfMaxTime))
-	ch <- prometheus.MustNewConstMetric(c.BfNodeSpaceSize, prometheus.GaugeValue, float64(metrics.BfExit.BfNodeSpaceSize))
-	ch <- prometheus.MustNewConstMetric(c.BfStateChanged, prometheus.GaugeValue, float64(metrics.BfExit.StateChanged))
-	ch <- prometheus.MustNewConstMetric(c.BfEndJobQueue, prometheus.GaugeValue, float64(metrics.BfExit.EndJobQueue))
+	ch <- c.cumulativeMetric(c.BfMaxJobStart, float64(metrics.BfExit.BfMaxJobStart), reset)
+	ch <- c.cumulativeMetric(c.BfMaxJobTest, float64(metrics.BfExit.BfMaxJobTest), reset)
+	ch <- c.cumulativeMetric(c.BfMaxTime, float64(metrics.BfExit.BfMaxTime), reset)
+	ch <- c.cumulativeMetric(c.BfNodeSpaceSize, float64(metrics.BfExit.BfNodeSpaceSize), reset)
+	ch <- c.cumulativeMetric(c.BfStateChanged, float64(metrics.BfExit.StateChanged), reset)
+	ch <- c.cumulativeMetric(c.BfEndJobQueue, float64(metrics.BfExit.EndJobQueue), reset)
 	// Jobs
 	ch <- prometheus.MustNewConstMetric(c.JobStatesTs, prometheus.GaugeValue, float64(metrics.JobStatesTs))
-	ch <- prometheus.MustNewConstMetric(c.JobsCanceled, prometheus.GaugeValue, float64(metrics.JobsCanceled))
-	ch <- prometheus.MustNewConstMetric(c.JobsCompleted, prometheus.GaugeValue, float64(metrics.JobsCompleted))
-	ch <- prometheus.MustNewConstMetric(c.JobsFailed, prometheus.GaugeValue, float64(metrics.JobsFailed))
+	ch <- c.cumulativeMetric(c.JobsCanceled, float64(metrics.JobsCanceled), reset)
+	ch <- c.cumulativeMetric(c.JobsCompleted, float64(metrics.JobsCompleted), reset)
+	ch <- c.cumulativeMetric(c.JobsFailed, float64(metrics.JobsFailed), reset)
 	ch <- prometheus.MustNewConstMetric(c.JobsPending, prometheus.GaugeValue, float64(metrics.JobsPending))
 	ch <- prometheus.MustNewConstMetric(c.JobsRunning, prometheus.GaugeValue, float64(metrics.JobsRunning))
-	ch <- prometheus.MustNewConstMetric(c.JobsStarted, prometheus.GaugeValue, float64(metrics.JobsStarted))
-	ch <- prometheus.MustNewConstMetric(c.JobsSubmitted, prometheus.GaugeValue, float64(metrics.JobsSubmitted))
+	ch <- c.cumulativeMetric(c.JobsStarted, float64(metrics.JobsStarted), reset)
+	ch <- c.cumulativeMetric(c.JobsSubmitted, float64(metrics.JobsSubmitted), reset)
 	// Agent
 	ch <- prometheus.MustNewConstMetric(c.AgentCount, prometheus.GaugeValue, float64(metrics.AgentCount))
 	ch <- prometheus.MustNewConstMetric(c.AgentQueueSize, prometheus.GaugeValue, float64(metrics.AgentQueueSize))
@@ -250,11 +281,21 @@ func (c *schedulerCollector) Collect(ch chan<- prometheus.Metric) {
 	// Other
 	ch <- prometheus.MustNewConstMetric(c.ServerThreadCount, prometheus.GaugeValue, float64(metrics.ServerThreadCount))
 	ch <- prometheus.MustNewConstMetric(c.DbdAgentQueueSize, prometheus.GaugeValue, float64(metrics.DbdAgentQueueSize))
+	return nil
 }
 
-func (c *schedulerCollector) getSchedulerMetrics(ctx context.Context) (*SchedulerMetrics, error) {
-	statsList := &types.V0043StatsList{}
-	if err := c.slurmClient.List(ctx, statsList); err != nil {
+// cumulativeMetric returns the metric of a statistic which accumulates since
+// the last reset, which is indicated by reset or by the value going down.
+func (c *schedulerCollector) cumulativeMetric(desc *prometheus.Desc, value float64, reset bool) prometheus.Metric {
+	if c.counters == nil {
+		return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, c.counters.observe(desc, value, reset))
+}
+
+func (c *schedulerCollector) getSchedulerMetrics(snapshot *Snapshot) (*SchedulerMetrics, error) {
+	statsList, err := snapshot.Stats()
+	if err != nil {
 		return nil, err
 	}
 	metrics := calculateSchedulerMetrics(statsList)
@@ -342,6 +383,63 @@ func calculateSchedulerStats(metrics *SchedulerMetrics, stats types.V0043Stats)
 	metrics.ServerThreadCount = ptr.Deref(stats.ServerThreadCount, 0)
 
 	metrics.DbdAgentQueueSize = ptr.Deref(stats.DbdAgentQueueSize, 0)
+
+	metrics.ReqTimeStart = ParseUint64NoVal(stats.ReqTimeStart)
+}
+
+// schedulerCounters converts the statistics which slurmctld resets to zero
+// (`sdiag -r`, restart) into monotonic counters.
+type schedulerCounters struct {
+	mu sync.Mutex
+
+	// reqTimeStart is the last observed time of the statistics reset.
+	reqTimeStart uint64
+	series       map[*prometheus.Desc]*resetCounter
+}
+
+func newSchedulerCounters() *schedulerCounters {
+	return &schedulerCounters{
+		series: make(map[*prometheus.Desc]*resetCounter),
+	}
+}
+
+// observeReset reports whether the statistics were reset since the last
+// observation, according to their reset time. Statistics without a reset
+// time are not observed.
+func (c *schedulerCounters) observeReset(reqTimeStart uint64) bool {
+	if c == nil || reqTimeStart == 0 {
+		return false
+	}
+	reset := c.reqTimeStart != 0 && reqTimeStart != c.reqTimeStart
+	c.reqTimeStart = reqTimeStart
+	return reset
+}
+
+func (c *schedulerCounters) observe(desc *prometheus.Desc, value float64, reset bool) float64 {
+	counter, ok := c.series[desc]
+	if !ok {
+		counter = &resetCounter{}
+		c.series[desc] = counter
+	}
+	return counter.observe(value, reset)
+}
+
+// resetCounter accumulates a value which is periodically reset to zero.
+type resetCounter struct {
+	observed bool
+	last     float64
+	offset   float64
+}
+
+// observe returns the accumulated value. The last value before a reset is kept
+// as an offset, so the counter never goes down.
+func (c *resetCounter) observe(value float64, reset bool) float64 {
+	if c.observed && (reset || value < c.last) {
+		c.offset += c.last
+	}
+	c.observed = true
+	c.last = value
+	return c.offset + value
 }
 
 type SchedulerMetrics struct {
@@ -396,6 +494,8 @@ type SchedulerMetrics struct {
 	ServerThreadCount int32
 
 	DbdAgentQueueSize int32
+
+	ReqTimeStart uint64
 }
 
 type ScheduleExitFields struct {
@@ -5,6 +5,7 @@ package collector
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/SlinkyProject/slurm-client/pkg/client"
@@ -12,6 +13,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -113,6 +115,8 @@ func TestSchedulerCollector_getSchedulerMetrics(t *testing.T) {
 				ServerThreadCount: 7,
 
 				DbdAgentQueueSize: 8,
+
+				ReqTimeStart: 9,
 			},
 		},
 		{
@@ -130,9 +134,9 @@ func TestSchedulerCollector_getSchedulerMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &schedulerCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getSchedulerMetrics(tt.args.ctx)
+			got, err := c.getSchedulerMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("schedulerCollector.getSchedulerMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -147,9 +151,117 @@ func TestSchedulerCollector_getSchedulerMetrics(t *testing.T) {
 	}
 }
 
+func TestSchedulerCollector_Collect_emptyStats(t *testing.T) {
+	c := NewSchedulerCollector(NewSnapshotter(testDataClient), true).(*schedulerCollector)
+	want := `
+# HELP slurm_scheduler_cycle_total Number of scheduling cycles since last reset
+# TYPE slurm_scheduler_cycle_total counter
+slurm_scheduler_cycle_total 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "slurm_scheduler_cycle_total"))
+
+	// Empty statistics have no reset time, and are not taken for a reset.
+	c.snapshots = NewSnapshotter(fake.NewFakeClient())
+	assert.Equal(t, 0, testutil.CollectAndCount(c))
+	c.snapshots = NewSnapshotter(testDataClient)
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want), "slurm_scheduler_cycle_total"))
+}
+
+func Test_resetCounter_observe(t *testing.T) {
+	type observation struct {
+		value float64
+		reset bool
+	}
+	tests := []struct {
+		name         string
+		observations []observation
+		want         []float64
+	}{
+		{
+			name:         "first observation",
+			observations: []observation{{value: 10}},
+			want:         []float64{10},
+		},
+		{
+			name:         "increasing",
+			observations: []observation{{value: 10}, {value: 15}, {value: 15}},
+			want:         []float64{10, 15, 15},
+		},
+		{
+			name:         "value going down",
+			observations: []observation{{value: 10}, {value: 3}, {value: 5}},
+			want:         []float64{10, 13, 15},
+		},
+		{
+			name:         "reset time changed",
+			observations: []observation{{value: 10}, {value: 12, reset: true}, {value: 20}},
+			want:         []float64{10, 22, 30},
+		},
+		{
+			name:         "reset on first observation",
+			observations: []observation{{value: 10, reset: true}},
+			want:         []float64{10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &resetCounter{}
+			got := make([]float64, 0, len(tt.observations))
+			for _, o := range tt.observations {
+				got = append(got, c.observe(o.value, o.reset))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("resetCounter.observe() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_schedulerCounters_observeReset(t *testing.T) {
+	tests := []struct {
+		name          string
+		reqTimeStarts []uint64
+		want          []bool
+	}{
+		{
+			name:          "unchanged",
+			reqTimeStarts: []uint64{100, 100},
+			want:          []bool{false, false},
+		},
+		{
+			name:          "changed",
+			reqTimeStarts: []uint64{100, 200, 200},
+			want:          []bool{false, true, false},
+		},
+		{
+			name:          "unset",
+			reqTimeStarts: []uint64{0, 0},
+			want:          []bool{false, false},
+		},
+		{
+			name:          "unset in between",
+			reqTimeStarts: []uint64{100, 0, 100},
+			want:          []bool{false, false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSchedulerCounters()
+			got := make([]bool, 0, len(tt.reqTimeStarts))
+			for _, reqTimeStart := range tt.reqTimeStarts {
+				got = append(got, c.observeReset(reqTimeStart))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("schedulerCounters.observeReset() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestSchedulerCollector_Collect(t *testing.T) {
 	type fields struct {
 		slurmClient client.Client
+		resetAware  bool
 	}
 	type args struct {
 		ch chan prometheus.Metric
@@ -178,6 +290,16 @@ func TestSchedulerCollector_Collect(t *testing.T) {
 				ch: make(chan prometheus.Metric),
 			},
 		},
+		{
+			name: "data, reset aware",
+			fields: fields{
+				slurmClient: testDataClient,
+				resetAware:  true,
+			},
+			args: args{
+				ch: make(chan prometheus.Metric),
+			},
+		},
 		{
 			name: "failure",
 			fields: fields{
@@ -191,7 +313,7 @@ func TestSchedulerCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewSchedulerCollector(tt.fields.slurmClient)
+			c := NewSchedulerCollector(NewSnapshotter(tt.fields.slurmClient), tt.fields.resetAware)
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -212,6 +334,7 @@ func TestSchedulerCollector_Collect(t *testing.T) {
 func TestSchedulerCollector_Describe(t *testing.T) {
 	type fields struct {
 		slurmClient client.Client
+		resetAware  bool
 	}
 	type args struct {
 		ch chan *prometheus.Desc
@@ -233,7 +356,7 @@ func TestSchedulerCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewSchedulerCollector(tt.fields.slurmClient)
+			c := NewSchedulerCollector(NewSnapshotter(tt.fields.slurmClient), tt.fields.resetAware)
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
@@ -0,0 +1,325 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/utils/set"
+)
+
+// MetricsSchema selects the naming scheme of the exported metrics.
+type MetricsSchema string
+
+const (
+	// MetricsSchemaV1 is the original metric schema.
+	MetricsSchemaV1 MetricsSchema = "v1"
+	// MetricsSchemaV2 uses base units (bytes, seconds), correct metric types and
+	// OpenMetrics compliant names.
+	MetricsSchemaV2 MetricsSchema = "v2"
+	// MetricsSchemaBoth exports both schemas side by side, to migrate
+	// dashboards and alerts from v1 to v2.
+	MetricsSchemaBoth MetricsSchema = "both"
+)
+
+func ParseMetricsSchema(schema string) (MetricsSchema, error) {
+	switch s := MetricsSchema(schema); s {
+	case MetricsSchemaV1, MetricsSchemaV2, MetricsSchemaBoth:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown metrics schema %q, must be one of: %s, %s, %s",
+		schema, MetricsSchemaV1, MetricsSchemaV2, MetricsSchemaBoth)
+}
+
+const (
+	bytesPerMegabyte      = 1024 * 1024
+	secondsPerMicrosecond = 1e-6
+)
+
+type metricV2 struct {
+	// Name is the v2 metric name, empty when the metric has no v2 form.
+	Name string
+	// Scale converts the v1 value into base units.
+	Scale float64
+	// Counter is set when the v1 gauge is cumulative.
+	Counter bool
+}
+
+var (
+	// metricsV2 are the v1 metrics whose v2 form cannot be derived by the
+	// naming rules in newMetricV2.
+	metricsV2 = map[string]metricV2{
+		"slurm_scheduler_cycle_last_seconds":      {Name: "slurm_scheduler_last_cycle_duration_seconds", Scale: secondsPerMicrosecond},
+		"slurm_scheduler_cycle_max_seconds":       {Name: "slurm_scheduler_cycle_duration_max_seconds", Scale: secondsPerMicrosecond},
+		"slurm_scheduler_cycle_mean_seconds":      {Name: "slurm_scheduler_cycle_duration_mean_seconds", Scale: secondsPerMicrosecond},
+		"slurm_scheduler_cycle_sum_seconds_total": {Name: "slurm_scheduler_cycle_duration_seconds_total", Scale: secondsPerMicrosecond, Counter: true},
+		"slurm_bfscheduler_cycle_seconds":         {Name: "slurm_bfscheduler_last_cycle_duration_seconds", Scale: secondsPerMicrosecond},
+		"slurm_bfscheduler_cycle_max_seconds":     {Name: "slurm_bfscheduler_cycle_duration_max_seconds", Scale: secondsPerMicrosecond},
+		"slurm_bfscheduler_cycle_mean_seconds":    {Name: "slurm_bfscheduler_cycle_duration_mean_seconds", Scale: secondsPerMicrosecond},
+		"slurm_bfscheduler_cycle_sum_seconds":     {Name: "slurm_bfscheduler_cycle_duration_seconds_total", Scale: secondsPerMicrosecond, Counter: true},
+	}
+
+	// memoryMetricsV2 maps the suffixes of the v1 memory metrics, which are in
+	// megabytes, to their v2 suffix. The v2 names differ from v1 so both
+	// schemas can be exported together.
+	memoryMetricsV2 = map[string]string{
+		"_memory_alloc_bytes":     "_memory_allocated_bytes",
+		"_memory_bytes":           "_memory_real_bytes",
+		"_memory_effective_bytes": "_memory_usable_bytes",
+		"_memory_free_bytes":      "_memory_os_free_bytes",
+	}
+
+	// cumulativeMetrics are the v1 gauges which accumulate since the last
+	// sdiag reset or slurmctld start.
+	cumulativeMetrics = set.New(
+		"slurm_scheduler_cycle_depth_total",
+		"slurm_scheduler_cycle_total",
+		"slurm_scheduler_defaultqueuedepth_total",
+		"slurm_scheduler_endjobqueue_total",
+		"slurm_scheduler_licenses_total",
+		"slurm_scheduler_maxjobstart_total",
+		"slurm_scheduler_maxrpc_total",
+		"slurm_scheduler_maxschedtime_total",
+		"slurm_bfscheduler_backfilledhetjobs_total",
+		"slurm_bfscheduler_backfilledjobs_total",
+		"slurm_bfscheduler_cycle_total",
+		"slurm_bfscheduler_depth_sum_total",
+		"slurm_bfscheduler_depth_trysum_total",
+		"slurm_bfscheduler_lastbackfilledjobs_total",
+		"slurm_bfscheduler_queue_sum_total",
+		"slurm_bfscheduler_tablesum_total",
+		"slurm_bfscheduler_maxjobstart_total",
+		"slurm_bfscheduler_maxjobtest_total",
+		"slurm_bfscheduler_maxtime_total",
+		"slurm_bfscheduler_nodespace_total",
+		"slurm_bfscheduler_endjobqueue_total",
+		"slurm_bfscheduler_statechanged_total",
+		"slurm_scheduler_jobs_canceled_total",
+		"slurm_scheduler_jobs_completed_total",
+		"slurm_scheduler_jobs_failed_total",
+		"slurm_scheduler_jobs_started_total",
+		"slurm_scheduler_jobs_submitted_total",
+	)
+
+	// supersededMetrics are the v1 per state gauges, which are replaced by the
+	// label-based state metrics (e.g. slurm_jobs{state,flag}) in v2.
+	supersededMetrics = newSupersededMetrics()
+)
+
+func newSupersededMetrics() set.Set[string] {
+	metrics := set.New[string]()
+	for _, prefix := range []string{"slurm_jobs", "slurm_partition_jobs", "slurm_account_jobs", "slurm_user_jobs"} {
+		metrics.Insert(prefix + "_total")
+		for _, state := range []string{
+			"bootfail", "cancelled", "completed", "deadline", "failed", "pending", "preempted", "running",
+			"suspended", "timeout", "nodefail", "outofmemory", "completing", "configuring", "powerupnode", "stageout",
+		} {
+			metrics.Insert(prefix + "_" + state + "_total")
+		}
+	}
+	for _, prefix := range []string{"slurm_nodes", "slurm_partition_nodes"} {
+		metrics.Insert(prefix + "_total")
+		for _, state := range []string{
+			"allocated", "down", "error", "future", "idle", "mixed", "unknown", "completing", "drain", "fail",
+			"maintenance", "notresponding", "planned", "rebootrequested", "reserved",
+		} {
+			metrics.Insert(prefix + "_" + state + "_total")
+		}
+	}
+	return metrics
+}
+
+// newMetricV2 returns the v2 form of a v1 metric.
+func newMetricV2(name string, valueType prometheus.ValueType) metricV2 {
+	if metric, ok := metricsV2[name]; ok {
+		return metric
+	}
+	if valueType == prometheus.CounterValue {
+		return metricV2{Name: name, Scale: 1, Counter: true}
+	}
+	if supersededMetrics.Has(name) {
+		return metricV2{}
+	}
+	for suffix, suffixV2 := range memoryMetricsV2 {
+		if strings.HasSuffix(name, suffix) {
+			return metricV2{
+				Name:  strings.TrimSuffix(name, suffix) + suffixV2,
+				Scale: bytesPerMegabyte,
+			}
+		}
+	}
+	if cumulativeMetrics.Has(name) {
+		return metricV2{Name: name, Scale: 1, Counter: true}
+	}
+
+	// Gauges must not carry the counter suffix.
+	nameV2 := name
+	switch {
+	case strings.HasSuffix(nameV2, "_bool"):
+		nameV2 = strings.TrimSuffix(nameV2, "_bool")
+	case strings.HasSuffix(nameV2, "_timestamp"):
+		nameV2 += "_seconds"
+	case strings.HasSuffix(nameV2, "_total"):
+		nameV2 = strings.TrimSuffix(nameV2, "_total")
+	}
+	nameV2 = strings.ReplaceAll(nameV2+"_", "_alloc_", "_allocated_")
+	nameV2 = strings.TrimSuffix(nameV2, "_")
+	return metricV2{Name: nameV2, Scale: 1}
+}
+
+// NewSchemaCollector exports the metrics of the collector, which are in the v1
+// schema, according to the given schema.
+func NewSchemaCollector(collector prometheus.Collector, schema MetricsSchema) prometheus.Collector {
+	if schema == MetricsSchemaV1 {
+		return collector
+	}
+	return &schemaCollector{
+		collector: collector,
+		schema:    schema,
+		descs:     make(map[string]*prometheus.Desc),
+	}
+}
+
+type schemaCollector struct {
+	collector prometheus.Collector
+	schema    MetricsSchema
+
+	mu    sync.Mutex
+	descs map[string]*prometheus.Desc
+}
+
+func (c *schemaCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *schemaCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collect(ch, func(metrics chan<- prometheus.Metric) error {
+		c.collector.Collect(metrics)
+		return nil
+	})
+}
+
+func (c *schemaCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	return c.collect(ch, func(metrics chan<- prometheus.Metric) error {
+		return collectFrom(snapshot, c.collector, metrics)
+	})
+}
+
+func (c *schemaCollector) collect(ch chan<- prometheus.Metric, collect func(chan<- prometheus.Metric) error) error {
+	metrics := make(chan prometheus.Metric)
+	var err error
+	go func() {
+		err = collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		c.collectMetric(ch, metric)
+	}
+	return err
+}
+
+// collectMetric converts the v1 metric into v2. When both schemas are exported,
+// the v1 metric is only kept if its v2 form has a different name, otherwise
+// it is superseded by the v2 form. Metrics whose descriptor was not returned by
+// newDesc are kept as is.
+func (c *schemaCollector) collectMetric(ch chan<- prometheus.Metric, metric prometheus.Metric) {
+	name, help, ok := descs.lookup(metric.Desc())
+	if !ok {
+		ch <- metric
+		return
+	}
+	m := &dto.Metric{}
+	if err := metric.Write(m); err != nil {
+		ch <- prometheus.NewInvalidMetric(metric.Desc(), err)
+		return
+	}
+
+	var valueType prometheus.ValueType
+	var value float64
+	switch {
+	case m.Gauge != nil:
+		valueType, value = prometheus.GaugeValue, m.Gauge.GetValue()
+	case m.Counter != nil:
+		valueType, value = prometheus.CounterValue, m.Counter.GetValue()
+	case m.Untyped != nil:
+		valueType, value = prometheus.UntypedValue, m.Untyped.GetValue()
+	default:
+		// Histograms and summaries already follow the conventions.
+		ch <- metric
+		return
+	}
+
+	metricV2 := newMetricV2(name, valueType)
+	if c.schema == MetricsSchemaBoth && metricV2.Name != name {
+		ch <- metric
+	}
+	if metricV2.Name == "" {
+		return
+	}
+	if metricV2.Counter {
+		valueType = prometheus.CounterValue
+	}
+
+	labelNames := make([]string, 0, len(m.Label))
+	labelValues := make([]string, 0, len(m.Label))
+	for _, label := range m.Label {
+		labelNames = append(labelNames, label.GetName())
+		labelValues = append(labelValues, label.GetValue())
+	}
+	desc := c.descV2(metricV2, help, labelNames)
+	ch <- prometheus.MustNewConstMetric(desc, valueType, value*metricV2.Scale, labelValues...)
+}
+
+func (c *schemaCollector) descV2(metric metricV2, help string, labelNames []string) *prometheus.Desc {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if desc, ok := c.descs[metric.Name]; ok {
+		return desc
+	}
+	if metric.Scale != 1 {
+		help = strings.ReplaceAll(help, " (MB)", "")
+		help = strings.ReplaceAll(help, " in microseconds", "")
+	}
+	desc := prometheus.NewDesc(metric.Name, help, labelNames, nil)
+	c.descs[metric.Name] = desc
+	return desc
+}
+
+// descs records the name and help of the descriptors of the v1 metrics, which
+// the client library does not otherwise expose, such that they are converted
+// into v2.
+var descs = &descRegistry{descs: make(map[*prometheus.Desc]descInfo)}
+
+type descRegistry struct {
+	mu    sync.RWMutex
+	descs map[*prometheus.Desc]descInfo
+}
+
+type descInfo struct {
+	name string
+	help string
+}
+
+// newDesc returns a new descriptor like prometheus.NewDesc, recording its name
+// and help.
+func newDesc(name, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
+	desc := prometheus.NewDesc(name, help, variableLabels, constLabels)
+	descs.mu.Lock()
+	defer descs.mu.Unlock()
+	descs.descs[desc] = descInfo{name: name, help: help}
+	return desc
+}
+
+// lookup returns the name and help of the descriptor, if it was returned by
+// newDesc.
+func (r *descRegistry) lookup(desc *prometheus.Desc) (name, help string, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, ok := r.descs[desc]
+	return info.name, info.help, ok
+}
@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetricsSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		want    MetricsSchema
+		wantErr bool
+	}{
+		{
+			name:   "v1",
+			schema: "v1",
+			want:   MetricsSchemaV1,
+		},
+		{
+			name:   "v2",
+			schema: "v2",
+			want:   MetricsSchemaV2,
+		},
+		{
+			name:   "both",
+			schema: "both",
+			want:   MetricsSchemaBoth,
+		},
+		{
+			name:    "unknown",
+			schema:  "v3",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMetricsSchema(tt.schema)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMetricsSchema() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseMetricsSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newMetricV2(t *testing.T) {
+	tests := []struct {
+		name      string
+		valueType prometheus.ValueType
+		want      metricV2
+	}{
+		{
+			name:      "slurm_node_memory_bytes",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_node_memory_real_bytes", Scale: bytesPerMegabyte},
+		},
+		{
+			name:      "slurm_partition_jobs_memory_alloc_bytes",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_partition_jobs_memory_allocated_bytes", Scale: bytesPerMegabyte},
+		},
+		{
+			name:      "slurm_scheduler_cycle_last_seconds",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_scheduler_last_cycle_duration_seconds", Scale: secondsPerMicrosecond},
+		},
+		{
+			name:      "slurm_bfscheduler_cycle_sum_seconds",
+			valueType: prometheus.CounterValue,
+			want:      metricV2{Name: "slurm_bfscheduler_cycle_duration_seconds_total", Scale: secondsPerMicrosecond, Counter: true},
+		},
+		{
+			name:      "slurm_scheduler_jobs_submitted_total",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_scheduler_jobs_submitted_total", Scale: 1, Counter: true},
+		},
+		{
+			name:      "slurm_job_lifecycle_started_total",
+			valueType: prometheus.CounterValue,
+			want:      metricV2{Name: "slurm_job_lifecycle_started_total", Scale: 1, Counter: true},
+		},
+		{
+			name:      "slurm_node_cpus_alloc_total",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_node_cpus_allocated", Scale: 1},
+		},
+		{
+			name:      "slurm_jobs_pending_total",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{},
+		},
+		{
+			name:      "slurm_partition_nodes_total",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{},
+		},
+		{
+			name:      "slurm_jobs_hold_total",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_jobs_hold", Scale: 1},
+		},
+		{
+			name:      "slurm_jobs",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_jobs", Scale: 1},
+		},
+		{
+			name:      "slurm_bfscheduler_active_bool",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_bfscheduler_active", Scale: 1},
+		},
+		{
+			name:      "slurm_bfscheduler_lastcycle_timestamp",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_bfscheduler_lastcycle_timestamp_seconds", Scale: 1},
+		},
+		{
+			name:      "slurm_node_state_duration_seconds",
+			valueType: prometheus.GaugeValue,
+			want:      metricV2{Name: "slurm_node_state_duration_seconds", Scale: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newMetricV2(tt.name, tt.valueType)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("newMetricV2() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSchemaCollector_Collect(t *testing.T) {
+	newMemoryGauge := func() prometheus.Collector {
+		desc := newDesc("slurm_node_memory_bytes", "Total amount of Memory (MB) on the node", nodeLabels, nil)
+		return &constCollector{metric: prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 2, "node0")}
+	}
+
+	t.Run("v1", func(t *testing.T) {
+		collector := newMemoryGauge()
+		assert.Equal(t, collector, NewSchemaCollector(collector, MetricsSchemaV1))
+	})
+	t.Run("v2", func(t *testing.T) {
+		c := NewSchemaCollector(newMemoryGauge(), MetricsSchemaV2)
+		assert.Equal(t, 1, testutil.CollectAndCount(c))
+		assert.Equal(t, 1, testutil.CollectAndCount(c, "slurm_node_memory_real_bytes"))
+		assert.Equal(t, float64(2*bytesPerMegabyte), testutil.ToFloat64(c))
+	})
+	t.Run("both", func(t *testing.T) {
+		c := NewSchemaCollector(newMemoryGauge(), MetricsSchemaBoth)
+		assert.Equal(t, 1, testutil.CollectAndCount(c, "slurm_node_memory_bytes"))
+		assert.Equal(t, 1, testutil.CollectAndCount(c, "slurm_node_memory_real_bytes"))
+	})
+	t.Run("unrecorded", func(t *testing.T) {
+		gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "slurm_node_memory_bytes",
+			Help: "Total amount of Memory (MB) on the node",
+		}, nodeLabels)
+		gauge.WithLabelValues("node0").Set(2)
+		c := NewSchemaCollector(gauge, MetricsSchemaV2)
+		assert.Equal(t, 1, testutil.CollectAndCount(c, "slurm_node_memory_bytes"))
+		assert.Equal(t, float64(2), testutil.ToFloat64(c))
+	})
+	t.Run("both, same name", func(t *testing.T) {
+		c := NewSchemaCollector(NewSchedulerCollector(NewSnapshotter(testDataClient), false), MetricsSchemaBoth)
+		assert.Equal(t, 1, testutil.CollectAndCount(c, "slurm_scheduler_jobs_submitted_total"))
+	})
+	for _, schema := range []MetricsSchema{MetricsSchemaV2, MetricsSchemaBoth} {
+		t.Run(string(schema)+", lint", func(t *testing.T) {
+			for _, collector := range []prometheus.Collector{
+				NewSchedulerCollector(NewSnapshotter(testDataClient), false),
+				NewNodeCollector(NewSnapshotter(testDataClient)),
+				NewNodePowerCollector(NewSnapshotter(testDataClient)),
+				NewJobCollector(NewSnapshotter(testDataClient)),
+				NewPartitionCollector(NewSnapshotter(testDataClient)),
+				NewAccountCollector(NewSnapshotter(testDataClient)),
+				NewUserCollector(NewSnapshotter(testDataClient)),
+			} {
+				registry := prometheus.NewPedanticRegistry()
+				assert.NoError(t, registry.Register(NewSchemaCollector(collector, schema)))
+				_, err := registry.Gather()
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_newDesc(t *testing.T) {
+	// Every metric of the collectors, other than the histograms, has a recorded
+	// descriptor, such that it is converted into v2.
+	snapshots := NewSnapshotter(testDataClient)
+	for _, collector := range []prometheus.Collector{
+		NewSchedulerCollector(snapshots, false),
+		NewNodeCollectorWithPods(snapshots, &fakeNodePods{}),
+		NewNodeTransitionCollector(snapshots),
+		NewNodePowerCollector(snapshots),
+		NewJobCollector(snapshots),
+		NewJobLifecycleCollector(snapshots),
+		NewPartitionCollector(snapshots),
+		NewAccountCollector(snapshots),
+		NewUserCollector(snapshots),
+	} {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector.Collect(ch)
+			close(ch)
+		}()
+		for metric := range ch {
+			m := &dto.Metric{}
+			assert.NoError(t, metric.Write(m))
+			if m.Histogram != nil {
+				continue
+			}
+			name, _, ok := descs.lookup(metric.Desc())
+			assert.True(t, ok, metric.Desc().String())
+			assert.NotEmpty(t, name)
+		}
+	}
+}
+
+// constCollector collects the metric.
+type constCollector struct {
+	metric prometheus.Metric
+}
+
+func (c *constCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.metric.Desc()
+}
+
+func (c *constCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.metric
+}
+
+func TestSchemaCollector_Describe(t *testing.T) {
+	c := NewSchemaCollector(NewSchedulerCollector(NewSnapshotter(testDataClient), false), MetricsSchemaV2)
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var got int
+	for desc := range ch {
+		assert.NotNil(t, desc)
+		got++
+	}
+	assert.NotZero(t, got)
+}
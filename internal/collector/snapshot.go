@@ -0,0 +1,298 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/ptr"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/utils"
+)
+
+// Snapshot is an immutable view of the Slurm objects, shared by the collectors
+// of a scrape. Each object type is listed on first use, and the job and node
+// metrics of every collector are aggregated in a single pass on first use,
+// such that the collectors neither copy nor walk the objects more than once.
+// Collectors must not modify anything returned by a Snapshot.
+type Snapshot struct {
+	ctx context.Context
+
+	jobs       func() (*types.V0043JobInfoList, error)
+	nodes      func() (*types.V0043NodeList, error)
+	partitions func() (*types.V0043PartitionInfoList, error)
+	stats      func() (*types.V0043StatsList, error)
+
+	jobAggregates  func() (*JobAggregates, error)
+	nodeAggregates func() (*NodeAggregates, error)
+}
+
+func newSnapshot(ctx context.Context, snapshots *Snapshotter) *Snapshot {
+	s := &Snapshot{
+		ctx: ctx,
+		jobs: sync.OnceValues(func() (*types.V0043JobInfoList, error) {
+			return listOrLastKnownGood(ctx, snapshots, objectTypeJobs, &types.V0043JobInfoList{})
+		}),
+		nodes: sync.OnceValues(func() (*types.V0043NodeList, error) {
+			return listOrLastKnownGood(ctx, snapshots, objectTypeNodes, &types.V0043NodeList{})
+		}),
+		partitions: sync.OnceValues(func() (*types.V0043PartitionInfoList, error) {
+			return listOrLastKnownGood(ctx, snapshots, objectTypePartitions, &types.V0043PartitionInfoList{})
+		}),
+		stats: sync.OnceValues(func() (*types.V0043StatsList, error) {
+			return listOrLastKnownGood(ctx, snapshots, objectTypeStats, &types.V0043StatsList{})
+		}),
+	}
+	s.jobAggregates = sync.OnceValues(func() (*JobAggregates, error) {
+		jobList, err := s.jobs()
+		if err != nil {
+			return nil, err
+		}
+		return calculateJobAggregates(jobList), nil
+	})
+	s.nodeAggregates = sync.OnceValues(func() (*NodeAggregates, error) {
+		nodeList, err := s.nodes()
+		if err != nil {
+			return nil, err
+		}
+		return calculateNodeAggregates(nodeList), nil
+	})
+	return s
+}
+
+// Context returns the context of the scrape, which bounds every List call of
+// the Snapshot.
+func (s *Snapshot) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Snapshot) Jobs() (*types.V0043JobInfoList, error) {
+	return s.jobs()
+}
+
+func (s *Snapshot) Nodes() (*types.V0043NodeList, error) {
+	return s.nodes()
+}
+
+func (s *Snapshot) Partitions() (*types.V0043PartitionInfoList, error) {
+	return s.partitions()
+}
+
+func (s *Snapshot) Stats() (*types.V0043StatsList, error) {
+	return s.stats()
+}
+
+func (s *Snapshot) JobAggregates() (*JobAggregates, error) {
+	return s.jobAggregates()
+}
+
+func (s *Snapshot) NodeAggregates() (*NodeAggregates, error) {
+	return s.nodeAggregates()
+}
+
+// PartitionMetrics returns the job and node metrics of every partition.
+func (s *Snapshot) PartitionMetrics() (*PartitionMetrics, error) {
+	partitionList, err := s.Partitions()
+	if err != nil {
+		return nil, err
+	}
+	jobAggregates, err := s.JobAggregates()
+	if err != nil {
+		return nil, err
+	}
+	nodeAggregates, err := s.NodeAggregates()
+	if err != nil {
+		return nil, err
+	}
+	return calculatePartitionMetrics(partitionList, nodeAggregates, jobAggregates), nil
+}
+
+// Snapshotter hands out Snapshots of the Slurm objects, and keeps what they
+// share across scrapes (e.g. the last known good objects).
+type Snapshotter struct {
+	slurmClient client.Client
+	clock       clock.PassiveClock
+	gracePeriod time.Duration
+	lastGood    map[string]*lastKnownGood
+}
+
+func NewSnapshotter(slurmClient client.Client) *Snapshotter {
+	return newSnapshotter(slurmClient, 0, clock.RealClock{})
+}
+
+// NewSnapshotterWithGracePeriod returns a Snapshotter whose Snapshots serve
+// the last objects which were listed successfully, while the objects cannot
+// be listed for up to the grace period.
+func NewSnapshotterWithGracePeriod(slurmClient client.Client, gracePeriod time.Duration) *Snapshotter {
+	return newSnapshotter(slurmClient, gracePeriod, clock.RealClock{})
+}
+
+func newSnapshotter(slurmClient client.Client, gracePeriod time.Duration, clk clock.PassiveClock) *Snapshotter {
+	lastGood := make(map[string]*lastKnownGood, len(objectTypes))
+	for _, objectType := range objectTypes {
+		lastGood[objectType] = &lastKnownGood{}
+	}
+	return &Snapshotter{
+		slurmClient: slurmClient,
+		clock:       clk,
+		gracePeriod: gracePeriod,
+		lastGood:    lastGood,
+	}
+}
+
+// Snapshot returns a new Snapshot, which is not bound by any context (e.g. a
+// collector is used on its own).
+func (s *Snapshotter) Snapshot() *Snapshot {
+	return s.SnapshotWithContext(context.Background())
+}
+
+// SnapshotWithContext returns a new Snapshot, whose List calls are bound by
+// the context.
+func (s *Snapshotter) SnapshotWithContext(ctx context.Context) *Snapshot {
+	return newSnapshot(ctx, s)
+}
+
+// Gatherer returns a Gatherer of the collectors, such that the collectors
+// share one Snapshot per Gather, bound by the context. Every Gather takes its
+// own Snapshot, which it hands down to its collectors, such that overlapping
+// Gathers never share a Snapshot nor cut each other short.
+func (s *Snapshotter) Gatherer(ctx context.Context, collectors ...prometheus.Collector) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		snapshot := s.SnapshotWithContext(ctx)
+		registry := prometheus.NewRegistry()
+		for _, collector := range collectors {
+			if err := registry.Register(&boundCollector{collector: collector, snapshot: snapshot}); err != nil {
+				return nil, err
+			}
+		}
+		return registry.Gather()
+	})
+}
+
+// snapshotCollector is a collector which collects from a given Snapshot,
+// rather than taking its own, and returns the error which failed the
+// collection, if any.
+type snapshotCollector interface {
+	prometheus.Collector
+	collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error
+}
+
+// collectFrom collects the collector from the Snapshot, if it collects from
+// Snapshots, otherwise as is, in which case it never fails.
+func collectFrom(snapshot *Snapshot, collector prometheus.Collector, ch chan<- prometheus.Metric) error {
+	if c, ok := collector.(snapshotCollector); ok {
+		return c.collectSnapshot(snapshot, ch)
+	}
+	collector.Collect(ch)
+	return nil
+}
+
+// boundCollector collects the collector from the Snapshot of a Gather. It
+// describes nothing, such that registering it for every Gather does not
+// describe the collector by collecting it.
+type boundCollector struct {
+	collector prometheus.Collector
+	snapshot  *Snapshot
+}
+
+func (c *boundCollector) Describe(_ chan<- *prometheus.Desc) {}
+
+func (c *boundCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = collectFrom(c.snapshot, c.collector, ch)
+}
+
+// calculateJobAggregates aggregates the job metrics of the job, partition,
+// account and user collectors in a single pass, deriving what they need from
+// each job only once.
+func calculateJobAggregates(jobList *types.V0043JobInfoList) *JobAggregates {
+	metrics := &JobAggregates{
+		PartitionJobMetricsPer: make(map[string]*PartitionJobMetrics),
+		AccountJobMetricsPer:   make(map[string]*JobMetrics),
+		UserJobMetricsPer:      make(map[UserContext]*JobMetrics),
+	}
+	for _, job := range jobList.Items {
+		sample := newJobSample(job)
+		sample.addTo(&metrics.JobMetrics)
+
+		for _, key := range utils.ParseCSV(ptr.Deref(job.Partition, "")) {
+			if _, ok := metrics.PartitionJobMetricsPer[key]; !ok {
+				metrics.PartitionJobMetricsPer[key] = &PartitionJobMetrics{}
+			}
+			sample.addTo(&metrics.PartitionJobMetricsPer[key].JobMetrics)
+			metrics.PartitionJobMetricsPer[key].PendingNodeCount = max(metrics.PartitionJobMetricsPer[key].PendingNodeCount, sample.pendingNodeCount)
+			metrics.PartitionJobMetricsPer[key].addPending(sample.pending)
+		}
+
+		accountKey := ptr.Deref(job.Account, "")
+		if _, ok := metrics.AccountJobMetricsPer[accountKey]; !ok {
+			metrics.AccountJobMetricsPer[accountKey] = &JobMetrics{}
+		}
+		sample.addTo(metrics.AccountJobMetricsPer[accountKey])
+
+		userKey := newUserContext(job)
+		if _, ok := metrics.UserJobMetricsPer[userKey]; !ok {
+			metrics.UserJobMetricsPer[userKey] = &JobMetrics{}
+		}
+		sample.addTo(metrics.UserJobMetricsPer[userKey])
+	}
+	return metrics
+}
+
+// calculateNodeAggregates aggregates the node metrics of the node and
+// partition collectors in a single pass.
+func calculateNodeAggregates(nodeList *types.V0043NodeList) *NodeAggregates {
+	metrics := &NodeAggregates{
+		NodeCollectorMetrics: NodeCollectorMetrics{
+			NodeMetrics: NodeMetrics{
+				NodeCount: uint(len(nodeList.Items)),
+			},
+			NodeTresPer: make(map[string]*NodeTres, len(nodeList.Items)),
+		},
+		PartitionNodeMetricsPer: make(map[string]*NodeMetrics),
+	}
+	for _, node := range nodeList.Items {
+		key := string(node.GetKey())
+		states := node.GetStateAsSet()
+		addNodeStates(&metrics.NodeStates, states)
+		calculateNodeTres(&metrics.NodeTres, node)
+		if _, ok := metrics.NodeTresPer[key]; !ok {
+			metrics.NodeTresPer[key] = &NodeTres{}
+		}
+		calculateNodeTres(metrics.NodeTresPer[key], node)
+
+		for _, partition := range ptr.Deref(node.Partitions, []string{}) {
+			if _, ok := metrics.PartitionNodeMetricsPer[partition]; !ok {
+				metrics.PartitionNodeMetricsPer[partition] = &NodeMetrics{}
+			}
+			metrics.PartitionNodeMetricsPer[partition].NodeCount++
+			addNodeStates(&metrics.PartitionNodeMetricsPer[partition].NodeStates, states)
+			calculateNodeTres(&metrics.PartitionNodeMetricsPer[partition].NodeTres, node)
+		}
+	}
+	return metrics
+}
+
+type JobAggregates struct {
+	JobMetrics
+	// Per Partition
+	PartitionJobMetricsPer map[string]*PartitionJobMetrics
+	// Per Account
+	AccountJobMetricsPer map[string]*JobMetrics
+	// Per User
+	UserJobMetricsPer map[UserContext]*JobMetrics
+}
+
+type NodeAggregates struct {
+	NodeCollectorMetrics
+	// Per Partition
+	PartitionNodeMetricsPer map[string]*NodeMetrics
+}
@@ -0,0 +1,358 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/client/interceptor"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/utils/ptr"
+)
+
+// newListCountingClient returns a client which counts the List calls per
+// object list type.
+func newListCountingClient(slurmClient client.Client) (client.Client, func() map[string]int) {
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	countingClient := fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, list object.ObjectList, opts ...client.ListOption) error {
+				mu.Lock()
+				counts[fmt.Sprintf("%T", list)]++
+				mu.Unlock()
+				return slurmClient.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	// Returns the counts since the previous call.
+	return countingClient, func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		got := counts
+		counts = make(map[string]int)
+		return got
+	}
+}
+
+// newSnapshotCollectors returns every collector which reads a Snapshot, each
+// getting its Snapshotter from snapshots.
+func newSnapshotCollectors(snapshots func() *Snapshotter) []prometheus.Collector {
+	return []prometheus.Collector{
+		NewSchedulerCollector(snapshots(), false),
+		NewNodeCollector(snapshots()),
+		NewNodeTransitionCollector(snapshots()),
+		NewNodePowerCollector(snapshots()),
+		NewJobCollector(snapshots()),
+		NewJobLifecycleCollector(snapshots()),
+		NewPartitionCollector(snapshots()),
+		NewAccountCollector(snapshots()),
+		NewUserCollector(snapshots()),
+	}
+}
+
+func sharedSnapshotter(snapshots *Snapshotter) func() *Snapshotter {
+	return func() *Snapshotter { return snapshots }
+}
+
+func TestSnapshotter_Gatherer(t *testing.T) {
+	slurmClient, counts := newListCountingClient(testDataClient)
+	snapshots := NewSnapshotter(slurmClient)
+	gatherer := snapshots.Gatherer(context.Background(), newSnapshotCollectors(sharedSnapshotter(snapshots))...)
+	want := map[string]int{
+		"*types.V0043JobInfoList":       1,
+		"*types.V0043NodeList":          1,
+		"*types.V0043PartitionInfoList": 1,
+		"*types.V0043StatsList":         1,
+	}
+
+	for range 2 {
+		_, err := gatherer.Gather()
+		assert.NoError(t, err)
+		assert.Equal(t, want, counts())
+	}
+}
+
+// snapshotRecorder records the Snapshot which it collects from.
+type snapshotRecorder struct {
+	desc      *prometheus.Desc
+	snapshots chan *Snapshot
+}
+
+func newSnapshotRecorder(name string) *snapshotRecorder {
+	return &snapshotRecorder{
+		desc:      prometheus.NewDesc(name, "Test metric", nil, nil),
+		snapshots: make(chan *Snapshot, 1),
+	}
+}
+
+func (c *snapshotRecorder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *snapshotRecorder) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(NewSnapshotter(testDataClient).Snapshot(), ch)
+}
+
+func (c *snapshotRecorder) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	c.snapshots <- snapshot
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestSnapshotter_Gatherer_shared(t *testing.T) {
+	snapshots := NewSnapshotter(testDataClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first, second := newSnapshotRecorder("test_first"), newSnapshotRecorder("test_second")
+	gatherer := snapshots.Gatherer(ctx, NewTimeoutCollector("first", first, snapshots), second)
+
+	_, err := gatherer.Gather()
+	assert.NoError(t, err)
+	snapshot := <-first.snapshots
+	assert.Same(t, snapshot, <-second.snapshots)
+	assert.Same(t, ctx, snapshot.Context())
+
+	_, err = gatherer.Gather()
+	assert.NoError(t, err)
+	assert.NotSame(t, snapshot, <-first.snapshots)
+	<-second.snapshots
+}
+
+func TestSnapshotter_Gatherer_overlapping(t *testing.T) {
+	snapshots := NewSnapshotter(testDataClient)
+	blocking := newBlockingCollector()
+	collectors := []prometheus.Collector{NewTimeoutCollector("test", blocking, snapshots)}
+
+	// The first Gather is ongoing while the second one times out.
+	first := make(chan string)
+	go func() {
+		families, err := snapshots.Gatherer(context.Background(), collectors...).Gather()
+		assert.NoError(t, err)
+		first <- timedOut(families)
+	}()
+	<-blocking.started
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	families, err := snapshots.Gatherer(ctx, collectors...).Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", timedOut(families))
+
+	close(blocking.release)
+	assert.Equal(t, "0", <-first)
+}
+
+// timedOut returns the value of the timeout metric of the metric families.
+func timedOut(families []*dto.MetricFamily) string {
+	for _, family := range families {
+		if family.GetName() == "slurm_exporter_collector_timeout" {
+			return fmt.Sprint(family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+	return ""
+}
+
+func TestSnapshotter_Snapshot(t *testing.T) {
+	snapshots := NewSnapshotter(testDataClient)
+	assert.NotSame(t, snapshots.Snapshot(), snapshots.Snapshot())
+	assert.Equal(t, context.Background(), snapshots.Snapshot().Context())
+}
+
+func TestSnapshot_failure(t *testing.T) {
+	snapshot := NewSnapshotter(testFailClient).Snapshot()
+	_, err := snapshot.JobAggregates()
+	assert.Error(t, err)
+	_, err = snapshot.NodeAggregates()
+	assert.Error(t, err)
+	_, err = snapshot.Partitions()
+	assert.Error(t, err)
+	_, err = snapshot.Stats()
+	assert.Error(t, err)
+}
+
+func TestSnapshot_Context(t *testing.T) {
+	slurmClient := fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, list object.ObjectList, opts ...client.ListOption) error {
+				return ctx.Err()
+			},
+		}).
+		Build()
+	snapshots := NewSnapshotter(slurmClient)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	snapshot := snapshots.SnapshotWithContext(ctx)
+	assert.Same(t, ctx, snapshot.Context())
+	_, err := snapshot.Jobs()
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_calculateJobAggregates(t *testing.T) {
+	jobs := &types.V0043JobInfoList{Items: []types.V0043JobInfo{
+		{V0043JobInfo: api.V0043JobInfo{
+			Account:   ptr.To("physics"),
+			JobState:  ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			NodeCount: &api.V0043Uint32NoValStruct{Number: ptr.To[int32](4), Set: ptr.To(true)},
+			Partition: ptr.To("blue,green"),
+			UserId:    ptr.To[int32](1000),
+			UserName:  ptr.To("alice"),
+		}},
+		{V0043JobInfo: api.V0043JobInfo{
+			Account:   ptr.To("physics"),
+			JobState:  ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStateRUNNING}),
+			Partition: ptr.To("blue"),
+			UserId:    ptr.To[int32](1001),
+			UserName:  ptr.To("bob"),
+		}},
+	}}
+	pending := JobStates{Pending: 1, States: StateCounts{{State: "pending"}: 1}}
+	running := JobStates{Running: 1, States: StateCounts{{State: "running"}: 1}}
+	both := JobStates{Pending: 1, Running: 1, States: StateCounts{{State: "pending"}: 1, {State: "running"}: 1}}
+
+	want := &JobAggregates{
+		JobMetrics: JobMetrics{JobCount: 2, JobStates: both},
+		PartitionJobMetricsPer: map[string]*PartitionJobMetrics{
+			"blue":  {JobMetrics: JobMetrics{JobCount: 2, JobStates: both}, PendingNodeCount: 4, PendingJobCount: 1},
+			"green": {JobMetrics: JobMetrics{JobCount: 1, JobStates: pending}, PendingNodeCount: 4, PendingJobCount: 1},
+		},
+		AccountJobMetricsPer: map[string]*JobMetrics{
+			"physics": {JobCount: 2, JobStates: both},
+		},
+		UserJobMetricsPer: map[UserContext]*JobMetrics{
+			{UserId: "1000", UserName: "alice"}: {JobCount: 1, JobStates: pending},
+			{UserId: "1001", UserName: "bob"}:   {JobCount: 1, JobStates: running},
+		},
+	}
+	got := calculateJobAggregates(jobs)
+	opts := []cmp.Option{
+		cmpopts.IgnoreUnexported(JobStates{}, JobTres{}),
+	}
+	if diff := cmp.Diff(want, got, opts...); diff != "" {
+		t.Errorf("calculateJobAggregates() = (-want,+got):\n%s", diff)
+	}
+}
+
+func Test_calculateNodeAggregates(t *testing.T) {
+	newNode := func(name string, partitions ...string) types.V0043Node {
+		node := newStateNode(name, api.V0043NodeStateIDLE)
+		node.Cpus = ptr.To[int32](8)
+		node.Partitions = ptr.To(api.V0043CsvString(partitions))
+		return node
+	}
+	nodes := &types.V0043NodeList{Items: []types.V0043Node{
+		newNode("node0", "blue", "green"),
+		newNode("node1", "blue"),
+	}}
+	idle := func(count uint) NodeStates {
+		return NodeStates{Idle: count, States: StateCounts{{State: "idle"}: count}}
+	}
+
+	want := &NodeAggregates{
+		NodeCollectorMetrics: NodeCollectorMetrics{
+			NodeMetrics: NodeMetrics{NodeCount: 2, NodeStates: idle(2), NodeTres: NodeTres{CpusTotal: 16}},
+			NodeTresPer: map[string]*NodeTres{
+				"node0": {CpusTotal: 8},
+				"node1": {CpusTotal: 8},
+			},
+		},
+		PartitionNodeMetricsPer: map[string]*NodeMetrics{
+			"blue":  {NodeCount: 2, NodeStates: idle(2), NodeTres: NodeTres{CpusTotal: 16}},
+			"green": {NodeCount: 1, NodeStates: idle(1), NodeTres: NodeTres{CpusTotal: 8}},
+		},
+	}
+	got := calculateNodeAggregates(nodes)
+	opts := []cmp.Option{
+		cmpopts.IgnoreUnexported(NodeStates{}, NodeTres{}),
+	}
+	if diff := cmp.Diff(want, got, opts...); diff != "" {
+		t.Errorf("calculateNodeAggregates() = (-want,+got):\n%s", diff)
+	}
+}
+
+// newBenchmarkClient returns a client with a cluster of the given size, whose
+// jobs are spread over a few partitions, accounts and users.
+func newBenchmarkClient(jobCount, nodeCount int) client.Client {
+	partitions := []string{"blue", "green", "red", "yellow"}
+	partitionList := &types.V0043PartitionInfoList{}
+	for _, name := range partitions {
+		partitionList.Items = append(partitionList.Items, types.V0043PartitionInfo{V0043PartitionInfo: api.V0043PartitionInfo{
+			Name: ptr.To(name),
+		}})
+	}
+	nodeList := &types.V0043NodeList{}
+	for i := range nodeCount {
+		nodeList.Items = append(nodeList.Items, types.V0043Node{V0043Node: api.V0043Node{
+			Name:       ptr.To(fmt.Sprintf("node%d", i)),
+			State:      ptr.To([]api.V0043NodeState{api.V0043NodeStateMIXED}),
+			Partitions: ptr.To(api.V0043CsvString{partitions[i%len(partitions)]}),
+			Cpus:       ptr.To[int32](64),
+			AllocCpus:  ptr.To[int32](32),
+			RealMemory: ptr.To[int64](256 * 1024),
+		}})
+	}
+	jobList := &types.V0043JobInfoList{}
+	for i := range jobCount {
+		state := api.V0043JobInfoJobStatePENDING
+		if i%2 == 0 {
+			state = api.V0043JobInfoJobStateRUNNING
+		}
+		jobList.Items = append(jobList.Items, types.V0043JobInfo{V0043JobInfo: api.V0043JobInfo{
+			JobId:        ptr.To(int32(i)),
+			JobState:     ptr.To([]api.V0043JobInfoJobState{state}),
+			Partition:    ptr.To(partitions[i%len(partitions)]),
+			Account:      ptr.To(fmt.Sprintf("account%d", i%32)),
+			UserId:       ptr.To(int32(i % 256)),
+			UserName:     ptr.To(fmt.Sprintf("user%d", i%256)),
+			TresAllocStr: ptr.To("cpu=4,mem=16G,node=1,billing=4"),
+		}})
+	}
+	return fake.NewClientBuilder().
+		WithLists(partitionList, nodeList, jobList).
+		Build()
+}
+
+// BenchmarkScrape compares collectors which each take their own snapshot,
+// and hence list and aggregate the objects themselves, against collectors
+// sharing one snapshot per scrape.
+func BenchmarkScrape(b *testing.B) {
+	slurmClient := newBenchmarkClient(10000, 1000)
+
+	b.Run("per collector", func(b *testing.B) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(newSnapshotCollectors(func() *Snapshotter {
+			return NewSnapshotter(slurmClient)
+		})...)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			if _, err := registry.Gather(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("shared", func(b *testing.B) {
+		snapshots := NewSnapshotter(slurmClient)
+		gatherer := snapshots.Gatherer(context.Background(), newSnapshotCollectors(sharedSnapshotter(snapshots))...)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for range b.N {
+			if _, err := gatherer.Gather(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
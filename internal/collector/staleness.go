@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+)
+
+// The object types of a Snapshot.
+const (
+	objectTypeJobs       = "jobs"
+	objectTypeNodes      = "nodes"
+	objectTypePartitions = "partitions"
+	objectTypeStats      = "stats"
+)
+
+var objectTypes = []string{
+	objectTypeJobs,
+	objectTypeNodes,
+	objectTypePartitions,
+	objectTypeStats,
+}
+
+// lastKnownGood is the last list of an object type which was listed
+// successfully.
+type lastKnownGood struct {
+	mu   sync.Mutex
+	list object.ObjectList
+	// When the list was listed.
+	listedAt time.Time
+	// Whether the last List call failed.
+	failing bool
+}
+
+// listOrLastKnownGood lists the objects. If that fails, the last objects which
+// were listed successfully are returned instead, until they are older than the
+// grace period of the Snapshotter.
+func listOrLastKnownGood[T object.ObjectList](ctx context.Context, snapshots *Snapshotter, objectType string, list T) (T, error) {
+	err := snapshots.slurmClient.List(ctx, list)
+	now := snapshots.clock.Now()
+
+	last := snapshots.lastGood[objectType]
+	last.mu.Lock()
+	defer last.mu.Unlock()
+	last.failing = err != nil
+	if err == nil {
+		last.list = list
+		last.listedAt = now
+		return list, nil
+	}
+	if snapshots.gracePeriod <= 0 || last.list == nil || now.Sub(last.listedAt) > snapshots.gracePeriod {
+		return list, err
+	}
+	log.FromContext(ctx).V(1).Info("serving last known good objects", "type", objectType, "age", now.Sub(last.listedAt), "err", err)
+	return last.list.(T), nil
+}
+
+// NewStalenessCollector exports how old the objects of each type are, and
+// whether they could not be listed on the last attempt.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewStalenessCollector(snapshots *Snapshotter) prometheus.Collector {
+	return &stalenessCollector{
+		snapshots: snapshots,
+
+		DataAge: prometheus.NewDesc("slurm_exporter_data_age_seconds", "Age of the objects of the type, since they were last listed successfully", []string{"type"}, nil),
+		Stale:   prometheus.NewDesc("slurm_exporter_data_stale", "Whether the objects of the type could not be listed on the last attempt", []string{"type"}, nil),
+	}
+}
+
+type stalenessCollector struct {
+	snapshots *Snapshotter
+
+	DataAge *prometheus.Desc
+	Stale   *prometheus.Desc
+}
+
+func (c *stalenessCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *stalenessCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *stalenessCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	// List the objects of this scrape first, such that they are accounted for.
+	_, _ = snapshot.Jobs()
+	_, _ = snapshot.Nodes()
+	_, _ = snapshot.Partitions()
+	_, _ = snapshot.Stats()
+
+	now := c.snapshots.clock.Now()
+	for objectType, last := range c.snapshots.lastGood {
+		last.mu.Lock()
+		listedAt, failing := last.listedAt, last.failing
+		last.mu.Unlock()
+
+		if !listedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.DataAge, prometheus.GaugeValue, now.Sub(listedAt).Seconds(), objectType)
+		}
+		stale := float64(0)
+		if failing {
+			stale = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.Stale, prometheus.GaugeValue, stale, objectType)
+	}
+	return nil
+}
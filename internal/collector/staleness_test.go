@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/client/interceptor"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// newOutageClient returns a client whose List calls fail while the returned
+// flag is set.
+func newOutageClient() (client.Client, *atomic.Bool) {
+	var outage atomic.Bool
+	outageClient := fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, list object.ObjectList, opts ...client.ListOption) error {
+				if outage.Load() {
+					return errors.New("slurmrestd is unavailable")
+				}
+				return testDataClient.List(ctx, list, opts...)
+			},
+		}).
+		Build()
+	return outageClient, &outage
+}
+
+func TestSnapshot_lastKnownGood(t *testing.T) {
+	slurmClient, outage := newOutageClient()
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	snapshots := newSnapshotter(slurmClient, time.Minute, clk)
+
+	want, err := snapshots.Snapshot().Nodes()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, want.Items)
+
+	// The last known good nodes are served within the grace period.
+	outage.Store(true)
+	clk.SetTime(clk.Now().Add(time.Minute))
+	got, err := snapshots.Snapshot().Nodes()
+	assert.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// And not after it.
+	clk.SetTime(clk.Now().Add(time.Second))
+	_, err = snapshots.Snapshot().Nodes()
+	assert.Error(t, err)
+
+	// Nothing is served before the first successful List.
+	_, err = snapshots.Snapshot().Jobs()
+	assert.Error(t, err)
+
+	outage.Store(false)
+	got, err = snapshots.Snapshot().Nodes()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, want.Items, got.Items)
+}
+
+func TestSnapshot_noGracePeriod(t *testing.T) {
+	slurmClient, outage := newOutageClient()
+	snapshots := newSnapshotter(slurmClient, 0, clocktesting.NewFakePassiveClock(time.Now()))
+
+	_, err := snapshots.Snapshot().Partitions()
+	assert.NoError(t, err)
+	outage.Store(true)
+	_, err = snapshots.Snapshot().Partitions()
+	assert.Error(t, err)
+}
+
+func TestStalenessCollector_Collect(t *testing.T) {
+	slurmClient, outage := newOutageClient()
+	clk := clocktesting.NewFakePassiveClock(time.Now())
+	snapshots := newSnapshotter(slurmClient, time.Minute, clk)
+	c := NewStalenessCollector(snapshots)
+
+	want := `
+# HELP slurm_exporter_data_age_seconds Age of the objects of the type, since they were last listed successfully
+# TYPE slurm_exporter_data_age_seconds gauge
+slurm_exporter_data_age_seconds{type="jobs"} 0
+slurm_exporter_data_age_seconds{type="nodes"} 0
+slurm_exporter_data_age_seconds{type="partitions"} 0
+slurm_exporter_data_age_seconds{type="stats"} 0
+# HELP slurm_exporter_data_stale Whether the objects of the type could not be listed on the last attempt
+# TYPE slurm_exporter_data_stale gauge
+slurm_exporter_data_stale{type="jobs"} 0
+slurm_exporter_data_stale{type="nodes"} 0
+slurm_exporter_data_stale{type="partitions"} 0
+slurm_exporter_data_stale{type="stats"} 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+
+	outage.Store(true)
+	clk.SetTime(clk.Now().Add(30 * time.Second))
+	want = `
+# HELP slurm_exporter_data_age_seconds Age of the objects of the type, since they were last listed successfully
+# TYPE slurm_exporter_data_age_seconds gauge
+slurm_exporter_data_age_seconds{type="jobs"} 30
+slurm_exporter_data_age_seconds{type="nodes"} 30
+slurm_exporter_data_age_seconds{type="partitions"} 30
+slurm_exporter_data_age_seconds{type="stats"} 30
+# HELP slurm_exporter_data_stale Whether the objects of the type could not be listed on the last attempt
+# TYPE slurm_exporter_data_stale gauge
+slurm_exporter_data_stale{type="jobs"} 1
+slurm_exporter_data_stale{type="nodes"} 1
+slurm_exporter_data_stale{type="partitions"} 1
+slurm_exporter_data_stale{type="stats"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestStalenessCollector_neverListed(t *testing.T) {
+	slurmClient, outage := newOutageClient()
+	outage.Store(true)
+	c := NewStalenessCollector(newSnapshotter(slurmClient, time.Minute, clocktesting.NewFakePassiveClock(time.Now())))
+
+	want := `
+# HELP slurm_exporter_data_stale Whether the objects of the type could not be listed on the last attempt
+# TYPE slurm_exporter_data_stale gauge
+slurm_exporter_data_stale{type="jobs"} 1
+slurm_exporter_data_stale{type="nodes"} 1
+slurm_exporter_data_stale{type="partitions"} 1
+slurm_exporter_data_stale{type="stats"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestStalenessCollector_Describe(t *testing.T) {
+	c := NewStalenessCollector(NewSnapshotter(testDataClient))
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	for desc := range ch {
+		assert.NotNil(t, desc)
+	}
+}
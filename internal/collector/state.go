@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"slices"
+	"strings"
+
+	"k8s.io/utils/set"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+)
+
+var (
+	// Ref: https://slurm.schedmd.com/job_state_codes.html#states
+	jobBaseStates = []api.V0043JobInfoJobState{
+		api.V0043JobInfoJobStateBOOTFAIL,
+		api.V0043JobInfoJobStateCANCELLED,
+		api.V0043JobInfoJobStateCOMPLETED,
+		api.V0043JobInfoJobStateDEADLINE,
+		api.V0043JobInfoJobStateFAILED,
+		api.V0043JobInfoJobStatePENDING,
+		api.V0043JobInfoJobStatePREEMPTED,
+		api.V0043JobInfoJobStateRUNNING,
+		api.V0043JobInfoJobStateSUSPENDED,
+		api.V0043JobInfoJobStateTIMEOUT,
+		api.V0043JobInfoJobStateNODEFAIL,
+		api.V0043JobInfoJobStateOUTOFMEMORY,
+	}
+	// Ref: https://slurm.schedmd.com/sinfo.html#SECTION_NODE-STATE-CODES
+	nodeBaseStates = []api.V0043NodeState{
+		api.V0043NodeStateALLOCATED,
+		api.V0043NodeStateDOWN,
+		api.V0043NodeStateERROR,
+		api.V0043NodeStateFUTURE,
+		api.V0043NodeStateIDLE,
+		api.V0043NodeStateMIXED,
+		api.V0043NodeStateUNKNOWN,
+	}
+)
+
+// StateKey identifies a series of the label-based state metrics. An empty Flag
+// counts every object in the base State, otherwise only those with the Flag.
+type StateKey struct {
+	State string
+	Flag  string
+}
+
+type StateCounts map[StateKey]uint
+
+// countStates counts the object under its base state, and under its base state
+// with each flag. Any state which is not a known base state is a flag, such
+// that new Slurm states are exported without code changes. Should there be
+// more than one base state, the first one in baseStates wins.
+func countStates[S ~string](counts StateCounts, states set.Set[S], baseStates []S) {
+	base := "unknown"
+	for _, state := range baseStates {
+		if states.Has(state) {
+			base = stateLabel(state)
+			break
+		}
+	}
+	counts[StateKey{State: base}]++
+	for state := range states {
+		if slices.Contains(baseStates, state) {
+			continue
+		}
+		counts[StateKey{State: base, Flag: stateLabel(state)}]++
+	}
+}
+
+func stateLabel[S ~string](state S) string {
+	return strings.ToLower(string(state))
+}
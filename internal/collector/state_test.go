@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/utils/set"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+)
+
+func Test_countStates(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []set.Set[api.V0043NodeState]
+		want   StateCounts
+	}{
+		{
+			name:   "no state",
+			states: []set.Set[api.V0043NodeState]{set.New[api.V0043NodeState]()},
+			want:   StateCounts{{State: "unknown"}: 1},
+		},
+		{
+			name: "base states",
+			states: []set.Set[api.V0043NodeState]{
+				set.New(api.V0043NodeStateIDLE),
+				set.New(api.V0043NodeStateIDLE),
+				set.New(api.V0043NodeStateMIXED),
+			},
+			want: StateCounts{
+				{State: "idle"}:  2,
+				{State: "mixed"}: 1,
+			},
+		},
+		{
+			name: "flags",
+			states: []set.Set[api.V0043NodeState]{
+				set.New(api.V0043NodeStateIDLE, api.V0043NodeStateDRAIN),
+				set.New(api.V0043NodeStateIDLE, api.V0043NodeStateDRAIN, api.V0043NodeStateNOTRESPONDING),
+			},
+			want: StateCounts{
+				{State: "idle"}:                         2,
+				{State: "idle", Flag: "drain"}:          2,
+				{State: "idle", Flag: "not_responding"}: 1,
+			},
+		},
+		{
+			name: "flags unknown to the collectors",
+			states: []set.Set[api.V0043NodeState]{
+				set.New(api.V0043NodeStateIDLE, api.V0043NodeStatePOWEREDDOWN),
+				set.New(api.V0043NodeStateDOWN, api.V0043NodeStateINVALIDREG),
+			},
+			want: StateCounts{
+				{State: "idle"}:                       1,
+				{State: "idle", Flag: "powered_down"}: 1,
+				{State: "down"}:                       1,
+				{State: "down", Flag: "invalid_reg"}:  1,
+			},
+		},
+		{
+			name: "flag without base state",
+			states: []set.Set[api.V0043NodeState]{
+				set.New(api.V0043NodeStateCLOUD),
+			},
+			want: StateCounts{
+				{State: "unknown"}:                1,
+				{State: "unknown", Flag: "cloud"}: 1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(StateCounts)
+			for _, states := range tt.states {
+				countStates(got, states, nodeBaseStates)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("countStates() = (-want,+got):\n%s", diff)
+			}
+		})
+	}
+}
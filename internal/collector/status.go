@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/utils/clock"
+)
+
+// CollectorStatus is the outcome of the last collections of a collector.
+type CollectorStatus struct {
+	LastSuccess   time.Time `json:"lastSuccess,omitzero"`
+	LastError     string    `json:"lastError,omitempty"`
+	LastErrorTime time.Time `json:"lastErrorTime,omitzero"`
+}
+
+// Status keeps the outcome of the last collections of each tracked collector.
+type Status struct {
+	clock clock.PassiveClock
+
+	mu         sync.Mutex
+	collectors map[string]*CollectorStatus
+}
+
+func NewStatus() *Status {
+	return newStatus(clock.RealClock{})
+}
+
+func newStatus(clk clock.PassiveClock) *Status {
+	return &Status{
+		clock:      clk,
+		collectors: make(map[string]*CollectorStatus),
+	}
+}
+
+// Track returns the collector, such that the outcome of its collections is
+// kept under the name. A collection fails when the collector, collecting from
+// a Snapshot of the snapshots, returns an error.
+func (s *Status) Track(name string, collector prometheus.Collector, snapshots *Snapshotter) prometheus.Collector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collectors[name] = &CollectorStatus{}
+	return &statusCollector{
+		name:      name,
+		collector: collector,
+		snapshots: snapshots,
+		status:    s,
+	}
+}
+
+// Collectors returns the status of every tracked collector, by name.
+func (s *Status) Collectors() map[string]CollectorStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	collectors := make(map[string]CollectorStatus, len(s.collectors))
+	for name, status := range s.collectors {
+		collectors[name] = *status
+	}
+	return collectors
+}
+
+// ServeHTTP serves the status of every tracked collector as JSON.
+func (s *Status) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(struct {
+		Collectors map[string]CollectorStatus `json:"collectors"`
+	}{
+		Collectors: s.Collectors(),
+	})
+}
+
+func (s *Status) record(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.collectors[name]
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorTime = s.clock.Now()
+		return
+	}
+	status.LastSuccess = s.clock.Now()
+}
+
+type statusCollector struct {
+	name      string
+	collector prometheus.Collector
+	snapshots *Snapshotter
+	status    *Status
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *statusCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	err := collectFrom(snapshot, c.collector, ch)
+	c.status.record(c.name, err)
+	return err
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func TestStatus_Track(t *testing.T) {
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	status := newStatus(clk)
+	snapshots, failSnapshots := NewSnapshotter(testDataClient), NewSnapshotter(testFailClient)
+	node := status.Track("node", NewNodeCollector(snapshots), snapshots)
+	failing := status.Track("failing", NewNodeCollector(failSnapshots), failSnapshots)
+	status.Track("idle", NewNodeCollector(snapshots), snapshots)
+
+	assert.NotZero(t, testutil.CollectAndCount(node))
+	assert.Zero(t, testutil.CollectAndCount(failing))
+
+	want := map[string]CollectorStatus{
+		"node": {
+			LastSuccess: time.Unix(1000, 0),
+		},
+		"failing": {
+			LastError:     "Internal Server Error",
+			LastErrorTime: time.Unix(1000, 0),
+		},
+		"idle": {},
+	}
+	assert.Equal(t, want, status.Collectors())
+
+	// The last error is kept after a success.
+	clk.SetTime(time.Unix(2000, 0))
+	assert.NotZero(t, testutil.CollectAndCount(node))
+	assert.Equal(t, time.Unix(2000, 0), status.Collectors()["node"].LastSuccess)
+}
+
+func TestStatus_ServeHTTP(t *testing.T) {
+	status := newStatus(clocktesting.NewFakePassiveClock(time.Unix(1000, 0).UTC()))
+	snapshots := NewSnapshotter(testFailClient)
+	failing := status.Track("node", NewNodeCollector(snapshots), snapshots)
+	testutil.CollectAndCount(failing)
+
+	w := httptest.NewRecorder()
+	status.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"collectors":{"node":{"lastError":"Internal Server Error","lastErrorTime":"1970-01-01T00:16:40Z"}}}`, w.Body.String())
+}
+
+func TestStatus_Track_gatherer(t *testing.T) {
+	status := newStatus(clocktesting.NewFakePassiveClock(time.Unix(1000, 0)))
+	snapshots := NewSnapshotter(testFailClient)
+	failing := status.Track("node", NewNodeCollector(snapshots), snapshots)
+	collector := NewTimeoutCollector("node", NewSchemaCollector(failing, MetricsSchemaV2), snapshots)
+
+	// The error is returned through the wrappers of the collector.
+	_, err := snapshots.Gatherer(context.Background(), collector).Gather()
+	assert.NoError(t, err)
+	assert.Equal(t, "Internal Server Error", status.Collectors()["node"].LastError)
+}
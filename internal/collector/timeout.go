@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// scrapeTimeoutHeader is set by Prometheus to the scrape timeout.
+	scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+	// scrapeTimeoutOffset leaves time to respond before the scrape timeout.
+	scrapeTimeoutOffset = 500 * time.Millisecond
+)
+
+// Handler serves the metrics of the gatherer like promhttp.HandlerFor, except
+// that the gatherer is bound by the context of the request and by the scrape
+// timeout of Prometheus, e.g. by Snapshotter.Gatherer. Like promhttp, the
+// requests beyond opts.MaxRequestsInFlight are answered with 503, before their
+// gatherer is created.
+func Handler(gatherer func(ctx context.Context) prometheus.Gatherer, opts promhttp.HandlerOpts) http.Handler {
+	var inFlight chan struct{}
+	if opts.MaxRequestsInFlight > 0 {
+		inFlight = make(chan struct{}, opts.MaxRequestsInFlight)
+	}
+	// The requests in flight are limited across the handlers of every request.
+	opts.MaxRequestsInFlight = 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				defer func() { <-inFlight }()
+			default:
+				http.Error(w, fmt.Sprintf("Limit of concurrent requests reached (%d), try again later.", cap(inFlight)), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		promhttp.HandlerFor(gatherer(ctx), opts).ServeHTTP(w, r)
+	})
+}
+
+// scrapeContext returns the context of the request, with the deadline of the
+// scrape timeout if Prometheus sent one.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := r.Context()
+	seconds, err := strconv.ParseFloat(r.Header.Get(scrapeTimeoutHeader), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// NewTimeoutCollector stops the collection of the collector when the context
+// of the scrape is done, keeping the metrics collected until then, and exports
+// whether it timed out.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func NewTimeoutCollector(name string, collector prometheus.Collector, snapshots *Snapshotter) prometheus.Collector {
+	return &timeoutCollector{
+		collector: collector,
+		snapshots: snapshots,
+
+		TimedOut: prometheus.NewDesc("slurm_exporter_collector_timeout", "Whether the collector did not finish before the scrape timeout", nil, prometheus.Labels{"collector": name}),
+	}
+}
+
+type timeoutCollector struct {
+	collector prometheus.Collector
+	snapshots *Snapshotter
+
+	TimedOut *prometheus.Desc
+}
+
+func (c *timeoutCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.collector.Describe(ch)
+	ch <- c.TimedOut
+}
+
+func (c *timeoutCollector) Collect(ch chan<- prometheus.Metric) {
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *timeoutCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
+
+	metrics := make(chan prometheus.Metric)
+	var err error
+	go func() {
+		err = collectFrom(snapshot, c.collector, metrics)
+		close(metrics)
+	}()
+
+	var timedOut float64
+collect:
+	for {
+		select {
+		case metric, ok := <-metrics:
+			if !ok {
+				break collect
+			}
+			ch <- metric
+		case <-ctx.Done():
+			timedOut = 1
+			// Let the collector finish, which it does soon, as its List calls
+			// are bound by the same context.
+			go func() {
+				for range metrics {
+				}
+			}()
+			break collect
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.TimedOut, prometheus.GaugeValue, timedOut)
+	if timedOut == 1 {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_scrapeContext(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       string
+		wantDeadline bool
+		wantTimeout  time.Duration
+	}{
+		{
+			name: "no header",
+		},
+		{
+			name:   "bad header",
+			header: "foo",
+		},
+		{
+			name:         "timeout",
+			header:       "10",
+			wantDeadline: true,
+			wantTimeout:  10*time.Second - scrapeTimeoutOffset,
+		},
+		{
+			name:         "short timeout",
+			header:       "0.25",
+			wantDeadline: true,
+			wantTimeout:  250 * time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set(scrapeTimeoutHeader, tt.header)
+			}
+			start := time.Now()
+			ctx, cancel := scrapeContext(r)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, tt.wantDeadline, ok)
+			if ok {
+				assert.WithinDuration(t, start.Add(tt.wantTimeout), deadline, 100*time.Millisecond)
+			}
+		})
+	}
+}
+
+// blockingCollector collects a metric, then blocks until it is released.
+type blockingCollector struct {
+	desc    *prometheus.Desc
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingCollector() *blockingCollector {
+	return &blockingCollector{
+		desc:    prometheus.NewDesc("test_metric", "Test metric", nil, nil),
+		started: make(chan struct{}, 1),
+		release: make(chan struct{}),
+	}
+}
+
+func (c *blockingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *blockingCollector) Collect(ch chan<- prometheus.Metric) {
+	select {
+	case c.started <- struct{}{}:
+	default:
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1)
+	<-c.release
+}
+
+func TestTimeoutCollector_Collect(t *testing.T) {
+	t.Run("finished", func(t *testing.T) {
+		blocking := newBlockingCollector()
+		close(blocking.release)
+		c := NewTimeoutCollector("test", blocking, NewSnapshotter(fake.NewFakeClient()))
+		want := `
+# HELP slurm_exporter_collector_timeout Whether the collector did not finish before the scrape timeout
+# TYPE slurm_exporter_collector_timeout gauge
+slurm_exporter_collector_timeout{collector="test"} 0
+# HELP test_metric Test metric
+# TYPE test_metric gauge
+test_metric 1
+`
+		assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(want)))
+	})
+	t.Run("timed out", func(t *testing.T) {
+		blocking := newBlockingCollector()
+		defer close(blocking.release)
+		snapshots := NewSnapshotter(fake.NewFakeClient())
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		want := `
+# HELP slurm_exporter_collector_timeout Whether the collector did not finish before the scrape timeout
+# TYPE slurm_exporter_collector_timeout gauge
+slurm_exporter_collector_timeout{collector="test"} 1
+# HELP test_metric Test metric
+# TYPE test_metric gauge
+test_metric 1
+`
+		assert.NoError(t, testutil.GatherAndCompare(snapshots.Gatherer(ctx, NewTimeoutCollector("test", blocking, snapshots)), strings.NewReader(want)))
+	})
+}
+
+func TestTimeoutCollector_Describe(t *testing.T) {
+	c := NewTimeoutCollector("test", newBlockingCollector(), NewSnapshotter(fake.NewFakeClient()))
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	var got int
+	for desc := range ch {
+		assert.NotNil(t, desc)
+		got++
+	}
+	assert.Equal(t, 2, got)
+}
+
+func TestHandler(t *testing.T) {
+	blocking := newBlockingCollector()
+	defer close(blocking.release)
+	snapshots := NewSnapshotter(fake.NewFakeClient())
+	collector := NewTimeoutCollector("test", blocking, snapshots)
+	handler := Handler(func(ctx context.Context) prometheus.Gatherer {
+		return snapshots.Gatherer(ctx, collector)
+	}, promhttp.HandlerOpts{})
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set(scrapeTimeoutHeader, "0.1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `slurm_exporter_collector_timeout{collector="test"} 1`)
+	assert.Contains(t, w.Body.String(), "test_metric 1")
+}
+
+func TestHandler_maxRequestsInFlight(t *testing.T) {
+	const maxRequests = 2
+	blocking := newBlockingCollector()
+	blocking.started = make(chan struct{}, maxRequests)
+	snapshots := NewSnapshotter(fake.NewFakeClient())
+	handler := Handler(func(ctx context.Context) prometheus.Gatherer {
+		return snapshots.Gatherer(ctx, blocking)
+	}, promhttp.HandlerOpts{MaxRequestsInFlight: maxRequests})
+
+	codes := make(chan int, maxRequests+1)
+	serve := func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		codes <- w.Code
+	}
+	for range maxRequests {
+		go serve()
+	}
+	for range maxRequests {
+		<-blocking.started
+	}
+
+	// The request beyond the limit is rejected while the others gather.
+	serve()
+	assert.Equal(t, http.StatusServiceUnavailable, <-codes)
+	close(blocking.release)
+	for range maxRequests {
+		assert.Equal(t, http.StatusOK, <-codes)
+	}
+}
@@ -4,56 +4,58 @@
 package collector
 
 import (
-	"context"
 	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	"github.com/SlinkyProject/slurm-client/pkg/client"
 	"github.com/SlinkyProject/slurm-client/pkg/types"
 )
 
 // Ref: https://prometheus.io/docs/practices/naming/#metric-names
-func NewUserCollector(slurmClient client.Client) prometheus.Collector {
+func NewUserCollector(snapshots *Snapshotter) prometheus.Collector {
 	return &userCollector{
-		slurmClient: slurmClient,
+		snapshots: snapshots,
 
-		JobCount: prometheus.NewDesc("slurm_user_jobs_total", "Total number of user jobs", userLabels, nil),
+		JobCount: newDesc("slurm_user_jobs_total", "Total number of user jobs", userLabels, nil),
 		JobStates: jobStatesCollector{
 			// Base States
-			BootFail:    prometheus.NewDesc("slurm_user_jobs_bootfail_total", "Number of user jobs in BootFail state", userLabels, nil),
-			Cancelled:   prometheus.NewDesc("slurm_user_jobs_cancelled_total", "Number of user jobs in Cancelled state", userLabels, nil),
-			Completed:   prometheus.NewDesc("slurm_user_jobs_completed_total", "Number of user jobs in Completed state", userLabels, nil),
-			Deadline:    prometheus.NewDesc("slurm_user_jobs_deadline_total", "Number of user jobs in Deadline state", userLabels, nil),
-			Failed:      prometheus.NewDesc("slurm_user_jobs_failed_total", "Number of user jobs in Failed state", userLabels, nil),
-			Pending:     prometheus.NewDesc("slurm_user_jobs_pending_total", "Number of user jobs in Pending state", userLabels, nil),
-			Preempted:   prometheus.NewDesc("slurm_user_jobs_preempted_total", "Number of user jobs in Preempted state", userLabels, nil),
-			Running:     prometheus.NewDesc("slurm_user_jobs_running_total", "Number of user jobs in Running state", userLabels, nil),
-			Suspended:   prometheus.NewDesc("slurm_user_jobs_suspended_total", "Number of user jobs in Suspended state", userLabels, nil),
-			Timeout:     prometheus.NewDesc("slurm_user_jobs_timeout_total", "Number of user jobs in Timeout state", userLabels, nil),
-			NodeFail:    prometheus.NewDesc("slurm_user_jobs_nodefail_total", "Number of user jobs in NodeFail state", userLabels, nil),
-			OutOfMemory: prometheus.NewDesc("slurm_user_jobs_outofmemory_total", "Number of user jobs in OutOfMemory state", userLabels, nil),
+			BootFail:    newDesc("slurm_user_jobs_bootfail_total", "Number of user jobs in BootFail state", userLabels, nil),
+			Cancelled:   newDesc("slurm_user_jobs_cancelled_total", "Number of user jobs in Cancelled state", userLabels, nil),
+			Completed:   newDesc("slurm_user_jobs_completed_total", "Number of user jobs in Completed state", userLabels, nil),
+			Deadline:    newDesc("slurm_user_jobs_deadline_total", "Number of user jobs in Deadline state", userLabels, nil),
+			Failed:      newDesc("slurm_user_jobs_failed_total", "Number of user jobs in Failed state", userLabels, nil),
+			Pending:     newDesc("slurm_user_jobs_pending_total", "Number of user jobs in Pending state", userLabels, nil),
+			Preempted:   newDesc("slurm_user_jobs_preempted_total", "Number of user jobs in Preempted state", userLabels, nil),
+			Running:     newDesc("slurm_user_jobs_running_total", "Number of user jobs in Running state", userLabels, nil),
+			Suspended:   newDesc("slurm_user_jobs_suspended_total", "Number of user jobs in Suspended state", userLabels, nil),
+			Timeout:     newDesc("slurm_user_jobs_timeout_total", "Number of user jobs in Timeout state", userLabels, nil),
+			NodeFail:    newDesc("slurm_user_jobs_nodefail_total", "Number of user jobs in NodeFail state", userLabels, nil),
+			OutOfMemory: newDesc("slurm_user_jobs_outofmemory_total", "Number of user jobs in OutOfMemory state", userLabels, nil),
 			// Flag States
-			Completing:  prometheus.NewDesc("slurm_user_jobs_completing_total", "Number of user jobs with Completing flag", userLabels, nil),
-			Configuring: prometheus.NewDesc("slurm_user_jobs_configuring_total", "Number of user jobs with Configuring flag", userLabels, nil),
-			PowerUpNode: prometheus.NewDesc("slurm_user_jobs_powerupnode_total", "Number of user jobs with PowerUpNode flag", userLabels, nil),
-			StageOut:    prometheus.NewDesc("slurm_user_jobs_stageout_total", "Number of user jobs with StageOut flag", userLabels, nil),
+			Completing:  newDesc("slurm_user_jobs_completing_total", "Number of user jobs with Completing flag", userLabels, nil),
+			Configuring: newDesc("slurm_user_jobs_configuring_total", "Number of user jobs with Configuring flag", userLabels, nil),
+			PowerUpNode: newDesc("slurm_user_jobs_powerupnode_total", "Number of user jobs with PowerUpNode flag", userLabels, nil),
+			StageOut:    newDesc("slurm_user_jobs_stageout_total", "Number of user jobs with StageOut flag", userLabels, nil),
 			// Other States
-			Hold: prometheus.NewDesc("slurm_user_jobs_hold_total", "Number of user jobs with Hold flag", userLabels, nil),
+			Hold: newDesc("slurm_user_jobs_hold_total", "Number of user jobs with Hold flag", userLabels, nil),
+			// All States
+			States: newDesc("slurm_user_jobs", "Number of user jobs by base state, and by base state and flag", userStateLabels, nil),
 		},
 		JobTres: jobTresCollector{
 			// CPUs
-			CpusAlloc: prometheus.NewDesc("slurm_user_jobs_cpus_alloc_total", "Number of Allocated CPUs among user jobs", userLabels, nil),
+			CpusAlloc: newDesc("slurm_user_jobs_cpus_alloc_total", "Number of Allocated CPUs among user jobs", userLabels, nil),
 			// Memory
-			MemoryAlloc: prometheus.NewDesc("slurm_user_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among user jobs", userLabels, nil),
+			MemoryAlloc: newDesc("slurm_user_jobs_memory_alloc_bytes", "Amount of Allocated Memory (MB) among user jobs", userLabels, nil),
+			// Energy
+			EnergyConsumed: newDesc("slurm_user_jobs_energy_consumed_joules", "Amount of Energy (J) consumed among the user jobs in the job list, which drops as jobs are purged; see slurm_job_lifecycle_user_energy_consumed_joules_total for the energy over time", userLabels, nil),
 		},
 	}
 }
 
 type userCollector struct {
-	slurmClient client.Client
+	snapshots *Snapshotter
 
 	JobCount  *prometheus.Desc
 	JobStates jobStatesCollector
@@ -65,15 +67,19 @@ func (c *userCollector) Describe(ch chan<- *prometheus.Desc) {
 }
 
 func (c *userCollector) Collect(ch chan<- prometheus.Metric) {
-	ctx := context.TODO()
+	_ = c.collectSnapshot(c.snapshots.Snapshot(), ch)
+}
+
+func (c *userCollector) collectSnapshot(snapshot *Snapshot, ch chan<- prometheus.Metric) error {
+	ctx := snapshot.Context()
 	logger := log.FromContext(ctx).WithName("UserCollector")
 
 	logger.V(1).Info("collecting metrics")
 
-	metrics, err := c.getUserMetrics(ctx)
+	metrics, err := c.getUserMetrics(snapshot)
 	if err != nil {
 		logger.Error(err, "failed to collect user metrics")
-		return
+		return err
 	}
 
 	for userCtx, data := range metrics.JobMetricsPer {
@@ -95,10 +101,15 @@ func (c *userCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.JobStates.Configuring, prometheus.GaugeValue, float64(data.JobStates.Configuring), userCtx.UserId, userCtx.UserName)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.PowerUpNode, prometheus.GaugeValue, float64(data.JobStates.PowerUpNode), userCtx.UserId, userCtx.UserName)
 		ch <- prometheus.MustNewConstMetric(c.JobStates.Hold, prometheus.GaugeValue, float64(data.JobStates.Hold), userCtx.UserId, userCtx.UserName)
+		for key, count := range data.JobStates.States {
+			ch <- prometheus.MustNewConstMetric(c.JobStates.States, prometheus.GaugeValue, float64(count), userCtx.UserId, userCtx.UserName, key.State, key.Flag)
+		}
 		// Tres
 		ch <- prometheus.MustNewConstMetric(c.JobTres.CpusAlloc, prometheus.GaugeValue, float64(data.JobTres.CpusAlloc), userCtx.UserId, userCtx.UserName)
 		ch <- prometheus.MustNewConstMetric(c.JobTres.MemoryAlloc, prometheus.GaugeValue, float64(data.JobTres.MemoryAlloc), userCtx.UserId, userCtx.UserName)
+		ch <- prometheus.MustNewConstMetric(c.JobTres.EnergyConsumed, prometheus.GaugeValue, float64(data.JobTres.EnergyConsumed), userCtx.UserId, userCtx.UserName)
 	}
+	return nil
 }
 
 type UserContext struct {
@@ -106,32 +117,22 @@ type UserContext struct {
 	UserName string
 }
 
-func (c *userCollector) getUserMetrics(ctx context.Context) (*UserMetrics, error) {
-	jobList := &types.V0043JobInfoList{}
-	if err := c.slurmClient.List(ctx, jobList); err != nil {
-		return nil, err
+func newUserContext(job types.V0043JobInfo) UserContext {
+	return UserContext{
+		UserId:   strconv.Itoa(int(ptr.Deref(job.UserId, 0))),
+		UserName: ptr.Deref(job.UserName, ""),
 	}
-	metrics := calculateUserMetrics(jobList)
-	return metrics, nil
 }
 
-func calculateUserMetrics(jobList *types.V0043JobInfoList) *UserMetrics {
-	metrics := &UserMetrics{
-		JobMetricsPer: make(map[UserContext]*JobMetrics),
+func (c *userCollector) getUserMetrics(snapshot *Snapshot) (*UserMetrics, error) {
+	aggregates, err := snapshot.JobAggregates()
+	if err != nil {
+		return nil, err
 	}
-	for _, job := range jobList.Items {
-		key := UserContext{
-			UserId:   strconv.Itoa(int(ptr.Deref(job.UserId, 0))),
-			UserName: ptr.Deref(job.UserName, ""),
-		}
-		if _, ok := metrics.JobMetricsPer[key]; !ok {
-			metrics.JobMetricsPer[key] = &JobMetrics{}
-		}
-		metrics.JobMetricsPer[key].JobCount++
-		calculateJobState(&metrics.JobMetricsPer[key].JobStates, job)
-		calculateJobTres(&metrics.JobMetricsPer[key].JobTres, job)
+	metrics := &UserMetrics{
+		JobMetricsPer: aggregates.UserJobMetricsPer,
 	}
-	return metrics
+	return metrics, nil
 }
 
 type UserMetrics struct {
@@ -53,12 +53,12 @@ func TestUserCollector_getUserMetrics(t *testing.T) {
 				JobMetricsPer: map[UserContext]*JobMetrics{
 					{UserId: "0", UserName: "root"}: {
 						JobCount:  2,
-						JobStates: JobStates{Pending: 1, Running: 1, Hold: 1},
+						JobStates: JobStates{Pending: 1, Running: 1, Hold: 1, States: StateCounts{{State: "pending"}: 1, {State: "running"}: 1}},
 						JobTres:   JobTres{CpusAlloc: 8, MemoryAlloc: 1024},
 					},
 					{UserId: "1000"}: {
 						JobCount:  2,
-						JobStates: JobStates{Pending: 1, Running: 1},
+						JobStates: JobStates{Pending: 1, Running: 1, States: StateCounts{{State: "pending"}: 1, {State: "running"}: 1}},
 						JobTres:   JobTres{CpusAlloc: 12, MemoryAlloc: 3072},
 					},
 				},
@@ -79,9 +79,9 @@ func TestUserCollector_getUserMetrics(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := &userCollector{
-				slurmClient: tt.fields.slurmClient,
+				snapshots: NewSnapshotter(tt.fields.slurmClient),
 			}
-			got, err := c.getUserMetrics(tt.args.ctx)
+			got, err := c.getUserMetrics(c.snapshots.SnapshotWithContext(tt.args.ctx))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("userCollector.getUserMetrics() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -142,7 +142,7 @@ func TestUserCollector_Collect(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewUserCollector(tt.fields.slurmClient)
+			c := NewUserCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Collect(tt.args.ch)
 				close(tt.args.ch)
@@ -184,7 +184,7 @@ func TestUserCollector_Describe(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := NewUserCollector(tt.fields.slurmClient)
+			c := NewUserCollector(NewSnapshotter(tt.fields.slurmClient))
 			go func() {
 				c.Describe(tt.args.ch)
 				close(tt.args.ch)
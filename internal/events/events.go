@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"k8s.io/utils/set"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+// The reasons of the Events, per unavailable node state.
+const (
+	ReasonNodeDown  = "SlurmNodeDown"
+	ReasonNodeDrain = "SlurmNodeDrain"
+	ReasonNodeFail  = "SlurmNodeFail"
+)
+
+// unavailableStates are the node states which are published, in the order
+// their Events are recorded.
+var unavailableStates = []struct {
+	state  api.V0043NodeState
+	reason string
+}{
+	{state: api.V0043NodeStateDOWN, reason: ReasonNodeDown},
+	{state: api.V0043NodeStateDRAIN, reason: ReasonNodeDrain},
+	{state: api.V0043NodeStateFAIL, reason: ReasonNodeFail},
+}
+
+// The event correlator of the broadcaster allows a burst of Events per pod,
+// then one Event per period, and aggregates similar Events of a pod.
+const (
+	eventBurst  = 10
+	eventPeriod = 5 * time.Minute
+)
+
+// NewBroadcaster returns a broadcaster which records Events to the Kubernetes
+// API. Events are deduplicated, aggregated and rate limited per pod by its
+// event correlator.
+func NewBroadcaster(kubeClient kubernetes.Interface) record.EventBroadcaster {
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		BurstSize: eventBurst,
+		QPS:       float32(1 / eventPeriod.Seconds()),
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	return broadcaster
+}
+
+// PodLookup looks up the pods which the Slurm nodes run in.
+type PodLookup interface {
+	// Pods returns the pods of the Slurm nodes, by node name.
+	Pods(ctx context.Context) (map[string]*corev1.Pod, error)
+}
+
+// Publisher records a Kubernetes Event on the pod of a Slurm node when the
+// node goes down, drains or fails, along with the reason of the node.
+type Publisher struct {
+	slurmClient client.Client
+	pods        PodLookup
+	recorder    record.EventRecorder
+	period      time.Duration
+
+	// tracker remembers the unavailable states of the nodes, as last observed.
+	tracker *collector.NodeStateTracker
+}
+
+// NewPublisher returns a Publisher which observes the nodes of the slurm
+// client once per period.
+func NewPublisher(slurmClient client.Client, pods PodLookup, recorder record.EventRecorder, period time.Duration) *Publisher {
+	return &Publisher{
+		slurmClient: slurmClient,
+		pods:        pods,
+		recorder:    recorder,
+		period:      period,
+		tracker:     collector.NewNodeStateTracker(nodeUnavailableStates),
+	}
+}
+
+// Start publishes the node state transitions until the context is done.
+func (p *Publisher) Start(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("Publisher")
+
+	ticker := time.NewTicker(p.period)
+	defer ticker.Stop()
+	for {
+		if err := p.publish(ctx); err != nil {
+			logger.Error(err, "failed to publish node events")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// nodeEvent is an Event of a node, entering an unavailable state.
+type nodeEvent struct {
+	node    string
+	reason  string
+	message string
+}
+
+// publish records the Events of the nodes which entered an unavailable state
+// since the last observation, or which were added in one. The states of the
+// first observation are not published, as they may have been entered long
+// before.
+func (p *Publisher) publish(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("Publisher")
+
+	nodeList := &types.V0043NodeList{}
+	if err := p.slurmClient.List(ctx, nodeList); err != nil {
+		return err
+	}
+	// The pods are looked up first, such that no Event is lost when they
+	// cannot be.
+	pods, err := p.pods.Pods(ctx)
+	if err != nil {
+		return err
+	}
+	for _, event := range p.observe(nodeList) {
+		pod, ok := pods[event.node]
+		if !ok {
+			logger.V(1).Info("no pod for the node, not recording its event", "node", event.node, "reason", event.reason)
+			continue
+		}
+		p.recorder.Event(pod, corev1.EventTypeWarning, event.reason, event.message)
+	}
+	return nil
+}
+
+// observe records the unavailable states of the nodes and returns the Events
+// of the states which were entered since the last observation, including by
+// the nodes which were added since.
+func (p *Publisher) observe(nodeList *types.V0043NodeList) []nodeEvent {
+	nodes := make(map[string]types.V0043Node, len(nodeList.Items))
+	for _, node := range nodeList.Items {
+		nodes[string(node.GetKey())] = node
+	}
+	transitions, _ := p.tracker.Observe(nodeList)
+	var nodeEvents []nodeEvent
+	for _, transition := range transitions {
+		last := set.New(strings.Split(transition.From, ",")...)
+		current := set.New(strings.Split(transition.To, ",")...)
+		for _, unavailable := range unavailableStates {
+			state := string(unavailable.state)
+			if !current.Has(state) || last.Has(state) {
+				continue
+			}
+			nodeEvents = append(nodeEvents, nodeEvent{
+				node:    transition.Node,
+				reason:  unavailable.reason,
+				message: nodeMessage(nodes[transition.Node], unavailable.state),
+			})
+		}
+	}
+	return nodeEvents
+}
+
+// nodeUnavailableStates reduces a node to its unavailable states, in the
+// order of unavailableStates, joined by commas.
+func nodeUnavailableStates(node types.V0043Node) string {
+	nodeStates := node.GetStateAsSet()
+	var states []string
+	for _, unavailable := range unavailableStates {
+		if nodeStates.Has(unavailable.state) {
+			states = append(states, string(unavailable.state))
+		}
+	}
+	return strings.Join(states, ",")
+}
+
+// nodeMessage describes the state of the node, along with its reason and the
+// user who set it.
+func nodeMessage(node types.V0043Node, state api.V0043NodeState) string {
+	message := fmt.Sprintf("Slurm node %s is %s", node.GetKey(), state)
+	if reason := ptr.Deref(node.Reason, ""); reason != "" {
+		message += ": " + reason
+	}
+	if user := ptr.Deref(node.ReasonSetByUser, ""); user != "" {
+		message += fmt.Sprintf(" (set by %s)", user)
+	}
+	return message
+}
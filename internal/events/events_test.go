@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+)
+
+// fakePodLookup returns the given pods, or else the error.
+type fakePodLookup struct {
+	pods map[string]*corev1.Pod
+	err  error
+}
+
+func (f *fakePodLookup) Pods(_ context.Context) (map[string]*corev1.Pod, error) {
+	return f.pods, f.err
+}
+
+func newNode(name string, states ...api.V0043NodeState) types.V0043Node {
+	return types.V0043Node{V0043Node: api.V0043Node{
+		Name:  ptr.To(name),
+		State: ptr.To(states),
+	}}
+}
+
+func newNodeList(nodes ...types.V0043Node) *types.V0043NodeList {
+	return &types.V0043NodeList{Items: nodes}
+}
+
+func newPod(name string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "slurm", Name: name}}
+}
+
+func TestPublisher_observe(t *testing.T) {
+	p := NewPublisher(nil, nil, nil, time.Minute)
+
+	// The states of the first observation are not published.
+	got := p.observe(newNodeList(
+		newNode("node0", api.V0043NodeStateIDLE),
+		newNode("node1", api.V0043NodeStateDOWN),
+	))
+	assert.Empty(t, got)
+
+	drained := newNode("node0", api.V0043NodeStateALLOCATED, api.V0043NodeStateDRAIN)
+	drained.Reason = ptr.To("bad gpu")
+	drained.ReasonSetByUser = ptr.To("alice")
+	got = p.observe(newNodeList(
+		drained,
+		newNode("node1", api.V0043NodeStateDOWN, api.V0043NodeStateFAIL),
+		newNode("node2", api.V0043NodeStateDOWN),
+	))
+	// The states of the nodes which were added since are published.
+	assert.ElementsMatch(t, []nodeEvent{
+		{node: "node0", reason: ReasonNodeDrain, message: "Slurm node node0 is DRAIN: bad gpu (set by alice)"},
+		{node: "node1", reason: ReasonNodeFail, message: "Slurm node node1 is FAIL"},
+		{node: "node2", reason: ReasonNodeDown, message: "Slurm node node2 is DOWN"},
+	}, got)
+
+	// The states are published once, until they are left and entered again.
+	got = p.observe(newNodeList(
+		drained,
+		newNode("node1", api.V0043NodeStateIDLE),
+		newNode("node2", api.V0043NodeStateDOWN),
+	))
+	assert.Empty(t, got)
+	got = p.observe(newNodeList(
+		drained,
+		newNode("node1", api.V0043NodeStateDOWN),
+		newNode("node2", api.V0043NodeStateDOWN),
+	))
+	assert.Equal(t, []nodeEvent{
+		{node: "node1", reason: ReasonNodeDown, message: "Slurm node node1 is DOWN"},
+	}, got)
+}
+
+func TestPublisher_publish(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	pods := &fakePodLookup{pods: map[string]*corev1.Pod{
+		"node0": newPod("slurm-compute-0"),
+	}}
+	p := NewPublisher(fake.NewClientBuilder().WithLists(newNodeList(
+		newNode("node0", api.V0043NodeStateIDLE),
+		newNode("node1", api.V0043NodeStateIDLE),
+	)).Build(), pods, recorder, time.Minute)
+	assert.NoError(t, p.publish(context.Background()))
+
+	// Nodes without a pod have no Events.
+	p.slurmClient = fake.NewClientBuilder().WithLists(newNodeList(
+		newNode("node0", api.V0043NodeStateDOWN),
+		newNode("node1", api.V0043NodeStateDOWN),
+	)).Build()
+	assert.NoError(t, p.publish(context.Background()))
+	assert.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning SlurmNodeDown Slurm node node0 is DOWN", <-recorder.Events)
+
+	pods.err = errors.New("cache not synced")
+	p.slurmClient = fake.NewClientBuilder().WithLists(newNodeList(
+		newNode("node0", api.V0043NodeStateDOWN, api.V0043NodeStateDRAIN),
+	)).Build()
+	assert.Error(t, p.publish(context.Background()))
+	assert.Empty(t, recorder.Events)
+
+	// The Events are recorded once the pods can be looked up.
+	pods.err = nil
+	assert.NoError(t, p.publish(context.Background()))
+	assert.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning SlurmNodeDrain Slurm node node0 is DRAIN", <-recorder.Events)
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package externalmetrics
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The ConfigMap by which the Kubernetes API server publishes how it
+// authenticates the requests which it proxies to the aggregated API servers.
+const (
+	authenticationNamespace = "kube-system"
+	authenticationConfigMap = "extension-apiserver-authentication"
+)
+
+// RequestHeader is how the Kubernetes API aggregation layer authenticates the
+// requests which it proxies: by its front-proxy client certificate, signed by
+// ClientCA and named by one of AllowedNames (any, if empty), and by the headers
+// which hold the user it authenticated.
+type RequestHeader struct {
+	ClientCA        *x509.CertPool
+	AllowedNames    []string
+	UsernameHeaders []string
+	GroupHeaders    []string
+}
+
+// LoadRequestHeader reads the RequestHeader from the
+// extension-apiserver-authentication ConfigMap of the Kubernetes API server.
+func LoadRequestHeader(ctx context.Context, kubeClient kubernetes.Interface) (*RequestHeader, error) {
+	configMap, err := kubeClient.CoreV1().ConfigMaps(authenticationNamespace).Get(ctx, authenticationConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	requestHeader := &RequestHeader{ClientCA: x509.NewCertPool()}
+	if !requestHeader.ClientCA.AppendCertsFromPEM([]byte(configMap.Data["requestheader-client-ca-file"])) {
+		return nil, fmt.Errorf("no requestheader-client-ca-file in ConfigMap %s/%s", authenticationNamespace, authenticationConfigMap)
+	}
+	lists := map[string]*[]string{
+		"requestheader-allowed-names":    &requestHeader.AllowedNames,
+		"requestheader-username-headers": &requestHeader.UsernameHeaders,
+		"requestheader-group-headers":    &requestHeader.GroupHeaders,
+	}
+	for key, list := range lists {
+		data, ok := configMap.Data[key]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal([]byte(data), list); err != nil {
+			return nil, fmt.Errorf("invalid %s in ConfigMap %s/%s: %w", key, authenticationNamespace, authenticationConfigMap, err)
+		}
+	}
+	if len(requestHeader.UsernameHeaders) == 0 {
+		return nil, fmt.Errorf("no requestheader-username-headers in ConfigMap %s/%s", authenticationNamespace, authenticationConfigMap)
+	}
+	return requestHeader, nil
+}
+
+// authenticate returns the user of a request, which the aggregation layer
+// proxied over a TLS connection verified against the ClientCA.
+func (h *RequestHeader) authenticate(r *http.Request) (user string, groups []string, err error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", nil, errors.New("no verified client certificate")
+	}
+	name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if len(h.AllowedNames) > 0 && !slices.Contains(h.AllowedNames, name) {
+		return "", nil, fmt.Errorf("client certificate %q is not allowed", name)
+	}
+	for _, header := range h.UsernameHeaders {
+		if user = r.Header.Get(header); user != "" {
+			break
+		}
+	}
+	if user == "" {
+		return "", nil, errors.New("no user in the request headers")
+	}
+	for _, header := range h.GroupHeaders {
+		groups = append(groups, r.Header.Values(header)...)
+	}
+	return user, groups, nil
+}
+
+// authorize returns whether the Kubernetes API server allows the user to
+// access the resource or the path, by a SubjectAccessReview, and if not why.
+func (s *Server) authorize(ctx context.Context, user string, groups []string, resource *authorizationv1.ResourceAttributes, path *authorizationv1.NonResourceAttributes) (bool, string, error) {
+	review, err := s.accessReviews.Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:                  user,
+			Groups:                groups,
+			ResourceAttributes:    resource,
+			NonResourceAttributes: path,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+	return review.Status.Allowed, review.Status.Reason, nil
+}
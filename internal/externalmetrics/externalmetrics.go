@@ -0,0 +1,257 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package externalmetrics
+
+import (
+	"cmp"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+// The external metrics, per partition.
+const (
+	MetricPendingJobs = "slurm_partition_pending_jobs"
+	MetricPendingCpus = "slurm_partition_pending_cpus"
+	MetricPendingGpus = "slurm_partition_pending_gpus"
+	MetricIdleNodes   = "slurm_partition_idle_nodes"
+)
+
+// PartitionLabel is the label of the external metrics which holds the
+// partition, to be selected by the label selector of the metric.
+const PartitionLabel = "partition"
+
+// partitionValues returns the value of each external metric of a partition.
+var partitionValues = map[string]func(jobs *collector.PartitionJobMetrics, nodes *collector.NodeMetrics) uint{
+	MetricPendingJobs: func(jobs *collector.PartitionJobMetrics, _ *collector.NodeMetrics) uint {
+		return jobs.PendingJobCount
+	},
+	MetricPendingCpus: func(jobs *collector.PartitionJobMetrics, _ *collector.NodeMetrics) uint {
+		return jobs.PendingCpus
+	},
+	MetricPendingGpus: func(jobs *collector.PartitionJobMetrics, _ *collector.NodeMetrics) uint {
+		return jobs.PendingGpus
+	},
+	MetricIdleNodes: func(_ *collector.PartitionJobMetrics, nodes *collector.NodeMetrics) uint {
+		return nodes.NodeStates.Idle
+	},
+}
+
+var groupVersion = v1beta1.SchemeGroupVersion.String()
+
+// Server serves the external metrics API (external.metrics.k8s.io) from the
+// partition metrics, such that a HorizontalPodAutoscaler can scale on them.
+// The metrics are cluster wide, hence the namespace of a request is ignored.
+//
+// Only the requests proxied by the Kubernetes API aggregation layer are
+// served, authenticated by its RequestHeader, and authorized by a
+// SubjectAccessReview of the user on whose behalf it proxies them.
+type Server struct {
+	snapshots     *collector.Snapshotter
+	ready         func() error
+	requestHeader *RequestHeader
+	accessReviews authorizationv1client.SubjectAccessReviewInterface
+	clock         clock.PassiveClock
+
+	mux *http.ServeMux
+}
+
+// NewServer returns a Server which serves the metrics while ready returns no
+// error, to the requests authenticated by the requestHeader and authorized by
+// the accessReviews.
+func NewServer(snapshots *collector.Snapshotter, ready func() error, requestHeader *RequestHeader, accessReviews authorizationv1client.SubjectAccessReviewInterface) *Server {
+	return newServer(snapshots, ready, requestHeader, accessReviews, clock.RealClock{})
+}
+
+func newServer(snapshots *collector.Snapshotter, ready func() error, requestHeader *RequestHeader, accessReviews authorizationv1client.SubjectAccessReviewInterface, clk clock.PassiveClock) *Server {
+	s := &Server{
+		snapshots:     snapshots,
+		ready:         ready,
+		requestHeader: requestHeader,
+		accessReviews: accessReviews,
+		clock:         clk,
+		mux:           http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /apis/"+groupVersion, s.authorized(s.serveResources))
+	s.mux.HandleFunc("GET /apis/"+groupVersion+"/namespaces/{namespace}/{metric}", s.authorized(s.serveMetric))
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorized returns the handler which serves only the authenticated and
+// authorized requests. They are authorized like the Kubernetes API server
+// would: the discovery by its path, the metrics as a list of the resource.
+func (s *Server) authorized(serve http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, groups, err := s.requestHeader.authenticate(r)
+		if err != nil {
+			writeStatus(w, apierrors.NewUnauthorized(err.Error()))
+			return
+		}
+		var resource *authorizationv1.ResourceAttributes
+		var path *authorizationv1.NonResourceAttributes
+		metric := r.PathValue("metric")
+		if metric != "" {
+			resource = &authorizationv1.ResourceAttributes{
+				Namespace: r.PathValue("namespace"),
+				Verb:      "list",
+				Group:     v1beta1.SchemeGroupVersion.Group,
+				Version:   v1beta1.SchemeGroupVersion.Version,
+				Resource:  metric,
+			}
+		} else {
+			path = &authorizationv1.NonResourceAttributes{Path: r.URL.Path, Verb: "get"}
+		}
+		allowed, reason, err := s.authorize(r.Context(), user, groups, resource, path)
+		if err != nil {
+			log.FromContext(r.Context()).WithName("ExternalMetrics").Error(err, "failed to authorize", "user", user)
+			writeStatus(w, apierrors.NewInternalError(err))
+			return
+		}
+		if !allowed {
+			writeStatus(w, apierrors.NewForbidden(v1beta1.Resource(metric), "", errors.New(cmp.Or(reason, "not allowed"))))
+			return
+		}
+		serve(w, r)
+	}
+}
+
+func (s *Server) serveResources(w http.ResponseWriter, _ *http.Request) {
+	list := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: groupVersion,
+	}
+	for _, name := range slices.Sorted(maps.Keys(partitionValues)) {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       name,
+			Namespaced: true,
+			Kind:       "ExternalMetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) serveMetric(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context()).WithName("ExternalMetrics")
+
+	name := r.PathValue("metric")
+	value, ok := partitionValues[name]
+	if !ok {
+		writeStatus(w, apierrors.NewNotFound(v1beta1.Resource(name), name))
+		return
+	}
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, apierrors.NewBadRequest(err.Error()))
+		return
+	}
+	if err := s.ready(); err != nil {
+		writeStatus(w, apierrors.NewServiceUnavailable(err.Error()))
+		return
+	}
+	metrics, err := s.snapshots.SnapshotWithContext(r.Context()).PartitionMetrics()
+	if err != nil {
+		logger.Error(err, "failed to get partition metrics", "metric", name)
+		writeStatus(w, apierrors.NewInternalError(err))
+		return
+	}
+
+	list := &v1beta1.ExternalMetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "ExternalMetricValueList", APIVersion: groupVersion},
+		Items:    []v1beta1.ExternalMetricValue{},
+	}
+	now := metav1.NewTime(s.clock.Now())
+	for partition, jobs := range metrics.JobMetricsPer {
+		metricLabels := map[string]string{PartitionLabel: partition}
+		if !selector.Matches(labels.Set(metricLabels)) {
+			continue
+		}
+		nodes, ok := metrics.NodeMetricsPer[partition]
+		if !ok {
+			nodes = &collector.NodeMetrics{}
+		}
+		list.Items = append(list.Items, v1beta1.ExternalMetricValue{
+			MetricName:   name,
+			MetricLabels: metricLabels,
+			Timestamp:    now,
+			Value:        *resource.NewQuantity(int64(value(jobs, nodes)), resource.DecimalSI),
+		})
+	}
+	slices.SortFunc(list.Items, func(a, b v1beta1.ExternalMetricValue) int {
+		return cmp.Compare(a.MetricLabels[PartitionLabel], b.MetricLabels[PartitionLabel])
+	})
+	writeJSON(w, http.StatusOK, list)
+}
+
+func writeStatus(w http.ResponseWriter, err *apierrors.StatusError) {
+	status := err.Status()
+	status.TypeMeta = metav1.TypeMeta{Kind: "Status", APIVersion: "v1"}
+	writeJSON(w, int(status.Code), &status)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// TLSConfig returns the TLS configuration of the Server, which the
+// Kubernetes API aggregation layer requires, and which requires its client
+// certificate, signed by the clientCA. Without a certificate and key, a
+// self-signed certificate is generated.
+func TLSConfig(certFile, keyFile string, clientCA *x509.CertPool) (*tls.Config, error) {
+	if clientCA == nil {
+		return nil, errors.New("no client CA")
+	}
+	var certPEM, keyPEM []byte
+	var err error
+	if certFile != "" || keyFile != "" {
+		certPEM, err = os.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err = os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		certPEM, keyPEM, err = cert.GenerateSelfSignedCertKey("slurm-exporter", nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCA,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
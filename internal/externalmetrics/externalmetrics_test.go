@@ -0,0 +1,380 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package externalmetrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/metrics/pkg/apis/external_metrics/v1beta1"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+func newTestServer(ready func() error) *Server {
+	partitionList := &types.V0043PartitionInfoList{Items: []types.V0043PartitionInfo{
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("cpu")}},
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("gpu")}},
+	}}
+	nodeList := &types.V0043NodeList{Items: []types.V0043Node{
+		{V0043Node: api.V0043Node{
+			Name:       ptr.To("node-0"),
+			Partitions: ptr.To(api.V0043CsvString{"cpu"}),
+			State:      ptr.To([]api.V0043NodeState{api.V0043NodeStateIDLE}),
+		}},
+		{V0043Node: api.V0043Node{
+			Name:       ptr.To("node-1"),
+			Partitions: ptr.To(api.V0043CsvString{"gpu"}),
+			State:      ptr.To([]api.V0043NodeState{api.V0043NodeStateALLOCATED}),
+		}},
+	}}
+	jobList := &types.V0043JobInfoList{Items: []types.V0043JobInfo{
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](1),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=16,node=2,gres/gpu=8"),
+		}},
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](2),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=4,node=1,gres/gpu=1"),
+		}},
+	}}
+	slurmClient := fake.NewClientBuilder().WithLists(partitionList, nodeList, jobList).Build()
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	return newServer(collector.NewSnapshotter(slurmClient), ready, newTestRequestHeader(), newTestAccessReviews(), clk)
+}
+
+// The user which the test access reviews allow to list the metrics of the
+// slurm namespace.
+const testUser = "system:serviceaccount:kube-system:horizontal-pod-autoscaler"
+
+func newTestRequestHeader() *RequestHeader {
+	return &RequestHeader{
+		ClientCA:        x509.NewCertPool(),
+		AllowedNames:    []string{"front-proxy-client"},
+		UsernameHeaders: []string{"X-Remote-User"},
+		GroupHeaders:    []string{"X-Remote-Group"},
+	}
+}
+
+func newTestAccessReviews() authorizationv1client.SubjectAccessReviewInterface {
+	kubeClient := kubefake.NewClientset()
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		spec := review.Spec
+		review.Status.Allowed = spec.User == testUser &&
+			(spec.NonResourceAttributes != nil ||
+				spec.ResourceAttributes.Namespace == "slurm" && spec.ResourceAttributes.Group == "external.metrics.k8s.io")
+		return true, review, nil
+	})
+	return kubeClient.AuthorizationV1().SubjectAccessReviews()
+}
+
+// newRequest returns a request proxied by the aggregation layer, on behalf of
+// the user, over a verified connection of the client certificate.
+func newRequest(path, clientName, user string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "https://slurm-exporter"+path, nil)
+	if clientName != "" {
+		r.TLS.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: clientName}}}}
+	}
+	if user != "" {
+		r.Header.Set("X-Remote-User", user)
+		r.Header.Add("X-Remote-Group", "system:authenticated")
+	}
+	return r
+}
+
+func get(s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, newRequest(path, "front-proxy-client", testUser))
+	return w
+}
+
+func TestServer_resources(t *testing.T) {
+	w := get(newTestServer(func() error { return nil }), "/apis/external.metrics.k8s.io/v1beta1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var got metav1.APIResourceList
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, "external.metrics.k8s.io/v1beta1", got.GroupVersion)
+	var names []string
+	for _, resource := range got.APIResources {
+		names = append(names, resource.Name)
+	}
+	assert.Equal(t, []string{MetricIdleNodes, MetricPendingCpus, MetricPendingGpus, MetricPendingJobs}, names)
+}
+
+func TestServer_metric(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   string
+		selector string
+		want     map[string]int64
+	}{
+		{
+			name:   "pending jobs",
+			metric: MetricPendingJobs,
+			want:   map[string]int64{"cpu": 0, "gpu": 2},
+		},
+		{
+			name:   "pending cpus",
+			metric: MetricPendingCpus,
+			want:   map[string]int64{"cpu": 0, "gpu": 20},
+		},
+		{
+			name:     "pending gpus by selector",
+			metric:   MetricPendingGpus,
+			selector: "partition=gpu",
+			want:     map[string]int64{"gpu": 9},
+		},
+		{
+			name:     "idle nodes by set selector",
+			metric:   MetricIdleNodes,
+			selector: "partition in (cpu,gpu)",
+			want:     map[string]int64{"cpu": 1, "gpu": 0},
+		},
+		{
+			name:     "no match",
+			metric:   MetricIdleNodes,
+			selector: "partition=debug",
+			want:     map[string]int64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/apis/external.metrics.k8s.io/v1beta1/namespaces/slurm/" + tt.metric
+			if tt.selector != "" {
+				path += "?labelSelector=" + url.QueryEscape(tt.selector)
+			}
+			w := get(newTestServer(func() error { return nil }), path)
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			var got v1beta1.ExternalMetricValueList
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, "ExternalMetricValueList", got.Kind)
+			values := make(map[string]int64, len(got.Items))
+			for _, item := range got.Items {
+				assert.Equal(t, tt.metric, item.MetricName)
+				assert.Equal(t, time.Unix(1000, 0), item.Timestamp.Time)
+				values[item.MetricLabels[PartitionLabel]] = item.Value.Value()
+			}
+			assert.Equal(t, tt.want, values)
+		})
+	}
+}
+
+func TestServer_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		ready func() error
+		want  int
+	}{
+		{
+			name:  "unknown metric",
+			path:  "/apis/external.metrics.k8s.io/v1beta1/namespaces/slurm/slurm_unknown",
+			ready: func() error { return nil },
+			want:  http.StatusNotFound,
+		},
+		{
+			name:  "invalid selector",
+			path:  "/apis/external.metrics.k8s.io/v1beta1/namespaces/slurm/" + MetricIdleNodes + "?labelSelector=" + url.QueryEscape("partition in"),
+			ready: func() error { return nil },
+			want:  http.StatusBadRequest,
+		},
+		{
+			name:  "not ready",
+			path:  "/apis/external.metrics.k8s.io/v1beta1/namespaces/slurm/" + MetricIdleNodes,
+			ready: func() error { return errors.New("not ready") },
+			want:  http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(newTestServer(tt.ready), tt.path)
+			assert.Equal(t, tt.want, w.Code)
+			var got metav1.Status
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, "Status", got.Kind)
+			assert.Equal(t, int32(tt.want), got.Code)
+		})
+	}
+}
+
+func TestServer_auth(t *testing.T) {
+	metricPath := "/apis/external.metrics.k8s.io/v1beta1/namespaces/slurm/" + MetricIdleNodes
+	tests := []struct {
+		name       string
+		path       string
+		clientName string
+		user       string
+		want       int
+	}{
+		{
+			name: "no client certificate",
+			path: metricPath,
+			user: testUser,
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:       "client certificate not allowed",
+			path:       metricPath,
+			clientName: "kube-apiserver",
+			user:       testUser,
+			want:       http.StatusUnauthorized,
+		},
+		{
+			name:       "no user",
+			path:       metricPath,
+			clientName: "front-proxy-client",
+			want:       http.StatusUnauthorized,
+		},
+		{
+			name:       "user not allowed",
+			path:       metricPath,
+			clientName: "front-proxy-client",
+			user:       "system:anonymous",
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "namespace not allowed",
+			path:       "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/" + MetricIdleNodes,
+			clientName: "front-proxy-client",
+			user:       testUser,
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "discovery allowed",
+			path:       "/apis/external.metrics.k8s.io/v1beta1",
+			clientName: "front-proxy-client",
+			user:       testUser,
+			want:       http.StatusOK,
+		},
+		{
+			name:       "metric allowed",
+			path:       metricPath,
+			clientName: "front-proxy-client",
+			user:       testUser,
+			want:       http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			newTestServer(func() error { return nil }).ServeHTTP(w, newRequest(tt.path, tt.clientName, tt.user))
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestLoadRequestHeader(t *testing.T) {
+	caPEM, _, err := cert.GenerateSelfSignedCertKey("front-proxy-ca", nil, nil)
+	assert.NoError(t, err)
+	configMap := func(data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "extension-apiserver-authentication"},
+			Data:       data,
+		}
+	}
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		want    *RequestHeader
+		wantErr bool
+	}{
+		{
+			name: "request header",
+			objects: []runtime.Object{configMap(map[string]string{
+				"requestheader-client-ca-file":   string(caPEM),
+				"requestheader-allowed-names":    `["front-proxy-client"]`,
+				"requestheader-username-headers": `["X-Remote-User"]`,
+				"requestheader-group-headers":    `["X-Remote-Group"]`,
+			})},
+			want: &RequestHeader{
+				AllowedNames:    []string{"front-proxy-client"},
+				UsernameHeaders: []string{"X-Remote-User"},
+				GroupHeaders:    []string{"X-Remote-Group"},
+			},
+		},
+		{
+			name:    "no ConfigMap",
+			wantErr: true,
+		},
+		{
+			name: "no client CA",
+			objects: []runtime.Object{configMap(map[string]string{
+				"requestheader-username-headers": `["X-Remote-User"]`,
+			})},
+			wantErr: true,
+		},
+		{
+			name: "invalid allowed names",
+			objects: []runtime.Object{configMap(map[string]string{
+				"requestheader-client-ca-file":   string(caPEM),
+				"requestheader-allowed-names":    `front-proxy-client`,
+				"requestheader-username-headers": `["X-Remote-User"]`,
+			})},
+			wantErr: true,
+		},
+		{
+			name: "no username headers",
+			objects: []runtime.Object{configMap(map[string]string{
+				"requestheader-client-ca-file": string(caPEM),
+			})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadRequestHeader(context.Background(), kubefake.NewClientset(tt.objects...))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, got.ClientCA)
+			got.ClientCA = nil
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	config, err := TLSConfig("", "", x509.NewCertPool())
+	assert.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	_, err = TLSConfig("", "", nil)
+	assert.Error(t, err)
+
+	_, err = TLSConfig("/nonexistent/tls.crt", "/nonexistent/tls.key", x509.NewCertPool())
+	assert.Error(t, err)
+}
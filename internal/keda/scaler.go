@@ -0,0 +1,223 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package keda
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+	"github.com/SlinkyProject/slurm-exporter/internal/keda/externalscaler"
+)
+
+// The scaler metadata of a ScaledObject.
+const (
+	// MetadataPartition is the partition whose pending demand scales the
+	// ScaledObject. Required.
+	MetadataPartition = "partition"
+	// MetadataMetric is the kind of pending demand, one of the Metric kinds.
+	// Defaults to MetricPendingNodes.
+	MetadataMetric = "metric"
+	// MetadataTargetSize is the value per replica. Defaults to 1.
+	MetadataTargetSize = "targetSize"
+	// MetadataActivationThreshold is the value above which the ScaledObject is
+	// active. Defaults to 0.
+	MetadataActivationThreshold = "activationThreshold"
+)
+
+// The kinds of pending demand of a partition.
+const (
+	// MetricPendingJobs is the number of pending jobs which are not held.
+	MetricPendingJobs = "pending_jobs"
+	// MetricPendingNodes is the largest number of nodes required among the
+	// pending jobs.
+	MetricPendingNodes = "pending_nodes"
+	// MetricPendingCpus is the number of CPUs requested by the pending jobs
+	// which are not held.
+	MetricPendingCpus = "pending_cpus"
+	// MetricPendingGpus is the number of GPUs requested by the pending jobs
+	// which are not held.
+	MetricPendingGpus = "pending_gpus"
+)
+
+// partitionValues returns the value of each kind of pending demand of a
+// partition.
+var partitionValues = map[string]func(jobs *collector.PartitionJobMetrics) uint{
+	MetricPendingJobs: func(jobs *collector.PartitionJobMetrics) uint {
+		return jobs.PendingJobCount
+	},
+	MetricPendingNodes: func(jobs *collector.PartitionJobMetrics) uint {
+		return jobs.PendingNodeCount
+	},
+	MetricPendingCpus: func(jobs *collector.PartitionJobMetrics) uint {
+		return jobs.PendingCpus
+	},
+	MetricPendingGpus: func(jobs *collector.PartitionJobMetrics) uint {
+		return jobs.PendingGpus
+	},
+}
+
+// Scaler implements the external scaler service of KEDA, scaling
+// ScaledObjects (e.g. Slurm NodeSets) on the pending demand of a partition.
+type Scaler struct {
+	externalscaler.UnimplementedExternalScalerServer
+
+	snapshots *collector.Snapshotter
+	ready     func() error
+	// How often StreamIsActive checks whether the ScaledObject is active.
+	interval time.Duration
+}
+
+var _ externalscaler.ExternalScalerServer = &Scaler{}
+
+// NewScaler returns a Scaler which serves while ready returns no error, and
+// whose StreamIsActive checks the activity every interval.
+func NewScaler(snapshots *collector.Snapshotter, ready func() error, interval time.Duration) *Scaler {
+	return &Scaler{
+		snapshots: snapshots,
+		ready:     ready,
+		interval:  interval,
+	}
+}
+
+// scalerMetadata is the parsed scaler metadata of a ScaledObject.
+type scalerMetadata struct {
+	partition           string
+	metric              string
+	targetSize          int64
+	activationThreshold int64
+}
+
+func (m scalerMetadata) metricName() string {
+	return "slurm_partition_" + m.metric
+}
+
+func parseScalerMetadata(ref *externalscaler.ScaledObjectRef) (scalerMetadata, error) {
+	metadata := scalerMetadata{
+		partition:  ref.GetScalerMetadata()[MetadataPartition],
+		metric:     MetricPendingNodes,
+		targetSize: 1,
+	}
+	if metadata.partition == "" {
+		return metadata, fmt.Errorf("scaler metadata %q is required", MetadataPartition)
+	}
+	if metric, ok := ref.GetScalerMetadata()[MetadataMetric]; ok {
+		if _, ok := partitionValues[metric]; !ok {
+			return metadata, fmt.Errorf("unknown %s %q", MetadataMetric, metric)
+		}
+		metadata.metric = metric
+	}
+	if targetSize, ok := ref.GetScalerMetadata()[MetadataTargetSize]; ok {
+		value, err := strconv.ParseInt(targetSize, 10, 64)
+		if err != nil || value <= 0 {
+			return metadata, fmt.Errorf("%s must be a positive integer, got %q", MetadataTargetSize, targetSize)
+		}
+		metadata.targetSize = value
+	}
+	if threshold, ok := ref.GetScalerMetadata()[MetadataActivationThreshold]; ok {
+		value, err := strconv.ParseInt(threshold, 10, 64)
+		if err != nil || value < 0 {
+			return metadata, fmt.Errorf("%s must be a non-negative integer, got %q", MetadataActivationThreshold, threshold)
+		}
+		metadata.activationThreshold = value
+	}
+	return metadata, nil
+}
+
+// value returns the pending demand of the ScaledObject.
+func (s *Scaler) value(ctx context.Context, ref *externalscaler.ScaledObjectRef) (scalerMetadata, int64, error) {
+	logger := log.FromContext(ctx).WithName("Scaler")
+
+	metadata, err := parseScalerMetadata(ref)
+	if err != nil {
+		return metadata, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.ready(); err != nil {
+		return metadata, 0, status.Error(codes.Unavailable, err.Error())
+	}
+	metrics, err := s.snapshots.SnapshotWithContext(ctx).PartitionMetrics()
+	if err != nil {
+		logger.Error(err, "failed to get partition metrics", "partition", metadata.partition)
+		return metadata, 0, status.Error(codes.Unavailable, err.Error())
+	}
+	jobs, ok := metrics.JobMetricsPer[metadata.partition]
+	if !ok {
+		return metadata, 0, status.Errorf(codes.NotFound, "partition %q not found", metadata.partition)
+	}
+	return metadata, int64(partitionValues[metadata.metric](jobs)), nil
+}
+
+// IsActive returns whether the pending demand exceeds the activation
+// threshold.
+func (s *Scaler) IsActive(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.IsActiveResponse, error) {
+	metadata, value, err := s.value(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.IsActiveResponse{Result: value > metadata.activationThreshold}, nil
+}
+
+// StreamIsActive sends whether the ScaledObject is active, initially and
+// whenever it changes, until the stream is done.
+func (s *Scaler) StreamIsActive(ref *externalscaler.ScaledObjectRef, stream externalscaler.ExternalScaler_StreamIsActiveServer) error {
+	ctx := stream.Context()
+	logger := log.FromContext(ctx).WithName("Scaler")
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	var last *bool
+	for {
+		resp, err := s.IsActive(ctx, ref)
+		switch {
+		case status.Code(err) == codes.InvalidArgument:
+			return err
+		case err != nil:
+			logger.Error(err, "failed to check whether the ScaledObject is active", "name", ref.GetName(), "namespace", ref.GetNamespace())
+		case last == nil || *last != resp.GetResult():
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+			last = &resp.Result
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetMetricSpec returns the target size of the pending demand per replica.
+func (s *Scaler) GetMetricSpec(_ context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.GetMetricSpecResponse, error) {
+	metadata, err := parseScalerMetadata(ref)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: []*externalscaler.MetricSpec{{
+			MetricName: metadata.metricName(),
+			TargetSize: metadata.targetSize,
+		}},
+	}, nil
+}
+
+// GetMetrics returns the pending demand.
+func (s *Scaler) GetMetrics(ctx context.Context, req *externalscaler.GetMetricsRequest) (*externalscaler.GetMetricsResponse, error) {
+	metadata, value, err := s.value(ctx, req.GetScaledObjectRef())
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{{
+			MetricName:  metadata.metricName(),
+			MetricValue: value,
+		}},
+	}, nil
+}
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package keda
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"k8s.io/utils/ptr"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+	"github.com/SlinkyProject/slurm-exporter/internal/keda/externalscaler"
+)
+
+// newTestClient serves the Scaler over an in-memory connection and returns a
+// client of it.
+func newTestClient(t *testing.T, ready func() error) externalscaler.ExternalScalerClient {
+	partitionList := &types.V0043PartitionInfoList{Items: []types.V0043PartitionInfo{
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("cpu")}},
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("gpu")}},
+	}}
+	jobList := &types.V0043JobInfoList{Items: []types.V0043JobInfo{
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](1),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			NodeCount:  &api.V0043Uint32NoValStruct{Number: ptr.To[int32](2), Set: ptr.To(true)},
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=16,node=2,gres/gpu=8"),
+		}},
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](2),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			NodeCount:  &api.V0043Uint32NoValStruct{Number: ptr.To[int32](1), Set: ptr.To(true)},
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=4,node=1,gres/gpu=1"),
+		}},
+	}}
+	slurmClient := fake.NewClientBuilder().WithLists(partitionList, &types.V0043NodeList{}, jobList).Build()
+	scaler := NewScaler(collector.NewSnapshotter(slurmClient), ready, 10*time.Millisecond)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	externalscaler.RegisterExternalScalerServer(server, scaler)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return externalscaler.NewExternalScalerClient(conn)
+}
+
+func ready() error {
+	return nil
+}
+
+func scaledObject(metadata map[string]string) *externalscaler.ScaledObjectRef {
+	return &externalscaler.ScaledObjectRef{
+		Name:           "slurm-gpu",
+		Namespace:      "slurm",
+		ScalerMetadata: metadata,
+	}
+}
+
+func TestScaler_GetMetrics(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     *externalscaler.MetricValue
+		wantCode codes.Code
+	}{
+		{
+			name:     "default metric",
+			metadata: map[string]string{"partition": "gpu"},
+			want:     &externalscaler.MetricValue{MetricName: "slurm_partition_pending_nodes", MetricValue: 2},
+		},
+		{
+			name:     "pending jobs",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_jobs"},
+			want:     &externalscaler.MetricValue{MetricName: "slurm_partition_pending_jobs", MetricValue: 2},
+		},
+		{
+			name:     "pending cpus",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_cpus"},
+			want:     &externalscaler.MetricValue{MetricName: "slurm_partition_pending_cpus", MetricValue: 20},
+		},
+		{
+			name:     "pending gpus",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_gpus"},
+			want:     &externalscaler.MetricValue{MetricName: "slurm_partition_pending_gpus", MetricValue: 9},
+		},
+		{
+			name:     "no demand",
+			metadata: map[string]string{"partition": "cpu", "metric": "pending_cpus"},
+			want:     &externalscaler.MetricValue{MetricName: "slurm_partition_pending_cpus", MetricValue: 0},
+		},
+		{
+			name:     "no partition",
+			metadata: map[string]string{},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown metric",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_memory"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "unknown partition",
+			metadata: map[string]string{"partition": "debug"},
+			wantCode: codes.NotFound,
+		},
+	}
+	client := newTestClient(t, ready)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.GetMetrics(context.Background(), &externalscaler.GetMetricsRequest{
+				ScaledObjectRef: scaledObject(tt.metadata),
+				MetricName:      "s0-slurm",
+			})
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantCode != codes.OK {
+				return
+			}
+			assert.Len(t, got.GetMetricValues(), 1)
+			assert.Equal(t, tt.want.GetMetricName(), got.GetMetricValues()[0].GetMetricName())
+			assert.Equal(t, tt.want.GetMetricValue(), got.GetMetricValues()[0].GetMetricValue())
+		})
+	}
+}
+
+func TestScaler_GetMetricSpec(t *testing.T) {
+	client := newTestClient(t, ready)
+
+	got, err := client.GetMetricSpec(context.Background(), scaledObject(map[string]string{"partition": "gpu", "metric": "pending_cpus", "targetSize": "32"}))
+	assert.NoError(t, err)
+	assert.Len(t, got.GetMetricSpecs(), 1)
+	assert.Equal(t, "slurm_partition_pending_cpus", got.GetMetricSpecs()[0].GetMetricName())
+	assert.Equal(t, int64(32), got.GetMetricSpecs()[0].GetTargetSize())
+
+	got, err = client.GetMetricSpec(context.Background(), scaledObject(map[string]string{"partition": "gpu"}))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), got.GetMetricSpecs()[0].GetTargetSize())
+
+	_, err = client.GetMetricSpec(context.Background(), scaledObject(map[string]string{"partition": "gpu", "targetSize": "0"}))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestScaler_IsActive(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     bool
+	}{
+		{
+			name:     "pending demand",
+			metadata: map[string]string{"partition": "gpu"},
+			want:     true,
+		},
+		{
+			name:     "no pending demand",
+			metadata: map[string]string{"partition": "cpu"},
+			want:     false,
+		},
+		{
+			name:     "below activation threshold",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_gpus", "activationThreshold": "9"},
+			want:     false,
+		},
+		{
+			name:     "above activation threshold",
+			metadata: map[string]string{"partition": "gpu", "metric": "pending_gpus", "activationThreshold": "8"},
+			want:     true,
+		},
+	}
+	client := newTestClient(t, ready)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := client.IsActive(context.Background(), scaledObject(tt.metadata))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got.GetResult())
+		})
+	}
+}
+
+func TestScaler_notReady(t *testing.T) {
+	client := newTestClient(t, func() error { return errors.New("not the leader") })
+	_, err := client.IsActive(context.Background(), scaledObject(map[string]string{"partition": "gpu"}))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestScaler_StreamIsActive(t *testing.T) {
+	client := newTestClient(t, ready)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.StreamIsActive(ctx, scaledObject(map[string]string{"partition": "gpu"}))
+	assert.NoError(t, err)
+	got, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.True(t, got.GetResult())
+
+	stream, err = client.StreamIsActive(ctx, scaledObject(map[string]string{}))
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
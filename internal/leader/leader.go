@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// LeaderLabel labels the pod of the leader "true", and the pods of the
+// followers "false", such that a Service selects the leader only, for the
+// endpoints which only the leader serves.
+const LeaderLabel = "slinky.slurm.net/slurm-exporter-leader"
+
+// Options configure the leader election.
+type Options struct {
+	// Namespace and Name of the Lease.
+	Namespace string
+	Name      string
+	// Identity of this replica, unique among the replicas.
+	Identity string
+	// Pod is the name of the pod of this replica, in Namespace. If set, the
+	// pod is labeled by LeaderLabel.
+	Pod string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Elector elects one leader among the exporter replicas by a Kubernetes Lease,
+// such that only the leader polls slurmrestd and exports the Slurm metrics.
+type Elector struct {
+	kubeClient kubernetes.Interface
+	options    Options
+
+	leading atomic.Bool
+}
+
+func NewElector(kubeClient kubernetes.Interface, options Options) (*Elector, error) {
+	if options.Namespace == "" {
+		return nil, errors.New("leader election namespace must not be empty")
+	}
+	if options.Name == "" {
+		return nil, errors.New("leader election lease name must not be empty")
+	}
+	if options.Identity == "" {
+		return nil, errors.New("leader election identity must not be empty")
+	}
+	return &Elector{
+		kubeClient: kubeClient,
+		options:    options,
+	}, nil
+}
+
+// IsLeader returns whether this replica is the leader.
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Run campaigns for the leadership until the context is done or the
+// leadership is lost, calling lead for as long as this replica leads. The
+// context of lead is done once the leadership is lost. The Lease is released
+// when the context is done.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context)) error {
+	logger := log.FromContext(ctx).WithName("Elector")
+
+	// The label may be left over from a previous run of this replica.
+	e.labelPod(ctx, false)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: e.options.Namespace,
+			Name:      e.options.Name,
+		},
+		Client: e.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: e.options.Identity,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   e.options.LeaseDuration,
+		RenewDeadline:   e.options.RenewDeadline,
+		RetryPeriod:     e.options.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            e.options.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("started leading", "identity", e.options.Identity)
+				e.leading.Store(true)
+				e.labelPod(ctx, true)
+				lead(ctx)
+			},
+			OnStoppedLeading: func() {
+				logger.Info("stopped leading", "identity", e.options.Identity)
+				e.leading.Store(false)
+				// The context of the election may be done already.
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.options.RenewDeadline)
+				defer cancel()
+				e.labelPod(ctx, false)
+			},
+			OnNewLeader: func(identity string) {
+				logger.Info("new leader", "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	elector.Run(ctx)
+	return nil
+}
+
+// labelPod labels the pod of this replica by whether it leads. A failure is
+// only logged, as the Service of the leader is then stale, but the election is
+// not affected.
+func (e *Elector) labelPod(ctx context.Context, leading bool) {
+	if e.options.Pod == "" {
+		return
+	}
+	patch := fmt.Appendf(nil, `{"metadata":{"labels":{%q:%q}}}`, LeaderLabel, strconv.FormatBool(leading))
+	if _, err := e.kubeClient.CoreV1().Pods(e.options.Namespace).Patch(ctx, e.options.Pod, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		log.FromContext(ctx).WithName("Elector").Error(err, "failed to label pod", "pod", e.options.Pod, "leader", leading)
+	}
+}
+
+// Gatherer wraps the gatherer, such that it gathers nothing unless this
+// replica is the leader.
+func (e *Elector) Gatherer(gatherer prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		if !e.IsLeader() {
+			return nil, nil
+		}
+		return gatherer.Gather()
+	})
+}
+
+// NewCollector exports whether this replica is the leader.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func (e *Elector) NewCollector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "slurm_exporter_leader",
+		Help: "Whether this replica is the leader, which exports the Slurm metrics",
+	}, func() float64 {
+		if e.IsLeader() {
+			return 1
+		}
+		return 0
+	})
+}
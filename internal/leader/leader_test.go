@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestElector(t *testing.T, kubeClient kubernetes.Interface, identity string) *Elector {
+	elector, err := NewElector(kubeClient, Options{
+		Namespace:     "slurm",
+		Name:          "slurm-exporter",
+		Identity:      identity,
+		Pod:           identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewElector() error = %v", err)
+	}
+	return elector
+}
+
+func TestNewElector(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			options: Options{Namespace: "slurm", Name: "slurm-exporter", Identity: "a"},
+		},
+		{
+			name:    "no namespace",
+			options: Options{Name: "slurm-exporter", Identity: "a"},
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			options: Options{Namespace: "slurm", Identity: "a"},
+			wantErr: true,
+		},
+		{
+			name:    "no identity",
+			options: Options{Namespace: "slurm", Name: "slurm-exporter"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewElector(fake.NewClientset(), tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewElector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// runElector runs the election until the returned function is called, which
+// waits for it to return.
+func runElector(t *testing.T, elector *Elector, led chan<- string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := elector.Run(ctx, func(ctx context.Context) {
+			led <- elector.options.Identity
+			<-ctx.Done()
+		})
+		assert.NoError(t, err)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// podLabel returns the LeaderLabel of the pod.
+func podLabel(t *testing.T, kubeClient kubernetes.Interface, name string) string {
+	pod, err := kubeClient.CoreV1().Pods("slurm").Get(context.Background(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	return pod.Labels[LeaderLabel]
+}
+
+func TestElector_Run(t *testing.T) {
+	kubeClient := fake.NewClientset(
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "slurm", Name: "a", Labels: map[string]string{LeaderLabel: "true"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "slurm", Name: "b"}},
+	)
+	electors := map[string]*Elector{
+		"a": newTestElector(t, kubeClient, "a"),
+		"b": newTestElector(t, kubeClient, "b"),
+	}
+	led := make(chan string, 2)
+	stop := map[string]func(){
+		"a": runElector(t, electors["a"], led),
+		"b": runElector(t, electors["b"], led),
+	}
+
+	leader := <-led
+	follower := "a"
+	if leader == "a" {
+		follower = "b"
+	}
+	assert.True(t, electors[leader].IsLeader())
+	assert.False(t, electors[follower].IsLeader())
+	assert.Equal(t, "true", podLabel(t, kubeClient, leader))
+	assert.Eventually(t, func() bool { return podLabel(t, kubeClient, follower) == "false" }, time.Second, time.Millisecond)
+
+	// The follower takes over once the leader releases the Lease.
+	stop[leader]()
+	assert.False(t, electors[leader].IsLeader())
+	select {
+	case got := <-led:
+		assert.Equal(t, follower, got)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not take over the leadership", follower)
+	}
+	assert.True(t, electors[follower].IsLeader())
+	assert.Equal(t, "false", podLabel(t, kubeClient, leader))
+	assert.Equal(t, "true", podLabel(t, kubeClient, follower))
+	stop[follower]()
+}
+
+func TestElector_Gatherer(t *testing.T) {
+	elector := newTestElector(t, fake.NewClientset(), "a")
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "slurm_test"}))
+	gatherer := elector.Gatherer(registry)
+
+	got, err := gatherer.Gather()
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	elector.leading.Store(true)
+	got, err = gatherer.Gather()
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestElector_NewCollector(t *testing.T) {
+	elector := newTestElector(t, fake.NewClientset(), "a")
+	c := elector.NewCollector()
+	assert.Equal(t, float64(0), testutil.ToFloat64(c))
+	elector.leading.Store(true)
+	assert.Equal(t, float64(1), testutil.ToFloat64(c))
+}
@@ -0,0 +1,545 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+
+	slurmclient "github.com/SlinkyProject/slurm-exporter/internal/client"
+)
+
+// The protocols of the OTLP endpoint.
+// Ref: https://opentelemetry.io/docs/specs/otlp/
+const (
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http/protobuf"
+)
+
+// The attributes of the resource of the metrics.
+const (
+	AttributeServiceName      = "service.name"
+	AttributeSlurmClusterName = "slurm.cluster.name"
+	AttributeSlurmVersion     = "slurm.version"
+)
+
+const (
+	serviceName = "slurm-exporter"
+	scopeName   = "github.com/SlinkyProject/slurm-exporter"
+	userAgent   = "slurm-exporter"
+)
+
+// resourceInterval is how often the detected attributes of the resource are
+// refreshed, e.g. for the Slurm release after an upgrade.
+const resourceInterval = 10 * time.Minute
+
+// Options configure an Exporter.
+type Options struct {
+	// Endpoint is the OTLP endpoint, as host:port for gRPC, or else the URL
+	// of the metrics (e.g. http://collector:4318/v1/metrics).
+	Endpoint string
+	// Protocol is either ProtocolGRPC or ProtocolHTTP.
+	Protocol string
+	// Insecure disables the TLS of the gRPC connection.
+	Insecure bool
+	// Interval is how often the metrics are gathered and exported.
+	Interval time.Duration
+	// Timeout bounds every export.
+	Timeout time.Duration
+	// Headers are added to every export, e.g. for authorization.
+	Headers map[string]string
+	// ResourceAttributes are added to the resource of the metrics, taking
+	// precedence over the detected attributes.
+	ResourceAttributes map[string]string
+}
+
+// ResourceFunc detects the attributes of the resource of the metrics.
+type ResourceFunc func(ctx context.Context) (map[string]string, error)
+
+// NewSlurmResource returns a ResourceFunc of the name and release of the Slurm
+// cluster, as reported by slurmctld.
+func NewSlurmResource(slurmClient client.Client) ResourceFunc {
+	return func(ctx context.Context) (map[string]string, error) {
+		info, err := slurmclient.GetControllerInfo(ctx, slurmClient)
+		if err != nil {
+			return nil, err
+		}
+		attributes := map[string]string{}
+		if info.Cluster != "" {
+			attributes[AttributeSlurmClusterName] = info.Cluster
+		}
+		if info.Release != "" {
+			attributes[AttributeSlurmVersion] = info.Release
+		}
+		return attributes, nil
+	}
+}
+
+// Exporter gathers the metrics on an interval and exports them to an OTLP
+// endpoint, over gRPC or HTTP, for observability platforms which are OTLP
+// native.
+//
+// Counters, histograms and summaries are exported as cumulative, hence a
+// failed export is not retried, the next export carries its data points.
+type Exporter struct {
+	gatherer func(ctx context.Context) prometheus.Gatherer
+	detect   ResourceFunc
+	options  Options
+	starts   *startTimes
+
+	// send exports the request over the protocol of the endpoint.
+	send func(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) error
+	// conn is the gRPC connection, if the protocol is gRPC.
+	conn *grpc.ClientConn
+
+	// detected are the attributes of the resource, as last detected
+	// successfully at detectedAt.
+	detected   map[string]string
+	detectedAt time.Time
+
+	dataPoints atomic.Uint64
+	failures   atomic.Uint64
+}
+
+// NewExporter returns an Exporter of the metrics of the gatherer, which returns
+// the Gatherer of each export, bound by the context of the export. The resource
+// is detected by the detect function, if set, on the first export and then
+// every resourceInterval.
+func NewExporter(gatherer func(ctx context.Context) prometheus.Gatherer, detect ResourceFunc, options Options) (*Exporter, error) {
+	if options.Endpoint == "" {
+		return nil, errors.New("otlp endpoint must not be empty")
+	}
+	if options.Interval <= 0 {
+		return nil, errors.New("otlp interval > 0")
+	}
+	e := &Exporter{
+		gatherer: gatherer,
+		detect:   detect,
+		options:  options,
+		starts:   newStartTimes(time.Now()),
+	}
+	switch options.Protocol {
+	case ProtocolGRPC:
+		creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+		if options.Insecure {
+			creds = insecure.NewCredentials()
+		}
+		conn, err := grpc.NewClient(options.Endpoint, grpc.WithTransportCredentials(creds), grpc.WithUserAgent(userAgent))
+		if err != nil {
+			return nil, err
+		}
+		e.conn = conn
+		e.send = e.sendGRPC(collectorpb.NewMetricsServiceClient(conn))
+	case ProtocolHTTP:
+		e.send = e.sendHTTP(&http.Client{})
+	default:
+		return nil, fmt.Errorf("otlp protocol must be one of: %s, %s", ProtocolGRPC, ProtocolHTTP)
+	}
+	return e, nil
+}
+
+// Start gathers and exports the metrics until the context is done.
+func (e *Exporter) Start(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("OTLPExporter")
+
+	if e.conn != nil {
+		defer func() { _ = e.conn.Close() }()
+	}
+	ticker := time.NewTicker(e.options.Interval)
+	defer ticker.Stop()
+	for {
+		if err := e.export(ctx); err != nil && ctx.Err() == nil {
+			logger.Error(err, "failed to export metrics", "endpoint", e.options.Endpoint)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// export exports the gathered metrics.
+func (e *Exporter) export(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("OTLPExporter")
+
+	if e.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.options.Timeout)
+		defer cancel()
+	}
+	families, err := e.gatherer(ctx).Gather()
+	if err != nil {
+		// Like promhttp, whatever was gathered is still exported.
+		logger.Error(err, "failed to gather some metrics")
+	}
+	req, dataPoints := toExportRequest(families, e.resource(ctx), e.starts, time.Now())
+	if dataPoints == 0 {
+		return nil
+	}
+	if err := e.send(ctx, req); err != nil {
+		e.failures.Add(1)
+		return err
+	}
+	e.dataPoints.Add(uint64(dataPoints))
+	return nil
+}
+
+// resource returns the resource of the metrics, of the detected attributes
+// overridden by the configured ones. The detected attributes are cached for
+// the resourceInterval. While detection fails, it is retried on every export
+// and the attributes which were last detected are used.
+func (e *Exporter) resource(ctx context.Context) *resourcepb.Resource {
+	logger := log.FromContext(ctx).WithName("OTLPExporter")
+
+	if now := time.Now(); e.detect != nil && (e.detectedAt.IsZero() || now.Sub(e.detectedAt) >= resourceInterval) {
+		detected, err := e.detect(ctx)
+		if err != nil {
+			logger.V(1).Info("failed to detect the resource attributes", "err", err.Error())
+		} else {
+			e.detected = detected
+			e.detectedAt = now
+		}
+	}
+	attributes := map[string]string{AttributeServiceName: serviceName}
+	maps.Copy(attributes, e.detected)
+	maps.Copy(attributes, e.options.ResourceAttributes)
+
+	resource := &resourcepb.Resource{}
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		resource.Attributes = append(resource.Attributes, stringAttribute(key, attributes[key]))
+	}
+	return resource
+}
+
+// sendGRPC exports by the Export call of the metrics service.
+func (e *Exporter) sendGRPC(metricsClient collectorpb.MetricsServiceClient) func(context.Context, *collectorpb.ExportMetricsServiceRequest) error {
+	return func(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) error {
+		for name, value := range e.options.Headers {
+			ctx = metadata.AppendToOutgoingContext(ctx, name, value)
+		}
+		resp, err := metricsClient.Export(ctx, req)
+		if err != nil {
+			return err
+		}
+		return partialSuccessError(resp)
+	}
+}
+
+// sendHTTP exports by posting the protobuf encoded request.
+func (e *Exporter) sendHTTP(httpClient *http.Client) func(context.Context, *collectorpb.ExportMetricsServiceRequest) error {
+	return func(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) error {
+		body, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.options.Endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/x-protobuf")
+		httpReq.Header.Set("User-Agent", userAgent)
+		for name, value := range e.options.Headers {
+			httpReq.Header.Set(name, value)
+		}
+
+		resp, err := httpClient.Do(httpReq)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("otlp endpoint responded with %s", resp.Status)
+		}
+		exportResp := &collectorpb.ExportMetricsServiceResponse{}
+		if err := proto.Unmarshal(data, exportResp); err != nil {
+			// The response is optional to parse, the export succeeded.
+			return nil
+		}
+		return partialSuccessError(exportResp)
+	}
+}
+
+// partialSuccessError returns an error if the endpoint rejected data points.
+func partialSuccessError(resp *collectorpb.ExportMetricsServiceResponse) error {
+	partial := resp.GetPartialSuccess()
+	if partial.GetRejectedDataPoints() == 0 {
+		return nil
+	}
+	return fmt.Errorf("otlp endpoint rejected %d data points: %s", partial.GetRejectedDataPoints(), partial.GetErrorMessage())
+}
+
+// toExportRequest converts the metric families into an export request, and
+// returns it along with its number of data points. The data points are at the
+// given time unless the metrics carry their timestamp, and cumulative data
+// points start at their created timestamp, or else at their start time.
+// Gauge histograms and native histograms are not exported.
+func toExportRequest(families []*dto.MetricFamily, resource *resourcepb.Resource, starts *startTimes, now time.Time) (*collectorpb.ExportMetricsServiceRequest, int) {
+	starts.begin()
+	defer starts.end()
+
+	scopeMetrics := &metricspb.ScopeMetrics{
+		Scope: &commonpb.InstrumentationScope{Name: scopeName},
+	}
+	dataPoints := 0
+	for _, family := range families {
+		otlpMetric := &metricspb.Metric{
+			Name:        family.GetName(),
+			Description: family.GetHelp(),
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := &metricspb.Sum{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}
+			for _, metric := range family.GetMetric() {
+				counter := metric.GetCounter()
+				sum.DataPoints = append(sum.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:        attributes(metric),
+					StartTimeUnixNano: startTime(counter.GetCreatedTimestamp(), starts.of(family, metric, counter.GetValue(), now)),
+					TimeUnixNano:      timestamp(metric, now),
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: counter.GetValue()},
+				})
+			}
+			dataPoints += len(sum.DataPoints)
+			otlpMetric.Data = &metricspb.Metric_Sum{Sum: sum}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			gauge := &metricspb.Gauge{}
+			for _, metric := range family.GetMetric() {
+				value := metric.GetGauge().GetValue()
+				if family.GetType() == dto.MetricType_UNTYPED {
+					value = metric.GetUntyped().GetValue()
+				}
+				gauge.DataPoints = append(gauge.DataPoints, &metricspb.NumberDataPoint{
+					Attributes:   attributes(metric),
+					TimeUnixNano: timestamp(metric, now),
+					Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				})
+			}
+			dataPoints += len(gauge.DataPoints)
+			otlpMetric.Data = &metricspb.Metric_Gauge{Gauge: gauge}
+		case dto.MetricType_HISTOGRAM:
+			histogram := &metricspb.Histogram{
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			}
+			for _, metric := range family.GetMetric() {
+				start := starts.of(family, metric, float64(metric.GetHistogram().GetSampleCount()), now)
+				histogram.DataPoints = append(histogram.DataPoints, histogramDataPoint(metric, start, now))
+			}
+			dataPoints += len(histogram.DataPoints)
+			otlpMetric.Data = &metricspb.Metric_Histogram{Histogram: histogram}
+		case dto.MetricType_SUMMARY:
+			summary := &metricspb.Summary{}
+			for _, metric := range family.GetMetric() {
+				dtoSummary := metric.GetSummary()
+				dataPoint := &metricspb.SummaryDataPoint{
+					Attributes:        attributes(metric),
+					StartTimeUnixNano: startTime(dtoSummary.GetCreatedTimestamp(), starts.of(family, metric, float64(dtoSummary.GetSampleCount()), now)),
+					TimeUnixNano:      timestamp(metric, now),
+					Count:             dtoSummary.GetSampleCount(),
+					Sum:               dtoSummary.GetSampleSum(),
+				}
+				for _, quantile := range dtoSummary.GetQuantile() {
+					dataPoint.QuantileValues = append(dataPoint.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+						Quantile: quantile.GetQuantile(),
+						Value:    quantile.GetValue(),
+					})
+				}
+				summary.DataPoints = append(summary.DataPoints, dataPoint)
+			}
+			dataPoints += len(summary.DataPoints)
+			otlpMetric.Data = &metricspb.Metric_Summary{Summary: summary}
+		default:
+			continue
+		}
+		scopeMetrics.Metrics = append(scopeMetrics.Metrics, otlpMetric)
+	}
+	return &collectorpb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource:     resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{scopeMetrics},
+		}},
+	}, dataPoints
+}
+
+// startTimes remembers the start time of the cumulative series, along with
+// their last value, such that a series which decreases (e.g. as Slurm resets
+// its statistics) starts anew. Series which are not exported are forgotten.
+type startTimes struct {
+	// start is the start time of the series which were never reset.
+	start time.Time
+
+	series map[string]seriesStart
+	// seen are the series of the current export.
+	seen map[string]seriesStart
+}
+
+type seriesStart struct {
+	start time.Time
+	value float64
+	time  time.Time
+}
+
+func newStartTimes(start time.Time) *startTimes {
+	return &startTimes{
+		start:  start,
+		series: make(map[string]seriesStart),
+	}
+}
+
+// begin starts an export.
+func (s *startTimes) begin() {
+	s.seen = make(map[string]seriesStart, len(s.series))
+}
+
+// end forgets the series which were not seen by the export.
+func (s *startTimes) end() {
+	s.series, s.seen = s.seen, nil
+}
+
+// of records the value of the series of the metric and returns its start
+// time. When the value decreased, the series was reset after it was last
+// seen, which is its new start time.
+func (s *startTimes) of(family *dto.MetricFamily, metric *dto.Metric, value float64, now time.Time) time.Time {
+	key := seriesKey(family, metric)
+	series, ok := s.series[key]
+	switch {
+	case !ok:
+		series.start = s.start
+	case value < series.value:
+		series.start = series.time
+	}
+	series.value = value
+	series.time = now
+	s.seen[key] = series
+	return series.start
+}
+
+// seriesKey identifies a series by the name of its family and its labels,
+// which are sorted by name.
+func seriesKey(family *dto.MetricFamily, metric *dto.Metric) string {
+	var key strings.Builder
+	key.WriteString(family.GetName())
+	for _, label := range metric.GetLabel() {
+		key.WriteByte(0xff)
+		key.WriteString(label.GetName())
+		key.WriteByte('=')
+		key.WriteString(label.GetValue())
+	}
+	return key.String()
+}
+
+// histogramDataPoint converts the cumulative buckets of a Prometheus histogram
+// into the bucket counts of OTLP, where the last bucket counts the
+// observations above the last bound.
+func histogramDataPoint(metric *dto.Metric, start, now time.Time) *metricspb.HistogramDataPoint {
+	histogram := metric.GetHistogram()
+	dataPoint := &metricspb.HistogramDataPoint{
+		Attributes:        attributes(metric),
+		StartTimeUnixNano: startTime(histogram.GetCreatedTimestamp(), start),
+		TimeUnixNano:      timestamp(metric, now),
+		Count:             histogram.GetSampleCount(),
+		Sum:               histogram.SampleSum,
+	}
+	var last uint64
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			break
+		}
+		dataPoint.ExplicitBounds = append(dataPoint.ExplicitBounds, bucket.GetUpperBound())
+		dataPoint.BucketCounts = append(dataPoint.BucketCounts, bucket.GetCumulativeCount()-last)
+		last = bucket.GetCumulativeCount()
+	}
+	dataPoint.BucketCounts = append(dataPoint.BucketCounts, histogram.GetSampleCount()-last)
+	return dataPoint
+}
+
+// attributes returns the labels of the metric as attributes.
+func attributes(metric *dto.Metric) []*commonpb.KeyValue {
+	attributes := make([]*commonpb.KeyValue, 0, len(metric.GetLabel()))
+	for _, label := range metric.GetLabel() {
+		attributes = append(attributes, stringAttribute(label.GetName(), label.GetValue()))
+	}
+	return attributes
+}
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   key,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+	}
+}
+
+// timestamp returns the timestamp of the metric, or else now.
+func timestamp(metric *dto.Metric, now time.Time) uint64 {
+	if metric.TimestampMs != nil {
+		return uint64(time.UnixMilli(metric.GetTimestampMs()).UnixNano())
+	}
+	return uint64(now.UnixNano())
+}
+
+// startTime returns the created timestamp, if set, or else the start time.
+func startTime(created *timestamppb.Timestamp, start time.Time) uint64 {
+	if created != nil {
+		return uint64(created.AsTime().UnixNano())
+	}
+	return uint64(start.UnixNano())
+}
+
+// NewCollector exports the progress of the OTLP export.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func (e *Exporter) NewCollector() prometheus.Collector {
+	return &exporterCollector{
+		exporter: e,
+
+		DataPoints: prometheus.NewDesc("slurm_exporter_otlp_data_points_total", "Number of data points exported to the OTLP endpoint", nil, nil),
+		Failures:   prometheus.NewDesc("slurm_exporter_otlp_failures_total", "Number of failed exports to the OTLP endpoint", nil, nil),
+	}
+}
+
+type exporterCollector struct {
+	exporter *Exporter
+
+	DataPoints *prometheus.Desc
+	Failures   *prometheus.Desc
+}
+
+func (c *exporterCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *exporterCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.DataPoints, prometheus.CounterValue, float64(c.exporter.dataPoints.Load()))
+	ch <- prometheus.MustNewConstMetric(c.Failures, prometheus.CounterValue, float64(c.exporter.failures.Load()))
+}
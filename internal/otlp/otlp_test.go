@@ -0,0 +1,332 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package otlp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"k8s.io/utils/ptr"
+
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+)
+
+// metricsServer is an OTLP/gRPC endpoint, which records the exports.
+type metricsServer struct {
+	collectorpb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*collectorpb.ExportMetricsServiceRequest
+	metadata []metadata.MD
+}
+
+func (s *metricsServer) Export(ctx context.Context, req *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.requests = append(s.requests, req)
+	s.metadata = append(s.metadata, md)
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func newTestGatherer() func(context.Context) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_nodes", Help: "Number of nodes"}, []string{"state"})
+	gauge.WithLabelValues("idle").Set(3)
+	registry.MustRegister(gauge)
+	return func(context.Context) prometheus.Gatherer {
+		return registry
+	}
+}
+
+func newTestOptions(endpoint, protocol string) Options {
+	return Options{
+		Endpoint: endpoint,
+		Protocol: protocol,
+		Insecure: true,
+		Interval: time.Hour,
+		Timeout:  time.Second,
+	}
+}
+
+// resourceAttributes returns the attributes of the resource of the request.
+func resourceAttributes(req *collectorpb.ExportMetricsServiceRequest) map[string]string {
+	attributes := map[string]string{}
+	for _, attribute := range req.GetResourceMetrics()[0].GetResource().GetAttributes() {
+		attributes[attribute.GetKey()] = attribute.GetValue().GetStringValue()
+	}
+	return attributes
+}
+
+func TestNewExporter(t *testing.T) {
+	options := newTestOptions("localhost:4317", ProtocolGRPC)
+	_, err := NewExporter(newTestGatherer(), nil, options)
+	assert.NoError(t, err)
+	_, err = NewExporter(newTestGatherer(), nil, newTestOptions("http://localhost:4318/v1/metrics", ProtocolHTTP))
+	assert.NoError(t, err)
+
+	invalid := options
+	invalid.Endpoint = ""
+	_, err = NewExporter(newTestGatherer(), nil, invalid)
+	assert.Error(t, err)
+	invalid = options
+	invalid.Interval = 0
+	_, err = NewExporter(newTestGatherer(), nil, invalid)
+	assert.Error(t, err)
+	invalid = options
+	invalid.Protocol = "http/json"
+	_, err = NewExporter(newTestGatherer(), nil, invalid)
+	assert.Error(t, err)
+}
+
+func TestExporter_grpc(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	receiver := &metricsServer{}
+	collectorpb.RegisterMetricsServiceServer(server, receiver)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	options := newTestOptions(listener.Addr().String(), ProtocolGRPC)
+	options.Headers = map[string]string{"X-Scope-OrgID": "slurm"}
+	options.ResourceAttributes = map[string]string{"deployment.environment": "prod", AttributeSlurmClusterName: "override"}
+	detect := func(_ context.Context) (map[string]string, error) {
+		return map[string]string{AttributeSlurmClusterName: "linux", AttributeSlurmVersion: "25.05.0"}, nil
+	}
+	exporter, err := NewExporter(newTestGatherer(), detect, options)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exporter.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return exporter.dataPoints.Load() == 1 }, 5*time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"slurm"}, receiver.metadata[0].Get("X-Scope-OrgID"))
+	req := receiver.requests[0]
+	assert.Equal(t, map[string]string{
+		AttributeServiceName:      "slurm-exporter",
+		AttributeSlurmClusterName: "override",
+		AttributeSlurmVersion:     "25.05.0",
+		"deployment.environment":  "prod",
+	}, resourceAttributes(req))
+	metrics := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	assert.Len(t, metrics, 1)
+	assert.Equal(t, "slurm_nodes", metrics[0].GetName())
+	assert.Equal(t, 3.0, metrics[0].GetGauge().GetDataPoints()[0].GetAsDouble())
+}
+
+func TestExporter_http(t *testing.T) {
+	var mu sync.Mutex
+	status := http.StatusOK
+	var requests []*collectorpb.ExportMetricsServiceRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &collectorpb.ExportMetricsServiceRequest{}
+		assert.NoError(t, proto.Unmarshal(body, req))
+		requests = append(requests, req)
+		data, _ := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(data)
+	}))
+	defer server.Close()
+
+	options := newTestOptions(server.URL+"/v1/metrics", ProtocolHTTP)
+	options.Headers = map[string]string{"Authorization": "Bearer secret"}
+	exporter, err := NewExporter(newTestGatherer(), nil, options)
+	assert.NoError(t, err)
+
+	assert.NoError(t, exporter.export(context.Background()))
+	assert.Len(t, requests, 1)
+	assert.Equal(t, map[string]string{AttributeServiceName: "slurm-exporter"}, resourceAttributes(requests[0]))
+	assert.Equal(t, uint64(1), exporter.dataPoints.Load())
+
+	status = http.StatusServiceUnavailable
+	assert.Error(t, exporter.export(context.Background()))
+	assert.Equal(t, uint64(1), exporter.failures.Load())
+}
+
+func TestExporter_resource(t *testing.T) {
+	var err error
+	detections := 0
+	detect := func(_ context.Context) (map[string]string, error) {
+		detections++
+		return map[string]string{AttributeSlurmClusterName: "linux"}, err
+	}
+	exporter, _ := NewExporter(newTestGatherer(), detect, newTestOptions("http://localhost:4318/v1/metrics", ProtocolHTTP))
+
+	get := func() map[string]string {
+		req, _ := toExportRequest(nil, exporter.resource(context.Background()), newStartTimes(time.Now()), time.Now())
+		return resourceAttributes(req)
+	}
+	want := map[string]string{AttributeServiceName: "slurm-exporter", AttributeSlurmClusterName: "linux"}
+	assert.Equal(t, want, get())
+	// The detected attributes are cached.
+	assert.Equal(t, want, get())
+	assert.Equal(t, 1, detections)
+
+	// The attributes which were last detected are kept while detection fails.
+	err = errors.New("slurmctld is down")
+	exporter.detectedAt = exporter.detectedAt.Add(-resourceInterval)
+	assert.Equal(t, want, get())
+	assert.Equal(t, want, get())
+	assert.Equal(t, 3, detections)
+}
+
+func TestNewSlurmResource(t *testing.T) {
+	// Clients which were not created by NewSlurmClient have no controller info.
+	got, err := NewSlurmResource(fake.NewFakeClient())(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestExporter_NewCollector(t *testing.T) {
+	exporter, err := NewExporter(newTestGatherer(), nil, newTestOptions("http://localhost:4318/v1/metrics", ProtocolHTTP))
+	assert.NoError(t, err)
+	exporter.dataPoints.Add(5)
+	exporter.failures.Add(1)
+
+	assert.NoError(t, testutil.CollectAndCompare(exporter.NewCollector(), strings.NewReader(`
+# HELP slurm_exporter_otlp_data_points_total Number of data points exported to the OTLP endpoint
+# TYPE slurm_exporter_otlp_data_points_total counter
+slurm_exporter_otlp_data_points_total 5
+# HELP slurm_exporter_otlp_failures_total Number of failed exports to the OTLP endpoint
+# TYPE slurm_exporter_otlp_failures_total counter
+slurm_exporter_otlp_failures_total 1
+`)))
+}
+
+func Test_toExportRequest(t *testing.T) {
+	start := time.Unix(100, 0)
+	now := time.Unix(200, 0)
+	families := []*dto.MetricFamily{
+		{
+			Name: ptr.To("slurm_jobs_submitted_total"),
+			Help: ptr.To("Number of submitted jobs"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{{Name: ptr.To("partition"), Value: ptr.To("gpu")}},
+				Counter: &dto.Counter{
+					Value:            ptr.To(5.0),
+					CreatedTimestamp: timestamppb.New(time.Unix(150, 0)),
+				},
+			}},
+		},
+		{
+			Name: ptr.To("slurm_node_unavailable_duration_seconds"),
+			Help: ptr.To("Duration of node unavailability"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: ptr.To[uint64](5),
+					SampleSum:   ptr.To(400.0),
+					Bucket: []*dto.Bucket{
+						{UpperBound: ptr.To(60.0), CumulativeCount: ptr.To[uint64](2)},
+						{UpperBound: ptr.To(300.0), CumulativeCount: ptr.To[uint64](4)},
+					},
+				},
+			}},
+		},
+		{
+			Name: ptr.To("slurm_scheduler_cycle_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{{
+				Summary: &dto.Summary{
+					SampleCount: ptr.To[uint64](2),
+					SampleSum:   ptr.To(3.0),
+					Quantile:    []*dto.Quantile{{Quantile: ptr.To(0.5), Value: ptr.To(1.0)}},
+				},
+				TimestampMs: ptr.To[int64](190_000),
+			}},
+		},
+	}
+	req, dataPoints := toExportRequest(families, nil, newStartTimes(start), now)
+	assert.Equal(t, 3, dataPoints)
+	metrics := req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+	assert.Len(t, metrics, 3)
+
+	sum := metrics[0].GetSum()
+	assert.Equal(t, "Number of submitted jobs", metrics[0].GetDescription())
+	assert.True(t, sum.GetIsMonotonic())
+	assert.Equal(t, metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, sum.GetAggregationTemporality())
+	counter := sum.GetDataPoints()[0]
+	assert.Equal(t, "partition", counter.GetAttributes()[0].GetKey())
+	assert.Equal(t, "gpu", counter.GetAttributes()[0].GetValue().GetStringValue())
+	assert.Equal(t, 5.0, counter.GetAsDouble())
+	assert.Equal(t, uint64(time.Unix(150, 0).UnixNano()), counter.GetStartTimeUnixNano())
+	assert.Equal(t, uint64(now.UnixNano()), counter.GetTimeUnixNano())
+
+	histogram := metrics[1].GetHistogram().GetDataPoints()[0]
+	assert.Equal(t, []float64{60, 300}, histogram.GetExplicitBounds())
+	assert.Equal(t, []uint64{2, 2, 1}, histogram.GetBucketCounts())
+	assert.Equal(t, uint64(5), histogram.GetCount())
+	assert.Equal(t, 400.0, histogram.GetSum())
+	assert.Equal(t, uint64(start.UnixNano()), histogram.GetStartTimeUnixNano())
+
+	summary := metrics[2].GetSummary().GetDataPoints()[0]
+	assert.Equal(t, uint64(2), summary.GetCount())
+	assert.Equal(t, 1.0, summary.GetQuantileValues()[0].GetValue())
+	assert.Equal(t, uint64(time.Unix(190, 0).UnixNano()), summary.GetTimeUnixNano())
+}
+
+func Test_startTimes(t *testing.T) {
+	start := time.Unix(100, 0)
+	counter := func(partition string, value float64) *dto.MetricFamily {
+		return &dto.MetricFamily{
+			Name: ptr.To("slurm_scheduler_backfilled_jobs_total"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label:   []*dto.LabelPair{{Name: ptr.To("partition"), Value: ptr.To(partition)}},
+				Counter: &dto.Counter{Value: ptr.To(value)},
+			}},
+		}
+	}
+	starts := newStartTimes(start)
+	export := func(family *dto.MetricFamily, now time.Time) uint64 {
+		req, _ := toExportRequest([]*dto.MetricFamily{family}, nil, starts, now)
+		return req.GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()[0].GetSum().GetDataPoints()[0].GetStartTimeUnixNano()
+	}
+
+	assert.Equal(t, uint64(start.UnixNano()), export(counter("gpu", 5), time.Unix(200, 0)))
+	assert.Equal(t, uint64(start.UnixNano()), export(counter("gpu", 8), time.Unix(300, 0)))
+	// A series which decreased was reset after it was last exported.
+	assert.Equal(t, uint64(time.Unix(300, 0).UnixNano()), export(counter("gpu", 2), time.Unix(400, 0)))
+	assert.Equal(t, uint64(time.Unix(300, 0).UnixNano()), export(counter("gpu", 4), time.Unix(500, 0)))
+
+	// Series which were not exported are forgotten.
+	export(counter("cpu", 1), time.Unix(600, 0))
+	assert.Len(t, starts.series, 1)
+	assert.Equal(t, uint64(start.UnixNano()), export(counter("gpu", 1), time.Unix(700, 0)))
+}
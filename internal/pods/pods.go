@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package pods
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+// nodeSetKind is the kind of the Slinky NodeSet, which owns the pods of its
+// Slurm nodes.
+const nodeSetKind = "NodeSet"
+
+// Options select the pods which Slurm nodes run in.
+type Options struct {
+	// Namespace of the pods. If empty, the pods of all namespaces.
+	Namespace string
+	// Selector of the pods. If nil, all pods.
+	Selector labels.Selector
+}
+
+// Watcher watches the pods which Slurm nodes run in, such that the nodes can
+// be mapped to them.
+type Watcher struct {
+	// cache is nil when the reader is not a cache.
+	cache   cache.Cache
+	reader  client.Reader
+	options Options
+}
+
+var _ collector.NodePods = &Watcher{}
+
+// NewWatcher returns a Watcher of the pods selected by the options, which
+// watches them once started.
+func NewWatcher(restConfig *rest.Config, options Options) (*Watcher, error) {
+	byObject := cache.ByObject{Label: options.Selector}
+	if options.Namespace != "" {
+		byObject.Namespaces = map[string]cache.Config{options.Namespace: {}}
+	}
+	podCache, err := cache.New(restConfig, cache.Options{
+		ByObject:         map[client.Object]cache.ByObject{&corev1.Pod{}: byObject},
+		DefaultTransform: cache.TransformStripManagedFields(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	w := newWatcher(podCache, options)
+	w.cache = podCache
+	return w, nil
+}
+
+func newWatcher(reader client.Reader, options Options) *Watcher {
+	return &Watcher{
+		reader:  reader,
+		options: options,
+	}
+}
+
+// Start watches the pods until the context is done.
+func (w *Watcher) Start(ctx context.Context) error {
+	if w.cache == nil {
+		return nil
+	}
+	return w.cache.Start(ctx)
+}
+
+// Pods returns the pods of the Slurm nodes, by node name. A Slurm node runs in
+// the pod whose hostname, or else name, is the node name. Pods which have
+// completed are ignored, and terminating pods only count when they have no
+// replacement yet.
+func (w *Watcher) Pods(ctx context.Context) (map[string]*corev1.Pod, error) {
+	var opts []client.ListOption
+	if w.options.Namespace != "" {
+		opts = append(opts, client.InNamespace(w.options.Namespace))
+	}
+	if w.options.Selector != nil {
+		opts = append(opts, client.MatchingLabelsSelector{Selector: w.options.Selector})
+	}
+	podList := &corev1.PodList{}
+	if err := w.reader.List(ctx, podList, opts...); err != nil {
+		return nil, err
+	}
+
+	pods := make(map[string]*corev1.Pod, len(podList.Items))
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		node := slurmNodeName(pod)
+		if other, ok := pods[node]; ok && other.DeletionTimestamp == nil {
+			continue
+		}
+		pods[node] = pod
+	}
+	return pods, nil
+}
+
+// NodePods implements collector.NodePods.
+func (w *Watcher) NodePods(ctx context.Context) (map[string]collector.NodePod, error) {
+	pods, err := w.Pods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodePods := make(map[string]collector.NodePod, len(pods))
+	for node, pod := range pods {
+		nodePods[node] = collector.NodePod{
+			Namespace: pod.Namespace,
+			Pod:       pod.Name,
+			K8sNode:   pod.Spec.NodeName,
+			NodeSet:   nodeSetName(pod),
+		}
+	}
+	return nodePods, nil
+}
+
+// slurmNodeName returns the name of the Slurm node which runs in the pod.
+func slurmNodeName(pod *corev1.Pod) string {
+	if pod.Spec.Hostname != "" {
+		return pod.Spec.Hostname
+	}
+	return pod.Name
+}
+
+// nodeSetName returns the name of the NodeSet which controls the pod, if any.
+func nodeSetName(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != nodeSetKind {
+		return ""
+	}
+	return owner.Name
+}
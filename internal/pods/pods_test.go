@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package pods
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+func newPod(namespace, name string, mutate func(pod *corev1.Pod)) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"app.kubernetes.io/name": "slurmd"},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "kube-node-0",
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+func ownedByNodeSet(name string) func(pod *corev1.Pod) {
+	return func(pod *corev1.Pod) {
+		pod.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "slinky.slurm.net/v1alpha1",
+			Kind:       nodeSetKind,
+			Name:       name,
+			UID:        "uid",
+			Controller: ptr.To(true),
+		}}
+	}
+}
+
+func TestWatcher_NodePods(t *testing.T) {
+	terminating := func(pod *corev1.Pod) {
+		pod.DeletionTimestamp = ptr.To(metav1.Now())
+		pod.Finalizers = []string{"test"}
+	}
+	kubeClient := fake.NewClientBuilder().WithObjects(
+		newPod("slurm", "gpu-0", ownedByNodeSet("gpu")),
+		newPod("slurm", "gpu-1", func(pod *corev1.Pod) {
+			ownedByNodeSet("gpu")(pod)
+			pod.Spec.Hostname = "gpu-a100-1"
+		}),
+		newPod("slurm", "cpu-0", func(pod *corev1.Pod) {
+			pod.Status.Phase = corev1.PodSucceeded
+		}),
+		newPod("slurm", "standalone", func(pod *corev1.Pod) {
+			pod.Spec.NodeName = ""
+			pod.Status.Phase = corev1.PodPending
+		}),
+		// A terminating pod and its replacement.
+		newPod("slurm", "old", func(pod *corev1.Pod) {
+			terminating(pod)
+			pod.Spec.Hostname = "cpu-1"
+		}),
+		newPod("slurm", "new", func(pod *corev1.Pod) {
+			pod.Spec.Hostname = "cpu-1"
+			pod.Spec.NodeName = "kube-node-1"
+		}),
+		newPod("slurm", "gone", func(pod *corev1.Pod) {
+			terminating(pod)
+			pod.Spec.Hostname = "cpu-2"
+		}),
+		newPod("slurm", "login-0", func(pod *corev1.Pod) {
+			pod.Labels = nil
+		}),
+		newPod("other", "gpu-2", nil),
+	).Build()
+
+	tests := []struct {
+		name    string
+		options Options
+		want    map[string]collector.NodePod
+	}{
+		{
+			name: "namespace and selector",
+			options: Options{
+				Namespace: "slurm",
+				Selector:  labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "slurmd"}),
+			},
+			want: map[string]collector.NodePod{
+				"gpu-0":      {Namespace: "slurm", Pod: "gpu-0", K8sNode: "kube-node-0", NodeSet: "gpu"},
+				"gpu-a100-1": {Namespace: "slurm", Pod: "gpu-1", K8sNode: "kube-node-0", NodeSet: "gpu"},
+				"standalone": {Namespace: "slurm", Pod: "standalone"},
+				"cpu-1":      {Namespace: "slurm", Pod: "new", K8sNode: "kube-node-1"},
+				"cpu-2":      {Namespace: "slurm", Pod: "gone", K8sNode: "kube-node-0"},
+			},
+		},
+		{
+			name: "all namespaces",
+			options: Options{
+				Selector: labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "slurmd"}),
+			},
+			want: map[string]collector.NodePod{
+				"gpu-0":      {Namespace: "slurm", Pod: "gpu-0", K8sNode: "kube-node-0", NodeSet: "gpu"},
+				"gpu-a100-1": {Namespace: "slurm", Pod: "gpu-1", K8sNode: "kube-node-0", NodeSet: "gpu"},
+				"gpu-2":      {Namespace: "other", Pod: "gpu-2", K8sNode: "kube-node-0"},
+				"standalone": {Namespace: "slurm", Pod: "standalone"},
+				"cpu-1":      {Namespace: "slurm", Pod: "new", K8sNode: "kube-node-1"},
+				"cpu-2":      {Namespace: "slurm", Pod: "gone", K8sNode: "kube-node-0"},
+			},
+		},
+		{
+			name: "all pods of the namespace",
+			options: Options{
+				Namespace: "other",
+			},
+			want: map[string]collector.NodePod{
+				"gpu-2": {Namespace: "other", Pod: "gpu-2", K8sNode: "kube-node-0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newWatcher(kubeClient, tt.options)
+			got, err := w.NodePods(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,461 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/remotewrite/prompb"
+)
+
+// The headers of a remote write request.
+// Ref: https://prometheus.io/docs/specs/prw/remote_write_spec/#protocol
+const (
+	remoteWriteVersion = "0.1.0"
+	userAgent          = "slurm-exporter"
+)
+
+// Options configure a Writer.
+type Options struct {
+	// URL is the remote write endpoint.
+	URL string
+	// Interval is how often the metrics are gathered and written.
+	Interval time.Duration
+	// Timeout bounds every write request.
+	Timeout time.Duration
+	// Headers are added to every write request, e.g. for authorization.
+	Headers map[string]string
+	// BearerTokenFile is read on every write request, such that a rotated
+	// token is picked up, and sent as the Authorization header.
+	BearerTokenFile string
+	// ExternalLabels are added to every series, e.g. job and instance, which
+	// Prometheus would add on scrape.
+	ExternalLabels map[string]string
+	// QueueSize is the number of gathered write requests which are kept while
+	// the endpoint is unavailable, beyond which the oldest are dropped.
+	QueueSize int
+	// MinBackoff is how long a failed write request waits before its first
+	// retry, doubling on every retry.
+	MinBackoff time.Duration
+	// MaxBackoff caps the backoff.
+	MaxBackoff time.Duration
+}
+
+// Writer gathers the metrics on an interval and pushes them to an endpoint of
+// the Prometheus remote write protocol, for when Prometheus cannot scrape the
+// exporter.
+//
+// The write requests are queued in memory, and sent one at a time. Failed
+// writes are retried with exponential backoff when they are recoverable
+// (errors, HTTP 5xx and 429), otherwise they are dropped. While the endpoint
+// is unavailable, the queue fills up and drops the oldest write requests.
+type Writer struct {
+	gatherer func(ctx context.Context) prometheus.Gatherer
+	client   *http.Client
+	options  Options
+	queue    *queue
+
+	samples  atomic.Uint64
+	failures atomic.Uint64
+	dropped  atomic.Uint64
+}
+
+// NewWriter returns a Writer of the metrics of the gatherer, which returns the
+// Gatherer of each push, bound by the context of the push.
+func NewWriter(gatherer func(ctx context.Context) prometheus.Gatherer, options Options) (*Writer, error) {
+	if options.URL == "" {
+		return nil, errors.New("remote write url must not be empty")
+	}
+	if options.Interval <= 0 {
+		return nil, errors.New("remote write interval > 0")
+	}
+	if options.QueueSize <= 0 {
+		return nil, errors.New("remote write queue size > 0")
+	}
+	if options.MinBackoff <= 0 {
+		return nil, errors.New("remote write min-backoff > 0")
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		return nil, errors.New("remote write max-backoff >= min-backoff")
+	}
+	return &Writer{
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: options.Timeout},
+		options:  options,
+		queue:    newQueue(options.QueueSize),
+	}, nil
+}
+
+// Start gathers and writes the metrics until the context is done.
+func (w *Writer) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.sendLoop(ctx)
+	}()
+
+	ticker := time.NewTicker(w.options.Interval)
+	defer ticker.Stop()
+	for {
+		w.gather(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// gather queues the write request of the gathered metrics.
+func (w *Writer) gather(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("RemoteWriter")
+
+	if w.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.options.Timeout)
+		defer cancel()
+	}
+	families, err := w.gatherer(ctx).Gather()
+	if err != nil {
+		// Like promhttp, whatever was gathered is still written.
+		logger.Error(err, "failed to gather some metrics")
+	}
+	req := toWriteRequest(families, w.options.ExternalLabels, time.Now())
+	if len(req.Timeseries) == 0 {
+		return
+	}
+	if w.queue.push(req) {
+		w.dropped.Add(1)
+		logger.Info("remote write queue is full, dropped the oldest write request")
+	}
+}
+
+func (w *Writer) sendLoop(ctx context.Context) {
+	logger := log.FromContext(ctx).WithName("RemoteWriter")
+
+	for {
+		req, ok := w.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		if err := w.sendWithRetries(ctx, req); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.dropped.Add(1)
+			logger.Error(err, "dropped write request", "url", w.options.URL)
+		}
+	}
+}
+
+// recoverableError is a failed write which may succeed when retried.
+type recoverableError struct {
+	err error
+	// retryAfter is how long the endpoint asked to wait, if it did.
+	retryAfter time.Duration
+}
+
+func (e *recoverableError) Error() string {
+	return e.err.Error()
+}
+
+// sendWithRetries sends the write request, retrying recoverable failures with
+// exponential backoff until the context is done.
+func (w *Writer) sendWithRetries(ctx context.Context, req *prompb.WriteRequest) error {
+	logger := log.FromContext(ctx).WithName("RemoteWriter")
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, data)
+
+	backoff := w.options.MinBackoff
+	for {
+		err := w.send(ctx, body)
+		if err == nil {
+			w.samples.Add(uint64(len(req.Timeseries)))
+			return nil
+		}
+		w.failures.Add(1)
+		var recoverable *recoverableError
+		if !errors.As(err, &recoverable) {
+			return err
+		}
+		wait := backoff
+		if recoverable.retryAfter > 0 {
+			wait = min(recoverable.retryAfter, w.options.MaxBackoff)
+		}
+		logger.V(1).Info("retrying write request", "url", w.options.URL, "backoff", wait, "err", err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, w.options.MaxBackoff)
+	}
+}
+
+// send posts the snappy compressed write request.
+func (w *Writer) send(ctx context.Context, body []byte) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", userAgent)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	for name, value := range w.options.Headers {
+		httpReq.Header.Set(name, value)
+	}
+	if w.options.BearerTokenFile != "" {
+		token, err := os.ReadFile(w.options.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return &recoverableError{err: err}
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write responded with %s: %s", resp.Status, bytes.TrimSpace(message))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return &recoverableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return err
+}
+
+// parseRetryAfter parses the seconds of a Retry-After header.
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// toWriteRequest converts the metric families into a write request, whose
+// samples are at the given time unless the metrics carry their timestamp.
+// Histograms and summaries are written as their classic series (_bucket,
+// _sum, _count and quantiles).
+func toWriteRequest(families []*dto.MetricFamily, externalLabels map[string]string, now time.Time) *prompb.WriteRequest {
+	req := &prompb.WriteRequest{}
+	for _, family := range families {
+		name := family.GetName()
+		req.Metadata = append(req.Metadata, &prompb.MetricMetadata{
+			Type:             metricType(family.GetType()),
+			MetricFamilyName: name,
+			Help:             family.GetHelp(),
+		})
+		for _, metric := range family.GetMetric() {
+			timestamp := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...string) {
+				req.Timeseries = append(req.Timeseries, &prompb.TimeSeries{
+					Labels:  seriesLabels(name, metric.GetLabel(), externalLabels, extra...),
+					Samples: []*prompb.Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, quantile.GetValue(), "quantile", formatFloat(quantile.GetQuantile()))
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				infSeen := false
+				for _, bucket := range histogram.GetBucket() {
+					if math.IsInf(bucket.GetUpperBound(), +1) {
+						infSeen = true
+					}
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), "le", formatFloat(bucket.GetUpperBound()))
+				}
+				if !infSeen {
+					add(name+"_bucket", float64(histogram.GetSampleCount()), "le", "+Inf")
+				}
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return req
+}
+
+// seriesLabels returns the labels of a series, sorted by name, where the
+// labels of the metric take precedence over the external labels.
+func seriesLabels(name string, metricLabels []*dto.LabelPair, externalLabels map[string]string, extra ...string) []*prompb.Label {
+	labels := make([]*prompb.Label, 0, 1+len(metricLabels)+len(externalLabels)+len(extra)/2)
+	labels = append(labels, &prompb.Label{Name: "__name__", Value: name})
+	for _, label := range metricLabels {
+		labels = append(labels, &prompb.Label{Name: label.GetName(), Value: label.GetValue()})
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, &prompb.Label{Name: extra[i], Value: extra[i+1]})
+	}
+	for labelName, value := range externalLabels {
+		if !slices.ContainsFunc(labels, func(label *prompb.Label) bool { return label.Name == labelName }) {
+			labels = append(labels, &prompb.Label{Name: labelName, Value: value})
+		}
+	}
+	slices.SortFunc(labels, func(a, b *prompb.Label) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return labels
+}
+
+func metricType(metricType dto.MetricType) prompb.MetricMetadata_MetricType {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return prompb.MetricMetadata_COUNTER
+	case dto.MetricType_GAUGE:
+		return prompb.MetricMetadata_GAUGE
+	case dto.MetricType_SUMMARY:
+		return prompb.MetricMetadata_SUMMARY
+	case dto.MetricType_HISTOGRAM:
+		return prompb.MetricMetadata_HISTOGRAM
+	case dto.MetricType_GAUGE_HISTOGRAM:
+		return prompb.MetricMetadata_GAUGEHISTOGRAM
+	}
+	return prompb.MetricMetadata_UNKNOWN
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// queue is a bounded FIFO of write requests, which drops the oldest write
+// request when it is full.
+type queue struct {
+	mu    sync.Mutex
+	items []*prompb.WriteRequest
+	size  int
+	// ready is signaled when an item is pushed.
+	ready chan struct{}
+}
+
+func newQueue(size int) *queue {
+	return &queue{
+		size:  size,
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// push appends the write request, and returns whether the oldest was dropped
+// to make room for it.
+func (q *queue) push(req *prompb.WriteRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dropped := false
+	if len(q.items) >= q.size {
+		q.items = q.items[1:]
+		dropped = true
+	}
+	q.items = append(q.items, req)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return dropped
+}
+
+// pop removes the oldest write request, waiting for one until the context is
+// done.
+func (q *queue) pop(ctx context.Context) (*prompb.WriteRequest, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			req := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return req, true
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// NewCollector exports the progress of the remote write.
+//
+// Ref: https://prometheus.io/docs/practices/naming/#metric-names
+func (w *Writer) NewCollector() prometheus.Collector {
+	return &writerCollector{
+		writer: w,
+
+		Samples:     prometheus.NewDesc("slurm_exporter_remote_write_samples_total", "Number of samples written to the remote write endpoint", nil, nil),
+		Failures:    prometheus.NewDesc("slurm_exporter_remote_write_failures_total", "Number of failed write requests, including retries", nil, nil),
+		Dropped:     prometheus.NewDesc("slurm_exporter_remote_write_dropped_requests_total", "Number of write requests dropped, as the queue was full or the failure not recoverable", nil, nil),
+		QueueLength: prometheus.NewDesc("slurm_exporter_remote_write_queue_length", "Number of write requests waiting to be sent", nil, nil),
+	}
+}
+
+type writerCollector struct {
+	writer *Writer
+
+	Samples     *prometheus.Desc
+	Failures    *prometheus.Desc
+	Dropped     *prometheus.Desc
+	QueueLength *prometheus.Desc
+}
+
+func (c *writerCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *writerCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.Samples, prometheus.CounterValue, float64(c.writer.samples.Load()))
+	ch <- prometheus.MustNewConstMetric(c.Failures, prometheus.CounterValue, float64(c.writer.failures.Load()))
+	ch <- prometheus.MustNewConstMetric(c.Dropped, prometheus.CounterValue, float64(c.writer.dropped.Load()))
+	ch <- prometheus.MustNewConstMetric(c.QueueLength, prometheus.GaugeValue, float64(c.writer.queue.len()))
+}
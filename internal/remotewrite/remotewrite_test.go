@@ -0,0 +1,292 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"k8s.io/utils/ptr"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/remotewrite/prompb"
+)
+
+// receiver is a remote write endpoint, which responds with the given status
+// codes in turn, then with 204.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*prompb.WriteRequest
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	compressed, _ := io.ReadAll(req.Body)
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeRequest := &prompb.WriteRequest{}
+	if err := proto.Unmarshal(data, writeRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, writeRequest)
+	r.headers = append(r.headers, req.Header.Clone())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) received() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestGatherer() func(context.Context) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "slurm_nodes", Help: "Number of nodes"}, []string{"state"})
+	gauge.WithLabelValues("idle").Set(3)
+	registry.MustRegister(gauge)
+	return func(context.Context) prometheus.Gatherer {
+		return registry
+	}
+}
+
+func newTestOptions(url string) Options {
+	return Options{
+		URL:        url,
+		Interval:   time.Hour,
+		Timeout:    time.Second,
+		QueueSize:  10,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}
+}
+
+func TestNewWriter(t *testing.T) {
+	options := newTestOptions("http://localhost:9090/api/v1/write")
+	_, err := NewWriter(newTestGatherer(), options)
+	assert.NoError(t, err)
+
+	invalid := options
+	invalid.URL = ""
+	_, err = NewWriter(newTestGatherer(), invalid)
+	assert.Error(t, err)
+	invalid = options
+	invalid.QueueSize = 0
+	_, err = NewWriter(newTestGatherer(), invalid)
+	assert.Error(t, err)
+	invalid = options
+	invalid.MaxBackoff = 0
+	_, err = NewWriter(newTestGatherer(), invalid)
+	assert.Error(t, err)
+}
+
+func TestWriter_Start(t *testing.T) {
+	receiver := &receiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0o600))
+	options := newTestOptions(server.URL)
+	options.Headers = map[string]string{"X-Scope-OrgID": "slurm"}
+	options.BearerTokenFile = tokenFile
+	options.ExternalLabels = map[string]string{"job": "slurm-exporter", "state": "ignored"}
+	writer, err := NewWriter(newTestGatherer(), options)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return writer.samples.Load() == 1 }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+
+	header := receiver.headers[0]
+	assert.Equal(t, "snappy", header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", header.Get("Content-Type"))
+	assert.Equal(t, "0.1.0", header.Get("X-Prometheus-Remote-Write-Version"))
+	assert.Equal(t, "slurm", header.Get("X-Scope-OrgID"))
+	assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+
+	series := receiver.requests[0].GetTimeseries()
+	assert.Len(t, series, 1)
+	assert.Equal(t, []*prompb.Label{
+		{Name: "__name__", Value: "slurm_nodes"},
+		{Name: "job", Value: "slurm-exporter"},
+		{Name: "state", Value: "idle"},
+	}, series[0].GetLabels())
+	assert.Equal(t, 3.0, series[0].GetSamples()[0].GetValue())
+}
+
+func TestWriter_gather(t *testing.T) {
+	gatherer := newTestGatherer()
+	var gatherCtx context.Context
+	writer, err := NewWriter(func(ctx context.Context) prometheus.Gatherer {
+		gatherCtx = ctx
+		return gatherer(ctx)
+	}, newTestOptions("http://localhost:9090/api/v1/write"))
+	assert.NoError(t, err)
+	writer.gather(context.Background())
+
+	// Every push gathers with its own context, bound by the timeout.
+	_, ok := gatherCtx.Deadline()
+	assert.True(t, ok)
+	assert.Error(t, gatherCtx.Err())
+}
+
+func TestWriter_retries(t *testing.T) {
+	receiver := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	writer, err := NewWriter(newTestGatherer(), newTestOptions(server.URL))
+	assert.NoError(t, err)
+	writer.gather(context.Background())
+	req, ok := writer.queue.pop(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, writer.sendWithRetries(context.Background(), req))
+	assert.Equal(t, 1, receiver.received())
+	assert.Equal(t, uint64(2), writer.failures.Load())
+}
+
+func TestWriter_notRecoverable(t *testing.T) {
+	receiver := &receiver{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	writer, err := NewWriter(newTestGatherer(), newTestOptions(server.URL))
+	assert.NoError(t, err)
+	writer.gather(context.Background())
+	req, ok := writer.queue.pop(context.Background())
+	assert.True(t, ok)
+	assert.Error(t, writer.sendWithRetries(context.Background(), req))
+	assert.Equal(t, 0, receiver.received())
+	assert.Equal(t, uint64(1), writer.failures.Load())
+}
+
+func TestWriter_NewCollector(t *testing.T) {
+	writer, err := NewWriter(newTestGatherer(), newTestOptions("http://localhost:9090/api/v1/write"))
+	assert.NoError(t, err)
+	writer.gather(context.Background())
+	writer.dropped.Add(2)
+
+	c := writer.NewCollector()
+	assert.Equal(t, 4, testutil.CollectAndCount(c))
+	assert.NoError(t, testutil.CollectAndCompare(c, strings.NewReader(`
+# HELP slurm_exporter_remote_write_dropped_requests_total Number of write requests dropped, as the queue was full or the failure not recoverable
+# TYPE slurm_exporter_remote_write_dropped_requests_total counter
+slurm_exporter_remote_write_dropped_requests_total 2
+# HELP slurm_exporter_remote_write_queue_length Number of write requests waiting to be sent
+# TYPE slurm_exporter_remote_write_queue_length gauge
+slurm_exporter_remote_write_queue_length 1
+`), "slurm_exporter_remote_write_dropped_requests_total", "slurm_exporter_remote_write_queue_length"))
+}
+
+func Test_queue(t *testing.T) {
+	q := newQueue(2)
+	first, second, third := &prompb.WriteRequest{}, &prompb.WriteRequest{}, &prompb.WriteRequest{}
+	assert.False(t, q.push(first))
+	assert.False(t, q.push(second))
+	// The oldest is dropped when the queue is full.
+	assert.True(t, q.push(third))
+	assert.Equal(t, 2, q.len())
+
+	got, ok := q.pop(context.Background())
+	assert.True(t, ok)
+	assert.Same(t, second, got)
+	got, ok = q.pop(context.Background())
+	assert.True(t, ok)
+	assert.Same(t, third, got)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok = q.pop(ctx)
+	assert.False(t, ok)
+}
+
+func Test_toWriteRequest(t *testing.T) {
+	now := time.UnixMilli(1000)
+	families := []*dto.MetricFamily{
+		{
+			Name: ptr.To("slurm_jobs_submitted_total"),
+			Help: ptr.To("Number of submitted jobs"),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{{
+				Label:       []*dto.LabelPair{{Name: ptr.To("partition"), Value: ptr.To("gpu")}},
+				Counter:     &dto.Counter{Value: ptr.To(5.0)},
+				TimestampMs: ptr.To[int64](500),
+			}},
+		},
+		{
+			Name: ptr.To("slurm_node_unavailable_duration_seconds"),
+			Help: ptr.To("Duration of node unavailability"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: ptr.To[uint64](3),
+					SampleSum:   ptr.To(120.0),
+					Bucket: []*dto.Bucket{
+						{UpperBound: ptr.To(60.0), CumulativeCount: ptr.To[uint64](2)},
+					},
+				},
+			}},
+		},
+	}
+	got := toWriteRequest(families, nil, now)
+
+	assert.Equal(t, []*prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "slurm_jobs_submitted_total", Help: "Number of submitted jobs"},
+		{Type: prompb.MetricMetadata_HISTOGRAM, MetricFamilyName: "slurm_node_unavailable_duration_seconds", Help: "Duration of node unavailability"},
+	}, got.GetMetadata())
+
+	type sample struct {
+		labels    map[string]string
+		value     float64
+		timestamp int64
+	}
+	var samples []sample
+	for _, series := range got.GetTimeseries() {
+		labels := map[string]string{}
+		for _, label := range series.GetLabels() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		samples = append(samples, sample{labels: labels, value: series.GetSamples()[0].GetValue(), timestamp: series.GetSamples()[0].GetTimestamp()})
+	}
+	assert.Equal(t, []sample{
+		{labels: map[string]string{"__name__": "slurm_jobs_submitted_total", "partition": "gpu"}, value: 5, timestamp: 500},
+		{labels: map[string]string{"__name__": "slurm_node_unavailable_duration_seconds_bucket", "le": "60"}, value: 2, timestamp: 1000},
+		{labels: map[string]string{"__name__": "slurm_node_unavailable_duration_seconds_bucket", "le": "+Inf"}, value: 3, timestamp: 1000},
+		{labels: map[string]string{"__name__": "slurm_node_unavailable_duration_seconds_sum"}, value: 120, timestamp: 1000},
+		{labels: map[string]string{"__name__": "slurm_node_unavailable_duration_seconds_count"}, value: 3, timestamp: 1000},
+	}, samples)
+}
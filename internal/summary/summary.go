@@ -0,0 +1,412 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package summary
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+// APIVersion is the version of the schema of the responses. Fields may be
+// added within a version, but are never renamed nor removed.
+const APIVersion = "v1"
+
+// The kinds of the responses.
+const (
+	KindSummary   = "Summary"
+	KindPartition = "Partition"
+	KindAccount   = "Account"
+	KindStatus    = "Status"
+)
+
+// TypeMeta identifies the schema of a response. It is only set on the top
+// level object of a response.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+// Summary is the cluster at a glance.
+type Summary struct {
+	TypeMeta
+	// Timestamp is when the summary was computed.
+	Timestamp time.Time `json:"timestamp"`
+	// Nodes of the cluster.
+	Nodes Nodes `json:"nodes"`
+	// Jobs of the cluster.
+	Jobs Jobs `json:"jobs"`
+	// Partitions of the cluster, by name.
+	Partitions []Partition `json:"partitions"`
+	// Accounts which have jobs, by name.
+	Accounts []string `json:"accounts"`
+}
+
+// Partition is the nodes and jobs of a partition.
+type Partition struct {
+	TypeMeta
+	// Timestamp is when the partition was computed, if it is the top level
+	// object.
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	Name      string    `json:"name"`
+	// Nodes of the partition.
+	Nodes Nodes `json:"nodes"`
+	// Jobs of the partition.
+	Jobs Jobs `json:"jobs"`
+	// Pending is what the pending jobs of the partition, which are not held,
+	// request.
+	Pending Pending `json:"pending"`
+}
+
+// Account is the jobs of an account.
+type Account struct {
+	TypeMeta
+	// Timestamp is when the account was computed.
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	Name      string    `json:"name"`
+	// Jobs of the account.
+	Jobs Jobs `json:"jobs"`
+}
+
+// Status is the error of a failed request.
+type Status struct {
+	TypeMeta
+	// Code is the HTTP status code.
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Nodes are the aggregates of a set of nodes.
+type Nodes struct {
+	Count  uint       `json:"count"`
+	States NodeStates `json:"states"`
+	Cpus   NodeCpus   `json:"cpus"`
+	// MemoryMegabytes is the memory of the nodes, in MB.
+	MemoryMegabytes NodeMemory `json:"memoryMegabytes"`
+}
+
+// NodeStates are the number of nodes per base state and flag. A node counts
+// under one base state, and under each of its flags.
+// Ref: https://slurm.schedmd.com/sinfo.html#SECTION_NODE-STATE-CODES
+type NodeStates struct {
+	// Base States
+	Allocated uint `json:"allocated"`
+	Down      uint `json:"down"`
+	Error     uint `json:"error"`
+	Future    uint `json:"future"`
+	Idle      uint `json:"idle"`
+	Mixed     uint `json:"mixed"`
+	Unknown   uint `json:"unknown"`
+	// Flag States
+	Completing      uint `json:"completing"`
+	Drain           uint `json:"drain"`
+	Fail            uint `json:"fail"`
+	Maintenance     uint `json:"maintenance"`
+	NotResponding   uint `json:"notResponding"`
+	Planned         uint `json:"planned"`
+	RebootRequested uint `json:"rebootRequested"`
+	Reserved        uint `json:"reserved"`
+}
+
+// NodeCpus are the CPUs of the nodes.
+type NodeCpus struct {
+	Total     uint `json:"total"`
+	Effective uint `json:"effective"`
+	Allocated uint `json:"allocated"`
+	Idle      uint `json:"idle"`
+}
+
+// NodeMemory is the memory of the nodes.
+type NodeMemory struct {
+	Total     uint `json:"total"`
+	Effective uint `json:"effective"`
+	Allocated uint `json:"allocated"`
+	Free      uint `json:"free"`
+}
+
+// Jobs are the aggregates of a set of jobs.
+type Jobs struct {
+	Count  uint      `json:"count"`
+	States JobStates `json:"states"`
+	// CpusAllocated is the number of CPUs allocated to the jobs.
+	CpusAllocated uint `json:"cpusAllocated"`
+	// MemoryAllocatedMegabytes is the memory allocated to the jobs, in MB.
+	MemoryAllocatedMegabytes uint `json:"memoryAllocatedMegabytes"`
+}
+
+// JobStates are the number of jobs per base state and flag. A job counts under
+// one base state, and under each of its flags.
+// Ref: https://slurm.schedmd.com/job_state_codes.html
+type JobStates struct {
+	// Base States
+	BootFail    uint `json:"bootFail"`
+	Cancelled   uint `json:"cancelled"`
+	Completed   uint `json:"completed"`
+	Deadline    uint `json:"deadline"`
+	Failed      uint `json:"failed"`
+	NodeFail    uint `json:"nodeFail"`
+	OutOfMemory uint `json:"outOfMemory"`
+	Pending     uint `json:"pending"`
+	Preempted   uint `json:"preempted"`
+	Running     uint `json:"running"`
+	Suspended   uint `json:"suspended"`
+	Timeout     uint `json:"timeout"`
+	// Flag States
+	Completing  uint `json:"completing"`
+	Configuring uint `json:"configuring"`
+	PowerUpNode uint `json:"powerUpNode"`
+	StageOut    uint `json:"stageOut"`
+	Hold        uint `json:"hold"`
+}
+
+// Pending is what the pending jobs request, which are not held.
+type Pending struct {
+	Jobs uint `json:"jobs"`
+	Cpus uint `json:"cpus"`
+	Gpus uint `json:"gpus"`
+	// MaxNodes is the largest number of nodes which a pending job requests.
+	MaxNodes uint `json:"maxNodes"`
+}
+
+// Server serves the summary API, a JSON view of the aggregates of the Slurm
+// metrics, for dashboards and scripts which do not speak PromQL.
+type Server struct {
+	snapshots *collector.Snapshotter
+	ready     func() error
+	clock     clock.PassiveClock
+
+	mux *http.ServeMux
+}
+
+// NewServer returns a Server which serves the aggregates while ready returns
+// no error.
+func NewServer(snapshots *collector.Snapshotter, ready func() error) *Server {
+	return newServer(snapshots, ready, clock.RealClock{})
+}
+
+func newServer(snapshots *collector.Snapshotter, ready func() error, clk clock.PassiveClock) *Server {
+	s := &Server{
+		snapshots: snapshots,
+		ready:     ready,
+		clock:     clk,
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("GET /api/"+APIVersion+"/summary", s.serveSummary)
+	s.mux.HandleFunc("GET /api/"+APIVersion+"/partitions/{name}", s.servePartition)
+	s.mux.HandleFunc("GET /api/"+APIVersion+"/accounts/{name}", s.serveAccount)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveSummary(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context()).WithName("Summary")
+
+	if err := s.ready(); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	snapshot := s.snapshots.SnapshotWithContext(r.Context())
+	nodeAggregates, err := snapshot.NodeAggregates()
+	if err != nil {
+		logger.Error(err, "failed to get node metrics")
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	jobAggregates, err := snapshot.JobAggregates()
+	if err != nil {
+		logger.Error(err, "failed to get job metrics")
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	partitionMetrics, err := snapshot.PartitionMetrics()
+	if err != nil {
+		logger.Error(err, "failed to get partition metrics")
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	summary := &Summary{
+		TypeMeta:   TypeMeta{APIVersion: APIVersion, Kind: KindSummary},
+		Timestamp:  s.clock.Now(),
+		Nodes:      newNodes(&nodeAggregates.NodeMetrics),
+		Jobs:       newJobs(&jobAggregates.JobMetrics),
+		Partitions: []Partition{},
+		Accounts:   slices.Sorted(maps.Keys(jobAggregates.AccountJobMetricsPer)),
+	}
+	for _, name := range slices.Sorted(maps.Keys(partitionMetrics.JobMetricsPer)) {
+		summary.Partitions = append(summary.Partitions, newPartition(name, partitionMetrics))
+	}
+	if summary.Accounts == nil {
+		summary.Accounts = []string{}
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+func (s *Server) servePartition(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context()).WithName("Summary")
+
+	name := r.PathValue("name")
+	if err := s.ready(); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	partitionMetrics, err := s.snapshots.SnapshotWithContext(r.Context()).PartitionMetrics()
+	if err != nil {
+		logger.Error(err, "failed to get partition metrics", "partition", name)
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if _, ok := partitionMetrics.JobMetricsPer[name]; !ok {
+		writeStatus(w, http.StatusNotFound, fmt.Sprintf("partition %q not found", name))
+		return
+	}
+
+	partition := newPartition(name, partitionMetrics)
+	partition.TypeMeta = TypeMeta{APIVersion: APIVersion, Kind: KindPartition}
+	partition.Timestamp = s.clock.Now()
+	writeJSON(w, http.StatusOK, &partition)
+}
+
+func (s *Server) serveAccount(w http.ResponseWriter, r *http.Request) {
+	logger := log.FromContext(r.Context()).WithName("Summary")
+
+	name := r.PathValue("name")
+	if err := s.ready(); err != nil {
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	jobAggregates, err := s.snapshots.SnapshotWithContext(r.Context()).JobAggregates()
+	if err != nil {
+		logger.Error(err, "failed to get account metrics", "account", name)
+		writeStatus(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	jobs, ok := jobAggregates.AccountJobMetricsPer[name]
+	if !ok {
+		writeStatus(w, http.StatusNotFound, fmt.Sprintf("account %q not found", name))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &Account{
+		TypeMeta:  TypeMeta{APIVersion: APIVersion, Kind: KindAccount},
+		Timestamp: s.clock.Now(),
+		Name:      name,
+		Jobs:      newJobs(jobs),
+	})
+}
+
+// newPartition returns the partition of the partition metrics, which has
+// metrics for every partition, even without nodes or jobs.
+func newPartition(name string, metrics *collector.PartitionMetrics) Partition {
+	nodes, ok := metrics.NodeMetricsPer[name]
+	if !ok {
+		nodes = &collector.NodeMetrics{}
+	}
+	jobs, ok := metrics.JobMetricsPer[name]
+	if !ok {
+		jobs = &collector.PartitionJobMetrics{}
+	}
+	return Partition{
+		Name:  name,
+		Nodes: newNodes(nodes),
+		Jobs:  newJobs(&jobs.JobMetrics),
+		Pending: Pending{
+			Jobs:     jobs.PendingJobCount,
+			Cpus:     jobs.PendingCpus,
+			Gpus:     jobs.PendingGpus,
+			MaxNodes: jobs.PendingNodeCount,
+		},
+	}
+}
+
+func newNodes(metrics *collector.NodeMetrics) Nodes {
+	states := metrics.NodeStates
+	tres := metrics.NodeTres
+	return Nodes{
+		Count: metrics.NodeCount,
+		States: NodeStates{
+			Allocated:       states.Allocated,
+			Down:            states.Down,
+			Error:           states.Error,
+			Future:          states.Future,
+			Idle:            states.Idle,
+			Mixed:           states.Mixed,
+			Unknown:         states.Unknown,
+			Completing:      states.Completing,
+			Drain:           states.Drain,
+			Fail:            states.Fail,
+			Maintenance:     states.Maintenance,
+			NotResponding:   states.NotResponding,
+			Planned:         states.Planned,
+			RebootRequested: states.RebootRequested,
+			Reserved:        states.Reserved,
+		},
+		Cpus: NodeCpus{
+			Total:     tres.CpusTotal,
+			Effective: tres.CpusEffective,
+			Allocated: tres.CpusAlloc,
+			Idle:      tres.CpusIdle,
+		},
+		MemoryMegabytes: NodeMemory{
+			Total:     tres.MemoryTotal,
+			Effective: tres.MemoryEffective,
+			Allocated: tres.MemoryAlloc,
+			Free:      tres.MemoryFree,
+		},
+	}
+}
+
+func newJobs(metrics *collector.JobMetrics) Jobs {
+	states := metrics.JobStates
+	return Jobs{
+		Count: metrics.JobCount,
+		States: JobStates{
+			BootFail:    states.BootFail,
+			Cancelled:   states.Cancelled,
+			Completed:   states.Completed,
+			Deadline:    states.Deadline,
+			Failed:      states.Failed,
+			NodeFail:    states.NodeFail,
+			OutOfMemory: states.OutOfMemory,
+			Pending:     states.Pending,
+			Preempted:   states.Preempted,
+			Running:     states.Running,
+			Suspended:   states.Suspended,
+			Timeout:     states.Timeout,
+			Completing:  states.Completing,
+			Configuring: states.Configuring,
+			PowerUpNode: states.PowerUpNode,
+			StageOut:    states.StageOut,
+			Hold:        states.Hold,
+		},
+		CpusAllocated:            metrics.JobTres.CpusAlloc,
+		MemoryAllocatedMegabytes: metrics.JobTres.MemoryAlloc,
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, &Status{
+		TypeMeta: TypeMeta{APIVersion: APIVersion, Kind: KindStatus},
+		Code:     code,
+		Message:  message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
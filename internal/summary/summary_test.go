@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: Copyright (C) SchedMD LLC.
+// SPDX-License-Identifier: Apache-2.0
+
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	clocktesting "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+
+	api "github.com/SlinkyProject/slurm-client/api/v0043"
+	"github.com/SlinkyProject/slurm-client/pkg/client"
+	"github.com/SlinkyProject/slurm-client/pkg/client/fake"
+	"github.com/SlinkyProject/slurm-client/pkg/client/interceptor"
+	"github.com/SlinkyProject/slurm-client/pkg/object"
+	"github.com/SlinkyProject/slurm-client/pkg/types"
+
+	"github.com/SlinkyProject/slurm-exporter/internal/collector"
+)
+
+func newTestServer(ready func() error) *Server {
+	partitionList := &types.V0043PartitionInfoList{Items: []types.V0043PartitionInfo{
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("gpu")}},
+		{V0043PartitionInfo: api.V0043PartitionInfo{Name: ptr.To("cpu")}},
+	}}
+	nodeList := &types.V0043NodeList{Items: []types.V0043Node{
+		{V0043Node: api.V0043Node{
+			Name:       ptr.To("node-0"),
+			Partitions: ptr.To(api.V0043CsvString{"cpu"}),
+			State:      ptr.To([]api.V0043NodeState{api.V0043NodeStateIDLE}),
+			Cpus:       ptr.To[int32](8),
+		}},
+		{V0043Node: api.V0043Node{
+			Name:       ptr.To("node-1"),
+			Partitions: ptr.To(api.V0043CsvString{"gpu"}),
+			State:      ptr.To([]api.V0043NodeState{api.V0043NodeStateALLOCATED, api.V0043NodeStateDRAIN}),
+			Cpus:       ptr.To[int32](16),
+		}},
+	}}
+	jobList := &types.V0043JobInfoList{Items: []types.V0043JobInfo{
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](1),
+			Account:    ptr.To("physics"),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStatePENDING}),
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=16,node=2,gres/gpu=8"),
+			NodeCount:  &api.V0043Uint32NoValStruct{Set: ptr.To(true), Number: ptr.To[int32](2)},
+		}},
+		{V0043JobInfo: api.V0043JobInfo{
+			JobId:      ptr.To[int32](2),
+			Account:    ptr.To("biology"),
+			JobState:   ptr.To([]api.V0043JobInfoJobState{api.V0043JobInfoJobStateRUNNING}),
+			Partition:  ptr.To("gpu"),
+			TresReqStr: ptr.To("cpu=4,node=1,gres/gpu=1"),
+		}},
+	}}
+	slurmClient := fake.NewClientBuilder().WithLists(partitionList, nodeList, jobList).Build()
+	clk := clocktesting.NewFakePassiveClock(time.Unix(1000, 0))
+	return newServer(collector.NewSnapshotter(slurmClient), ready, clk)
+}
+
+func get(s *Server, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestServer_summary(t *testing.T) {
+	w := get(newTestServer(func() error { return nil }), "/api/v1/summary")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var got Summary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, TypeMeta{APIVersion: "v1", Kind: KindSummary}, got.TypeMeta)
+	assert.True(t, time.Unix(1000, 0).Equal(got.Timestamp))
+	assert.Equal(t, uint(2), got.Nodes.Count)
+	assert.Equal(t, uint(1), got.Nodes.States.Idle)
+	assert.Equal(t, uint(1), got.Nodes.States.Allocated)
+	assert.Equal(t, uint(1), got.Nodes.States.Drain)
+	assert.Equal(t, uint(24), got.Nodes.Cpus.Total)
+	assert.Equal(t, uint(2), got.Jobs.Count)
+	assert.Equal(t, uint(1), got.Jobs.States.Pending)
+	assert.Equal(t, uint(1), got.Jobs.States.Running)
+	assert.Equal(t, []string{"biology", "physics"}, got.Accounts)
+
+	var names []string
+	for _, partition := range got.Partitions {
+		assert.Equal(t, TypeMeta{}, partition.TypeMeta)
+		assert.True(t, partition.Timestamp.IsZero())
+		names = append(names, partition.Name)
+	}
+	assert.Equal(t, []string{"cpu", "gpu"}, names)
+}
+
+func TestServer_partition(t *testing.T) {
+	w := get(newTestServer(func() error { return nil }), "/api/v1/partitions/gpu")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got Partition
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, TypeMeta{APIVersion: "v1", Kind: KindPartition}, got.TypeMeta)
+	assert.True(t, time.Unix(1000, 0).Equal(got.Timestamp))
+	assert.Equal(t, "gpu", got.Name)
+	assert.Equal(t, uint(1), got.Nodes.Count)
+	assert.Equal(t, uint(1), got.Nodes.States.Allocated)
+	assert.Equal(t, uint(2), got.Jobs.Count)
+	assert.Equal(t, Pending{Jobs: 1, Cpus: 16, Gpus: 8, MaxNodes: 2}, got.Pending)
+}
+
+func TestServer_account(t *testing.T) {
+	w := get(newTestServer(func() error { return nil }), "/api/v1/accounts/physics")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var got Account
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+	assert.Equal(t, TypeMeta{APIVersion: "v1", Kind: KindAccount}, got.TypeMeta)
+	assert.Equal(t, "physics", got.Name)
+	assert.Equal(t, uint(1), got.Jobs.Count)
+	assert.Equal(t, uint(1), got.Jobs.States.Pending)
+}
+
+func TestServer_errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		ready func() error
+		want  int
+	}{
+		{
+			name:  "unknown partition",
+			path:  "/api/v1/partitions/debug",
+			ready: func() error { return nil },
+			want:  http.StatusNotFound,
+		},
+		{
+			name:  "unknown account",
+			path:  "/api/v1/accounts/chemistry",
+			ready: func() error { return nil },
+			want:  http.StatusNotFound,
+		},
+		{
+			name:  "not ready",
+			path:  "/api/v1/summary",
+			ready: func() error { return errors.New("not ready") },
+			want:  http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := get(newTestServer(tt.ready), tt.path)
+			assert.Equal(t, tt.want, w.Code)
+			var got Status
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+			assert.Equal(t, TypeMeta{APIVersion: "v1", Kind: KindStatus}, got.TypeMeta)
+			assert.Equal(t, tt.want, got.Code)
+			assert.NotEmpty(t, got.Message)
+		})
+	}
+}
+
+func TestServer_unavailable(t *testing.T) {
+	slurmClient := fake.NewClientBuilder().
+		WithInterceptorFuncs(interceptor.Funcs{
+			List: func(ctx context.Context, list object.ObjectList, opts ...client.ListOption) error {
+				return errors.New("slurmrestd is down")
+			},
+		}).
+		Build()
+	s := newServer(collector.NewSnapshotter(slurmClient), func() error { return nil }, clocktesting.NewFakePassiveClock(time.Unix(1000, 0)))
+	for _, path := range []string{"/api/v1/summary", "/api/v1/partitions/gpu", "/api/v1/accounts/physics"} {
+		w := get(s, path)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+		var got Status
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, "slurmrestd is down", got.Message)
+	}
+}
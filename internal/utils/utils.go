@@ -16,3 +16,17 @@ func ParseCSV(in string) []string {
 func pruneEmpty(list []string) []string {
 	return slices.DeleteFunc(list, func(s string) bool { return s == "" })
 }
+
+// ParseTRES parses a TRES string (e.g. "cpu=4,mem=8G,gres/gpu=1") into a map
+// of TRES type, including the name if any, to its unparsed count.
+func ParseTRES(in string) map[string]string {
+	tres := make(map[string]string)
+	for _, item := range ParseCSV(in) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok || key == "" {
+			continue
+		}
+		tres[key] = value
+	}
+	return tres
+}
@@ -61,3 +61,52 @@ func TestPruneEmpty(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTRES(t *testing.T) {
+	type args struct {
+		in string
+	}
+	tests := []struct {
+		name string
+		args args
+		want map[string]string
+	}{
+		{
+			name: "empty",
+			args: args{
+				in: "",
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "tres",
+			args: args{
+				in: "cpu=4,mem=8G,node=1,billing=4,energy=3600,gres/gpu=1",
+			},
+			want: map[string]string{
+				"cpu":      "4",
+				"mem":      "8G",
+				"node":     "1",
+				"billing":  "4",
+				"energy":   "3600",
+				"gres/gpu": "1",
+			},
+		},
+		{
+			name: "malformed",
+			args: args{
+				in: "cpu=4,,mem,=1",
+			},
+			want: map[string]string{
+				"cpu": "4",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseTRES(tt.args.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseTRES() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}